@@ -0,0 +1,227 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// BatchOptions control the behaviour of a BatchWriter. A nil *BatchOptions
+// provides default values.
+type BatchOptions struct {
+	// A batch is written when it holds at least this many bytes.
+	// If zero, a default of 64KiB is used.
+	MaxBytes int
+
+	// If positive, a non-empty batch is written at most this long after its
+	// first record was added, even if it is not full.
+	MaxDelay time.Duration
+
+	// The number of records that may be queued before calls to Encode block.
+	// If zero, a default of 1024 is used.
+	QueueLen int
+
+	// If positive, each batch is framed as a single record with this tag,
+	// whose value is the records of the batch. Otherwise the records of each
+	// batch are written as they are.
+	Tag int
+
+	// If non-nil, OnError is called with the error from a failed write. After
+	// an error, the BatchWriter discards further records.
+	OnError func(error)
+}
+
+func (o *BatchOptions) maxBytes() int {
+	if o == nil || o.MaxBytes <= 0 {
+		return 64 << 10
+	}
+	return o.MaxBytes
+}
+
+func (o *BatchOptions) maxDelay() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.MaxDelay
+}
+
+func (o *BatchOptions) queueLen() int {
+	if o == nil || o.QueueLen <= 0 {
+		return 1024
+	}
+	return o.QueueLen
+}
+
+func (o *BatchOptions) tag() int {
+	if o == nil {
+		return 0
+	}
+	return o.Tag
+}
+
+func (o *BatchOptions) onError(err error) {
+	if o != nil && o.OnError != nil {
+		o.OnError(err)
+	}
+}
+
+// A BatchWriter accumulates records and writes them in batches from a
+// separate goroutine. A batch is written when it is full, when it reaches
+// its maximum delay, or on a call to Flush or Close. Each batch is written
+// with a single call to the underlying writer.
+//
+// Records are queued for the writer goroutine, and when the queue is full
+// Encode blocks until there is room, so that a slow writer applies
+// backpressure to its callers. The methods of a BatchWriter are safe for
+// concurrent use by multiple goroutines.
+type BatchWriter struct {
+	w    io.Writer
+	opts *BatchOptions
+	q    chan batchItem
+	done chan struct{}
+
+	mu     sync.RWMutex // guards closed, and sends to q
+	closed bool
+
+	emu sync.Mutex
+	err error // the first write error
+}
+
+// A batchItem is either an encoded record or a request to flush.
+type batchItem struct {
+	rec   []byte
+	flush chan error
+}
+
+// NewBatchWriter constructs a BatchWriter that writes batches to w. The
+// caller must call Close to write the final batch and stop the writer.
+func NewBatchWriter(w io.Writer, opts *BatchOptions) *BatchWriter {
+	b := &BatchWriter{
+		w:    w,
+		opts: opts,
+		q:    make(chan batchItem, opts.queueLen()),
+		done: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Encode queues a record with the given tag and value to be written. It
+// reports an error if the record is invalid, if the writer is closed, or if
+// a previous write failed.
+func (b *BatchWriter) Encode(tag int, value []byte) error {
+	e := NewEncoder(nil)
+	if err := e.Encode(tag, value); err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrWriterClosed
+	} else if err := b.writeErr(); err != nil {
+		return err
+	}
+	b.q <- batchItem{rec: e.Data.Bytes()}
+	return nil
+}
+
+// Flush writes all the records queued before the call, and reports the
+// error from writing them, if any.
+func (b *BatchWriter) Flush() error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrWriterClosed
+	}
+	ch := make(chan error, 1)
+	b.q <- batchItem{flush: ch}
+	b.mu.RUnlock()
+	return <-ch
+}
+
+// Close writes all queued records and stops the writer goroutine. It does
+// not close the underlying writer. It reports the first error from writing,
+// if any.
+func (b *BatchWriter) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.q)
+	}
+	b.mu.Unlock()
+	<-b.done
+	return b.writeErr()
+}
+
+func (b *BatchWriter) writeErr() error {
+	b.emu.Lock()
+	defer b.emu.Unlock()
+	return b.err
+}
+
+// run is the writer goroutine. It exits when the queue is closed.
+func (b *BatchWriter) run() {
+	defer close(b.done)
+
+	var buf bytes.Buffer
+	var timer *time.Timer
+	var expired <-chan time.Time
+	write := func() error {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if buf.Len() == 0 {
+			return nil
+		}
+		defer buf.Reset()
+		if err := b.writeErr(); err != nil {
+			return err // discard the batch
+		}
+		data := buf.Bytes()
+		var err error
+		if tag := b.opts.tag(); tag > 0 {
+			e := NewEncoder(nil)
+			err = e.Encode(tag, data)
+			data = e.Data.Bytes()
+		}
+		if err == nil {
+			_, err = b.w.Write(data)
+		}
+		if err != nil {
+			b.emu.Lock()
+			b.err = err
+			b.emu.Unlock()
+			b.opts.onError(err)
+		}
+		return err
+	}
+
+	for {
+		select {
+		case item, ok := <-b.q:
+			if !ok {
+				write()
+				return
+			} else if item.flush != nil {
+				write()
+				item.flush <- b.writeErr()
+				continue
+			}
+			if buf.Len() == 0 && b.opts.maxDelay() > 0 {
+				timer = time.NewTimer(b.opts.maxDelay())
+				expired = timer.C
+			}
+			buf.Write(item.rec)
+			if buf.Len() >= b.opts.maxBytes() {
+				write()
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			write()
+		}
+	}
+}
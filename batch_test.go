@@ -0,0 +1,114 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creachadair/binpack"
+)
+
+// batchSink records the writes made to it.
+type batchSink struct {
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (s *batchSink) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.writes = append(s.writes, append([]byte(nil), data...))
+	return len(data), nil
+}
+
+func (s *batchSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.writes)
+}
+
+func TestBatchWriter(t *testing.T) {
+	sink := new(batchSink)
+	w := binpack.NewBatchWriter(sink, &binpack.BatchOptions{MaxBytes: 100, QueueLen: 2})
+	want := binpack.NewEncoder(nil)
+	for i := 0; i < 50; i++ {
+		if err := w.Encode(i%5+1, []byte("some value")); err != nil {
+			t.Fatalf("Encode %d failed: %v", i, err)
+		}
+		want.Encode(i%5+1, []byte("some value"))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Encode(1, nil); !errors.Is(err, binpack.ErrWriterClosed) {
+		t.Errorf("Encode after Close: got %v, want %v", err, binpack.ErrWriterClosed)
+	}
+
+	// Each full batch is written in one call; the concatenation of the
+	// batches is the original sequence of records.
+	if n := sink.count(); n < 5 {
+		t.Errorf("Got %d writes, want at least 5", n)
+	}
+	got := bytes.Join(sink.writes, nil)
+	if !bytes.Equal(got, want.Data.Bytes()) {
+		t.Errorf("Wrong output:\n got %q\nwant %q", got, want.Data.Bytes())
+	}
+}
+
+func TestBatchWriterDelay(t *testing.T) {
+	sink := new(batchSink)
+	w := binpack.NewBatchWriter(sink, &binpack.BatchOptions{
+		MaxDelay: 5 * time.Millisecond,
+		Tag:      99,
+	})
+	defer w.Close()
+	if err := w.Encode(1, []byte("alpha")); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	for start := time.Now(); sink.count() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Batch was not written after its delay")
+		}
+	}
+
+	// The batch is framed as a single record.
+	inner := binpack.NewEncoder(nil)
+	inner.Encode(1, []byte("alpha"))
+	want := binpack.NewEncoder(nil)
+	want.Encode(99, inner.Data.Bytes())
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if got := sink.writes[0]; !bytes.Equal(got, want.Data.Bytes()) {
+		t.Errorf("Wrong batch: got %q, want %q", got, want.Data.Bytes())
+	}
+}
+
+func TestBatchWriterError(t *testing.T) {
+	bad := errors.New("the disk is full")
+	sink := &batchSink{err: bad}
+	var reported []error
+	w := binpack.NewBatchWriter(sink, &binpack.BatchOptions{
+		OnError: func(err error) { reported = append(reported, err) },
+	})
+	w.Encode(1, []byte("lost"))
+	if err := w.Flush(); err != bad {
+		t.Errorf("Flush: got %v, want %v", err, bad)
+	}
+	if err := w.Encode(2, []byte("also lost")); err != bad {
+		t.Errorf("Encode after error: got %v, want %v", err, bad)
+	}
+	if err := w.Close(); err != bad {
+		t.Errorf("Close: got %v, want %v", err, bad)
+	}
+	if len(reported) != 1 || reported[0] != bad {
+		t.Errorf("OnError calls: got %v, want [%v]", reported, bad)
+	}
+}
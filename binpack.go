@@ -6,7 +6,7 @@
 // tag-value records. A tag is an unsigned integer value, a value is an array
 // of bytes. The tags and values are opaque to the encoding; the caller must
 // provide additional structure as needed.  For example, the application may
-// encode type information in some low-order bits of the tag.
+// encode type information in some low-order bits of the tag (see MakeTag).
 //
 // Tags are encoded as 1, 2, or 4 bytes, having values up to 2^30-1.  Values
 // are length-prefixed byte arrays up to 2^29-1 bytes in length.
@@ -43,6 +43,11 @@
 // encoded directly with a prefix of 0; otherwise the length is 1, 2, or 4
 // bytes.
 //
+// The record encoding itself is implemented by the wire subpackage, which
+// does not depend on reflection. This package re-exports its types and
+// functions, and adds reflection-based encoding of Go values (see Marshal and
+// Unmarshal).
+//
 // # Primitive Types
 //
 // Integer types are sign-extended to 64 bits and encoded using PackUint64 or
@@ -54,265 +59,205 @@
 package binpack
 
 import (
-	"bufio"
 	"bytes"
-	"encoding/binary"
-	"fmt"
+	"errors"
 	"io"
-	"math"
-	"strings"
+
+	"github.com/creachadair/binpack/wire"
 )
 
-// An Encoder encodes tag-value records to a buffer.  Call the Encode method to
-// add values. The buffer can be recovered from the Data field.
-type Encoder struct {
-	Data *bytes.Buffer
-}
+// An Encoder encodes tag-value records to a buffer or stream.
+// See wire.Encoder.
+type Encoder = wire.Encoder
+
+// A Decoder decodes tag-value pairs from an io.Reader.
+// See wire.Decoder.
+type Decoder = wire.Decoder
+
+// ByteReader is the interface required by ReadTag and ReadValue.
+type ByteReader = wire.ByteReader
+
+// A Record is a single tag-value pair.
+type Record = wire.Record
+
+// A Layout describes the sizes of the components of an encoded record.
+type Layout = wire.Layout
+
+// TrailerTag is the tag of a message trailer record, which carries the count
+// and checksum of the records before it. See wire.TrailerTag.
+const TrailerTag = wire.TrailerTag
+
+// Errors reported by this package. These are generally wrapped with more
+// detail, so use errors.Is to check for them.
+var (
+	// ErrOverBudget is reported by an Encoder when a record does not fit
+	// within its size budget. See Encoder.SetBudget.
+	ErrOverBudget = wire.ErrOverBudget
+
+	// ErrBufferFull is reported by an Encoder when a record does not fit in
+	// its fixed buffer. See NewFixedEncoder.
+	ErrBufferFull = wire.ErrBufferFull
+
+	// ErrTagTooLarge is reported for a tag outside the range 0..2^30-1.
+	ErrTagTooLarge = wire.ErrTagTooLarge
+
+	// ErrValueTooLarge is reported for a value longer than 2^29-1 bytes.
+	ErrValueTooLarge = wire.ErrValueTooLarge
+
+	// ErrTruncated is reported when the input ends partway through a record.
+	// For compatibility, errors.Is(ErrTruncated, io.ErrUnexpectedEOF) is true.
+	ErrTruncated = wire.ErrTruncated
+
+	// ErrTrailer is reported by a Decoder that verifies trailers, when a
+	// trailer is missing or does not match the records before it. See
+	// Decoder.VerifyTrailers.
+	ErrTrailer = wire.ErrTrailer
+
+	// ErrTagOrder is reported when records are required to be in tag order
+	// and a record has a smaller tag than the one before it. See
+	// Decoder.RequireTagOrder and UnmarshalOptions.StrictOrder.
+	ErrTagOrder = wire.ErrTagOrder
+
+	// ErrExtraData is reported by DecodeRecord when its input contains more
+	// than one record.
+	ErrExtraData = wire.ErrExtraData
+
+	// ErrInvalidNumber is reported when decoding a number from a value that
+	// is empty or longer than 8 bytes.
+	ErrInvalidNumber = wire.ErrInvalidNumber
+
+	// ErrUnknownField is reported when a field name or tag does not match any
+	// field of a schema or struct type.
+	ErrUnknownField = errors.New("unknown field")
+
+	// ErrNotFound is reported when a requested record is not present.
+	ErrNotFound = errors.New("record not found")
+
+	// ErrInvalidContainer is reported for data that are not a well-formed
+	// block container (see BlockWriter).
+	ErrInvalidContainer = errors.New("invalid block container")
+
+	// ErrDecrypt is reported when a block of an encrypted container cannot
+	// be decrypted, because the key is wrong or the data were modified.
+	ErrDecrypt = errors.New("block decryption failed")
+
+	// ErrWriterClosed is reported for a write to a closed BatchWriter.
+	ErrWriterClosed = errors.New("writer is closed")
+
+	// ErrDuplicateField is reported by Unmarshal for a non-sequence field
+	// whose tag occurs more than once, or a repeated map key, if the
+	// Duplicates policy of the UnmarshalOptions is RejectDuplicate.
+	ErrDuplicateField = errors.New("duplicate field")
+
+	// ErrInvalidUTF8 is reported by Unmarshal for a string value that is not
+	// valid UTF-8, if UnmarshalOptions.ValidateUTF8 is set.
+	ErrInvalidUTF8 = errors.New("invalid UTF-8 in string")
+
+	// ErrAllocLimit is reported by Unmarshal when decoding would allocate
+	// more than the limit set by UnmarshalOptions.MaxAlloc.
+	ErrAllocLimit = errors.New("allocation limit exceeded")
+
+	// ErrOutOfRange is reported by Unmarshal for an integer value that does
+	// not fit in its destination type, if UnmarshalOptions.CheckRange is set.
+	ErrOutOfRange = errors.New("value out of range")
+)
 
 // NewEncoder constructs an Encoder that writes data to buf. If buf == nil, a
 // new empty buffer is allocated and can be retrieved from the Data field of
 // the Encoder.
-func NewEncoder(buf *bytes.Buffer) *Encoder {
-	if buf == nil {
-		buf = bytes.NewBuffer(nil)
-	}
-	return &Encoder{Data: buf}
-}
+func NewEncoder(buf *bytes.Buffer) *Encoder { return wire.NewEncoder(buf) }
 
-// Encode appends a single tag-value pair to the output.
-func (e *Encoder) Encode(tag int, value []byte) error {
-	e.Data.Grow(tagSize(tag) + lengthSize(value) + len(value))
-	err := writeTag(e.Data, tag)
-	if err == nil {
-		err = writeValue(e.Data, value)
-	}
-	return err
-}
+// NewStreamEncoder constructs an Encoder that writes records directly to w
+// rather than to a buffer.
+func NewStreamEncoder(w io.Writer) *Encoder { return wire.NewStreamEncoder(w) }
 
-// tagSize returns the number of bytes needed to encode tag, or -1.
-func tagSize(tag int) int {
-	if tag < 128 {
-		return 1
-	} else if tag < (1 << 14) {
-		return 2
-	} else if tag < (1 << 30) {
-		return 4
-	}
-	return -1
-}
+// NewFixedEncoder constructs an Encoder that writes records to buf without
+// growing it beyond its capacity. See wire.NewFixedEncoder.
+func NewFixedEncoder(buf []byte) *Encoder { return wire.NewFixedEncoder(buf) }
 
-// writeTag appends the encoding of tag to w.
-func writeTag(w io.Writer, tag int) (err error) {
-	switch tagSize(tag) {
-	case 1:
-		_, err = w.Write([]byte{byte(tag)})
-	case 2:
-		_, err = w.Write([]byte{0x80 | byte(tag>>8), byte(tag & 0xff)})
-	case 4:
-		_, err = w.Write([]byte{
-			0xC0 | byte(tag>>24), byte(tag >> 16), byte(tag >> 8), byte(tag),
-		})
-	default:
-		return fmt.Errorf("tag too big (%d > %d)", tag, 1<<30-1)
-	}
-	return
-}
+// NewDecoder constructs a Decoder that reads records from r.
+func NewDecoder(r io.Reader) *Decoder { return wire.NewDecoder(r) }
 
-// lengthSize returns the number of bytes to encode the length of value, or -1.
-func lengthSize(value []byte) int {
-	n := len(value)
-	if n == 1 && value[0] < 128 {
-		return 0
-	} else if n < (1 << 6) {
-		return 1
-	} else if n < (1 << 13) {
-		return 2
-	} else if n < (1 << 29) {
-		return 4
-	}
-	return -1
-}
+// NewBytesDecoder constructs a Decoder that reads records from data.
+// See wire.NewBytesDecoder.
+func NewBytesDecoder(data []byte) *Decoder { return wire.NewBytesDecoder(data) }
 
-// writeValue writes the encoding of value to w.
-func writeValue(w io.Writer, value []byte) error {
-	n := len(value)
-	var err error
-	switch lengthSize(value) {
-	case 0:
-		_, err := w.Write([]byte{value[0]})
-		return err
-	case 1:
-		_, err = w.Write([]byte{0x80 | byte(n)})
-	case 2:
-		_, err = w.Write([]byte{0xC0 | byte(n>>8), byte(n)})
-	case 4:
-		_, err = w.Write([]byte{0xE0 | byte(n>>24), byte(n >> 16), byte(n >> 8), byte(n)})
-	default:
-		return fmt.Errorf("value too big (%d bytes > %d)", len(value), 1<<29-1)
-	}
-	if err == nil {
-		_, err = w.Write(value)
-	}
-	return err
-}
+// EncodeRecord returns the encoding of r. See wire.EncodeRecord.
+func EncodeRecord(r Record) ([]byte, error) { return wire.EncodeRecord(r) }
 
-// A Decoder decodes tag-value pairs from an io.Reader.
-type Decoder struct {
-	buf bufReader
-}
+// DecodeRecord decodes data, which must contain exactly one complete record.
+// See wire.DecodeRecord.
+func DecodeRecord(data []byte) (Record, error) { return wire.DecodeRecord(data) }
 
-// NewDecoder constructs a Decoder that reads records from r.
-func NewDecoder(r io.Reader) *Decoder {
-	switch t := r.(type) {
-	case *bytes.Buffer, *bytes.Reader, *strings.Reader:
-		return &Decoder{buf: t.(bufReader)}
-	case *bufio.Reader:
-		return &Decoder{buf: t}
-	default:
-		return &Decoder{buf: bufio.NewReader(r)}
-	}
+// AppendRecord appends the encoding of a record to buf. See wire.AppendRecord.
+func AppendRecord(buf []byte, tag int, value []byte) ([]byte, error) {
+	return wire.AppendRecord(buf, tag, value)
 }
 
-// Decode returns the next tag-value record from the reader.
-// At the end of the input, it returns io.EOF.
-func (d *Decoder) Decode() (int, []byte, error) {
-	tag, err := readTag(d.buf)
-	if err != nil {
-		return 0, nil, err
-	}
-	value, err := readValue(d.buf)
-	if err != nil {
-		return tag, nil, err
-	}
-	return tag, value, err
-}
+// WriteTag writes the encoding of tag to w. See wire.WriteTag.
+func WriteTag(w io.Writer, tag int) error { return wire.WriteTag(w, tag) }
 
-type bufReader interface {
-	io.Reader
-	io.ByteReader
-}
+// ReadTag reads an encoded tag from the current position of buf.
+func ReadTag(buf ByteReader) (int, error) { return wire.ReadTag(buf) }
 
-// readTag reads a tag from the current position of the decoder.
-func readTag(buf bufReader) (int, error) {
-	b, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	switch v := b >> 6; v {
-	case 0, 1:
-		return int(b), nil
-	case 2:
-		c, err := buf.ReadByte()
-		if err != nil {
-			return 0, err
-		}
-		return int(b&0x3f)<<8 | int(c), nil
-	default:
-		z, err := readInt24(buf)
-		if err != nil {
-			return 0, err
-		}
-		return int(b&0x3f)<<24 | z, nil
-	}
-}
+// WriteValue writes the encoding of value, including its length prefix, to w.
+// See wire.WriteValue.
+func WriteValue(w io.Writer, value []byte) error { return wire.WriteValue(w, value) }
 
-// readValue reads a value from the current position of the decoder.
-func readValue(buf bufReader) ([]byte, error) {
-	b, err := buf.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-	var n int
-	if v := b >> 5; v < 4 {
-		// index with 1-byte value; no additional data bytes
-		return []byte{b}, nil
-	} else if v < 6 {
-		// index + data
-		n = int(b & 0x3f)
-	} else if v == 6 {
-		// index + 2 + data
-		c, err := buf.ReadByte()
-		if err != nil {
-			return nil, err
-		}
-		n = int(b&0x1f)<<8 | int(c)
-	} else {
-		// index + 3 + data
-		n, err = readInt24(buf)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Now n is the number of data bytes we need to read.
-	data := make([]byte, n)
-	if _, err := io.ReadFull(buf, data); err != nil {
-		return nil, err
-	}
-	return data, nil
-}
+// ReadValue reads an encoded value, including its length prefix, from the
+// current position of buf.
+func ReadValue(buf ByteReader) ([]byte, error) { return wire.ReadValue(buf) }
+
+// Split splits data into a slice of complete tag-value records.
+// See wire.Split.
+func Split(data []byte) ([][]byte, error) { return wire.Split(data) }
+
+// AppendOffsets appends to dst the offset of each complete record in data.
+// See wire.AppendOffsets.
+func AppendOffsets(dst []int, data []byte) ([]int, error) { return wire.AppendOffsets(dst, data) }
 
-// readInt24 reads three bytes from the input and decodes the value as an
-// unsigned integer in big-endian order.
-func readInt24(buf bufReader) (int, error) {
-	var data [3]byte
-	if _, err := io.ReadFull(buf, data[:]); err != nil {
-		return 0, err
-	}
-	return int(data[0])<<16 | int(data[1])<<8 | int(data[2]), nil
+// ScanRecords is a split function for a bufio.Scanner that returns each
+// complete tag-value record as a token. See wire.ScanRecords.
+func ScanRecords(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	return wire.ScanRecords(data, atEOF)
 }
 
+// A RecordScanner reads tag-value records from an io.Reader using a
+// bufio.Scanner. See wire.RecordScanner.
+type RecordScanner = wire.RecordScanner
+
+// NewRecordScanner constructs a RecordScanner that reads records from r.
+func NewRecordScanner(r io.Reader) *RecordScanner { return wire.NewRecordScanner(r) }
+
 // PackUint64 encodes z as a slice in big-endian order, omitting leading zeroes.
-// The encoding of 0 is a slice of length 1.
-func PackUint64(z uint64) []byte {
-	var buf [8]byte
-	binary.BigEndian.PutUint64(buf[:], z)
-	for i, b := range buf {
-		if b != 0 {
-			return buf[i:]
-		}
-	}
-	return buf[:1]
-}
+// See wire.PackUint64.
+func PackUint64(z uint64) []byte { return wire.PackUint64(z) }
 
 // UnpackUint64 decodes z from a big-endian slice.
-func UnpackUint64(data []byte) uint64 {
-	var z uint64
-	for _, b := range data {
-		z = (z << 8) | uint64(b)
-	}
-	return z
-}
+func UnpackUint64(data []byte) uint64 { return wire.UnpackUint64(data) }
 
 // PackInt64 encodes z as a slice in big-endian order with zigzag encoding,
-// omitting leading zeroes. The encoding of 0 is a slice of length 1.
-//
-// Zigzag encoding represents a signed value as the bitwise complement of its
-// 2s complement value, with its sign in the least-significant bit.
-func PackInt64(z int64) []byte {
-	u := uint64(z<<1) ^ uint64(z>>63)
-	return PackUint64(u)
-}
+// omitting leading zeroes. See wire.PackInt64.
+func PackInt64(z int64) []byte { return wire.PackInt64(z) }
 
 // UnpackInt64 decodes z from a big-endian slice with zigzag encoding.
-func UnpackInt64(data []byte) int64 {
-	z := UnpackUint64(data)
-	mask := math.MaxUint64 + (1 - z&1)
-	return int64(mask ^ z>>1)
-}
+func UnpackInt64(data []byte) int64 { return wire.UnpackInt64(data) }
+
+// PackFloat64 encodes v as PackUint64 of its IEEE 754 representation.
+func PackFloat64(v float64) []byte { return wire.PackFloat64(v) }
+
+// UnpackFloat64 decodes a float64 encoded by PackFloat64.
+func UnpackFloat64(data []byte) float64 { return wire.UnpackFloat64(data) }
 
-// PackFloat64 encodes v by converting it to a uint64 in IEEE 754
-// representation and encoding that value as PackUint64.
-func PackFloat64(v float64) []byte { return PackUint64(math.Float64bits(v)) }
+// PackFloat32 encodes v as PackUint64 of its IEEE 754 representation.
+func PackFloat32(v float32) []byte { return wire.PackFloat32(v) }
 
-// UnpackFloat64 decodes data as a uint64 in IEEE 754 representation, and
-// converts that representation back to a float64.
-func UnpackFloat64(data []byte) float64 { return math.Float64frombits(UnpackUint64(data)) }
+// UnpackFloat32 decodes a float32 encoded by PackFloat32.
+func UnpackFloat32(data []byte) float32 { return wire.UnpackFloat32(data) }
 
-// PackFloat32 encodes v by converting it to a uint64 in IEEE 754
-// representation and encoding that value as PackUint64.
-func PackFloat32(v float32) []byte { return PackUint64(uint64(math.Float32bits(v))) }
+// PackFloat16 encodes v as PackUint64 of its IEEE 754 half-precision
+// representation. See wire.PackFloat16.
+func PackFloat16(v float32) []byte { return wire.PackFloat16(v) }
 
-// UnpackFloat32 decodes data as a uint64 in IEEE 754 representation, and
-// converts that representation back to a float32.
-func UnpackFloat32(data []byte) float32 { return math.Float32frombits(uint32(UnpackUint64(data))) }
+// UnpackFloat16 decodes a float32 encoded by PackFloat16.
+func UnpackFloat16(data []byte) float32 { return wire.UnpackFloat16(data) }
@@ -4,9 +4,15 @@ package binpack_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/creachadair/binpack"
 	"github.com/google/go-cmp/cmp"
@@ -191,3 +197,1346 @@ func TestMarshalRoundTrip(t *testing.T) {
 		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
 	}
 }
+
+func TestWriteToReadFrom(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("apple"))
+	e.Encode(200, []byte("pear"))
+	e.Encode(3, nil)
+	want := e.Data.String()
+
+	var buf bytes.Buffer
+	if n, err := e.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	} else if int(n) != len(want) {
+		t.Errorf("WriteTo: got %d bytes, want %d", n, len(want))
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTo: got %q, want %q", got, want)
+	}
+
+	cp := binpack.NewEncoder(nil)
+	if n, err := cp.ReadFrom(strings.NewReader(want)); err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	} else if int(n) != len(want) {
+		t.Errorf("ReadFrom: got %d bytes, want %d", n, len(want))
+	}
+	if got := cp.Data.String(); got != want {
+		t.Errorf("ReadFrom: got %q, want %q", got, want)
+	}
+
+	var msg binpack.Message
+	if n, err := msg.ReadFrom(strings.NewReader(want)); err != nil {
+		t.Fatalf("Message.ReadFrom: %v", err)
+	} else if int(n) != len(want) {
+		t.Errorf("Message.ReadFrom: got %d bytes, want %d", n, len(want))
+	} else if len(msg) != 3 {
+		t.Errorf("Message.ReadFrom: got %d records, want 3", len(msg))
+	}
+	buf.Reset()
+	if n, err := msg.WriteTo(&buf); err != nil {
+		t.Fatalf("Message.WriteTo: %v", err)
+	} else if int(n) != len(want) {
+		t.Errorf("Message.WriteTo: got %d bytes, want %d", n, len(want))
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("Message.WriteTo: got %q, want %q", got, want)
+	}
+}
+
+func TestMessageReadFromCount(t *testing.T) {
+	tests := []struct {
+		input   string
+		records int
+		fail    bool
+	}{
+		{"", 0, false},
+		{"\x01\xc0\x01a", 1, false},          // non-minimal length prefix
+		{"\x02\x81x\x01\xc0\x01a", 2, false}, // minimal and non-minimal
+		{"\x02\x81x\x01\x83ab", 1, true},     // truncated final record
+	}
+	for _, test := range tests {
+		var msg binpack.Message
+		n, err := msg.ReadFrom(strings.NewReader(test.input))
+		if (err != nil) != test.fail {
+			t.Errorf("ReadFrom(%q): got error %v, want failure %v", test.input, err, test.fail)
+		}
+		if int(n) != len(test.input) {
+			t.Errorf("ReadFrom(%q): got %d bytes, want %d", test.input, n, len(test.input))
+		}
+		if len(msg) != test.records {
+			t.Errorf("ReadFrom(%q): got %d records, want %d", test.input, len(msg), test.records)
+		}
+	}
+}
+
+func TestTagValuePrimitives(t *testing.T) {
+	var buf bytes.Buffer
+	if err := binpack.WriteTag(&buf, 300); err != nil {
+		t.Fatalf("WriteTag: %v", err)
+	}
+	if err := binpack.WriteValue(&buf, []byte("hello")); err != nil {
+		t.Fatalf("WriteValue: %v", err)
+	}
+	if got, want := buf.String(), "\x81\x2c\x85hello"; got != want {
+		t.Errorf("Encoded: got %q, want %q", got, want)
+	}
+
+	if tag, err := binpack.ReadTag(&buf); err != nil || tag != 300 {
+		t.Errorf("ReadTag: got %d, %v; want 300, nil", tag, err)
+	}
+	if value, err := binpack.ReadValue(&buf); err != nil || string(value) != "hello" {
+		t.Errorf("ReadValue: got %q, %v; want hello, nil", value, err)
+	}
+
+	for _, tag := range []int{-1, 1 << 30} {
+		if err := binpack.WriteTag(&buf, tag); err == nil {
+			t.Errorf("WriteTag(%d): got nil, want error", tag)
+		}
+	}
+}
+
+// failWriter accepts up to n bytes, then fails all further writes.
+type failWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (f *failWriter) Write(data []byte) (int, error) {
+	if f.Len()+len(data) > f.n {
+		return 0, errWriteFailed
+	}
+	return f.Buffer.Write(data)
+}
+
+func TestEncoderStickyError(t *testing.T) {
+	w := &failWriter{n: 8}
+	e := binpack.NewStreamEncoder(w)
+
+	if err := e.Encode(1, []byte("abc")); err != nil {
+		t.Fatalf("Encode 1: unexpected error: %v", err)
+	}
+
+	// Range errors are reported, but are not sticky.
+	if err := e.Encode(-1, nil); err == nil {
+		t.Error("Encode(-1): got nil, want error")
+	}
+	if err := e.Err(); err != nil {
+		t.Errorf("Err: got %v, want nil", err)
+	}
+
+	// Write errors are sticky.
+	if err := e.Encode(2, []byte("defgh")); err != errWriteFailed {
+		t.Errorf("Encode 2: got %v, want %v", err, errWriteFailed)
+	}
+	w.n = 100 // subsequent writes would succeed, but should not be attempted
+	if err := e.Encode(3, []byte("i")); err != errWriteFailed {
+		t.Errorf("Encode 3: got %v, want %v", err, errWriteFailed)
+	}
+	if err := e.Err(); err != errWriteFailed {
+		t.Errorf("Err: got %v, want %v", err, errWriteFailed)
+	}
+	// Each small record is written in a single call, so the failed record
+	// was not written at all.
+	if got, want := w.String(), "\x01\x83abc"; got != want {
+		t.Errorf("Output: got %q, want %q", got, want)
+	}
+}
+
+// countWriter counts the calls to its Write method.
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countWriter) Write(data []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(data)
+}
+
+func TestStreamEncoderWrites(t *testing.T) {
+	tests := []struct {
+		tag    int
+		value  []byte
+		writes int
+	}{
+		{1, nil, 1},
+		{2, []byte("x"), 1},
+		{300, []byte("apple"), 1},
+		{1 << 20, bytes.Repeat([]byte("p"), 4096), 1},
+		{5, bytes.Repeat([]byte("q"), 10000), 2}, // large values are not copied
+	}
+	for _, test := range tests {
+		var w countWriter
+		if err := binpack.NewStreamEncoder(&w).Encode(test.tag, test.value); err != nil {
+			t.Fatalf("Encode(%d, ...) failed: %v", test.tag, err)
+		}
+		if w.writes != test.writes {
+			t.Errorf("Encode(%d, ...): got %d writes, want %d", test.tag, w.writes, test.writes)
+		}
+		want := binpack.NewEncoder(nil)
+		want.Encode(test.tag, test.value)
+		if !bytes.Equal(w.Bytes(), want.Data.Bytes()) {
+			t.Errorf("Encode(%d, ...): stream and buffer output differ", test.tag)
+		}
+	}
+}
+
+func TestEncodeGroup(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("a"))
+
+	// A failed group leaves no trace in the output.
+	errStop := errors.New("stop")
+	if err := e.EncodeGroup(func(g *binpack.Encoder) error {
+		g.Encode(2, []byte("b"))
+		return errStop
+	}); err != errStop {
+		t.Errorf("EncodeGroup: got %v, want %v", err, errStop)
+	}
+
+	// A successful group is written completely.
+	if err := e.EncodeGroup(func(g *binpack.Encoder) error {
+		g.Encode(3, []byte("c"))
+		return g.Encode(4, []byte("d"))
+	}); err != nil {
+		t.Errorf("EncodeGroup: unexpected error: %v", err)
+	}
+	if got, want := e.Data.String(), "\x01a\x03c\x04d"; got != want {
+		t.Errorf("Output: got %q, want %q", got, want)
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	var w countWriter
+	e := binpack.NewStreamEncoder(&w)
+
+	// An invalid record prevents the whole group from being written.
+	err := e.EncodeRecords(
+		binpack.Record{Tag: 1, Value: []byte("a")},
+		binpack.Record{Tag: 1 << 30},
+	)
+	if !errors.Is(err, binpack.ErrTagTooLarge) {
+		t.Errorf("EncodeRecords: got %v, want %v", err, binpack.ErrTagTooLarge)
+	}
+	if err := e.Err(); err != nil {
+		t.Errorf("Err: got %v, want nil", err)
+	}
+
+	// A valid group is written in a single call.
+	if err := e.EncodeAll([]binpack.Record{
+		{Tag: 2, Value: []byte("b")},
+		{Tag: 300, Value: []byte("cde")},
+		{Tag: 4},
+	}); err != nil {
+		t.Fatalf("EncodeAll failed: %v", err)
+	}
+	if err := e.EncodeAll(nil); err != nil {
+		t.Errorf("EncodeAll(nil): unexpected error: %v", err)
+	}
+	if got, want := w.String(), "\x02b\x81\x2c\x83cde\x04\x80"; got != want {
+		t.Errorf("Output: got %q, want %q", got, want)
+	}
+	if w.writes != 1 {
+		t.Errorf("Writes: got %d, want 1", w.writes)
+	}
+}
+
+func TestEncoderBudget(t *testing.T) {
+	var msgs []string
+	e := binpack.NewEncoder(nil)
+	e.SetBudget(10, func(msg []byte) error {
+		msgs = append(msgs, string(msg))
+		return nil
+	})
+	for i, s := range []string{"abc", "de", "fghij", "k", "lmnopq"} {
+		if err := e.Encode(i+1, []byte(s)); err != nil {
+			t.Fatalf("Encode %q: unexpected error: %v", s, err)
+		}
+	}
+	msgs = append(msgs, e.Data.String())
+	want := []string{"\x01\x83abc\x02\x82de", "\x03\x85fghij\x04k", "\x05\x86lmnopq"}
+	if diff := cmp.Diff(want, msgs); diff != "" {
+		t.Errorf("Messages (-want, +got):\n%s", diff)
+	}
+
+	// A record that does not fit by itself is rejected.
+	if err := e.Encode(6, []byte("0123456789")); err != binpack.ErrOverBudget {
+		t.Errorf("Encode: got %v, want %v", err, binpack.ErrOverBudget)
+	}
+
+	// Without a split function, exceeding the budget is an error.
+	e = binpack.NewEncoder(nil)
+	e.SetBudget(5, nil)
+	if err := e.Encode(1, []byte("ab")); err != nil {
+		t.Errorf("Encode: unexpected error: %v", err)
+	}
+	if err := e.Encode(2, []byte("cd")); err != binpack.ErrOverBudget {
+		t.Errorf("Encode: got %v, want %v", err, binpack.ErrOverBudget)
+	}
+	if got, want := e.Data.String(), "\x01\x82ab"; got != want {
+		t.Errorf("Output: got %q, want %q", got, want)
+	}
+}
+
+func TestMarshalExactSize(t *testing.T) {
+	type thing struct {
+		Name  string   `binpack:"tag=1"`
+		Blob  []byte   `binpack:"tag=300"`
+		Parts []string `binpack:"tag=20000"`
+	}
+	in := &thing{
+		Name:  "sizable",
+		Blob:  bytes.Repeat([]byte("blob"), 5000),
+		Parts: []string{"a", "bb", strings.Repeat("c", 100)},
+	}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(bits) != cap(bits) {
+		t.Errorf("Marshal output has len %d, cap %d; want equal", len(bits), cap(bits))
+	}
+}
+
+func TestMarshalSizes(t *testing.T) {
+	type inner struct {
+		Text string `binpack:"tag=1"`
+	}
+	type thing struct {
+		Name  string   `binpack:"tag=1"`
+		Sub   *inner   `binpack:"tag=2"`
+		Parts []string `binpack:"tag=300"`
+	}
+	in := &thing{
+		Name:  "apple",
+		Sub:   &inner{Text: strings.Repeat("x", 100)},
+		Parts: []string{"a", "bc"},
+	}
+	opts := &binpack.MarshalOptions{Sizes: make(map[int]int)}
+	bits, err := opts.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := map[int]int{
+		1:   1 + 1 + 5,             // tag, prefix, "apple"
+		2:   1 + 2 + (1 + 2 + 100), // tag, prefix, nested record
+		300: (2 + 1) + (2 + 1 + 2), // two records
+	}
+	if diff := cmp.Diff(want, opts.Sizes); diff != "" {
+		t.Errorf("Sizes differ (-want, +got):\n%s", diff)
+	}
+	var total int
+	for _, n := range opts.Sizes {
+		total += n
+	}
+	if total != len(bits) {
+		t.Errorf("Total size is %d, want %d", total, len(bits))
+	}
+}
+
+type smallThing struct {
+	OK    bool    `binpack:"tag=1"`
+	Byte  byte    `binpack:"tag=2"`
+	Count uint32  `binpack:"tag=3"`
+	Delta int     `binpack:"tag=4"`
+	Small int8    `binpack:"tag=5"`
+	Ratio float64 `binpack:"tag=6"`
+	Frac  float32 `binpack:"tag=7"`
+	Name  string  `binpack:"tag=200"`
+	Data  []byte  `binpack:"tag=20000"`
+	Skip  int
+}
+
+var smallInput = &smallThing{
+	OK: true, Byte: 200, Count: 65537, Delta: -25, Small: 3,
+	Ratio: 0.5, Frac: -1.25, Name: "small", Data: []byte("thing"),
+}
+
+func TestMarshalSmall(t *testing.T) {
+	tests := []*smallThing{
+		smallInput,
+		{},
+		{Name: "x", Byte: 5},
+		{Data: []byte{}, Delta: 1 << 40},
+	}
+	for _, in := range tests {
+		got, err := binpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %+v failed: %v", in, err)
+		}
+
+		// Requesting sizes uses the general path, whose output must match.
+		opts := &binpack.MarshalOptions{Sizes: make(map[int]int)}
+		want, err := opts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %+v with sizes failed: %v", in, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Marshal %+v: got %q, want %q", in, got, want)
+		}
+
+		var out smallThing
+		if err := binpack.Unmarshal(got, &out); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if diff := cmp.Diff(in, &out); diff != "" {
+			t.Errorf("Round trip differs (-want, +got):\n%s", diff)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() { binpack.Marshal(smallInput) })
+	if allocs > 1 {
+		t.Errorf("Marshal allocated %.1f times, want at most 1", allocs)
+	}
+}
+
+// flatKinds has a field of each type supported by the fast path of Marshal.
+type flatKinds struct {
+	Bool  bool    `binpack:"tag=1"`
+	U8    uint8   `binpack:"tag=2"`
+	U16   uint16  `binpack:"tag=3"`
+	U32   uint32  `binpack:"tag=4"`
+	U64   uint64  `binpack:"tag=5"`
+	Int   int     `binpack:"tag=6"`
+	I8    int8    `binpack:"tag=7"`
+	I16   int16   `binpack:"tag=8"`
+	I32   int32   `binpack:"tag=9"`
+	I64   int64   `binpack:"tag=10"`
+	F32   float32 `binpack:"tag=11"`
+	F64   float64 `binpack:"tag=12"`
+	Str   string  `binpack:"tag=13"`
+	Bytes []byte  `binpack:"tag=14"`
+}
+
+func TestMarshalFlatKinds(t *testing.T) {
+	negZero := math.Copysign(0, -1)
+	tests := []*flatKinds{
+		{}, // all zero
+		{F32: float32(negZero), F64: negZero, Bytes: []byte{}},
+		{
+			Bool: true, U8: 1, U16: 2, U32: 3, U64: 4, Int: -5, I8: -6, I16: -7, I32: -8, I64: -9,
+			F32: -0.5, F64: 1e300, Str: "s", Bytes: []byte("b"),
+		},
+		{
+			U8: math.MaxUint8, U16: math.MaxUint16, U32: math.MaxUint32, U64: math.MaxUint64,
+			Int: math.MinInt64, I8: math.MinInt8, I16: math.MinInt16, I32: math.MinInt32, I64: math.MaxInt64,
+			F32: float32(math.Inf(1)), F64: math.SmallestNonzeroFloat64,
+		},
+	}
+	for _, in := range tests {
+		got, err := binpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %+v failed: %v", in, err)
+		}
+		opts := &binpack.MarshalOptions{Sizes: make(map[int]int)}
+		want, err := opts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal %+v with sizes failed: %v", in, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Marshal %+v: fast path %q, general path %q", in, got, want)
+		}
+	}
+}
+
+func BenchmarkMarshalSmall(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := binpack.Marshal(smallInput); err != nil {
+			b.Fatalf("Marshal failed: %v", err)
+		}
+	}
+}
+
+func TestDecoderPeek(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("apple"))
+	e.Encode(200, []byte("x"))
+	e.Encode(3, nil)
+
+	d := binpack.NewDecoder(bytes.NewReader(e.Data.Bytes()))
+	for _, want := range []struct {
+		tag   int
+		value string
+	}{{1, "apple"}, {200, "x"}, {3, ""}} {
+		// Peeking repeatedly does not consume the record.
+		for i := 0; i < 2; i++ {
+			tag, n, err := d.Peek()
+			if err != nil {
+				t.Fatalf("Peek failed: %v", err)
+			}
+			if tag != want.tag || n != len(want.value) {
+				t.Errorf("Peek: got tag %d, len %d; want %d, %d", tag, n, want.tag, len(want.value))
+			}
+		}
+		tag, value, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if tag != want.tag || string(value) != want.value {
+			t.Errorf("Decode: got (%d, %q), want (%d, %q)", tag, value, want.tag, want.value)
+		}
+	}
+	if tag, n, err := d.Peek(); err != io.EOF {
+		t.Errorf("Peek: got (%d, %d, %v), want EOF", tag, n, err)
+	}
+}
+
+func TestDecoderMore(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	for i := 1; i <= 5; i++ {
+		e.Encode(i, bytes.Repeat([]byte("x"), i))
+	}
+	d := binpack.NewDecoder(bytes.NewReader(e.Data.Bytes()))
+	for d.More() {
+		tag, value, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if len(value) != tag {
+			t.Errorf("Decode: got tag %d, value %q", tag, value)
+		}
+		if got := d.Count(); got != tag {
+			t.Errorf("Count: got %d, want %d", got, tag)
+		}
+	}
+	if got := d.Count(); got != 5 {
+		t.Errorf("Final count: got %d, want 5", got)
+	}
+	if _, _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode: got err=%v, want EOF", err)
+	}
+}
+
+func TestDecoderSkipUntil(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("header"))
+	e.Encode(2, bytes.Repeat([]byte("skip"), 1000))
+	e.Encode(3, []byte("z"))
+	e.Encode(4, []byte("section"))
+	e.Encode(5, []byte("trailer"))
+
+	for _, d := range []*binpack.Decoder{
+		binpack.NewDecoder(bytes.NewReader(e.Data.Bytes())),
+		binpack.NewDecoder(io.MultiReader(bytes.NewReader(e.Data.Bytes()))), // via bufio
+		binpack.NewBytesDecoder(e.Data.Bytes()),
+	} {
+		value, err := d.SkipUntil(4)
+		if err != nil {
+			t.Fatalf("SkipUntil(4) failed: %v", err)
+		} else if got := string(value); got != "section" {
+			t.Errorf("SkipUntil(4): got %q, want %q", got, "section")
+		}
+		if tag, value, err := d.Decode(); err != nil || tag != 5 {
+			t.Errorf("Decode: got (%d, %q, %v), want tag 5", tag, value, err)
+		}
+		if value, err := d.SkipUntil(1); err != io.EOF {
+			t.Errorf("SkipUntil(1): got (%q, %v), want EOF", value, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	type thing struct {
+		N int `binpack:"tag=1"`
+	}
+	decode := func(input string) error {
+		d := binpack.NewDecoder(strings.NewReader(input))
+		for {
+			_, _, err := d.Decode()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	decodeBytes := func(input string) error {
+		d := binpack.NewBytesDecoder([]byte(input))
+		for {
+			_, _, err := d.Decode()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Encode tag", binpack.NewEncoder(nil).Encode(1<<30, nil), binpack.ErrTagTooLarge},
+		{"Encode negative tag", binpack.NewEncoder(nil).Encode(-1, nil), binpack.ErrTagTooLarge},
+		{"Decode after tag", decode("\x01"), binpack.ErrTruncated},
+		{"Decode in tag", decode("\x01\x00\x80"), binpack.ErrTruncated},
+		{"Decode in prefix", decode("\x01\xE0\x00"), binpack.ErrTruncated},
+		{"Decode in value", decode("\x01\x85abc"), binpack.ErrTruncated},
+		{"Decode compat", decode("\x01\x85abc"), io.ErrUnexpectedEOF},
+		{"Bytes decode in tag", decodeBytes("\x01\x00\x80"), binpack.ErrTruncated},
+		{"Bytes decode in value", decodeBytes("\x01\x85abc"), binpack.ErrTruncated},
+		{"Unmarshal truncated", binpack.Unmarshal([]byte("\x01\x85abc"), new(thing)), binpack.ErrTruncated},
+		{"Unmarshal number", binpack.Unmarshal([]byte("\x01\x89123456789"), new(thing)), binpack.ErrInvalidNumber},
+		{"Split", func() error { _, err := binpack.Split([]byte("\x01")); return err }(), binpack.ErrTruncated},
+	}
+	if !testing.Short() {
+		// This case allocates a value larger than MaxValueLen (512MiB).
+		tests = append(tests, struct {
+			name string
+			err  error
+			want error
+		}{"Encode value", binpack.NewEncoder(nil).Encode(1, make([]byte, 1<<29)), binpack.ErrValueTooLarge})
+	}
+	if s, err := binpack.SchemaOf(thing{}); err != nil {
+		t.Fatalf("SchemaOf failed: %v", err)
+	} else {
+		err := binpack.NewDynamicMessage(s).SetByName("Nonesuch", 1)
+		tests = append(tests, struct {
+			name string
+			err  error
+			want error
+		}{"SetByName", err, binpack.ErrUnknownField})
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, test.err, test.want)
+		}
+	}
+}
+
+func TestBytesDecoder(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("apple"))
+	e.Encode(200, []byte("x"))
+	e.Encode(3, nil)
+	e.Encode(20000, bytes.Repeat([]byte("pear"), 100))
+	e.Encode(1<<20, bytes.Repeat([]byte("plum"), 2100))
+	input := e.Data.Bytes()
+
+	type result struct {
+		Tag   int
+		Value []byte
+		Err   error
+	}
+	decodeAll := func(d *binpack.Decoder) []result {
+		var out []result
+		for {
+			tag, value, err := d.Decode()
+			out = append(out, result{tag, value, err})
+			if err != nil {
+				return out
+			}
+		}
+	}
+
+	// Every prefix of the input, complete or not, should decode the same way
+	// as from a reader. Beyond the length prefix of the last record, the
+	// prefixes differ only in how much of its value is present.
+	for n := 0; n <= len(input); n++ {
+		if n > 500 && n < len(input)-10 {
+			n = len(input) - 10
+		}
+		want := decodeAll(binpack.NewDecoder(bytes.NewReader(input[:n])))
+		got := decodeAll(binpack.NewBytesDecoder(input[:n]))
+		if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+			t.Fatalf("Prefix %d: decode differs (-want, +got):\n%s", n, diff)
+		}
+	}
+
+	// Decoded values do not alias the input.
+	data := append([]byte(nil), input...)
+	_, value, err := binpack.NewBytesDecoder(data).Decode()
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	copy(data, bytes.Repeat([]byte("?"), len(data)))
+	if got := string(value); got != "apple" {
+		t.Errorf("Decode: got %q after modifying input, want %q", got, "apple")
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	e := binpack.NewEncoder(nil)
+	for i := 0; i < 1000; i++ {
+		e.Encode(i, bytes.Repeat([]byte("v"), i%100))
+	}
+	input := e.Data.Bytes()
+	run := func(b *testing.B, newDecoder func() *binpack.Decoder) {
+		b.SetBytes(int64(len(input)))
+		for i := 0; i < b.N; i++ {
+			d := newDecoder()
+			for d.More() {
+				if _, _, err := d.Decode(); err != nil {
+					b.Fatalf("Decode failed: %v", err)
+				}
+			}
+		}
+	}
+	b.Run("Reader", func(b *testing.B) {
+		run(b, func() *binpack.Decoder { return binpack.NewDecoder(bytes.NewReader(input)) })
+	})
+	b.Run("Bytes", func(b *testing.B) {
+		run(b, func() *binpack.Decoder { return binpack.NewBytesDecoder(input) })
+	})
+}
+
+func TestDecodeBatch(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	var want []binpack.Record
+	for i := 1; i <= 10; i++ {
+		value := bytes.Repeat([]byte{byte('a' + i)}, i%4)
+		e.Encode(i, value)
+		want = append(want, binpack.Record{Tag: i, Value: value})
+	}
+	input := e.Data.Bytes()
+
+	for _, d := range []*binpack.Decoder{
+		binpack.NewDecoder(bytes.NewReader(input)),
+		binpack.NewBytesDecoder(input),
+	} {
+		var got []binpack.Record
+		buf := make([]binpack.Record, 8)
+		for _, max := range []int{3, 4, 0} {
+			n, err := d.DecodeBatch(buf, max)
+			if err != nil {
+				t.Fatalf("DecodeBatch(%d) failed: %v", max, err)
+			}
+			got = append(got, buf[:n]...)
+		}
+		if n, err := d.DecodeBatch(buf, 0); err != io.EOF {
+			t.Errorf("DecodeBatch at end: got (%d, %v), want EOF", n, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Records differ (-want, +got):\n%s", diff)
+		}
+		if n := d.Count(); n != len(want) {
+			t.Errorf("Count: got %d, want %d", n, len(want))
+		}
+
+		// Values within a batch do not overlap.
+		got[1].Value = append(got[1].Value, '!')
+		if v := string(got[2].Value); v != "ddd" {
+			t.Errorf("Value 3 after appending to value 2: got %q, want %q", v, "ddd")
+		}
+	}
+
+	// A truncated record ends the batch with an error, after the records
+	// that precede it.
+	for _, d := range []*binpack.Decoder{
+		binpack.NewDecoder(bytes.NewReader(input[:len(input)-1])),
+		binpack.NewBytesDecoder(input[:len(input)-1]),
+	} {
+		buf := make([]binpack.Record, 20)
+		n, err := d.DecodeBatch(buf, 0)
+		if !errors.Is(err, binpack.ErrTruncated) || n != len(want)-1 {
+			t.Errorf("DecodeBatch: got (%d, %v), want (%d, %v)", n, err, len(want)-1, binpack.ErrTruncated)
+		}
+	}
+}
+
+func TestFixedEncoder(t *testing.T) {
+	buf := make([]byte, 0, 14)
+	e := binpack.NewFixedEncoder(buf)
+	if e.Data != nil {
+		t.Errorf("Data: got %v, want nil", e.Data)
+	}
+	if err := e.Encode(1, []byte("abc")); err != nil {
+		t.Fatalf("Encode 1 failed: %v", err)
+	}
+
+	// A record that does not fit is not written, and the error is not sticky.
+	if err := e.Encode(2, []byte("defghijk")); !errors.Is(err, binpack.ErrBufferFull) {
+		t.Errorf("Encode 2: got %v, want %v", err, binpack.ErrBufferFull)
+	}
+	if err := e.Err(); err != nil {
+		t.Errorf("Err: got %v, want nil", err)
+	}
+	if err := e.Encode(3, []byte("defgh")); err != nil {
+		t.Fatalf("Encode 3 failed: %v", err)
+	}
+	if err := e.WriteRecords([]byte("\x04\x81z")); !errors.Is(err, binpack.ErrBufferFull) {
+		t.Errorf("WriteRecords: got %v, want %v", err, binpack.ErrBufferFull)
+	}
+	if err := e.Encode(4, nil); err != nil {
+		t.Fatalf("Encode 4 failed: %v", err)
+	}
+
+	const want = "\x01\x83abc\x03\x85defgh\x04\x80"
+	if got := string(e.Bytes()); got != want {
+		t.Errorf("Bytes: got %q, want %q", got, want)
+	}
+	if got := string(buf[:len(want)]); got != want {
+		t.Errorf("Buffer: got %q, want %q", got, want)
+	}
+	if err := e.Encode(5, nil); !errors.Is(err, binpack.ErrBufferFull) {
+		t.Errorf("Encode 5: got %v, want %v", err, binpack.ErrBufferFull)
+	}
+}
+
+func TestMarshalNestedContainers(t *testing.T) {
+	type thing struct {
+		MS  map[string][]int       `binpack:"tag=1"`
+		SM  []map[int]string       `binpack:"tag=2"`
+		SS  [][]string             `binpack:"tag=3"`
+		MM  map[string]map[int]int `binpack:"tag=4"`
+		SB  [][]byte               `binpack:"tag=5"`
+		MSB map[int][][]byte       `binpack:"tag=6"`
+	}
+	in := &thing{
+		MS:  map[string][]int{"a": {1, 2}, "b": {}, "c": {0}},
+		SM:  []map[int]string{{1: "x"}, {}, {2: "y", 3: ""}},
+		SS:  [][]string{{"p", "q"}, {}, {""}},
+		MM:  map[string]map[int]int{"m": {1: 2, 3: 4}, "n": {}},
+		SB:  [][]byte{[]byte("a"), {}},
+		MSB: map[int][][]byte{5: {[]byte("five"), {}}, 6: {}},
+	}
+	opts := &binpack.MarshalOptions{Deterministic: true}
+	bits, err := opts.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	out := new(thing)
+	if err := binpack.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// Check the documented layout: one record per entry or element, with
+	// nested containers packed into the record value.
+	check := func(v interface{}, want string) {
+		t.Helper()
+		bits, err := opts.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal %+v failed: %v", v, err)
+		}
+		if got := string(bits); got != want {
+			t.Errorf("Marshal %+v: got %q, want %q", v, got, want)
+		}
+	}
+	check(&thing{MS: map[string][]int{"a": {1, 2}}}, "\x01\x84a\x82\x02\x04")
+	check(&thing{SM: []map[int]string{{1: "x"}}}, "\x02\x83\x82\x02x")
+	check(&thing{SS: [][]string{{"p", "q"}, {}}}, "\x03\x82pq\x03\x80")
+}
+
+func TestMarshalPointerContainers(t *testing.T) {
+	type thing struct {
+		List  *[]int          `binpack:"tag=1"`
+		Attrs *map[string]int `binpack:"tag=2"`
+		Names *[]string       `binpack:"tag=3"`
+	}
+	tests := []struct {
+		in   *thing
+		want string
+	}{
+		{&thing{}, ""},
+		{&thing{List: &[]int{}, Attrs: &map[string]int{}}, "\x01\x80\x02\x80"},
+		{&thing{List: &[]int{1, 2}, Names: &[]string{"a", ""}}, "\x01\x82\x02\x04\x03\x82a\x80"},
+		{&thing{Attrs: &map[string]int{"x": 1}}, "\x02\x83\x82x\x02"},
+	}
+	for _, test := range tests {
+		bits, err := binpack.Marshal(test.in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if got := string(bits); got != test.want {
+			t.Errorf("Marshal %+v: got %q, want %q", test.in, got, test.want)
+		}
+		out := new(thing)
+		if err := binpack.Unmarshal(bits, out); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if diff := cmp.Diff(test.in, out); diff != "" {
+			t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+		}
+	}
+
+	// In self-describing mode, a pointer to a map is a list of entries.
+	opts := &binpack.MarshalOptions{SelfDescribing: true}
+	bits, err := opts.Marshal(&thing{Attrs: &map[string]int{"x": 1}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got, err := binpack.DecodeGeneric(bits)
+	if err != nil {
+		t.Fatalf("DecodeGeneric failed: %v", err)
+	}
+	want := map[int]interface{}{2: []interface{}{[]byte("x\x02")}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DecodeGeneric output differs (-want, +got):\n%s", diff)
+	}
+}
+
+type testColor int
+
+func (c testColor) String() string { return [...]string{"red", "green"}[c] }
+
+type testError struct{ code int }
+
+func (e *testError) Error() string { return fmt.Sprintf("code %d", e.code) }
+
+func TestMarshalStringers(t *testing.T) {
+	type report struct {
+		Name   string       `binpack:"tag=1"`
+		Err    error        `binpack:"tag=2"`
+		Color  testColor    `binpack:"tag=3"`
+		Cause  *testError   `binpack:"tag=4"`
+		Others []error      `binpack:"tag=5"`
+		Stamp  fmt.Stringer `binpack:"tag=6"`
+	}
+	in := &report{
+		Name:   "diag",
+		Err:    errors.New("it broke"),
+		Color:  1,
+		Cause:  &testError{code: 17},
+		Others: []error{io.EOF, &testError{code: 3}},
+		Stamp:  testColor(0),
+	}
+	if _, err := binpack.Marshal(in); err == nil {
+		t.Error("Marshal without Stringers: got nil error")
+	}
+	bits, err := (&binpack.MarshalOptions{Stringers: true}).Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// The adapted values decode as strings.
+	type logged struct {
+		Name   string   `binpack:"tag=1"`
+		Err    string   `binpack:"tag=2"`
+		Color  string   `binpack:"tag=3"`
+		Cause  string   `binpack:"tag=4"`
+		Others []string `binpack:"tag=5"`
+		Stamp  string   `binpack:"tag=6"`
+	}
+	want := &logged{
+		Name:   "diag",
+		Err:    "it broke",
+		Color:  "green",
+		Cause:  "code 17",
+		Others: []string{"EOF", "code 3"},
+		Stamp:  "red",
+	}
+	got := new(logged)
+	if err := binpack.Unmarshal(bits, got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDecoderRecordTimeout(t *testing.T) {
+	if binpack.NewDecoder(strings.NewReader("")).SetRecordTimeout(time.Second) {
+		t.Error("SetRecordTimeout on a reader without deadlines: got true")
+	}
+
+	rc, wc := net.Pipe()
+	defer rc.Close()
+	defer wc.Close()
+	go func() {
+		// Write one complete record and the start of another, then stall.
+		wc.Write([]byte("\x01\x83abc\x02"))
+	}()
+
+	d := binpack.NewDecoder(rc)
+	if !d.SetRecordTimeout(50 * time.Millisecond) {
+		t.Fatal("SetRecordTimeout on a net.Conn: got false")
+	}
+	if tag, value, err := d.Decode(); err != nil || tag != 1 || string(value) != "abc" {
+		t.Errorf("Decode: got (%d, %q, %v), want (1, abc, nil)", tag, value, err)
+	}
+	if _, _, err := d.Decode(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Decode stalled: got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	e := binpack.NewStreamEncoder(&buf)
+	if err := e.FinishWithTrailer(); err == nil {
+		t.Error("FinishWithTrailer without EnableTrailer: got nil error")
+	}
+	e.EnableTrailer()
+	mustEncode := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	// Two messages, each with a trailer, written by different methods.
+	mustEncode(e.Encode(1, []byte("a")))
+	mustEncode(e.Encode(2, bytes.Repeat([]byte("b"), 200)))
+	mustEncode(e.FinishWithTrailer())
+	mustEncode(e.EncodeRecords(binpack.Record{Tag: 3, Value: []byte("cc")}, binpack.Record{Tag: 4}))
+	mustEncode(e.FinishWithTrailer())
+	stream := buf.Bytes()
+
+	decodeAll := func(data []byte) ([]int, error) {
+		d := binpack.NewDecoder(bytes.NewReader(data))
+		d.VerifyTrailers()
+		var tags []int
+		for {
+			tag, _, err := d.Decode()
+			if err == io.EOF {
+				return tags, nil
+			} else if err != nil {
+				return tags, err
+			}
+			tags = append(tags, tag)
+		}
+	}
+	tags, err := decodeAll(stream)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3, 4}, tags); diff != "" {
+		t.Errorf("Decoded tags differ (-want, +got):\n%s", diff)
+	}
+
+	// Without verification, the trailers are ordinary records.
+	recs, err := binpack.Split(stream)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	var plain []int
+	for _, rec := range recs {
+		r, err := binpack.DecodeRecord(rec)
+		if err != nil {
+			t.Fatalf("DecodeRecord failed: %v", err)
+		}
+		plain = append(plain, r.Tag)
+	}
+	if diff := cmp.Diff([]int{1, 2, binpack.TrailerTag, 3, 4, binpack.TrailerTag}, plain); diff != "" {
+		t.Errorf("Split tags differ (-want, +got):\n%s", diff)
+	}
+
+	// A corrupted value fails the checksum.
+	bad := append([]byte(nil), stream...)
+	bad[1] = 'z'
+	if _, err := decodeAll(bad); !errors.Is(err, binpack.ErrTrailer) {
+		t.Errorf("Decode corrupted: got %v, want %v", err, binpack.ErrTrailer)
+	}
+
+	// A dropped record fails the count.
+	first := recs[0]
+	if _, err := decodeAll(stream[len(first):]); !errors.Is(err, binpack.ErrTrailer) {
+		t.Errorf("Decode with a dropped record: got %v, want %v", err, binpack.ErrTrailer)
+	}
+
+	// Records after the last trailer are reported at the end of the input.
+	extra := append(append([]byte(nil), stream...), first...)
+	if tags, err := decodeAll(extra); !errors.Is(err, binpack.ErrTrailer) || len(tags) != 5 {
+		t.Errorf("Decode with no final trailer: got %v, %v; want 5 tags, %v", tags, err, binpack.ErrTrailer)
+	}
+}
+
+func TestEncoderTyped(t *testing.T) {
+	type thing struct {
+		Name  string    `binpack:"tag=1"`
+		Count uint32    `binpack:"tag=2"`
+		Delta int       `binpack:"tag=3"`
+		Ratio float64   `binpack:"tag=4"`
+		Hot   bool      `binpack:"tag=5"`
+		When  time.Time `binpack:"tag=6"`
+		Cold  bool      `binpack:"tag=7"`
+	}
+	want := &thing{
+		Name:  "alpha",
+		Count: 1000,
+		Delta: -25,
+		Ratio: 0.25,
+		Hot:   true,
+		When:  time.Date(2020, 3, 14, 15, 9, 26, 535, time.UTC),
+	}
+	e := binpack.NewEncoder(nil)
+	for _, err := range []error{
+		e.EncodeString(1, want.Name),
+		e.EncodeUint(2, uint64(want.Count)),
+		e.EncodeInt(3, int64(want.Delta)),
+		e.EncodeFloat(4, want.Ratio),
+		e.EncodeBool(5, want.Hot),
+		e.EncodeTime(6, want.When),
+		e.EncodeBool(7, false),
+	} {
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+
+	got := new(thing)
+	if err := binpack.Unmarshal(e.Data.Bytes(), got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// The encoding matches Marshal, apart from the zero value of Cold.
+	bits, err := binpack.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if got := e.Data.Bytes(); !bytes.Equal(got[:len(got)-2], bits) {
+		t.Errorf("Typed encoding differs from Marshal:\n got %q\nwant %q", got, bits)
+	}
+}
+
+func TestRecordTyped(t *testing.T) {
+	when := time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)
+	e := binpack.NewEncoder(nil)
+	e.EncodeString(1, "alpha")
+	e.EncodeUint(2, 1000)
+	e.EncodeInt(3, -25)
+	e.EncodeFloat(4, 0.25)
+	e.EncodeBool(5, true)
+	e.EncodeTime(6, when)
+	if err := e.Err(); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	d := binpack.NewBytesDecoder(e.Data.Bytes())
+	next := func() binpack.Record {
+		t.Helper()
+		rec, err := d.Next()
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		return rec
+	}
+	check := func(got, want interface{}, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("Decoding value: unexpected error: %v", err)
+		} else if got != want {
+			t.Errorf("Decoding value: got %v, want %v", got, want)
+		}
+	}
+	check(next().Text(), "alpha", nil)
+	u, err := next().Uint64()
+	check(u, uint64(1000), err)
+	i, err := next().Int64()
+	check(i, int64(-25), err)
+	f, err := next().Float64()
+	check(f, 0.25, err)
+	b, err := next().Bool()
+	check(b, true, err)
+	w, err := next().Time()
+	check(w.Equal(when), true, err)
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next at end: got %v, want %v", err, io.EOF)
+	}
+
+	// Invalid encodings report errors.
+	bad := binpack.Record{Tag: 7, Value: make([]byte, 9)}
+	if _, err := bad.Uint64(); !errors.Is(err, binpack.ErrInvalidNumber) {
+		t.Errorf("Uint64 of %d bytes: got %v, want %v", len(bad.Value), err, binpack.ErrInvalidNumber)
+	}
+	if _, err := (binpack.Record{Tag: 7}).Int64(); !errors.Is(err, binpack.ErrInvalidNumber) {
+		t.Errorf("Int64 of empty value: got %v, want %v", err, binpack.ErrInvalidNumber)
+	}
+	if _, err := bad.Bool(); err == nil {
+		t.Error("Bool of 9 bytes: got nil error")
+	}
+	if _, err := bad.Time(); err == nil {
+		t.Error("Time of invalid data: got nil error")
+	}
+}
+
+func TestDecoderTagOrder(t *testing.T) {
+	data := new(binpack.Builder).Str(1, "a").Str(3, "c").Str(3, "d").Str(2, "b").Str(4, "e").Bytes()
+
+	t.Run("Decode", func(t *testing.T) {
+		d := binpack.NewBytesDecoder(data)
+		d.RequireTagOrder()
+		var tags []int
+		for {
+			tag, _, err := d.Decode()
+			if err == io.EOF {
+				break
+			} else if errors.Is(err, binpack.ErrTagOrder) {
+				tags = append(tags, -1)
+				continue
+			} else if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			tags = append(tags, tag)
+		}
+		if diff := cmp.Diff([]int{1, 3, 3, -1, 4}, tags); diff != "" {
+			t.Errorf("Tags (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("SkipUntil", func(t *testing.T) {
+		d := binpack.NewDecoder(bytes.NewReader(data))
+		d.RequireTagOrder()
+		if _, err := d.SkipUntil(4); !errors.Is(err, binpack.ErrTagOrder) {
+			t.Errorf("SkipUntil: got %v, want %v", err, binpack.ErrTagOrder)
+		}
+		if v, err := d.SkipUntil(4); err != nil || string(v) != "e" {
+			t.Errorf("SkipUntil: got %q, %v; want e, nil", v, err)
+		}
+	})
+
+	t.Run("Trailers", func(t *testing.T) {
+		e := binpack.NewEncoder(nil)
+		e.EnableTrailer()
+		e.EncodeString(2, "x")
+		e.FinishWithTrailer()
+		e.EncodeString(1, "y")
+		e.FinishWithTrailer()
+
+		d := binpack.NewBytesDecoder(e.Data.Bytes())
+		d.VerifyTrailers()
+		d.RequireTagOrder()
+		for d.More() {
+			if _, _, err := d.Decode(); err != nil {
+				t.Errorf("Decode failed: %v", err)
+			}
+		}
+	})
+}
+
+func TestMarshalNilable(t *testing.T) {
+	type point struct {
+		X int `binpack:"tag=1"`
+	}
+	type msg struct {
+		P  *int            `binpack:"tag=1,nilable"`
+		Q  *point          `binpack:"tag=2,nilable"`
+		S  []*int          `binpack:"tag=3,nilable"`
+		M  map[string]*int `binpack:"tag=4,nilable"`
+		Xs []*point        `binpack:"tag=5"`
+	}
+	zero, one := 0, 1
+	in := msg{
+		Q:  &point{},
+		S:  []*int{nil, &zero, &one},
+		M:  map[string]*int{"nil": nil, "zero": &zero},
+		Xs: []*point{{X: 1}},
+	}
+	data, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Decoding over existing values sets nil pointers explicitly.
+	out := msg{P: &one, Q: nil}
+	if err := binpack.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Round trip (-want, +got):\n%s", diff)
+	}
+	if out.P != nil {
+		t.Errorf("Field P: got %v, want nil", *out.P)
+	}
+	if out.S[0] != nil || out.S[1] == nil {
+		t.Errorf("Field S: got %v, want [nil, &0, &1]", out.S)
+	}
+	if v, ok := out.M["nil"]; !ok || v != nil {
+		t.Errorf("Field M: got %v, want nil entry", out.M)
+	}
+
+	// A nilable field must hold pointers.
+	type bad struct {
+		V int `binpack:"tag=1,nilable"`
+	}
+	if _, err := binpack.Marshal(bad{V: 1}); err == nil {
+		t.Error("Marshal of non-pointer nilable field: got nil error")
+	}
+
+	// A malformed optional value is an error.
+	var m msg
+	if err := binpack.Unmarshal([]byte{0x01, 0x02, 0x05, 0x01}, &m); err == nil {
+		t.Errorf("Unmarshal of invalid optional: got %+v, want error", m)
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		in   float32
+		bits uint64
+		want float32
+	}{
+		{0, 0x0000, 0},
+		{1, 0x3c00, 1},
+		{-2, 0xc000, -2},
+		{0.5, 0x3800, 0.5},
+		{65504, 0x7bff, 65504},              // largest finite
+		{1e6, 0x7c00, float32(math.Inf(1))}, // overflow
+		{float32(math.Inf(-1)), 0xfc00, float32(math.Inf(-1))},
+		{6.1035156e-05, 0x0400, 6.1035156e-05}, // smallest normal
+		{5.9604645e-08, 0x0001, 5.9604645e-08}, // smallest subnormal
+		{1e-9, 0x0000, 0},                      // underflow
+		{1.0009766, 0x3c01, 1.0009766},         // 1 + 2^-10
+		{1.0004883, 0x3c00, 1},                 // tie rounds to even
+		{1.0014648, 0x3c02, 1.0019531},         // tie rounds to even
+		{3.14159, 0x4248, 3.140625},
+	}
+	for _, test := range tests {
+		data := binpack.PackFloat16(test.in)
+		if got := binpack.UnpackUint64(data); got != test.bits {
+			t.Errorf("PackFloat16(%v): got %#04x, want %#04x", test.in, got, test.bits)
+		}
+		if got := binpack.UnpackFloat16(data); got != test.want {
+			t.Errorf("UnpackFloat16(%#04x): got %v, want %v", test.bits, got, test.want)
+		}
+	}
+	if got := binpack.UnpackFloat16(binpack.PackFloat16(float32(math.NaN()))); !math.IsNaN(float64(got)) {
+		t.Errorf("Float16 NaN: got %v, want NaN", got)
+	}
+}
+
+func TestMarshalFloatWidth(t *testing.T) {
+	type telemetry struct {
+		Temp    float64   `binpack:"tag=1,f16"`
+		Load    float32   `binpack:"tag=2,width=2"`
+		Ratio   float64   `binpack:"tag=3,width=4"`
+		Samples []float32 `binpack:"tag=4,f16"`
+	}
+	in := telemetry{Temp: 21.5, Load: 0.75, Ratio: 0.1, Samples: []float32{1, -0.5, 1024}}
+	data, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	full, err := binpack.Marshal(struct {
+		Temp    float64   `binpack:"tag=1"`
+		Load    float32   `binpack:"tag=2"`
+		Ratio   float64   `binpack:"tag=3"`
+		Samples []float32 `binpack:"tag=4"`
+	}(in))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(data) >= len(full) {
+		t.Errorf("Reduced width: got %d bytes, want fewer than %d", len(data), len(full))
+	}
+
+	var out telemetry
+	if err := binpack.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := telemetry{Temp: 21.5, Load: 0.75, Ratio: float64(float32(0.1)), Samples: in.Samples}
+	if diff := cmp.Diff(want, out); diff != "" {
+		t.Errorf("Round trip (-want, +got):\n%s", diff)
+	}
+
+	for _, v := range []interface{}{
+		struct {
+			N int `binpack:"tag=1,f16"`
+		}{1},
+		struct {
+			F float64 `binpack:"tag=1,width=3"`
+		}{1},
+	} {
+		if _, err := binpack.Marshal(v); err == nil {
+			t.Errorf("Marshal %T: got nil error", v)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+// Package binpacktest provides support for testing binpack encodings,
+// including a set of conformance test vectors that other implementations of
+// the format can use to check compatibility with this one.
+package binpacktest
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+// A Vector is a single conformance test vector. A valid vector gives a tag
+// and value and their encoding as one record. An invalid vector gives an
+// encoding that does not decode to a complete record.
+type Vector struct {
+	Name     string `json:"name"`
+	Tag      int    `json:"tag"`
+	Value    string `json:"value"`    // hex-encoded value bytes
+	Encoding string `json:"encoding"` // hex-encoded record bytes
+
+	// If false, Encoding is a valid but non-canonical encoding of the record,
+	// which an implementation must decode but should not produce. A nil value
+	// means true.
+	Canonical *bool `json:"canonical,omitempty"`
+
+	// If true, Encoding is invalid and decoding it must fail.
+	Invalid bool `json:"invalid,omitempty"`
+}
+
+// IsCanonical reports whether v is the canonical encoding of its record.
+func (v Vector) IsCanonical() bool { return !v.Invalid && (v.Canonical == nil || *v.Canonical) }
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// VectorsJSON returns the conformance vectors in their machine-readable JSON
+// form, an array of objects with the fields of a Vector.
+func VectorsJSON() []byte { return append([]byte(nil), vectorsJSON...) }
+
+// Vectors returns the conformance test vectors.
+func Vectors() []Vector {
+	var vs []Vector
+	if err := json.Unmarshal(vectorsJSON, &vs); err != nil {
+		panic("invalid test vectors: " + err.Error())
+	}
+	return vs
+}
+
+// A Codec is an implementation of the binpack record encoding to be checked
+// by Conformance.
+type Codec interface {
+	// Encode returns the encoding of a single record.
+	Encode(tag int, value []byte) ([]byte, error)
+
+	// Decode decodes data, which must contain exactly one complete record.
+	Decode(data []byte) (tag int, value []byte, err error)
+}
+
+// Conformance checks c against all the conformance test vectors, reporting
+// each failure as an error on t.
+func Conformance(t *testing.T, c Codec) {
+	t.Helper()
+	for _, v := range Vectors() {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			enc, err := hex.DecodeString(v.Encoding)
+			if err != nil {
+				t.Fatalf("Invalid vector encoding: %v", err)
+			}
+			if v.Invalid {
+				if tag, value, err := c.Decode(enc); err == nil {
+					t.Errorf("Decode(%x): got tag=%d value=%x, want error", enc, tag, value)
+				}
+				return
+			}
+			value, err := hex.DecodeString(v.Value)
+			if err != nil {
+				t.Fatalf("Invalid vector value: %v", err)
+			}
+
+			tag, got, err := c.Decode(enc)
+			if err != nil {
+				t.Errorf("Decode(%x): unexpected error: %v", enc, err)
+			} else if tag != v.Tag || !bytes.Equal(got, value) {
+				t.Errorf("Decode(%x): got tag=%d value=%x, want tag=%d value=%x", enc, tag, got, v.Tag, value)
+			}
+			if v.IsCanonical() {
+				if got, err := c.Encode(v.Tag, value); err != nil {
+					t.Errorf("Encode(%d, %x): unexpected error: %v", v.Tag, value, err)
+				} else if !bytes.Equal(got, enc) {
+					t.Errorf("Encode(%d, %x): got %x, want %x", v.Tag, value, got, enc)
+				}
+			}
+		})
+	}
+}
+
+// Reference is a Codec that uses the binpack package.
+type Reference struct{}
+
+// Encode implements a method of the Codec interface.
+func (Reference) Encode(tag int, value []byte) ([]byte, error) {
+	e := binpack.NewEncoder(nil)
+	if err := e.Encode(tag, value); err != nil {
+		return nil, err
+	}
+	return e.Data.Bytes(), nil
+}
+
+// Decode implements a method of the Codec interface.
+func (Reference) Decode(data []byte) (int, []byte, error) {
+	d := binpack.NewDecoder(bytes.NewReader(data))
+	tag, value, err := d.Decode()
+	if err == io.EOF {
+		return 0, nil, binpack.ErrTruncated
+	} else if err != nil {
+		return 0, nil, err
+	} else if _, _, err := d.Decode(); err != io.EOF {
+		return 0, nil, errors.New("extra data after record")
+	}
+	return tag, value, nil
+}
+
+// Transform returns a cmp.Option that compares []byte values containing
+// binpack records as decoded binpack.Message values, so that a diff shows
+// which records differ rather than the raw bytes. The transformation applies
+// recursively to record values that themselves contain binpack records.
+//
+// Since the format does not record types, any pair of non-empty byte slices
+// that both split into complete records is compared this way. This is a
+// heuristic: short values may happen to parse as records.
+func Transform() cmp.Option {
+	return cmp.FilterValues(func(x, y []byte) bool {
+		return isRecords(x) && isRecords(y)
+	}, cmp.Transformer("binpack.Message", func(data []byte) binpack.Message {
+		var m binpack.Message
+		m.UnmarshalBinary(data) // checked by the filter
+		return m
+	}))
+}
+
+// isRecords reports whether data is a non-empty sequence of complete records.
+func isRecords(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	_, err := binpack.Split(data)
+	return err == nil
+}
+
+// The flag is namespaced so that it does not conflict with an -update flag
+// defined by a test that imports this package.
+var updateGolden = flag.Bool("binpacktest.update", false, "Update golden files for binpacktest.Golden")
+
+// Golden marshals v canonically (with deterministic map order) and compares
+// the result to the golden file testdata/name.golden, reporting a structural
+// diff of any mismatch as an error on t. The golden file stores the records
+// in the binpack text format (see binpack.Message.MarshalText).
+//
+// If the -binpacktest.update flag is set, Golden writes the golden file
+// instead of comparing against it.
+func Golden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	opts := &binpack.MarshalOptions{Deterministic: true}
+	bits, err := opts.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var got binpack.Message
+	if err := got.UnmarshalBinary(bits); err != nil {
+		t.Fatalf("Decoding message failed: %v", err)
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		text, err := got.MarshalText()
+		if err != nil {
+			t.Fatalf("Encoding text failed: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Creating golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, text, 0644); err != nil {
+			t.Fatalf("Writing golden file: %v", err)
+		}
+		t.Logf("Updated golden file %q", path)
+		return
+	}
+
+	text, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Reading golden file (use -binpacktest.update to create it): %v", err)
+	}
+	var want binpack.Message
+	if err := want.UnmarshalText(text); err != nil {
+		t.Fatalf("Invalid golden file %q: %v", path, err)
+	}
+	if diff := cmp.Diff(want, got, Transform()); diff != "" {
+		t.Errorf("Output does not match golden file %q (-want, +got):\n%s", path, diff)
+	}
+}
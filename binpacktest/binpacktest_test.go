@@ -0,0 +1,111 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpacktest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/creachadair/binpack/binpacktest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReferenceConformance(t *testing.T) {
+	binpacktest.Conformance(t, binpacktest.Reference{})
+}
+
+func TestTransform(t *testing.T) {
+	type blob struct {
+		Name string `binpack:"tag=1"`
+		Data []byte `binpack:"tag=2"`
+	}
+	type wrapper struct {
+		Blob []byte
+	}
+	mustMarshal := func(v interface{}) []byte {
+		bits, err := binpack.Marshal(v)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		return bits
+	}
+	inner := mustMarshal(&blob{Name: "inner", Data: []byte("payload")})
+	x := wrapper{Blob: mustMarshal(&blob{Name: "outer", Data: inner})}
+	y := wrapper{Blob: mustMarshal(&blob{Name: "outer", Data: inner})}
+	if diff := cmp.Diff(x, y, binpacktest.Transform()); diff != "" {
+		t.Errorf("Equal values differ (-x, +y):\n%s", diff)
+	}
+
+	// A change in a nested record is reported in terms of its tag.
+	changed := mustMarshal(&blob{Name: "inner", Data: []byte("PAYLOAD")})
+	y = wrapper{Blob: mustMarshal(&blob{Name: "outer", Data: changed})}
+	diff := cmp.Diff(x, y, binpacktest.Transform())
+	if diff == "" {
+		t.Fatal("Different values compare equal")
+	}
+	if !strings.Contains(diff, "Tag:") || !strings.Contains(diff, `"payload"`) {
+		t.Errorf("Diff does not show the changed record:\n%s", diff)
+	}
+	t.Logf("Diff:\n%s", diff)
+}
+
+func TestGolden(t *testing.T) {
+	type item struct {
+		Name  string         `binpack:"tag=1"`
+		Count int            `binpack:"tag=2"`
+		Attrs map[string]int `binpack:"tag=3"`
+		Sub   *item          `binpack:"tag=4"`
+	}
+	binpacktest.Golden(t, "item", &item{
+		Name:  "widget",
+		Count: 25,
+		Attrs: map[string]int{"red": 1, "green": 2, "blue": 3},
+		Sub:   &item{Name: "gadget"},
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	type inner struct {
+		N int `binpack:"tag=1"`
+	}
+	type msg struct {
+		Name   string         `binpack:"tag=1"`
+		Empty  []string       `binpack:"tag=2"`
+		Nested [][]int        `binpack:"tag=3"`
+		Ptr    *inner         `binpack:"tag=4"`
+		Any    interface{}    `binpack:"tag=5"`
+		Attrs  map[string]int `binpack:"tag=6"`
+		Skip   int            // untagged
+	}
+
+	// A value that round-trips reports no losses.
+	binpacktest.CheckRoundTrip(t, &msg{Name: "ok", Ptr: &inner{N: 1}, Attrs: map[string]int{"a": 1}})
+
+	losses, err := binpacktest.RoundTrip(&msg{
+		Empty:  []string{},
+		Nested: [][]int{nil},
+		Any:    "text",
+		Skip:   5,
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	var got []string
+	for _, loss := range losses {
+		got = append(got, loss.String())
+	}
+	want := []string{
+		"Empty: empty slice decoded as nil",
+		"Nested[0]: nil slice decoded as empty",
+		"Any: type string decoded as []uint8",
+		"Skip: field has no binpack tag",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Losses (-want, +got):\n%s", diff)
+	}
+
+	if _, err := binpacktest.RoundTrip(msg{}, nil, nil); err == nil {
+		t.Error("RoundTrip of non-pointer: got nil error")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpacktest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+// A Loss describes a part of a value that did not survive a round trip
+// through Marshal and Unmarshal unchanged.
+type Loss struct {
+	Path   string      // the location of the part, e.g., "Items[2].Name"
+	Reason string      // a description of the difference
+	Want   interface{} // the original value of the part
+	Got    interface{} // the value of the part after the round trip
+}
+
+func (l Loss) String() string {
+	if l.Path == "" {
+		return l.Reason
+	}
+	return l.Path + ": " + l.Reason
+}
+
+// RoundTrip marshals v, which must be a pointer to a struct, using mo, then
+// unmarshals the result into a new value of the same type using uo, and
+// reports each part of the value that differs from the original. Either
+// options value may be nil to use the defaults. It reports an error if v
+// cannot be marshaled or the result cannot be unmarshaled.
+//
+// This is meant for auditing message types before relying on them for
+// persistence. The differences it reports include nil slices, maps, and
+// pointers that decode as non-nil (and vice versa), reordered slice
+// elements, untagged fields, and interface values whose dynamic types are
+// not preserved. Unexported fields are not compared.
+func RoundTrip(v interface{}, mo *binpack.MarshalOptions, uo *binpack.UnmarshalOptions) ([]Loss, error) {
+	in := reflect.ValueOf(v)
+	if in.Kind() != reflect.Ptr || in.IsNil() || in.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("value of type %T is not a pointer to a struct", v)
+	}
+	bits, err := mo.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	out := reflect.New(in.Elem().Type())
+	if err := uo.Unmarshal(bits, out.Interface()); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	var losses []Loss
+	diffValues("", in.Elem(), out.Elem(), &losses)
+	return losses, nil
+}
+
+// CheckRoundTrip reports an error on t for each loss reported by RoundTrip
+// for v with the default options.
+func CheckRoundTrip(t *testing.T, v interface{}) {
+	t.Helper()
+	losses, err := RoundTrip(v, nil, nil)
+	if err != nil {
+		t.Fatalf("RoundTrip failed: %v", err)
+	}
+	for _, loss := range losses {
+		t.Errorf("Round trip of %T: %v (want %v, got %v)", v, loss, loss.Want, loss.Got)
+	}
+}
+
+// diffValues appends to out a loss for each difference between want and
+// got, which have the same type.
+func diffValues(path string, want, got reflect.Value, out *[]Loss) {
+	add := func(reason string, args ...interface{}) {
+		*out = append(*out, Loss{
+			Path:   path,
+			Reason: fmt.Sprintf(reason, args...),
+			Want:   want.Interface(),
+			Got:    got.Interface(),
+		})
+	}
+	switch want.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if want.IsNil() != got.IsNil() {
+			add("%s %s decoded as %s", nilName(want), kindName(want), nilName(got))
+		} else if !want.IsNil() {
+			want, got := want.Elem(), got.Elem()
+			if want.Type() != got.Type() {
+				*out = append(*out, Loss{
+					Path:   path,
+					Reason: fmt.Sprintf("type %v decoded as %v", want.Type(), got.Type()),
+					Want:   want.Interface(),
+					Got:    got.Interface(),
+				})
+				return
+			}
+			diffValues(path, want, got, out)
+		}
+
+	case reflect.Struct:
+		typ := want.Type()
+		exported := 0
+		for i := 0; i < typ.NumField(); i++ {
+			ft := typ.Field(i)
+			if ft.PkgPath != "" {
+				continue // unexported
+			}
+			exported++
+			fpath := ft.Name
+			if path != "" {
+				fpath = path + "." + ft.Name
+			}
+			if _, ok := ft.Tag.Lookup("binpack"); !ok {
+				if !want.Field(i).IsZero() {
+					*out = append(*out, Loss{
+						Path:   fpath,
+						Reason: "field has no binpack tag",
+						Want:   want.Field(i).Interface(),
+						Got:    got.Field(i).Interface(),
+					})
+				}
+				continue
+			}
+			diffValues(fpath, want.Field(i), got.Field(i), out)
+		}
+		if exported == 0 && !reflect.DeepEqual(want.Interface(), got.Interface()) {
+			add("value changed") // e.g., time.Time
+		}
+
+	case reflect.Slice:
+		if want.IsNil() != got.IsNil() {
+			if want.Len() == 0 && got.Len() == 0 {
+				add("%s slice decoded as %s", emptyName(want), emptyName(got))
+			} else {
+				add("length %d decoded as %d", want.Len(), got.Len())
+			}
+			return
+		} else if want.Len() != got.Len() {
+			add("length %d decoded as %d", want.Len(), got.Len())
+			return
+		} else if reflect.DeepEqual(want.Interface(), got.Interface()) {
+			return
+		} else if want.Type().Elem().Kind() != reflect.Uint8 && isPermutation(want, got) {
+			add("elements reordered")
+			return
+		}
+		if want.Type().Elem().Kind() == reflect.Uint8 {
+			add("value changed")
+			return
+		}
+		for i := 0; i < want.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), want.Index(i), got.Index(i), out)
+		}
+
+	case reflect.Map:
+		if want.IsNil() != got.IsNil() && want.Len() == 0 && got.Len() == 0 {
+			add("%s map decoded as %s", emptyName(want), emptyName(got))
+			return
+		}
+		for _, key := range want.MapKeys() {
+			kpath := fmt.Sprintf("%s[%v]", path, key.Interface())
+			gv := got.MapIndex(key)
+			if !gv.IsValid() {
+				*out = append(*out, Loss{Path: kpath, Reason: "key missing", Want: want.MapIndex(key).Interface()})
+				continue
+			}
+			diffValues(kpath, want.MapIndex(key), gv, out)
+		}
+		for _, key := range got.MapKeys() {
+			if !want.MapIndex(key).IsValid() {
+				kpath := fmt.Sprintf("%s[%v]", path, key.Interface())
+				*out = append(*out, Loss{Path: kpath, Reason: "key added", Got: got.MapIndex(key).Interface()})
+			}
+		}
+
+	default:
+		if !reflect.DeepEqual(want.Interface(), got.Interface()) {
+			add("value changed")
+		}
+	}
+}
+
+// nilName describes a pointer or interface value as nil or non-nil.
+func nilName(v reflect.Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+	return "non-nil"
+}
+
+// kindName names the kind of a pointer or interface value.
+func kindName(v reflect.Value) string {
+	if v.Kind() == reflect.Interface {
+		return "interface"
+	}
+	return "pointer"
+}
+
+// emptyName describes an empty slice or map as nil or empty.
+func emptyName(v reflect.Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+	return "empty"
+}
+
+// isPermutation reports whether the slices want and got, which have the same
+// length, contain the same elements in a different order.
+func isPermutation(want, got reflect.Value) bool {
+	used := make([]bool, got.Len())
+next:
+	for i := 0; i < want.Len(); i++ {
+		for j := 0; j < got.Len(); j++ {
+			if !used[j] && reflect.DeepEqual(want.Index(i).Interface(), got.Index(j).Interface()) {
+				used[j] = true
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// Tags of the records in the header of an encrypted container.
+const (
+	headerKeyID       = 1 // the key ID
+	headerContainerID = 2 // a random identifier for the container
+
+	containerIDSize = 16
+)
+
+// A sealInfo identifies the key and the container to which the encrypted
+// blocks and index of a container are bound.
+type sealInfo struct {
+	keyID string
+	id    []byte // containerIDSize random bytes
+}
+
+// newSealInfo returns a sealInfo for a new container with the given key ID.
+func newSealInfo(keyID string) (sealInfo, error) {
+	id := make([]byte, containerIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return sealInfo{}, fmt.Errorf("generating container ID: %w", err)
+	}
+	return sealInfo{keyID: keyID, id: id}, nil
+}
+
+// encode returns the value of the header record for s.
+func (s sealInfo) encode() []byte {
+	e := NewEncoder(nil)
+	e.Encode(headerKeyID, []byte(s.keyID))
+	e.Encode(headerContainerID, s.id)
+	return e.Data.Bytes()
+}
+
+// parseSealInfo decodes the value of a header record.
+func parseSealInfo(data []byte) (sealInfo, error) {
+	var s sealInfo
+	for len(data) != 0 {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return s, fmt.Errorf("%w: header: %v", ErrInvalidContainer, err)
+		}
+		data = rest
+		switch tag {
+		case headerKeyID:
+			s.keyID = string(value)
+		case headerContainerID:
+			s.id = value
+		}
+	}
+	if len(s.id) != containerIDSize {
+		return s, fmt.Errorf("%w: header has no container ID", ErrInvalidContainer)
+	}
+	return s, nil
+}
+
+// aad returns the additional authenticated data for block k of the
+// container. The index uses k = -1.
+func (s sealInfo) aad(k int) []byte {
+	aad := make([]byte, 8, 8+len(s.id)+len(s.keyID))
+	binary.BigEndian.PutUint64(aad, uint64(int64(k)))
+	aad = append(aad, s.id...)
+	return append(aad, s.keyID...)
+}
+
+// sealBlock encrypts data with a random nonce, which is prepended to the
+// result.
+func sealBlock(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, aad), nil
+}
+
+// openBlock decrypts data produced by sealBlock.
+func openBlock(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	ns := aead.NonceSize()
+	if len(data) < ns {
+		return nil, ErrDecrypt
+	}
+	out, err := aead.Open(nil, data[:ns], data[ns:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+// keyFor returns the AEAD to use for a container with the given key ID, or
+// nil if the container is not encrypted.
+func (o *BlockOptions) keyFor(keyID string, encrypted bool) (cipher.AEAD, error) {
+	switch {
+	case !encrypted:
+		if o != nil && (o.AEAD != nil || o.Keys != nil) {
+			return nil, errors.New("container is not encrypted")
+		}
+		return nil, nil
+	case o != nil && o.Keys != nil:
+		aead, err := o.Keys(keyID)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyID, err)
+		}
+		return aead, nil
+	case o != nil && o.AEAD != nil:
+		if o.KeyID != "" && o.KeyID != keyID {
+			return nil, fmt.Errorf("container key %q does not match %q", keyID, o.KeyID)
+		}
+		return o.AEAD, nil
+	}
+	return nil, fmt.Errorf("container is encrypted with key %q", keyID)
+}
+
+// readBlockHeader reads the header record, if any, at the start of a
+// container whose index begins at offset end. It returns the contents of the
+// header, and reports whether the container is encrypted.
+func readBlockHeader(r io.ReaderAt, end int64) (sealInfo, bool, error) {
+	var buf [8]byte
+	n := int64(len(buf))
+	if n > end {
+		n = end
+	}
+	if _, err := r.ReadAt(buf[:n], 0); err != nil {
+		return sealInfo{}, false, err
+	}
+	lay, _ := wire.ParseLayout(buf[:n])
+	if n == 0 || lay.TagLen != 1 || buf[0] != blockHeader {
+		return sealInfo{}, false, nil
+	}
+	size := int64(lay.Size())
+	if size > end {
+		return sealInfo{}, false, ErrInvalidContainer
+	}
+	hdr := make([]byte, size)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return sealInfo{}, false, err
+	}
+	_, value, _, err := nextRecord(hdr)
+	if err != nil {
+		return sealInfo{}, false, ErrInvalidContainer
+	}
+	seal, err := parseSealInfo(value)
+	if err != nil {
+		return sealInfo{}, false, err
+	}
+	return seal, true, nil
+}
+
+// ReadBlocks reads a block container sequentially from r, and calls fn with
+// the tag and value of each record in order. Unlike OpenBlocks, it does not
+// require random access to the input, and it reads only one block at a time.
+// If fn reports an error, ReadBlocks stops and returns that error.
+//
+// When it reaches the index, ReadBlocks checks that the index describes the
+// blocks and records it has read, so that missing blocks are reported, and
+// that the container codec matches the options, as OpenBlocks does. Since
+// the records of each block are passed to fn as the block is read, fn may be
+// called for the records of a container that is later found to be invalid.
+func ReadBlocks(r io.Reader, opts *BlockOptions, fn func(tag int, value []byte) error) error {
+	if err := opts.checkCodec(); err != nil {
+		return err
+	}
+	dec := NewDecoder(r)
+	var aead cipher.AEAD
+	var seal sealInfo
+	var records int
+	for k := 0; ; {
+		tag, value, err := dec.Decode()
+		if err == io.EOF {
+			return fmt.Errorf("%w: missing index", ErrInvalidContainer)
+		} else if err != nil {
+			return err
+		}
+		switch tag {
+		case blockHeader:
+			if k != 0 || aead != nil {
+				return fmt.Errorf("%w: misplaced header", ErrInvalidContainer)
+			}
+			seal, err = parseSealInfo(value)
+			if err != nil {
+				return err
+			}
+			aead, err = opts.keyFor(seal.keyID, true)
+			if err != nil {
+				return err
+			}
+			continue
+		case blockIndex, blockData:
+		default:
+			return fmt.Errorf("%w: unexpected record tag %d", ErrInvalidContainer, tag)
+		}
+		if k == 0 && aead == nil {
+			if _, err := opts.keyFor("", false); err != nil {
+				return err
+			}
+		}
+		if tag == blockIndex {
+			return checkReadIndex(aead, seal, value, opts.codec(), k, records)
+		}
+		if aead != nil {
+			value, err = openBlock(aead, value, seal.aad(k))
+			if err != nil {
+				return fmt.Errorf("block %d: %w", k, err)
+			}
+		}
+		data, err := opts.decompress(value)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", k, err)
+		}
+		for len(data) != 0 {
+			tag, value, rest, err := nextRecord(data)
+			if err != nil {
+				return fmt.Errorf("block %d: %w", k, err)
+			}
+			if err := fn(tag, value); err != nil {
+				return err
+			}
+			records++
+			data = rest
+		}
+		k++
+	}
+}
+
+// checkReadIndex decrypts the index of a container read by ReadBlocks, if it
+// is encrypted, and reports an error unless it names the given codec and
+// describes the given numbers of blocks and records.
+func checkReadIndex(aead cipher.AEAD, seal sealInfo, index []byte, codec string, blocks, records int) error {
+	if aead != nil {
+		var err error
+		index, err = openBlock(aead, index, seal.aad(-1))
+		if err != nil {
+			return fmt.Errorf("index: %w", err)
+		}
+	}
+	var n, total int
+	var got string
+	for len(index) != 0 {
+		tag, value, rest, err := nextRecord(index)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidContainer, err)
+		}
+		index = rest
+		switch tag {
+		case indexCodec:
+			got = string(value)
+		case indexEntry:
+			e, err := parseBlockEntry(value)
+			if err != nil {
+				return err
+			}
+			n++
+			total += e.count
+		}
+	}
+	if got != codec {
+		return fmt.Errorf("container codec %q does not match %q", got, codec)
+	} else if n != blocks || total != records {
+		return fmt.Errorf("%w: read %d blocks with %d records, index has %d with %d",
+			ErrInvalidContainer, blocks, records, n, total)
+	}
+	return nil
+}
+
+// RekeyBlocks copies a block container of the given size from src to dst,
+// re-encrypting each block and the index with the key given by to. The key
+// of the source is found from the options in from, as for OpenBlocks. Either
+// container may be unencrypted, so RekeyBlocks can also add or remove
+// encryption. The compression codec of the source is kept, and the codec
+// settings of from and to are ignored.
+//
+// The blocks are processed one at a time, and are not decompressed, so at
+// most one block of plaintext is held in memory at once.
+func RekeyBlocks(dst io.Writer, src io.ReaderAt, size int64, from, to *BlockOptions) error {
+	br, err := openBlocks(src, size, from)
+	if err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	var seal sealInfo
+	if to != nil && to.AEAD != nil {
+		aead = to.AEAD
+		seal, err = newSealInfo(to.KeyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw := &countWriter{w: dst}
+	enc := NewStreamEncoder(cw)
+	if aead != nil {
+		enc.Encode(blockHeader, seal.encode())
+	}
+	index := NewEncoder(nil)
+	index.Encode(indexCodec, []byte(br.codec))
+	for k, e := range br.blocks {
+		data, err := br.readBlock(k)
+		if err != nil {
+			return err
+		}
+		if aead != nil {
+			data, err = sealBlock(aead, data, seal.aad(k))
+			if err != nil {
+				return err
+			}
+		}
+		e.offset = cw.n
+		if err := enc.Encode(blockData, data); err != nil {
+			return err
+		}
+		e.size = cw.n - e.offset
+		index.Encode(indexEntry, e.encode())
+	}
+	if br.bloom != nil {
+		index.Encode(indexBloom, br.bloom)
+	}
+
+	idx := index.Data.Bytes()
+	if aead != nil {
+		idx, err = sealBlock(aead, idx, seal.aad(-1))
+		if err != nil {
+			return err
+		}
+	}
+	var off [8]byte
+	binary.BigEndian.PutUint64(off[:], uint64(cw.n))
+	enc.Encode(blockIndex, idx)
+	return enc.Encode(blockTrailer, off[:])
+}
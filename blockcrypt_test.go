@@ -0,0 +1,231 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func newAEAD(t *testing.T, seed byte) cipher.AEAD {
+	t.Helper()
+	key := bytes.Repeat([]byte{seed}, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM: %v", err)
+	}
+	return aead
+}
+
+func TestEncryptedBlocks(t *testing.T) {
+	const secret = "the eagle flies at midnight"
+	aead := newAEAD(t, 1)
+	var buf bytes.Buffer
+	w := binpack.NewBlockWriter(&buf, &binpack.BlockOptions{
+		BlockSize: 256,
+		Summary:   true,
+		AEAD:      aead,
+		KeyID:     "key-1",
+	})
+	for i := 0; i < 100; i++ {
+		w.Encode(i%3+1, []byte(fmt.Sprintf("%s %d", secret, i)))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data := buf.Bytes()
+	if bytes.Contains(data, []byte("eagle")) {
+		t.Error("Container contains plaintext")
+	}
+
+	// Random access with the key, found by its ID.
+	opts := &binpack.BlockOptions{
+		Keys: func(id string) (cipher.AEAD, error) {
+			if id == "key-1" {
+				return aead, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	r, err := binpack.OpenBlocks(bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		t.Fatalf("OpenBlocks failed: %v", err)
+	}
+	if r.Len() != 100 || r.NumBlocks() < 2 {
+		t.Fatalf("Got %d records in %d blocks, want 100 in at least 2", r.Len(), r.NumBlocks())
+	}
+	rec, err := r.Record(57)
+	if err != nil {
+		t.Fatalf("Record(57) failed: %v", err)
+	} else if !bytes.HasSuffix(rec, []byte(secret+" 57")) {
+		t.Errorf("Record(57): got %q", rec)
+	}
+
+	// Sequential reading.
+	var n int
+	if err := binpack.ReadBlocks(bytes.NewReader(data), opts, func(tag int, value []byte) error {
+		if want := fmt.Sprintf("%s %d", secret, n); string(value) != want {
+			t.Errorf("Record %d: got %q, want %q", n, value, want)
+		}
+		n++
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadBlocks failed: %v", err)
+	} else if n != 100 {
+		t.Errorf("ReadBlocks: got %d records, want 100", n)
+	}
+
+	// The wrong key, or no key, fails.
+	wrong := &binpack.BlockOptions{AEAD: newAEAD(t, 2)}
+	if _, err := binpack.OpenBlocks(bytes.NewReader(data), int64(len(data)), wrong); !errors.Is(err, binpack.ErrDecrypt) {
+		t.Errorf("OpenBlocks with wrong key: got %v, want %v", err, binpack.ErrDecrypt)
+	}
+	if _, err := binpack.OpenBlocks(bytes.NewReader(data), int64(len(data)), nil); err == nil {
+		t.Error("OpenBlocks without a key: got nil, want error")
+	}
+
+	// Modifying a block is detected.
+	bad := append([]byte(nil), data...)
+	bad[40] ^= 1
+	r, err = binpack.OpenBlocks(bytes.NewReader(bad), int64(len(bad)), opts)
+	if err != nil {
+		t.Fatalf("OpenBlocks failed: %v", err)
+	}
+	if _, err := r.Record(0); !errors.Is(err, binpack.ErrDecrypt) {
+		t.Errorf("Record(0) of modified block: got %v, want %v", err, binpack.ErrDecrypt)
+	}
+}
+
+func TestRekeyBlocks(t *testing.T) {
+	oldKey, newKey := newAEAD(t, 1), newAEAD(t, 2)
+	var src bytes.Buffer
+	w := binpack.NewBlockWriter(&src, &binpack.BlockOptions{
+		BlockSize: 128,
+		BloomTag:  1,
+		AEAD:      oldKey,
+		KeyID:     "old",
+	})
+	for i := 0; i < 50; i++ {
+		w.Encode(1, []byte(fmt.Sprintf("record %d", i)))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var dst bytes.Buffer
+	from := &binpack.BlockOptions{AEAD: oldKey}
+	to := &binpack.BlockOptions{AEAD: newKey, KeyID: "new"}
+	if err := binpack.RekeyBlocks(&dst, bytes.NewReader(src.Bytes()), int64(src.Len()), from, to); err != nil {
+		t.Fatalf("RekeyBlocks failed: %v", err)
+	}
+
+	// The old key no longer works; the new one does.
+	if _, err := binpack.OpenBlocks(bytes.NewReader(dst.Bytes()), int64(dst.Len()), from); err == nil {
+		t.Error("OpenBlocks with old key: got nil, want error")
+	}
+	r, err := binpack.OpenBlocks(bytes.NewReader(dst.Bytes()), int64(dst.Len()), to)
+	if err != nil {
+		t.Fatalf("OpenBlocks with new key failed: %v", err)
+	}
+	if r.Len() != 50 {
+		t.Errorf("Len: got %d, want 50", r.Len())
+	}
+	for _, i := range []int{0, 24, 49} {
+		rec, err := r.Record(i)
+		if err != nil {
+			t.Errorf("Record(%d) failed: %v", i, err)
+		} else if want := fmt.Sprintf("record %d", i); !bytes.HasSuffix(rec, []byte(want)) {
+			t.Errorf("Record(%d): got %q, want suffix %q", i, rec, want)
+		}
+	}
+	if !r.MayContain([]byte("record 7")) {
+		t.Error("Bloom filter was not preserved")
+	}
+
+	// Decrypting entirely yields a plain container.
+	var plain bytes.Buffer
+	if err := binpack.RekeyBlocks(&plain, bytes.NewReader(dst.Bytes()), int64(dst.Len()), to, nil); err != nil {
+		t.Fatalf("RekeyBlocks to plaintext failed: %v", err)
+	}
+	if r, err := binpack.OpenBlocks(bytes.NewReader(plain.Bytes()), int64(plain.Len()), nil); err != nil {
+		t.Errorf("OpenBlocks of plaintext failed: %v", err)
+	} else if r.Len() != 50 {
+		t.Errorf("Plaintext Len: got %d, want 50", r.Len())
+	}
+}
+
+func TestBlockBinding(t *testing.T) {
+	type record struct {
+		tag   int
+		value []byte
+	}
+	aead := newAEAD(t, 1)
+	opts := &binpack.BlockOptions{BlockSize: 64, AEAD: aead, KeyID: "key-1"}
+
+	// Write two containers with the same contents and key, and split each
+	// into its top-level records.
+	var containers [2][]record
+	for i := range containers {
+		var buf bytes.Buffer
+		w := binpack.NewBlockWriter(&buf, opts)
+		for j := 0; j < 20; j++ {
+			w.Encode(1, []byte(fmt.Sprintf("record %d", j)))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		d := binpack.NewBytesDecoder(buf.Bytes())
+		for {
+			tag, value, err := d.Decode()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			containers[i] = append(containers[i], record{tag, append([]byte(nil), value...)})
+		}
+	}
+	join := func(recs []record) []byte {
+		var buf bytes.Buffer
+		e := binpack.NewEncoder(&buf)
+		for _, rec := range recs {
+			e.Encode(rec.tag, rec.value)
+		}
+		return buf.Bytes()
+	}
+	read := func(data []byte) error {
+		return binpack.ReadBlocks(bytes.NewReader(data), opts, func(int, []byte) error { return nil })
+	}
+	a, b := containers[0], containers[1]
+	if n := len(a); n < 5 {
+		t.Fatalf("Container has %d records, want at least 5", n)
+	}
+	if err := read(join(a)); err != nil {
+		t.Fatalf("ReadBlocks failed: %v", err)
+	}
+
+	// A block moved from another container with the same key fails to
+	// decrypt. The first record is the header, so a[1] is block 0.
+	moved := append([]record{a[0], b[1]}, a[2:]...)
+	if err := read(join(moved)); !errors.Is(err, binpack.ErrDecrypt) {
+		t.Errorf("ReadBlocks with a moved block: got %v, want %v", err, binpack.ErrDecrypt)
+	}
+
+	// Dropping the last block is detected by the index.
+	n := len(a)
+	dropped := append(append([]record(nil), a[:n-3]...), a[n-2:]...)
+	if err := read(join(dropped)); !errors.Is(err, binpack.ErrInvalidContainer) {
+		t.Errorf("ReadBlocks with a dropped block: got %v, want %v", err, binpack.ErrInvalidContainer)
+	}
+}
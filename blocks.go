@@ -0,0 +1,640 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Tags of the records in a block container.
+const (
+	blockData    = 1 // value is a compressed sequence of records
+	blockIndex   = 2 // value is the index of blocks
+	blockTrailer = 3 // value is the offset of the index, as 8 bytes
+	blockHeader  = 4 // the key ID of an encrypted container
+
+	indexCodec = 1 // the name of the compression codec
+	indexEntry = 2 // value describes one block
+	indexBloom = 3 // a Bloom filter over the keys of the container
+
+	entryOffset = 1 // the offset of the block record in the container
+	entrySize   = 2 // the length of the block record
+	entryFirst  = 3 // the index of the first record in the block
+	entryCount  = 4 // the number of records in the block
+	entryTags   = 5 // the tags in the block and their counts, as packed values
+	entryBloom  = 6 // a Bloom filter over the keys of the block
+
+	trailerSize = 1 + 1 + 8 // tag, length, offset
+)
+
+// BlockOptions control the encoding of a block container. A nil
+// *BlockOptions provides default values.
+//
+// The default codec is "deflate", using compress/flate. To use another codec
+// such as zstd, set Codec to its name and provide Compress and Decompress
+// functions. A reader must use the same codec as the writer.
+type BlockOptions struct {
+	// The uncompressed size of each block, in bytes. Records are not split
+	// across blocks, so a block may be larger if a record is. If zero, a
+	// default of 64KiB is used.
+	BlockSize int
+
+	// The name of the compression codec, which is recorded in the container.
+	Codec string
+
+	// Compress and Decompress implement the codec. They must both be set if
+	// Codec is set to a name other than "deflate", and Codec must be set if
+	// either of them is; otherwise the writer or reader reports an error. If
+	// Codec is "deflate" and they are not set, the built-in codec is used.
+	Compress   func(data []byte) ([]byte, error)
+	Decompress func(data []byte) ([]byte, error)
+
+	// If true, the index records which tags occur in each block, and how
+	// many times. See BlockReader.Summary.
+	Summary bool
+
+	// If positive, the index includes Bloom filters over the values of the
+	// records with this tag, for each block and for the whole container.
+	// See BlockReader.MayContain.
+	BloomTag int
+
+	// The size of the Bloom filters, in bits per key. If zero, a default of
+	// 10 is used, giving a false positive rate of about 1%.
+	BloomBits int
+
+	// If AEAD is set, the writer encrypts each block, and the index, with it.
+	// KeyID identifies the key, and is recorded in the clear at the start of
+	// the container, with a random container ID. Each block, and the index,
+	// is bound to its position, the key ID, and the container ID, so a block
+	// that is moved or copied from another container fails to decrypt. The
+	// index records the number of blocks, so that readers also detect blocks
+	// missing from the end of the container. The sizes of the blocks are not
+	// concealed.
+	AEAD  cipher.AEAD
+	KeyID string
+
+	// If set, a reader calls Keys with the key ID of an encrypted container
+	// to obtain its AEAD. Otherwise, AEAD is used.
+	Keys func(keyID string) (cipher.AEAD, error)
+}
+
+const defaultBlockSize = 64 << 10
+
+func (o *BlockOptions) blockSize() int {
+	if o == nil || o.BlockSize <= 0 {
+		return defaultBlockSize
+	}
+	return o.BlockSize
+}
+
+func (o *BlockOptions) summary() bool { return o != nil && o.Summary }
+
+func (o *BlockOptions) bloomTag() int {
+	if o == nil {
+		return 0
+	}
+	return o.BloomTag
+}
+
+func (o *BlockOptions) bloomBits() int {
+	if o == nil || o.BloomBits <= 0 {
+		return 10
+	}
+	return o.BloomBits
+}
+
+func (o *BlockOptions) codec() string {
+	if o == nil || o.Codec == "" {
+		return "deflate"
+	}
+	return o.Codec
+}
+
+// checkCodec reports an error if o provides codec functions without naming
+// the codec, since the container would then record the wrong codec.
+func (o *BlockOptions) checkCodec() error {
+	if o != nil && o.Codec == "" && (o.Compress != nil || o.Decompress != nil) {
+		return fmt.Errorf("codec functions are set without a codec name")
+	}
+	return nil
+}
+
+func (o *BlockOptions) compress(data []byte) ([]byte, error) {
+	if err := o.checkCodec(); err != nil {
+		return nil, err
+	} else if o != nil && o.Compress != nil {
+		return o.Compress(data)
+	} else if c := o.codec(); c != "deflate" {
+		return nil, fmt.Errorf("codec %q has no Compress function", c)
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (o *BlockOptions) decompress(data []byte) ([]byte, error) {
+	if err := o.checkCodec(); err != nil {
+		return nil, err
+	} else if o != nil && o.Decompress != nil {
+		return o.Decompress(data)
+	} else if c := o.codec(); c != "deflate" {
+		return nil, fmt.Errorf("codec %q has no Decompress function", c)
+	}
+	return io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
+
+// A BlockWriter writes a sequence of records to a block container. Records
+// are gathered into blocks of approximately fixed size, each of which is
+// compressed separately. When the writer is closed, an index of the blocks
+// is written at the end of the container, so that a BlockReader can read any
+// record without decompressing the blocks that precede it.
+type BlockWriter struct {
+	enc  *Encoder
+	opts *BlockOptions
+	cw   *countWriter
+	seal sealInfo // for an encrypted container
+	err  error    // an error setting up the writer
+
+	buf   bytes.Buffer // the current block, uncompressed
+	n     int          // records in the current block
+	total int          // records written before the current block
+	index *Encoder     // index entries
+
+	nblocks int // blocks written
+
+	tags    map[int]int // tag counts for the current block, if enabled
+	keys    []uint64    // key hashes for the current block, if enabled
+	allKeys []uint64    // key hashes for the container, if enabled
+}
+
+// countWriter counts the bytes written to an underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(data []byte) (int, error) {
+	n, err := c.w.Write(data)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewBlockWriter constructs a BlockWriter that writes a container to w. The
+// caller must call Close to complete the container.
+func NewBlockWriter(w io.Writer, opts *BlockOptions) *BlockWriter {
+	cw := &countWriter{w: w}
+	index := NewEncoder(nil)
+	index.Encode(indexCodec, []byte(opts.codec()))
+	b := &BlockWriter{enc: NewStreamEncoder(cw), opts: opts, cw: cw, index: index}
+	b.err = opts.checkCodec()
+	if b.err == nil && opts != nil && opts.AEAD != nil {
+		b.seal, b.err = newSealInfo(opts.KeyID)
+		if b.err == nil {
+			b.enc.Encode(blockHeader, b.seal.encode()) // errors are kept by enc
+		}
+	}
+	return b
+}
+
+// Encode adds a record with the given tag and value to the container.
+func (b *BlockWriter) Encode(tag int, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := NewStreamEncoder(&b.buf).Encode(tag, value); err != nil {
+		return err
+	}
+	b.n++
+	if b.opts.summary() {
+		if b.tags == nil {
+			b.tags = make(map[int]int)
+		}
+		b.tags[tag]++
+	}
+	if bt := b.opts.bloomTag(); bt > 0 && tag == bt {
+		h := keyHash(value)
+		b.keys = append(b.keys, h)
+		b.allKeys = append(b.allKeys, h)
+	}
+	if b.buf.Len() >= b.opts.blockSize() {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush writes the current block, if it is not empty.
+func (b *BlockWriter) flush() error {
+	if b.n == 0 {
+		return nil
+	}
+	data, err := b.opts.compress(b.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("compressing block: %w", err)
+	}
+	if b.opts != nil && b.opts.AEAD != nil {
+		data, err = sealBlock(b.opts.AEAD, data, b.seal.aad(b.nblocks))
+		if err != nil {
+			return err
+		}
+	}
+	start := b.cw.n
+	if err := b.enc.Encode(blockData, data); err != nil {
+		return err
+	}
+	e := blockEntry{offset: start, size: b.cw.n - start, first: b.total, count: b.n}
+	if b.opts.summary() {
+		e.tags = b.tags
+		if e.tags == nil {
+			e.tags = make(map[int]int)
+		}
+	}
+	if b.opts.bloomTag() > 0 {
+		e.bloom = makeBloom(b.keys, b.opts.bloomBits())
+	}
+	b.index.Encode(indexEntry, e.encode())
+
+	b.total += b.n
+	b.nblocks++
+	b.n = 0
+	b.buf.Reset()
+	b.tags, b.keys = nil, nil
+	return nil
+}
+
+// Close writes any remaining records, followed by the index. It does not
+// close the underlying writer.
+func (b *BlockWriter) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.flush(); err != nil {
+		return err
+	}
+	if b.opts.bloomTag() > 0 {
+		b.index.Encode(indexBloom, makeBloom(b.allKeys, b.opts.bloomBits()))
+	}
+	index := b.index.Data.Bytes()
+	if b.opts != nil && b.opts.AEAD != nil {
+		var err error
+		index, err = sealBlock(b.opts.AEAD, index, b.seal.aad(-1))
+		if err != nil {
+			return err
+		}
+	}
+	var off [8]byte
+	binary.BigEndian.PutUint64(off[:], uint64(b.cw.n))
+	b.enc.Encode(blockIndex, index)
+	return b.enc.Encode(blockTrailer, off[:])
+}
+
+// A BlockReader provides random access to the records of a block container
+// written by a BlockWriter.
+type BlockReader struct {
+	r      io.ReaderAt
+	opts   *BlockOptions
+	aead   cipher.AEAD // if non-nil, the container is encrypted
+	seal   sealInfo
+	codec  string
+	blocks []blockEntry
+	bloom  []byte // the container Bloom filter, if any
+
+	// The most recently decompressed block.
+	cur  int
+	recs [][]byte
+}
+
+type blockEntry struct {
+	offset, size int64
+	first, count int
+	tags         map[int]int // nil if the container has no summaries
+	bloom        []byte      // nil if the container has no Bloom filters
+}
+
+// OpenBlocks reads the index of a block container of the given size from r.
+// The options must specify the codec used to write the container.
+func OpenBlocks(r io.ReaderAt, size int64, opts *BlockOptions) (*BlockReader, error) {
+	if err := opts.checkCodec(); err != nil {
+		return nil, err
+	}
+	br, err := openBlocks(r, size, opts)
+	if err != nil {
+		return nil, err
+	} else if br.codec != opts.codec() {
+		return nil, fmt.Errorf("container codec %q does not match %q", br.codec, opts.codec())
+	}
+	return br, nil
+}
+
+// openBlocks reads the index of a container, without checking its codec.
+func openBlocks(r io.ReaderAt, size int64, opts *BlockOptions) (*BlockReader, error) {
+	if size < trailerSize {
+		return nil, ErrInvalidContainer
+	}
+	var trailer [trailerSize]byte
+	if _, err := r.ReadAt(trailer[:], size-trailerSize); err != nil {
+		return nil, err
+	}
+	tag, off, rest, err := nextRecord(trailer[:])
+	if err != nil || tag != blockTrailer || len(off) != 8 || len(rest) != 0 {
+		return nil, ErrInvalidContainer
+	}
+	start := int64(binary.BigEndian.Uint64(off))
+	if start < 0 || start > size-trailerSize {
+		return nil, ErrInvalidContainer
+	}
+	buf := make([]byte, size-trailerSize-start)
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	tag, index, rest, err := nextRecord(buf)
+	if err != nil || tag != blockIndex || len(rest) != 0 {
+		return nil, ErrInvalidContainer
+	}
+
+	br := &BlockReader{r: r, opts: opts, cur: -1}
+	seal, encrypted, err := readBlockHeader(r, start)
+	if err != nil {
+		return nil, err
+	}
+	br.aead, err = opts.keyFor(seal.keyID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	br.seal = seal
+	if br.aead != nil {
+		index, err = openBlock(br.aead, index, seal.aad(-1))
+		if err != nil {
+			return nil, fmt.Errorf("index: %w", err)
+		}
+	}
+	for len(index) != 0 {
+		tag, value, rest, err := nextRecord(index)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContainer, err)
+		}
+		index = rest
+		switch tag {
+		case indexCodec:
+			br.codec = string(value)
+		case indexEntry:
+			e, err := parseBlockEntry(value)
+			if err != nil {
+				return nil, err
+			}
+			br.blocks = append(br.blocks, e)
+		case indexBloom:
+			br.bloom = value
+		}
+	}
+	if err := checkBlockEntries(br.blocks, start); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// checkBlockEntries reports an error if the blocks of an index do not lie
+// within the first end bytes of the container in order without overlapping,
+// or if their records do not follow each other without gaps.
+func checkBlockEntries(blocks []blockEntry, end int64) error {
+	var pos int64
+	next := 0
+	for k, e := range blocks {
+		if e.offset < pos || e.size <= 0 || e.size > end-e.offset {
+			return fmt.Errorf("%w: block %d at offset %d size %d is out of range",
+				ErrInvalidContainer, k, e.offset, e.size)
+		} else if e.first != next || e.count <= 0 || e.count > math.MaxInt-next {
+			return fmt.Errorf("%w: block %d has records [%d, +%d), want first %d",
+				ErrInvalidContainer, k, e.first, e.count, next)
+		}
+		pos = e.offset + e.size
+		next += e.count
+	}
+	return nil
+}
+
+// encode returns the encoding of e in the index.
+func (e blockEntry) encode() []byte {
+	enc := NewEncoder(nil)
+	enc.Encode(entryOffset, PackUint64(uint64(e.offset)))
+	enc.Encode(entrySize, PackUint64(uint64(e.size)))
+	enc.Encode(entryFirst, PackUint64(uint64(e.first)))
+	enc.Encode(entryCount, PackUint64(uint64(e.count)))
+	if e.tags != nil {
+		tags := make([]int, 0, len(e.tags))
+		for tag := range e.tags {
+			tags = append(tags, tag)
+		}
+		sort.Ints(tags)
+		var buf bytes.Buffer
+		for _, tag := range tags {
+			WriteValue(&buf, PackUint64(uint64(tag)))
+			WriteValue(&buf, PackUint64(uint64(e.tags[tag])))
+		}
+		enc.Encode(entryTags, buf.Bytes())
+	}
+	if e.bloom != nil {
+		enc.Encode(entryBloom, e.bloom)
+	}
+	return enc.Data.Bytes()
+}
+
+func parseBlockEntry(data []byte) (blockEntry, error) {
+	var e blockEntry
+	for len(data) != 0 {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return e, fmt.Errorf("%w: %v", ErrInvalidContainer, err)
+		}
+		data = rest
+		switch tag {
+		case entryTags:
+			tags, err := parseTagCounts(value)
+			if err != nil {
+				return e, err
+			}
+			e.tags = tags
+		case entryBloom:
+			e.bloom = value
+		case entryOffset, entrySize:
+			v, err := entryNumber(tag, value, math.MaxInt64)
+			if err != nil {
+				return e, err
+			} else if tag == entryOffset {
+				e.offset = int64(v)
+			} else {
+				e.size = int64(v)
+			}
+		case entryFirst, entryCount:
+			v, err := entryNumber(tag, value, math.MaxInt)
+			if err != nil {
+				return e, err
+			} else if tag == entryFirst {
+				e.first = int(v)
+			} else {
+				e.count = int(v)
+			}
+		}
+	}
+	return e, nil
+}
+
+// entryNumber decodes the value of a numeric field of an index entry, and
+// reports an error if it is not a valid number at most max.
+func entryNumber(tag int, value []byte, max uint64) (uint64, error) {
+	if len(value) > 8 {
+		return 0, fmt.Errorf("%w: index entry tag %d: %v", ErrInvalidContainer, tag, ErrInvalidNumber)
+	}
+	v := UnpackUint64(value)
+	if v > max {
+		return 0, fmt.Errorf("%w: index entry tag %d: value %d out of range", ErrInvalidContainer, tag, v)
+	}
+	return v, nil
+}
+
+// parseTagCounts decodes the tag counts of a block summary.
+func parseTagCounts(data []byte) (map[int]int, error) {
+	tags := make(map[int]int)
+	for len(data) != 0 {
+		tag, rest, err := nextValue(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContainer, err)
+		}
+		count, rest, err := nextValue(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidContainer, err)
+		}
+		tags[int(UnpackUint64(tag))] = int(UnpackUint64(count))
+		data = rest
+	}
+	return tags, nil
+}
+
+// Len reports the number of records in the container.
+func (b *BlockReader) Len() int {
+	if len(b.blocks) == 0 {
+		return 0
+	}
+	last := b.blocks[len(b.blocks)-1]
+	return last.first + last.count
+}
+
+// Record returns the encoding of the record at index i of the container,
+// 0 ≤ i < Len. Only the block containing the record is read.
+func (b *BlockReader) Record(i int) ([]byte, error) {
+	if i < 0 || i >= b.Len() {
+		return nil, fmt.Errorf("record %d out of range [0, %d)", i, b.Len())
+	}
+	k := sort.Search(len(b.blocks), func(k int) bool {
+		return b.blocks[k].first+b.blocks[k].count > i
+	})
+	if err := b.load(k); err != nil {
+		return nil, err
+	}
+	return b.recs[i-b.blocks[k].first], nil
+}
+
+// load reads and decompresses block k, if it is not already loaded.
+func (b *BlockReader) load(k int) error {
+	if k == b.cur {
+		return nil
+	}
+	e := b.blocks[k]
+	value, err := b.readBlock(k)
+	if err != nil {
+		return err
+	}
+	data, err := b.opts.decompress(value)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", k, err)
+	}
+	recs, err := Split(data)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", k, err)
+	} else if len(recs) != e.count {
+		return fmt.Errorf("block %d has %d records, want %d", k, len(recs), e.count)
+	}
+	b.cur, b.recs = k, recs
+	return nil
+}
+
+// A BlockSummary describes the contents of one block of a container.
+type BlockSummary struct {
+	First int // the index of the first record in the block
+	Count int // the number of records in the block
+
+	// The number of records with each tag in the block, or nil if the
+	// container was written without summaries (see BlockOptions.Summary).
+	Tags map[int]int
+}
+
+// NumBlocks reports the number of blocks in the container.
+func (b *BlockReader) NumBlocks() int { return len(b.blocks) }
+
+// Summary returns a summary of block k of the container, 0 ≤ k < NumBlocks.
+func (b *BlockReader) Summary(k int) BlockSummary {
+	e := b.blocks[k]
+	return BlockSummary{First: e.first, Count: e.count, Tags: e.tags}
+}
+
+// MayContain reports whether the container may contain a record with the
+// Bloom filter tag (see BlockOptions.BloomTag) whose value is key. If it
+// reports false, no such record exists. If the container has no Bloom
+// filter, MayContain reports true.
+func (b *BlockReader) MayContain(key []byte) bool {
+	if b.bloom == nil {
+		return true
+	}
+	return bloomContains(b.bloom, keyHash(key))
+}
+
+// BlocksFor returns the indexes of the blocks that may contain a record with
+// the Bloom filter tag whose value is key. If the container has no Bloom
+// filters, all blocks are returned.
+func (b *BlockReader) BlocksFor(key []byte) []int {
+	if !b.MayContain(key) {
+		return nil
+	}
+	h := keyHash(key)
+	var out []int
+	for k, e := range b.blocks {
+		if e.bloom == nil || bloomContains(e.bloom, h) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// readBlock reads block k, and decrypts it if the container is encrypted.
+// The result is still compressed.
+func (b *BlockReader) readBlock(k int) ([]byte, error) {
+	e := b.blocks[k]
+	buf := make([]byte, e.size)
+	if _, err := b.r.ReadAt(buf, e.offset); err != nil {
+		return nil, err
+	}
+	tag, value, rest, err := nextRecord(buf)
+	if err != nil || tag != blockData || len(rest) != 0 {
+		return nil, fmt.Errorf("block %d: %w", k, ErrInvalidContainer)
+	}
+	if b.aead != nil {
+		value, err = openBlock(b.aead, value, b.seal.aad(k))
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", k, err)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,290 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestBlocks(t *testing.T) {
+	const numRecords = 500
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf("record %d of a highly repetitive sequence", i))
+	}
+
+	var buf bytes.Buffer
+	w := binpack.NewBlockWriter(&buf, &binpack.BlockOptions{BlockSize: 1024})
+	for i := 0; i < numRecords; i++ {
+		if err := w.Encode(i%7+1, value(i)); err != nil {
+			t.Fatalf("Encode %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	t.Logf("Container is %d bytes", buf.Len())
+
+	// The container is itself a sequence of records.
+	if _, err := binpack.Split(buf.Bytes()); err != nil {
+		t.Errorf("Split container: %v", err)
+	}
+
+	r, err := binpack.OpenBlocks(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	if err != nil {
+		t.Fatalf("OpenBlocks failed: %v", err)
+	}
+	if got := r.Len(); got != numRecords {
+		t.Errorf("Len: got %d, want %d", got, numRecords)
+	}
+	for _, i := range []int{0, 499, 250, 1, 251, 17} {
+		rec, err := r.Record(i)
+		if err != nil {
+			t.Errorf("Record(%d) failed: %v", i, err)
+			continue
+		}
+		want := binpack.NewEncoder(nil)
+		want.Encode(i%7+1, value(i))
+		if !bytes.Equal(rec, want.Data.Bytes()) {
+			t.Errorf("Record(%d): got %q, want %q", i, rec, want.Data.Bytes())
+		}
+	}
+	if _, err := r.Record(numRecords); err == nil {
+		t.Errorf("Record(%d): got nil, want error", numRecords)
+	}
+
+	// A reader with the wrong codec is rejected.
+	other := &binpack.BlockOptions{
+		Codec:      "identity",
+		Compress:   func(data []byte) ([]byte, error) { return data, nil },
+		Decompress: func(data []byte) ([]byte, error) { return data, nil },
+	}
+	if _, err := binpack.OpenBlocks(bytes.NewReader(buf.Bytes()), int64(buf.Len()), other); err == nil {
+		t.Error("OpenBlocks with wrong codec: got nil, want error")
+	}
+
+	// ReadBlocks checks the codec recorded in the index, even if the blocks
+	// can be read with the reader's codec.
+	var ibuf bytes.Buffer
+	iw := binpack.NewBlockWriter(&ibuf, other)
+	iw.Encode(1, []byte("x"))
+	if err := iw.Close(); err != nil {
+		t.Fatalf("Close (identity): unexpected error: %v", err)
+	}
+	raw := &binpack.BlockOptions{
+		Codec:      "raw",
+		Compress:   other.Compress,
+		Decompress: other.Decompress,
+	}
+	for _, test := range []struct {
+		opts *binpack.BlockOptions
+		ok   bool
+	}{{other, true}, {raw, false}} {
+		err := binpack.ReadBlocks(bytes.NewReader(ibuf.Bytes()), test.opts, func(int, []byte) error { return nil })
+		if (err == nil) != test.ok {
+			t.Errorf("ReadBlocks (codec %q): got error %v, want success %v", test.opts.Codec, err, test.ok)
+		}
+	}
+
+	// Truncated data are not a valid container.
+	trunc := buf.Bytes()[:buf.Len()-5]
+	if _, err := binpack.OpenBlocks(bytes.NewReader(trunc), int64(len(trunc)), nil); !errors.Is(err, binpack.ErrInvalidContainer) {
+		t.Errorf("OpenBlocks(truncated): got %v, want %v", err, binpack.ErrInvalidContainer)
+	}
+}
+
+func TestBlockSummary(t *testing.T) {
+	var buf bytes.Buffer
+	w := binpack.NewBlockWriter(&buf, &binpack.BlockOptions{
+		BlockSize: 256,
+		Summary:   true,
+		BloomTag:  1,
+	})
+	for i := 0; i < 200; i++ {
+		// Tag 1 holds a key; tag 2 a payload; tag 3 appears only early on.
+		w.Encode(1, []byte(fmt.Sprintf("key-%d", i)))
+		w.Encode(2, []byte("payload"))
+		if i < 10 {
+			w.Encode(3, []byte("rare"))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	r, err := binpack.OpenBlocks(bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil)
+	if err != nil {
+		t.Fatalf("OpenBlocks failed: %v", err)
+	}
+	if r.NumBlocks() < 10 {
+		t.Fatalf("Got %d blocks, want at least 10", r.NumBlocks())
+	}
+
+	var total, rare int
+	for k := 0; k < r.NumBlocks(); k++ {
+		s := r.Summary(k)
+		if s.Tags == nil {
+			t.Fatalf("Block %d has no tag summary", k)
+		}
+		n := 0
+		for _, c := range s.Tags {
+			n += c
+		}
+		if n != s.Count {
+			t.Errorf("Block %d: tag counts sum to %d, want %d", k, n, s.Count)
+		}
+		total += s.Count
+		rare += s.Tags[3]
+	}
+	if total != r.Len() || rare != 10 {
+		t.Errorf("Summaries: got %d records and %d of tag 3, want %d and 10", total, rare, r.Len())
+	}
+
+	// Each present key is found in the block that holds it.
+	for _, i := range []int{0, 77, 199} {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if !r.MayContain(key) {
+			t.Errorf("MayContain(%q): got false, want true", key)
+		}
+		found := false
+		for _, k := range r.BlocksFor(key) {
+			s := r.Summary(k)
+			for j := s.First; j < s.First+s.Count; j++ {
+				rec, err := r.Record(j)
+				if err != nil {
+					t.Fatalf("Record(%d) failed: %v", j, err)
+				}
+				if bytes.HasSuffix(rec, key) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Key %q not found in candidate blocks", key)
+		}
+	}
+
+	// Absent keys are mostly excluded.
+	var hits int
+	for i := 0; i < 100; i++ {
+		hits += len(r.BlocksFor([]byte(fmt.Sprintf("absent-%d", i))))
+	}
+	if max := 100 * r.NumBlocks() / 10; hits > max {
+		t.Errorf("Absent keys matched %d blocks, want at most %d", hits, max)
+	}
+}
+
+func TestBlockIndexErrors(t *testing.T) {
+	var buf bytes.Buffer
+	w := binpack.NewBlockWriter(&buf, &binpack.BlockOptions{BlockSize: 64})
+	for i := 0; i < 20; i++ {
+		if err := w.Encode(1, []byte(fmt.Sprintf("record %d", i))); err != nil {
+			t.Fatalf("Encode %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	// Locate the index record, which precedes the trailer.
+	const trailerSize = 10
+	recs, err := binpack.Split(data)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	indexRec := recs[len(recs)-2]
+	start := len(data) - trailerSize - len(indexRec)
+	index, err := binpack.DecodeRecord(indexRec)
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+
+	// withEntry returns a copy of the container in which field tag of index
+	// entry k has the given value.
+	withEntry := func(k, tag int, value uint64) []byte {
+		t.Helper()
+		s, e, entry, err := binpack.FindRecord(index.Value, 2, k)
+		if err != nil {
+			t.Fatalf("FindRecord entry %d: %v", k, err)
+		}
+		entry, err = binpack.SetPath(entry, binpack.PackUint64(value), tag)
+		if err != nil {
+			t.Fatalf("SetPath failed: %v", err)
+		}
+		out := binpack.NewEncoder(nil)
+		out.WriteRecords(index.Value[:s])
+		out.Encode(2, entry)
+		out.WriteRecords(index.Value[e:])
+
+		c := binpack.NewEncoder(nil)
+		c.WriteRecords(data[:start])
+		c.Encode(index.Tag, out.Data.Bytes())
+		c.WriteRecords(data[len(data)-trailerSize:])
+		return c.Data.Bytes()
+	}
+	// The first block begins at offset 0, so this does not change it.
+	if _, err := binpack.OpenBlocks(bytes.NewReader(withEntry(0, 1, 0)), int64(len(data)), nil); err != nil {
+		t.Fatalf("OpenBlocks unchanged: unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		k, tag int
+		value  uint64
+	}{
+		{"Huge size", 0, 2, 1 << 62},
+		{"Negative size", 0, 2, 1 << 63},
+		{"Offset past end", 1, 1, uint64(len(data))},
+		{"Overlapping", 1, 1, 0},
+		{"Gap in records", 1, 3, 1000},
+		{"Empty block", 0, 4, 0},
+		{"Huge count", 0, 4, 1 << 62},
+	} {
+		bad := withEntry(test.k, test.tag, test.value)
+		if _, err := binpack.OpenBlocks(bytes.NewReader(bad), int64(len(bad)), nil); !errors.Is(err, binpack.ErrInvalidContainer) {
+			t.Errorf("OpenBlocks(%s): got %v, want %v", test.name, err, binpack.ErrInvalidContainer)
+		}
+	}
+
+	// A codec without functions is reported, rather than causing a panic.
+	nofunc := &binpack.BlockOptions{Codec: "zstd"}
+	w = binpack.NewBlockWriter(new(bytes.Buffer), nofunc)
+	w.Encode(1, []byte("x"))
+	if err := w.Close(); err == nil {
+		t.Error("Close with codec but no functions: got nil, want error")
+	}
+
+	// Codec functions without a codec name are reported, rather than being
+	// ignored in favour of deflate.
+	noname := &binpack.BlockOptions{
+		Compress:   func(data []byte) ([]byte, error) { return data, nil },
+		Decompress: func(data []byte) ([]byte, error) { return data, nil },
+	}
+	w = binpack.NewBlockWriter(new(bytes.Buffer), noname)
+	if err := w.Encode(1, []byte("x")); err == nil {
+		t.Error("Encode with functions but no codec: got nil, want error")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("Close with functions but no codec: got nil, want error")
+	}
+	if _, err := binpack.OpenBlocks(bytes.NewReader(data), int64(len(data)), noname); err == nil {
+		t.Error("OpenBlocks with functions but no codec: got nil, want error")
+	}
+	if err := binpack.ReadBlocks(bytes.NewReader(data), noname, func(int, []byte) error {
+		return nil
+	}); err == nil {
+		t.Error("ReadBlocks with functions but no codec: got nil, want error")
+	}
+
+	deflate := &binpack.BlockOptions{Codec: "deflate"}
+	r, err := binpack.OpenBlocks(bytes.NewReader(data), int64(len(data)), deflate)
+	if err != nil {
+		t.Fatalf("OpenBlocks(deflate): unexpected error: %v", err)
+	} else if _, err := r.Record(0); err != nil {
+		t.Errorf("Record(0) with built-in deflate: unexpected error: %v", err)
+	}
+}
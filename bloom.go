@@ -0,0 +1,59 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import "hash/fnv"
+
+// keyHash returns the hash of a key for a Bloom filter.
+func keyHash(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+// makeBloom constructs a Bloom filter containing the given key hashes, with
+// approximately bitsPerKey bits per key. The first byte of the filter is the
+// number of probes; the remainder are the bits.
+func makeBloom(hashes []uint64, bitsPerKey int) []byte {
+	nbits := len(hashes) * bitsPerKey
+	if nbits < 64 {
+		nbits = 64
+	}
+	nbytes := (nbits + 7) / 8
+	nbits = nbytes * 8
+
+	// The optimal number of probes is ln(2) times the bits per key.
+	k := bitsPerKey * 69 / 100
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	filter := make([]byte, 1+nbytes)
+	filter[0] = byte(k)
+	for _, h := range hashes {
+		h1, h2 := h, h>>33|h<<31 // double hashing
+		for i := 0; i < k; i++ {
+			pos := (h1 + uint64(i)*h2) % uint64(nbits)
+			filter[1+pos/8] |= 1 << (pos % 8)
+		}
+	}
+	return filter
+}
+
+// bloomContains reports whether the Bloom filter may contain the key with
+// hash h. An empty filter may contain any key.
+func bloomContains(filter []byte, h uint64) bool {
+	if len(filter) < 2 {
+		return true
+	}
+	k, nbits := int(filter[0]), uint64(len(filter)-1)*8
+	h1, h2 := h, h>>33|h<<31
+	for i := 0; i < k; i++ {
+		pos := (h1 + uint64(i)*h2) % nbits
+		if filter[1+pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
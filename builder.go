@@ -0,0 +1,184 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A SortedBuilder accumulates records in any order, and keeps them sorted by
+// tag. Records with the same tag are kept in the order they were added. This
+// allows a message to be produced in canonical order without first
+// collecting its fields in a struct. The zero value is ready for use.
+type SortedBuilder struct {
+	msg  Message
+	size int // encoded size of msg
+}
+
+// Add adds a record with the given tag and value. The value is copied. It
+// reports an error without adding the record if the tag or value cannot be
+// encoded.
+func (b *SortedBuilder) Add(tag int, value []byte) error {
+	if tag < 0 || tag > wire.MaxTag {
+		return fmt.Errorf("tag %d: %w", tag, ErrTagTooLarge)
+	} else if len(value) > wire.MaxValueLen {
+		return fmt.Errorf("tag %d: %w", tag, ErrValueTooLarge)
+	}
+	i := sort.Search(len(b.msg), func(i int) bool { return b.msg[i].Tag > tag })
+	b.msg = append(b.msg, Record{})
+	copy(b.msg[i+1:], b.msg[i:])
+	b.msg[i] = Record{Tag: tag, Value: copyOf(value)}
+	b.size += wire.EncodedSize(tag, value)
+	return nil
+}
+
+// AddRecords adds each of the encoded records in data. It reports an error
+// without adding any records if data is not a well-formed sequence of
+// records.
+func (b *SortedBuilder) AddRecords(data []byte) error {
+	var recs []Record
+	for len(data) != 0 {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return err
+		}
+		recs = append(recs, Record{Tag: tag, Value: value})
+		data = rest
+	}
+	for _, r := range recs {
+		b.Add(r.Tag, r.Value) // already known to be valid
+	}
+	return nil
+}
+
+// Len reports the number of records in b.
+func (b *SortedBuilder) Len() int { return len(b.msg) }
+
+// Message returns the records of b in sorted order. The caller must not
+// modify the result.
+func (b *SortedBuilder) Message() Message { return b.msg }
+
+// Bytes returns the encoding of the records of b in sorted order.
+func (b *SortedBuilder) Bytes() []byte {
+	e := NewEncoder(newBufSize(b.size))
+	b.msg.Encode(e) // cannot fail; records were checked by Add
+	return e.Data.Bytes()
+}
+
+// Reset discards all the records of b.
+func (b *SortedBuilder) Reset() { b.msg, b.size = nil, 0 }
+
+// A Builder constructs an encoded message from a chain of method calls, one
+// per record, without defining a struct type for the message. For example:
+//
+//	data, err := new(binpack.Builder).
+//	   Str(1, "name").
+//	   Uint(2, 5).
+//	   Nested(3, func(b *binpack.Builder) { b.Bool(1, true) }).
+//	   Result()
+//
+// Records are encoded in the order they are added, using the standard
+// encodings of the binpack package for each type. If adding a record fails,
+// the Builder records the error and ignores all subsequent records; the error
+// is reported by Err and Result. The zero value is ready for use.
+type Builder struct {
+	enc *Encoder
+	err error
+}
+
+// add encodes a record with the given tag and value, unless b has failed.
+func (b *Builder) add(tag int, value []byte) *Builder {
+	if b.err != nil {
+		return b
+	} else if b.enc == nil {
+		b.enc = NewEncoder(nil)
+	}
+	if err := b.enc.Encode(tag, value); err != nil {
+		b.err = fmt.Errorf("tag %d: %w", tag, err)
+	}
+	return b
+}
+
+// Raw adds a record with the given tag whose value is a copy of data.
+func (b *Builder) Raw(tag int, data []byte) *Builder { return b.add(tag, data) }
+
+// Str adds a record with the given tag whose value is the bytes of s.
+func (b *Builder) Str(tag int, s string) *Builder { return b.add(tag, []byte(s)) }
+
+// Uint adds a record with the given tag whose value is z, as by
+// wire.PackUint64.
+func (b *Builder) Uint(tag int, z uint64) *Builder { return b.add(tag, wire.PackUint64(z)) }
+
+// Int adds a record with the given tag whose value is z, as by
+// wire.PackInt64.
+func (b *Builder) Int(tag int, z int64) *Builder { return b.add(tag, wire.PackInt64(z)) }
+
+// Float adds a record with the given tag whose value is v, as by
+// wire.PackFloat64.
+func (b *Builder) Float(tag int, v float64) *Builder { return b.add(tag, wire.PackFloat64(v)) }
+
+// Bool adds a record with the given tag whose value is a single byte, 1 if v
+// is true and 0 otherwise.
+func (b *Builder) Bool(tag int, v bool) *Builder {
+	if v {
+		return b.add(tag, []byte{1})
+	}
+	return b.add(tag, []byte{0})
+}
+
+// Value adds a record with the given tag whose value is the encoding of v by
+// Marshal.
+func (b *Builder) Value(tag int, v interface{}) *Builder {
+	if b.err != nil {
+		return b
+	}
+	data, err := Marshal(v)
+	if err != nil {
+		b.err = fmt.Errorf("tag %d: %w", tag, err)
+		return b
+	}
+	return b.add(tag, data)
+}
+
+// Nested adds a record with the given tag whose value is the message built
+// by calling f with a new empty Builder. If f leaves its Builder with an
+// error, that error becomes the error of b.
+func (b *Builder) Nested(tag int, f func(*Builder)) *Builder {
+	if b.err != nil {
+		return b
+	}
+	var sub Builder
+	f(&sub)
+	if sub.err != nil {
+		b.err = fmt.Errorf("tag %d: %w", tag, sub.err)
+		return b
+	}
+	return b.add(tag, sub.Bytes())
+}
+
+// Err reports the first error that occurred while adding records to b, or nil.
+func (b *Builder) Err() error { return b.err }
+
+// Bytes returns the encoded records added to b. If b has failed, the result
+// contains only the records added before the failure. The caller must not
+// modify the result.
+func (b *Builder) Bytes() []byte {
+	if b.enc == nil {
+		return nil
+	}
+	return b.enc.Data.Bytes()
+}
+
+// Result returns the encoded records added to b and the error reported by Err.
+func (b *Builder) Result() ([]byte, error) { return b.Bytes(), b.err }
+
+// Reset discards all the records and any error of b.
+func (b *Builder) Reset() {
+	if b.enc != nil {
+		b.enc.Data.Reset()
+	}
+	b.err = nil
+}
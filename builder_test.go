@@ -0,0 +1,126 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSortedBuilder(t *testing.T) {
+	var b binpack.SortedBuilder
+	for _, r := range []struct {
+		tag   int
+		value string
+	}{
+		{5, "e1"}, {2, "b"}, {5, "e2"}, {1, "a"}, {9, "i"}, {5, "e3"}, {3, "c"},
+	} {
+		if err := b.Add(r.tag, []byte(r.value)); err != nil {
+			t.Fatalf("Add(%d, %q) failed: %v", r.tag, r.value, err)
+		}
+	}
+	more := binpack.NewEncoder(nil)
+	more.Encode(4, []byte("d"))
+	more.Encode(0, []byte("z"))
+	if err := b.AddRecords(more.Data.Bytes()); err != nil {
+		t.Fatalf("AddRecords failed: %v", err)
+	}
+
+	want := binpack.Message{
+		{Tag: 0, Value: []byte("z")},
+		{Tag: 1, Value: []byte("a")},
+		{Tag: 2, Value: []byte("b")},
+		{Tag: 3, Value: []byte("c")},
+		{Tag: 4, Value: []byte("d")},
+		{Tag: 5, Value: []byte("e1")},
+		{Tag: 5, Value: []byte("e2")},
+		{Tag: 5, Value: []byte("e3")},
+		{Tag: 9, Value: []byte("i")},
+	}
+	if diff := cmp.Diff(want, b.Message()); diff != "" {
+		t.Errorf("Message (-want, +got):\n%s", diff)
+	}
+	wantBits, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if diff := cmp.Diff(wantBits, b.Bytes()); diff != "" {
+		t.Errorf("Bytes (-want, +got):\n%s", diff)
+	}
+
+	if err := b.Add(-1, nil); !errors.Is(err, binpack.ErrTagTooLarge) {
+		t.Errorf("Add(-1): got %v, want %v", err, binpack.ErrTagTooLarge)
+	}
+	if b.Len() != len(want) {
+		t.Errorf("Len: got %d, want %d", b.Len(), len(want))
+	}
+	b.Reset()
+	if b.Len() != 0 || len(b.Bytes()) != 0 {
+		t.Errorf("After Reset: got %d records, want 0", b.Len())
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	type inner struct {
+		OK   bool   `binpack:"tag=1"`
+		Note string `binpack:"tag=2"`
+	}
+	type outer struct {
+		Name  string  `binpack:"tag=1"`
+		Count uint64  `binpack:"tag=2"`
+		Delta int64   `binpack:"tag=3"`
+		Ratio float64 `binpack:"tag=4"`
+		Sub   inner   `binpack:"tag=5"`
+		Raw   []byte  `binpack:"tag=6"`
+		Val   inner   `binpack:"tag=7"`
+	}
+	data, err := new(binpack.Builder).
+		Str(1, "x").
+		Uint(2, 5).
+		Int(3, -3).
+		Float(4, 1.5).
+		Nested(5, func(b *binpack.Builder) { b.Bool(1, true).Str(2, "hi") }).
+		Raw(6, []byte{1, 2, 3}).
+		Value(7, inner{Note: "v"}).
+		Result()
+	if err != nil {
+		t.Fatalf("Builder failed: %v", err)
+	}
+	want := outer{
+		Name: "x", Count: 5, Delta: -3, Ratio: 1.5,
+		Sub: inner{OK: true, Note: "hi"},
+		Raw: []byte{1, 2, 3},
+		Val: inner{Note: "v"},
+	}
+	wantBits, err := binpack.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if diff := cmp.Diff(wantBits, data); diff != "" {
+		t.Errorf("Builder output (-want, +got):\n%s", diff)
+	}
+	var got outer
+	if err := binpack.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal (-want, +got):\n%s", diff)
+	}
+
+	// Errors are sticky, and errors in nested builders propagate.
+	var b binpack.Builder
+	b.Str(1, "a").Nested(2, func(b *binpack.Builder) { b.Uint(-1, 0) }).Str(3, "c")
+	if err := b.Err(); !errors.Is(err, binpack.ErrTagTooLarge) {
+		t.Errorf("Nested error: got %v, want %v", err, binpack.ErrTagTooLarge)
+	}
+	if got, want := string(b.Bytes()), "\x01a"; got != want {
+		t.Errorf("Bytes after error: got %q, want %q", got, want)
+	}
+	b.Reset()
+	if b.Err() != nil || len(b.Bytes()) != 0 {
+		t.Errorf("After Reset: got %q, %v; want empty", b.Bytes(), b.Err())
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Tags of the records in a bus envelope.
+const (
+	busSchema  = 1 // the schema identifier
+	busPayload = 2 // the payload
+	busHeader  = 3 // a header, as a nested message (repeated)
+
+	busHeaderKey   = 1 // the header name
+	busHeaderValue = 2 // the header value
+)
+
+// A BusEnvelope wraps a binpack payload for transport on a message bus, with
+// enough metadata for a consumer to choose how to decode it. Its encoding is
+// a binpack message with the schema identifier, the payload, and one nested
+// record for each header, in order of header name.
+type BusEnvelope struct {
+	// An identifier for the schema of the payload, which consumers use to
+	// choose a decoder. The format of identifiers is up to the application.
+	SchemaID string
+
+	// The encoded payload.
+	Payload []byte
+
+	// Application-defined metadata, such as trace or routing information.
+	Headers map[string]string
+}
+
+// PackEnvelope returns the encoding of an envelope with the given schema ID,
+// payload, and headers.
+func PackEnvelope(schemaID string, payload []byte, headers map[string]string) ([]byte, error) {
+	return (&BusEnvelope{SchemaID: schemaID, Payload: payload, Headers: headers}).MarshalBinary()
+}
+
+// UnpackEnvelope decodes an envelope encoded by PackEnvelope.
+func UnpackEnvelope(data []byte) (*BusEnvelope, error) {
+	env := new(BusEnvelope)
+	if err := env.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// MarshalBinary encodes e, and implements the encoding.BinaryMarshaler
+// interface. The encoding is deterministic.
+func (e *BusEnvelope) MarshalBinary() ([]byte, error) {
+	keys := make([]string, 0, len(e.Headers))
+	for key := range e.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	recs := []Record{
+		{Tag: busSchema, Value: []byte(e.SchemaID)},
+		{Tag: busPayload, Value: e.Payload},
+	}
+	for _, key := range keys {
+		hdr, err := EncodeRecord(Record{Tag: busHeaderKey, Value: []byte(key)})
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", key, err)
+		}
+		hdr, err = AppendRecord(hdr, busHeaderValue, []byte(e.Headers[key]))
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", key, err)
+		}
+		recs = append(recs, Record{Tag: busHeader, Value: hdr})
+	}
+	enc := NewEncoder(nil)
+	if err := enc.EncodeAll(recs); err != nil {
+		return nil, err
+	}
+	return enc.Data.Bytes(), nil
+}
+
+// UnmarshalBinary decodes data into e, replacing its contents, and
+// implements the encoding.BinaryUnmarshaler interface. Records with unknown
+// tags are ignored, so that fields can be added in the future.
+func (e *BusEnvelope) UnmarshalBinary(data []byte) error {
+	var env BusEnvelope
+	for len(data) != 0 {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return fmt.Errorf("invalid envelope: %w", err)
+		}
+		data = rest
+		switch tag {
+		case busSchema:
+			env.SchemaID = string(value)
+		case busPayload:
+			env.Payload = copyOf(value)
+		case busHeader:
+			key, err := headerField(value, busHeaderKey)
+			if err != nil {
+				return fmt.Errorf("invalid envelope header: %w", err)
+			}
+			val, err := headerField(value, busHeaderValue)
+			if err != nil {
+				return fmt.Errorf("invalid envelope header %q: %w", key, err)
+			}
+			if env.Headers == nil {
+				env.Headers = make(map[string]string)
+			}
+			env.Headers[string(key)] = string(val)
+		}
+	}
+	*e = env
+	return nil
+}
+
+// headerField returns the value of the record with the given tag in the
+// encoding of a header. It reports an error if there is no such record.
+func headerField(data []byte, tag int) ([]byte, error) {
+	_, _, value, ok, err := findRecord(data, tag, 0)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("tag %d: %w", tag, ErrNotFound)
+	}
+	return value, nil
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBusEnvelope(t *testing.T) {
+	type event struct {
+		Kind string `binpack:"tag=1"`
+		ID   int    `binpack:"tag=2"`
+	}
+	payload, err := binpack.Marshal(event{Kind: "click", ID: 25})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	headers := map[string]string{"trace": "abc123", "region": "us-east", "empty": ""}
+
+	data, err := binpack.PackEnvelope("events.v2", payload, headers)
+	if err != nil {
+		t.Fatalf("PackEnvelope failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := binpack.PackEnvelope("events.v2", payload, headers)
+		if err != nil {
+			t.Fatalf("PackEnvelope failed: %v", err)
+		} else if diff := cmp.Diff(data, again); diff != "" {
+			t.Fatalf("PackEnvelope is not deterministic (-first, +later):\n%s", diff)
+		}
+	}
+
+	// Unknown records are ignored.
+	data = append(data, "\x63\x00"...)
+	env, err := binpack.UnpackEnvelope(data)
+	if err != nil {
+		t.Fatalf("UnpackEnvelope failed: %v", err)
+	}
+	want := &binpack.BusEnvelope{SchemaID: "events.v2", Payload: payload, Headers: headers}
+	if diff := cmp.Diff(want, env); diff != "" {
+		t.Errorf("UnpackEnvelope (-want, +got):\n%s", diff)
+	}
+	var got event
+	if err := binpack.Unmarshal(env.Payload, &got); err != nil {
+		t.Fatalf("Unmarshal payload failed: %v", err)
+	} else if got != (event{Kind: "click", ID: 25}) {
+		t.Errorf("Payload: got %+v", got)
+	}
+
+	if _, err := binpack.UnpackEnvelope(data[:len(data)-3]); !errors.Is(err, binpack.ErrTruncated) {
+		t.Errorf("UnpackEnvelope truncated: got %v, want %v", err, binpack.ErrTruncated)
+	}
+	if _, err := binpack.UnpackEnvelope([]byte("\x03\x82\x01k")); !errors.Is(err, binpack.ErrNotFound) {
+		t.Errorf("UnpackEnvelope without header value: got %v, want %v", err, binpack.ErrNotFound)
+	}
+}
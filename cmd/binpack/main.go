@@ -0,0 +1,161 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+// Program binpack is a command-line tool for inspecting and editing data in
+// the binpack encoding.
+//
+// Usage:
+//
+//	binpack <command> [flags] [args...]
+//
+// Run "binpack help" for a list of commands. Commands that read input take
+// an optional file name, and read standard input if it is omitted or "-".
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/creachadair/binpack"
+)
+
+// A command is a subcommand of the tool.
+type command struct {
+	usage string // argument synopsis
+	help  string // one-line description
+	run   func(fs *flag.FlagSet, args []string) error
+	flags func(fs *flag.FlagSet) // if non-nil, registers flags
+}
+
+var commands = map[string]*command{
+	"dump": {
+		usage: "[-split] [file]",
+		help:  "Print an annotated hex dump of the records in the input.",
+		run:   runDump,
+		flags: dumpFlags,
+	},
+	"filter": {
+		usage: "[-keep tags] [-drop tags] [file]",
+		help:  "Copy the records of the input that pass the filters to stdout.",
+		run:   runFilter,
+		flags: filterFlags,
+	},
+	"jsonl": {
+		usage: "[-reverse] [file]",
+		help:  "Convert the records of the input to JSON lines on stdout, or the reverse.",
+		run:   runJSONL,
+		flags: jsonlFlags,
+	},
+	"map": {
+		usage: "[-keep tags] [-drop tags] [-tags old=new,...] [-set tag=value] [file]",
+		help:  "Copy the records of the input to stdout, renumbering tags and replacing values.",
+		run:   runMap,
+		flags: mapFlags,
+	},
+	"query": {
+		usage: "[-o raw|hex|json] <query> [file]",
+		help:  "Print the value selected by a query (see below).",
+		run:   runQuery,
+		flags: queryFlags,
+	},
+	"rekey": {
+		usage: "[-key file] [-new-key file] [-new-id id] <file>",
+		help:  "Re-encrypt a block container under a new key, and write it to stdout.",
+		run:   runRekey,
+		flags: rekeyFlags,
+	},
+	"set": {
+		usage: "[-v raw|hex] <query> <value> [file]",
+		help:  "Replace the value selected by a query, and print the result.",
+		run:   runSet,
+		flags: setFlags,
+	},
+}
+
+const queryHelp = `
+A query selects a value by the path of tags leading to it, starting from the
+records of the input. Each step has the form .tag or .tag[n], where n selects
+the nth record with that tag (counting from 0, or from -1 at the end). The
+default is the first record. For example,
+
+  .5.2[1]
+
+selects the second record with tag 2 in the value of the first record with
+tag 5. The query "." selects the whole input.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "binpack: unknown command %q\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: binpack %s %s\n\n%s\n", name, cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Parse(os.Args[2:])
+	if err := cmd.run(fs, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "binpack %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: binpack <command> [flags] [args...]\n\nCommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(w, "  %s %s\n      %s\n", name, cmd.usage, cmd.help)
+	}
+	fmt.Fprint(w, queryHelp)
+}
+
+// readInput reads the contents of the named file, or of standard input if
+// the args are empty or name "-". It reports an error for extra arguments.
+func readInput(args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("extra arguments after %q", args[0])
+	} else if len(args) == 0 || args[0] == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(args[0])
+}
+
+var dumpOpts binpack.DumpOptions
+
+func dumpFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&dumpOpts.SplitTags, "split", false, "Show each tag split into its ID and kind")
+}
+
+func runDump(fs *flag.FlagSet, args []string) error {
+	data, err := readInput(args)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = dumpOpts.HexDump(&buf, data)
+	os.Stdout.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpSplit(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.WriteFile(input, []byte("\x0b\x05"), 0644); err != nil {
+		t.Fatalf("Writing input: %v", err)
+	}
+
+	// runDump writes to os.Stdout, so capture it in a file.
+	dump := func(args ...string) string {
+		t.Helper()
+		out, err := os.Create(filepath.Join(dir, "output"))
+		if err != nil {
+			t.Fatalf("Creating output: %v", err)
+		}
+		defer out.Close()
+		saved := os.Stdout
+		os.Stdout = out
+		defer func() { os.Stdout = saved }()
+		defer func() { dumpOpts.SplitTags = false }()
+
+		fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+		dumpFlags(fs)
+		if err := fs.Parse(append(args, input)); err != nil {
+			t.Fatalf("Parsing flags %q: %v", args, err)
+		}
+		if err := runDump(fs, fs.Args()); err != nil {
+			t.Fatalf("runDump %q: %v", args, err)
+		}
+		text, err := os.ReadFile(out.Name())
+		if err != nil {
+			t.Fatalf("Reading output: %v", err)
+		}
+		return string(text)
+	}
+
+	const split = "tag 11 [id 1 kind 3]"
+	if got := dump(); strings.Contains(got, split) || !strings.Contains(got, "tag 11") {
+		t.Errorf("dump: got %q, want tag 11 without %q", got, split)
+	}
+	if got := dump("-split"); !strings.Contains(got, split) {
+		t.Errorf("dump -split: got %q, want %q", got, split)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/binpack"
+)
+
+// A step is one step of a query, selecting the record with the given index
+// among the records with the given tag.
+type step struct {
+	tag   int
+	index int // negative values count from the end
+}
+
+// parseQuery parses a query of the form .tag[n].tag[n]...
+func parseQuery(s string) ([]step, error) {
+	if s == "." {
+		return nil, nil
+	} else if !strings.HasPrefix(s, ".") {
+		return nil, fmt.Errorf("query %q does not begin with %q", s, ".")
+	}
+	var q []step
+	for _, part := range strings.Split(s[1:], ".") {
+		st := step{}
+		tag := part
+		if i := strings.Index(part, "["); i >= 0 {
+			if !strings.HasSuffix(part, "]") {
+				return nil, fmt.Errorf("query %q: missing %q", s, "]")
+			}
+			n, err := strconv.Atoi(part[i+1 : len(part)-1])
+			if err != nil {
+				return nil, fmt.Errorf("query %q: invalid index %q", s, part[i+1:len(part)-1])
+			}
+			tag, st.index = part[:i], n
+		}
+		t, err := strconv.Atoi(tag)
+		if err != nil || t < 0 {
+			return nil, fmt.Errorf("query %q: invalid tag %q", s, tag)
+		}
+		st.tag = t
+		q = append(q, st)
+	}
+	return q, nil
+}
+
+func (s step) String() string {
+	if s.index == 0 {
+		return "." + strconv.Itoa(s.tag)
+	}
+	return fmt.Sprintf(".%d[%d]", s.tag, s.index)
+}
+
+// find locates the record selected by s in data, as binpack.FindRecord.
+func (s step) find(data []byte) (start, end int, value []byte, err error) {
+	return binpack.FindRecord(data, s.tag, s.index)
+}
+
+// get returns the value selected by q in data.
+func get(data []byte, q []step) ([]byte, error) {
+	for i, s := range q {
+		_, _, value, err := s.find(data)
+		if err != nil {
+			return nil, fmt.Errorf("at %s: %w", formatQuery(q[:i+1]), err)
+		}
+		data = value
+	}
+	return data, nil
+}
+
+// set returns a copy of data with the value selected by q replaced by value.
+// If the first record with some tag in the query does not exist, it is added
+// to the end of the enclosing message.
+func set(data []byte, q []step, value []byte) ([]byte, error) {
+	if len(q) == 0 {
+		return value, nil
+	}
+	s := q[0]
+	start, end, old, err := s.find(data)
+	if errors.Is(err, binpack.ErrNotFound) && s.index == 0 {
+		start, end, old = len(data), len(data), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("at %s: %w", s, err)
+	}
+	sub, err := set(old, q[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	if err := binpack.NewEncoder(&buf).Encode(s.tag, sub); err != nil {
+		return nil, err
+	}
+	buf.Write(data[end:])
+	return buf.Bytes(), nil
+}
+
+func formatQuery(q []step) string {
+	if len(q) == 0 {
+		return "."
+	}
+	var sb strings.Builder
+	for _, s := range q {
+		sb.WriteString(s.String())
+	}
+	return sb.String()
+}
+
+var (
+	outputFormat = "raw"
+	valueFormat  = "raw"
+)
+
+func queryFlags(fs *flag.FlagSet) {
+	fs.StringVar(&outputFormat, "o", outputFormat, "Output format (raw, hex, or json)")
+}
+
+func setFlags(fs *flag.FlagSet) {
+	fs.StringVar(&valueFormat, "v", valueFormat, "Value format (raw or hex)")
+}
+
+func runQuery(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("missing query")
+	}
+	q, err := parseQuery(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := readInput(args[1:])
+	if err != nil {
+		return err
+	}
+	value, err := get(data, q)
+	if err != nil {
+		return err
+	}
+	return writeValue(os.Stdout, outputFormat, value)
+}
+
+func runSet(fs *flag.FlagSet, args []string) error {
+	if len(args) < 2 {
+		fs.Usage()
+		return errors.New("missing query or value")
+	}
+	q, err := parseQuery(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := parseValue(valueFormat, args[1])
+	if err != nil {
+		return err
+	}
+	data, err := readInput(args[2:])
+	if err != nil {
+		return err
+	}
+	out, err := set(data, q, value)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// parseValue decodes a value given on the command line.
+func parseValue(format, s string) ([]byte, error) {
+	switch format {
+	case "raw":
+		return []byte(s), nil
+	case "hex":
+		return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	default:
+		return nil, fmt.Errorf("unknown value format %q", format)
+	}
+}
+
+// writeValue writes value to w in the given format.
+func writeValue(w io.Writer, format string, value []byte) error {
+	var err error
+	switch format {
+	case "raw":
+		_, err = w.Write(value)
+	case "hex":
+		_, err = fmt.Fprintln(w, hex.EncodeToString(value))
+	case "json":
+		var bits []byte
+		bits, err = json.MarshalIndent(jsonValue(value), "", "  ")
+		if err == nil {
+			_, err = fmt.Fprintln(w, string(bits))
+		}
+	default:
+		err = fmt.Errorf("unknown output format %q", format)
+	}
+	return err
+}
+
+// A jsonRecord is the JSON representation of a record.
+type jsonRecord struct {
+	Tag   int         `json:"tag"`
+	Value interface{} `json:"value"`
+}
+
+// jsonValue returns a JSON-friendly representation of value. A value that
+// appears to be a nested message is rendered as an array of records, text is
+// rendered as a string, and other values as an object with a "hex" field.
+func jsonValue(value []byte) interface{} {
+	if binpack.IsText(value) {
+		return string(value)
+	}
+	if recs, err := binpack.Split(value); err == nil && len(recs) != 0 {
+		out := make([]jsonRecord, len(recs))
+		for i, rec := range recs {
+			r, _ := binpack.DecodeRecord(rec)
+			out[i] = jsonRecord{Tag: r.Tag, Value: jsonValue(r.Value)}
+		}
+		return out
+	}
+	return map[string]string{"hex": hex.EncodeToString(value)}
+}
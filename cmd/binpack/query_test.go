@@ -0,0 +1,79 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestQuery(t *testing.T) {
+	// Build a message: 1: "a", 5 { 2: "x", 2: "y" }, 5 { 2: "z" }
+	inner := func(values ...string) []byte {
+		e := binpack.NewEncoder(nil)
+		for _, v := range values {
+			e.Encode(2, []byte(v))
+		}
+		return e.Data.Bytes()
+	}
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte("a"))
+	e.Encode(5, inner("x", "y"))
+	e.Encode(5, inner("z"))
+	data := e.Data.Bytes()
+
+	tests := []struct {
+		query, want string
+	}{
+		{".1", "a"},
+		{".5.2", "x"},
+		{".5.2[1]", "y"},
+		{".5[1].2", "z"},
+		{".5[-1].2[-1]", "z"},
+		{".5.2[2]", "error"},
+		{".3", "error"},
+	}
+	for _, test := range tests {
+		q, err := parseQuery(test.query)
+		if err != nil {
+			t.Errorf("parseQuery(%q) failed: %v", test.query, err)
+			continue
+		}
+		if s := formatQuery(q); s != strings.ReplaceAll(test.query, "[0]", "") {
+			t.Errorf("formatQuery(%q): got %q", test.query, s)
+		}
+		got, err := get(data, q)
+		if err != nil {
+			got = []byte("error")
+		}
+		if string(got) != test.want {
+			t.Errorf("get(%q): got %q, want %q", test.query, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "5", ".x", ".5[", ".5[a]", ".-1"} {
+		if q, err := parseQuery(bad); err == nil {
+			t.Errorf("parseQuery(%q): got %v, want error", bad, q)
+		}
+	}
+
+	// Set replaces existing values and creates missing ones.
+	q, _ := parseQuery(".5.2[1]")
+	out, err := set(data, q, []byte("Y"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	q2, _ := parseQuery(".7.1")
+	out, err = set(out, q2, []byte("new"))
+	if err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	for query, want := range map[string]string{".5.2[1]": "Y", ".5[1].2": "z", ".7.1": "new", ".1": "a"} {
+		q, _ := parseQuery(query)
+		if got, err := get(out, q); err != nil || string(got) != want {
+			t.Errorf("After set, get(%q): got (%q, %v), want %q", query, got, err, want)
+		}
+	}
+}
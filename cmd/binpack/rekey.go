@@ -0,0 +1,90 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/creachadair/binpack"
+)
+
+var (
+	oldKeyFile string
+	newKeyFile string
+	newKeyID   string
+)
+
+func rekeyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&oldKeyFile, "key", "", "File containing the current key (hex); empty if unencrypted")
+	fs.StringVar(&newKeyFile, "new-key", "", "File containing the new key (hex); empty to decrypt")
+	fs.StringVar(&newKeyID, "new-id", "", "Key ID to record for the new key")
+}
+
+func runRekey(fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errors.New("rekey requires exactly one input file")
+	}
+	from, err := keyOptions(oldKeyFile, "")
+	if err != nil {
+		return err
+	}
+	to, err := keyOptions(newKeyFile, newKeyID)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	err = binpack.RekeyBlocks(out, f, fi.Size(), from, to)
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// keyOptions returns block options using the AES-GCM key in the named file,
+// or nil if path is empty.
+func keyOptions(path, keyID string) (*binpack.BlockOptions, error) {
+	if path == "" {
+		return nil, nil
+	}
+	aead, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return &binpack.BlockOptions{AEAD: aead, KeyID: keyID}, nil
+}
+
+// loadKey reads a hex-encoded AES key from the named file, and returns an
+// AES-GCM AEAD using it.
+func loadKey(path string) (cipher.AEAD, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %q: %w", path, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key file %q: %w", path, err)
+	}
+	return cipher.NewGCM(block)
+}
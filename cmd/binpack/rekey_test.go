@@ -0,0 +1,31 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.key")
+	os.WriteFile(good, []byte("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f\n"), 0600)
+	if _, err := loadKey(good); err != nil {
+		t.Errorf("loadKey(good): unexpected error: %v", err)
+	}
+	for name, text := range map[string]string{
+		"nothex.key": "not a hex key",
+		"short.key":  "0102",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(text), 0600)
+		if _, err := loadKey(path); err == nil {
+			t.Errorf("loadKey(%s): got nil, want error", name)
+		}
+	}
+	if opts, err := keyOptions("", "x"); opts != nil || err != nil {
+		t.Errorf("keyOptions(empty): got %v, %v; want nil, nil", opts, err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/binpack"
+)
+
+// A tagSet is a set of tags given as a comma-separated list of tags and
+// inclusive ranges, e.g. "1,3,10-20". It implements flag.Value.
+type tagSet []struct{ lo, hi int }
+
+func (s *tagSet) String() string {
+	var parts []string
+	for _, r := range *s {
+		if r.lo == r.hi {
+			parts = append(parts, strconv.Itoa(r.lo))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r.lo, r.hi))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *tagSet) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		a, err := strconv.Atoi(lo)
+		if err != nil || a < 0 {
+			return fmt.Errorf("invalid tag %q", lo)
+		}
+		b, err := strconv.Atoi(hi)
+		if err != nil || b < a {
+			return fmt.Errorf("invalid tag range %q", part)
+		}
+		*s = append(*s, struct{ lo, hi int }{a, b})
+	}
+	return nil
+}
+
+// has reports whether s contains tag.
+func (s tagSet) has(tag int) bool {
+	for _, r := range s {
+		if r.lo <= tag && tag <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// A tagMap maps tags to new tags, given as a comma-separated list of old=new
+// pairs, e.g. "1=10,2=20". It implements flag.Value.
+type tagMap map[int]int
+
+func (m tagMap) String() string {
+	var parts []string
+	for k, v := range m {
+		parts = append(parts, fmt.Sprintf("%d=%d", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m tagMap) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid mapping %q (want old=new)", part)
+		}
+		a, err := strconv.Atoi(part[:i])
+		if err != nil || a < 0 {
+			return fmt.Errorf("invalid tag %q", part[:i])
+		}
+		b, err := strconv.Atoi(part[i+1:])
+		if err != nil || b < 0 {
+			return fmt.Errorf("invalid tag %q", part[i+1:])
+		}
+		m[a] = b
+	}
+	return nil
+}
+
+// A substitution replaces the value of each record with a given tag. Each
+// flag value has the form tag=value, where the value is parsed according to
+// the value format. It implements flag.Value.
+type substitution map[int]string
+
+func (s substitution) String() string {
+	var parts []string
+	for k, v := range s {
+		parts = append(parts, fmt.Sprintf("%d=%s", k, v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s substitution) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return fmt.Errorf("invalid substitution %q (want tag=value)", v)
+	}
+	tag, err := strconv.Atoi(v[:i])
+	if err != nil || tag < 0 {
+		return fmt.Errorf("invalid tag %q", v[:i])
+	}
+	s[tag] = v[i+1:]
+	return nil
+}
+
+var (
+	keepTags tagSet
+	dropTags tagSet
+	renumber = make(tagMap)
+	replace  = make(substitution)
+)
+
+func filterFlags(fs *flag.FlagSet) {
+	fs.Var(&keepTags, "keep", "Keep only records with these tags (e.g., 1,3,10-20)")
+	fs.Var(&dropTags, "drop", "Drop records with these tags")
+}
+
+func mapFlags(fs *flag.FlagSet) {
+	filterFlags(fs)
+	fs.Var(renumber, "tags", "Renumber tags (e.g., 1=10,2=20)")
+	fs.Var(replace, "set", "Replace values of records with a tag (tag=value; repeatable)")
+	fs.StringVar(&valueFormat, "v", valueFormat, "Value format for -set (raw or hex)")
+}
+
+// selectTag reports whether a record with the given tag passes the filters.
+func selectTag(tag int) bool {
+	if len(keepTags) != 0 && !keepTags.has(tag) {
+		return false
+	}
+	return !dropTags.has(tag)
+}
+
+func runFilter(fs *flag.FlagSet, args []string) error {
+	return streamRecords(args, func(tag int, value []byte) (int, []byte, bool) {
+		return tag, value, selectTag(tag)
+	})
+}
+
+func runMap(fs *flag.FlagSet, args []string) error {
+	values := make(map[int][]byte)
+	for tag, s := range replace {
+		v, err := parseValue(valueFormat, s)
+		if err != nil {
+			return fmt.Errorf("value for tag %d: %w", tag, err)
+		}
+		values[tag] = v
+	}
+	return streamRecords(args, func(tag int, value []byte) (int, []byte, bool) {
+		if !selectTag(tag) {
+			return 0, nil, false
+		}
+		if v, ok := values[tag]; ok {
+			value = v
+		}
+		if t, ok := renumber[tag]; ok {
+			tag = t
+		}
+		return tag, value, true
+	})
+}
+
+var jsonlReverse bool
+
+func jsonlFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&jsonlReverse, "reverse", false, "Convert JSON lines to records")
+}
+
+func runJSONL(fs *flag.FlagSet, args []string) error {
+	in, err := openInput(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	if jsonlReverse {
+		err = binpack.NewJSONLinesReader(in, nil).CopyTo(binpack.NewStreamEncoder(out))
+	} else {
+		err = binpack.NewJSONLinesWriter(out, nil).CopyFrom(binpack.NewDecoder(in))
+	}
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// streamRecords reads records from the input named by args, applies fn to
+// each as binpack.Transform, and writes the results to stdout.
+func streamRecords(args []string, fn func(int, []byte) (int, []byte, bool)) error {
+	in, err := openInput(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	err = binpack.Transform(binpack.NewStreamEncoder(out), binpack.NewDecoder(in), fn)
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// openInput opens the named file, or standard input if the args are empty or
+// name "-". It reports an error for extra arguments.
+func openInput(args []string) (io.ReadCloser, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("extra arguments after %q", args[0])
+	} else if len(args) == 0 || args[0] == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(args[0])
+}
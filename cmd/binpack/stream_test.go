@@ -0,0 +1,46 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package main
+
+import "testing"
+
+func TestTagFlags(t *testing.T) {
+	var s tagSet
+	if err := s.Set("1,3,10-20"); err != nil {
+		t.Fatalf("tagSet.Set failed: %v", err)
+	}
+	for tag, want := range map[int]bool{0: false, 1: true, 2: false, 3: true, 10: true, 15: true, 20: true, 21: false} {
+		if got := s.has(tag); got != want {
+			t.Errorf("has(%d): got %v, want %v", tag, got, want)
+		}
+	}
+	if got, want := s.String(), "1,3,10-20"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	for _, bad := range []string{"", "x", "-1", "5-2", "1,,2"} {
+		var s tagSet
+		if err := s.Set(bad); err == nil {
+			t.Errorf("tagSet.Set(%q): got %v, want error", bad, s)
+		}
+	}
+
+	m := make(tagMap)
+	if err := m.Set("1=10,2=20"); err != nil {
+		t.Fatalf("tagMap.Set failed: %v", err)
+	}
+	if m[1] != 10 || m[2] != 20 || len(m) != 2 {
+		t.Errorf("tagMap: got %v, want 1=10,2=20", m)
+	}
+	for _, bad := range []string{"1", "a=2", "1=b", "1=-2"} {
+		if err := make(tagMap).Set(bad); err == nil {
+			t.Errorf("tagMap.Set(%q): got nil, want error", bad)
+		}
+	}
+
+	sub := make(substitution)
+	if err := sub.Set("3=a=b"); err != nil {
+		t.Fatalf("substitution.Set failed: %v", err)
+	} else if sub[3] != "a=b" {
+		t.Errorf("substitution: got %q, want %q", sub[3], "a=b")
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Tags of the records in a columnar container.
+const (
+	columnGroup = 1 // value is a group of rows, in columns
+
+	groupRows   = 1 // the number of rows in the group
+	groupColumn = 2 // value is a column block
+
+	columnTag    = 1 // the field tag of the column
+	columnCounts = 2 // the number of records in each row, as packed values
+	columnValues = 3 // the values of all records in the column, as packed values
+)
+
+// A ColumnWriter pivots a stream of messages into a columnar container. The
+// messages are accumulated into groups of rows, and within each group the
+// values of all the records with a given tag are stored together as a column.
+// Since the values of a column are usually similar, the container generally
+// compresses much better than the messages themselves, and a ColumnReader can
+// reconstruct rows from just the columns it needs.
+//
+// The container is a sequence of records, one per group of rows, so groups
+// can be appended to an existing container.
+type ColumnWriter struct {
+	enc  *Encoder
+	size int // flush after this many rows
+	rows int // rows in the current group
+	cols map[int]*columnData
+}
+
+// columnData accumulates the contents of a column.
+type columnData struct {
+	counts []int        // records per row; rows after len(counts) have none
+	values bytes.Buffer // packed values
+}
+
+// NewColumnWriter constructs a ColumnWriter that writes to w. Every
+// groupSize rows, the current group is written to w. If groupSize <= 0, rows
+// are only written by calls to Flush or Close.
+func NewColumnWriter(w io.Writer, groupSize int) *ColumnWriter {
+	return &ColumnWriter{
+		enc:  NewStreamEncoder(w),
+		size: groupSize,
+		cols: make(map[int]*columnData),
+	}
+}
+
+// Append adds the encoded message msg as a row of the container. Messages
+// need not have the same fields, but the container is most effective when
+// they do.
+func (c *ColumnWriter) Append(msg []byte) error {
+	// Check the whole message before adding any of it to the group.
+	var recs []Record
+	for len(msg) != 0 {
+		tag, value, rest, err := nextRecord(msg)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", c.rows, err)
+		}
+		recs = append(recs, Record{Tag: tag, Value: value})
+		msg = rest
+	}
+	for _, rec := range recs {
+		col, ok := c.cols[rec.Tag]
+		if !ok {
+			col = new(columnData)
+			c.cols[rec.Tag] = col
+		}
+		for len(col.counts) <= c.rows {
+			col.counts = append(col.counts, 0)
+		}
+		col.counts[c.rows]++
+		WriteValue(&col.values, rec.Value)
+	}
+	c.rows++
+	if c.size > 0 && c.rows >= c.size {
+		return c.Flush()
+	}
+	return nil
+}
+
+// Flush writes the rows accumulated since the last flush, if any, as a group.
+func (c *ColumnWriter) Flush() error {
+	if c.rows == 0 {
+		return nil
+	}
+	tags := make([]int, 0, len(c.cols))
+	for tag := range c.cols {
+		tags = append(tags, tag)
+	}
+	sort.Ints(tags)
+
+	g := NewEncoder(nil)
+	g.Encode(groupRows, PackUint64(uint64(c.rows)))
+	for _, tag := range tags {
+		col := c.cols[tag]
+		var counts bytes.Buffer
+		for i := 0; i < c.rows; i++ {
+			n := 0
+			if i < len(col.counts) {
+				n = col.counts[i]
+			}
+			WriteValue(&counts, PackUint64(uint64(n)))
+		}
+		b := NewEncoder(nil)
+		b.Encode(columnTag, PackUint64(uint64(tag)))
+		b.Encode(columnCounts, counts.Bytes())
+		b.Encode(columnValues, col.values.Bytes())
+		if err := g.Encode(groupColumn, b.Data.Bytes()); err != nil {
+			return err
+		}
+	}
+	c.rows = 0
+	c.cols = make(map[int]*columnData)
+	return c.enc.Encode(columnGroup, g.Data.Bytes())
+}
+
+// Close flushes any remaining rows. It does not close the underlying writer.
+func (c *ColumnWriter) Close() error { return c.Flush() }
+
+// A ColumnReader reconstructs rows from a columnar container written by a
+// ColumnWriter.
+type ColumnReader struct {
+	dec  *Decoder
+	keep map[int]bool // if non-nil, the columns to read
+
+	rows int            // rows remaining in the current group
+	cols []*columnState // in order of increasing tag
+}
+
+// columnState tracks the position of a ColumnReader within a column.
+type columnState struct {
+	tag            int
+	counts, values []byte // unread packed values
+}
+
+// NewColumnReader constructs a ColumnReader that reads a container from r.
+// If any tags are given, rows are reconstructed with only the records having
+// those tags, and the other columns are not decoded.
+func NewColumnReader(r io.Reader, tags ...int) *ColumnReader {
+	c := &ColumnReader{dec: NewDecoder(r)}
+	if len(tags) != 0 {
+		c.keep = make(map[int]bool)
+		for _, tag := range tags {
+			c.keep[tag] = true
+		}
+	}
+	return c
+}
+
+// Next returns the encoding of the next row of the container. It returns
+// io.EOF when no rows remain.
+//
+// Within a row, records are ordered by tag, and records with the same tag are
+// in their original order. For messages produced by Marshal, which are
+// ordered by tag, the rows are equal to the original messages.
+func (c *ColumnReader) Next() ([]byte, error) {
+	for c.rows == 0 {
+		if err := c.nextGroup(); err != nil {
+			return nil, err
+		}
+	}
+	c.rows--
+	row := NewEncoder(nil)
+	for _, col := range c.cols {
+		cv, rest, err := nextValue(col.counts)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %w", col.tag, err)
+		}
+		col.counts = rest
+		for n := UnpackUint64(cv); n > 0; n-- {
+			value, rest, err := nextValue(col.values)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: %w", col.tag, err)
+			}
+			col.values = rest
+			if err := row.Encode(col.tag, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return row.Data.Bytes(), nil
+}
+
+// nextGroup reads the next group of rows from the container.
+func (c *ColumnReader) nextGroup() error {
+	tag, data, err := c.dec.Decode()
+	if err != nil {
+		return err
+	} else if tag != columnGroup {
+		return fmt.Errorf("invalid container record tag %d", tag)
+	}
+	c.rows, c.cols = 0, nil
+	for len(data) != 0 {
+		var tag int
+		var value []byte
+		tag, value, data, err = nextRecord(data)
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case groupRows:
+			c.rows = int(UnpackUint64(value))
+		case groupColumn:
+			col, err := parseColumn(value)
+			if err != nil {
+				return err
+			}
+			if c.keep == nil || c.keep[col.tag] {
+				c.cols = append(c.cols, col)
+			}
+		default:
+			return fmt.Errorf("invalid group record tag %d", tag)
+		}
+	}
+	sort.Slice(c.cols, func(i, j int) bool {
+		return c.cols[i].tag < c.cols[j].tag
+	})
+	return nil
+}
+
+// parseColumn decodes a column block.
+func parseColumn(data []byte) (*columnState, error) {
+	col := new(columnState)
+	for len(data) != 0 {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+		switch tag {
+		case columnTag:
+			col.tag = int(UnpackUint64(value))
+		case columnCounts:
+			col.counts = value
+		case columnValues:
+			col.values = value
+		default:
+			return nil, fmt.Errorf("invalid column record tag %d", tag)
+		}
+	}
+	return col, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type columnRow struct {
+	Host   string   `binpack:"tag=1"`
+	Load   float64  `binpack:"tag=2"`
+	Labels []string `binpack:"tag=3"`
+	Note   string   `binpack:"tag=10"`
+}
+
+func TestColumns(t *testing.T) {
+	var rows [][]byte
+	for i := 0; i < 25; i++ {
+		r := &columnRow{
+			Host: fmt.Sprintf("host-%d", i%3),
+			Load: float64(i) / 4,
+		}
+		for j := 0; j < i%4; j++ {
+			r.Labels = append(r.Labels, fmt.Sprintf("label-%d", j))
+		}
+		if i%7 == 3 {
+			r.Note = "check this one"
+		}
+		bits, err := binpack.Marshal(r)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		rows = append(rows, bits)
+	}
+
+	var buf bytes.Buffer
+	w := binpack.NewColumnWriter(&buf, 10)
+	for i, row := range rows {
+		if err := w.Append(row); err != nil {
+			t.Fatalf("Append row %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	readAll := func(tags ...int) [][]byte {
+		t.Helper()
+		var got [][]byte
+		r := binpack.NewColumnReader(bytes.NewReader(buf.Bytes()), tags...)
+		for {
+			row, err := r.Next()
+			if err == io.EOF {
+				return got
+			} else if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			got = append(got, row)
+		}
+	}
+
+	// Reading all columns reconstructs the original rows.
+	if diff := cmp.Diff(rows, readAll()); diff != "" {
+		t.Errorf("Wrong rows (-want, +got):\n%s", diff)
+	}
+
+	// Reading selected columns yields only those fields.
+	got := readAll(1, 10)
+	if len(got) != len(rows) {
+		t.Fatalf("Got %d rows, want %d", len(got), len(rows))
+	}
+	for i, row := range got {
+		var in, out columnRow
+		if err := binpack.Unmarshal(rows[i], &in); err != nil {
+			t.Fatalf("Unmarshal input %d: %v", i, err)
+		}
+		if err := binpack.Unmarshal(row, &out); err != nil {
+			t.Fatalf("Unmarshal row %d: %v", i, err)
+		}
+		want := columnRow{Host: in.Host, Note: in.Note}
+		if diff := cmp.Diff(want, out); diff != "" {
+			t.Errorf("Row %d (-want, +got):\n%s", i, diff)
+		}
+	}
+}
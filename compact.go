@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import "fmt"
+
+// A CompactPolicy selects which record Compact keeps among several records
+// with the same tag.
+type CompactPolicy int
+
+// Constants defining the compaction policies.
+const (
+	KeepLast  CompactPolicy = iota // keep the last record ("latest write wins")
+	KeepFirst                      // keep the first record
+)
+
+func (p CompactPolicy) String() string {
+	switch p {
+	case KeepLast:
+		return "KeepLast"
+	case KeepFirst:
+		return "KeepFirst"
+	}
+	return fmt.Sprintf("CompactPolicy(%d)", int(p))
+}
+
+// Compact returns a copy of the message in data in which, for each tag that
+// has several records, only one record is kept as selected by policy. The
+// records that are kept are copied unmodified, in their original order, and
+// records with unknown tags are preserved. Note that this collapses repeated
+// (slice and map) fields to a single element.
+//
+// Compact reports an error if data is not a well-formed sequence of records.
+func Compact(data []byte, policy CompactPolicy) ([]byte, error) {
+	if policy != KeepLast && policy != KeepFirst {
+		return nil, fmt.Errorf("invalid compaction policy %v", policy)
+	}
+	type span struct {
+		tag        int
+		start, end int
+	}
+	var recs []span
+	keep := make(map[int]int) // tag → index in recs of the kept record
+	for pos := 0; pos < len(data); {
+		tag, _, rest, err := nextRecord(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", pos, err)
+		}
+		end := len(data) - len(rest)
+		if _, ok := keep[tag]; !ok || policy == KeepLast {
+			keep[tag] = len(recs)
+		}
+		recs = append(recs, span{tag: tag, start: pos, end: end})
+		pos = end
+	}
+
+	out := make([]byte, 0, len(data))
+	for i, r := range recs {
+		if keep[r.tag] == i {
+			out = append(out, data[r.start:r.end]...)
+		}
+	}
+	return out, nil
+}
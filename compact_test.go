@@ -0,0 +1,63 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompact(t *testing.T) {
+	encode := func(recs ...binpack.Record) []byte {
+		e := binpack.NewEncoder(nil)
+		for _, rec := range recs {
+			e.Encode(rec.Tag, rec.Value)
+		}
+		return e.Data.Bytes()
+	}
+	in := encode(
+		binpack.Record{Tag: 1, Value: []byte("a1")},
+		binpack.Record{Tag: 2, Value: []byte("b1")},
+		binpack.Record{Tag: 1, Value: []byte("a2")},
+		binpack.Record{Tag: 99, Value: []byte("unknown")},
+		binpack.Record{Tag: 2, Value: []byte("b2")},
+		binpack.Record{Tag: 1, Value: []byte("a3")},
+	)
+	saved := append([]byte(nil), in...)
+
+	tests := []struct {
+		policy binpack.CompactPolicy
+		want   []byte
+	}{
+		{binpack.KeepLast, encode(
+			binpack.Record{Tag: 99, Value: []byte("unknown")},
+			binpack.Record{Tag: 2, Value: []byte("b2")},
+			binpack.Record{Tag: 1, Value: []byte("a3")},
+		)},
+		{binpack.KeepFirst, encode(
+			binpack.Record{Tag: 1, Value: []byte("a1")},
+			binpack.Record{Tag: 2, Value: []byte("b1")},
+			binpack.Record{Tag: 99, Value: []byte("unknown")},
+		)},
+	}
+	for _, test := range tests {
+		got, err := binpack.Compact(in, test.policy)
+		if err != nil {
+			t.Errorf("Compact(%v): unexpected error: %v", test.policy, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Compact(%v) (-want, +got):\n%s", test.policy, diff)
+		}
+	}
+	if !bytes.Equal(in, saved) {
+		t.Error("Compact modified its input")
+	}
+
+	if _, err := binpack.Compact(in[:len(in)-1], binpack.KeepLast); err == nil {
+		t.Error("Compact(truncated): got nil, want error")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import "fmt"
+
+// A ConcatPolicy selects how Concat handles a tag that occurs in more than
+// one of the messages it combines.
+type ConcatPolicy int
+
+// Constants defining the concatenation policies.
+const (
+	AppendAll        ConcatPolicy = iota // keep all records, as if concatenated
+	LastWins                             // keep the records from the last message with the tag
+	ErrorOnDuplicate                     // report an error for a tag in several messages
+)
+
+func (p ConcatPolicy) String() string {
+	switch p {
+	case AppendAll:
+		return "AppendAll"
+	case LastWins:
+		return "LastWins"
+	case ErrorOnDuplicate:
+		return "ErrorOnDuplicate"
+	}
+	return fmt.Sprintf("ConcatPolicy(%d)", int(p))
+}
+
+// Concat combines the messages in msgs into a single message, in order. The
+// records that are kept are copied unmodified, in their original order.
+//
+// Concatenating the bytes of several messages produces a valid message, but
+// when decoded, a scalar field that occurs in several of them takes only one
+// of the values, and a repeated field combines the elements from all of
+// them. The policy determines how a tag that occurs in more than one message
+// is handled. A tag that occurs several times in one message is never a
+// conflict, so repeated fields within a message are preserved.
+//
+// Concat reports an error if any of msgs is not a well-formed sequence of
+// records.
+func Concat(policy ConcatPolicy, msgs ...[]byte) ([]byte, error) {
+	if policy != AppendAll && policy != LastWins && policy != ErrorOnDuplicate {
+		return nil, fmt.Errorf("invalid concatenation policy %v", policy)
+	}
+	type span struct {
+		tag        int
+		start, end int
+	}
+	recs := make([][]span, len(msgs))
+	owner := make(map[int]int) // tag → index of the last message having it
+	size := 0
+	for i, data := range msgs {
+		for pos := 0; pos < len(data); {
+			tag, _, rest, err := nextRecord(data[pos:])
+			if err != nil {
+				return nil, fmt.Errorf("message %d offset %d: %w", i, pos, err)
+			}
+			end := len(data) - len(rest)
+			if j, ok := owner[tag]; ok && j != i && policy == ErrorOnDuplicate {
+				return nil, fmt.Errorf("tag %d occurs in messages %d and %d", tag, j, i)
+			}
+			owner[tag] = i
+			recs[i] = append(recs[i], span{tag: tag, start: pos, end: end})
+			pos = end
+		}
+		size += len(data)
+	}
+
+	out := make([]byte, 0, size)
+	for i, data := range msgs {
+		for _, r := range recs[i] {
+			if policy != LastWins || owner[r.tag] == i {
+				out = append(out, data[r.start:r.end]...)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConcat(t *testing.T) {
+	encode := func(recs ...binpack.Record) []byte {
+		e := binpack.NewEncoder(nil)
+		if err := e.EncodeAll(recs); err != nil {
+			t.Fatalf("EncodeAll failed: %v", err)
+		}
+		return e.Data.Bytes()
+	}
+	m1 := encode(
+		binpack.Record{Tag: 1, Value: []byte("a1")},
+		binpack.Record{Tag: 2, Value: []byte("b1")},
+		binpack.Record{Tag: 2, Value: []byte("b2")},
+	)
+	m2 := encode(
+		binpack.Record{Tag: 3, Value: []byte("c1")},
+		binpack.Record{Tag: 2, Value: []byte("b3")},
+	)
+	m3 := encode(
+		binpack.Record{Tag: 1, Value: []byte("a2")},
+	)
+
+	tests := []struct {
+		policy binpack.ConcatPolicy
+		msgs   [][]byte
+		want   []byte
+	}{
+		{binpack.AppendAll, nil, []byte{}},
+		{binpack.AppendAll, [][]byte{m1, m2, m3}, encode(
+			binpack.Record{Tag: 1, Value: []byte("a1")},
+			binpack.Record{Tag: 2, Value: []byte("b1")},
+			binpack.Record{Tag: 2, Value: []byte("b2")},
+			binpack.Record{Tag: 3, Value: []byte("c1")},
+			binpack.Record{Tag: 2, Value: []byte("b3")},
+			binpack.Record{Tag: 1, Value: []byte("a2")},
+		)},
+		{binpack.LastWins, [][]byte{m1, m2, m3}, encode(
+			binpack.Record{Tag: 3, Value: []byte("c1")},
+			binpack.Record{Tag: 2, Value: []byte("b3")},
+			binpack.Record{Tag: 1, Value: []byte("a2")},
+		)},
+		{binpack.LastWins, [][]byte{m2, m1}, encode(
+			binpack.Record{Tag: 3, Value: []byte("c1")},
+			binpack.Record{Tag: 1, Value: []byte("a1")},
+			binpack.Record{Tag: 2, Value: []byte("b1")},
+			binpack.Record{Tag: 2, Value: []byte("b2")},
+		)},
+		{binpack.ErrorOnDuplicate, [][]byte{m1, encode(binpack.Record{Tag: 3})}, encode(
+			binpack.Record{Tag: 1, Value: []byte("a1")},
+			binpack.Record{Tag: 2, Value: []byte("b1")},
+			binpack.Record{Tag: 2, Value: []byte("b2")},
+			binpack.Record{Tag: 3},
+		)},
+	}
+	for _, test := range tests {
+		got, err := binpack.Concat(test.policy, test.msgs...)
+		if err != nil {
+			t.Errorf("Concat(%v): unexpected error: %v", test.policy, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Concat(%v) (-want, +got):\n%s", test.policy, diff)
+		}
+	}
+
+	if _, err := binpack.Concat(binpack.ErrorOnDuplicate, m1, m2); err == nil {
+		t.Error("Concat(ErrorOnDuplicate): got nil error for a duplicate tag")
+	}
+	if _, err := binpack.Concat(binpack.AppendAll, m1, m2[:len(m2)-1]); !errors.Is(err, binpack.ErrTruncated) {
+		t.Errorf("Concat truncated: got %v, want %v", err, binpack.ErrTruncated)
+	}
+	if _, err := binpack.Concat(binpack.ConcatPolicy(99), m1); err == nil {
+		t.Error("Concat with an invalid policy: got nil error")
+	}
+}
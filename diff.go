@@ -0,0 +1,145 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// DiffTag is the tag of the record that lists the fields cleared by a patch
+// produced by MarshalDiff. It should not be used by application messages
+// that are to be diffed.
+const DiffTag = wire.DiffTag
+
+// MarshalDiff encodes a patch that transforms old into new, which must be
+// structs (or pointers to structs) of the same type. The patch contains a
+// record listing the tags of all the fields whose values differ between old
+// and new, followed by the encodings of those fields that are not zero in
+// new. Fields are compared with reflect.DeepEqual.
+//
+// Applying the patch with ApplyDiff replaces each changed field as a whole:
+// In particular, a repeated (slice or map) field that differs in any element
+// is encoded in full, not element by element. If old and new are equal, the
+// patch is empty.
+func MarshalDiff(old, new interface{}) ([]byte, error) {
+	_, oval := deref(old)
+	_, nval := deref(new)
+	if oval.Kind() != reflect.Struct || nval.Kind() != reflect.Struct {
+		return nil, errors.New("values are not structs or pointers to structs")
+	} else if oval.Type() != nval.Type() {
+		return nil, fmt.Errorf("mismatched types %v and %v", oval.Type(), nval.Type())
+	}
+	otags, err := fieldsByTag(oval)
+	if err != nil {
+		return nil, err
+	}
+	ntags, err := fieldsByTag(nval)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[int]bool)
+	var cleared []int
+	for tag, nf := range ntags {
+		if !reflect.DeepEqual(otags[tag].Interface(), nf.Interface()) {
+			changed[tag] = true
+			cleared = append(cleared, tag)
+		}
+	}
+	if len(cleared) == 0 {
+		return nil, nil
+	}
+
+	info, err := checkStructType(nval, false /* no pointers */)
+	if err != nil {
+		return nil, err
+	}
+	var keep []*fieldInfo
+	for _, fi := range info {
+		if changed[fi.tag] {
+			keep = append(keep, fi)
+		}
+	}
+	m := &marshaler{}
+	sort.Ints(cleared)
+	tags, err := m.marshalSlice(reflect.ValueOf(cleared))
+	if err != nil {
+		return nil, err
+	}
+	buf := NewEncoder(nil)
+	if err := buf.Encode(DiffTag, tags); err != nil {
+		return nil, err
+	} else if err := m.marshalFields(buf, nval, keep); err != nil {
+		return nil, err
+	}
+	return buf.Data.Bytes(), nil
+}
+
+// ApplyDiff applies a patch produced by MarshalDiff to base, which must be a
+// non-nil pointer to a struct of the same type the patch was made from. The
+// fields listed by the patch are reset to zero, and the rest of the patch is
+// then unmarshaled into base.
+func ApplyDiff(base interface{}, patch []byte) error {
+	val := reflect.ValueOf(base)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("base %T is not a non-nil pointer to struct", base)
+	} else if len(patch) == 0 {
+		return nil
+	}
+	buf := bytes.NewReader(patch)
+	tag, err := ReadTag(buf)
+	if err != nil {
+		return err
+	} else if tag != DiffTag {
+		return errors.New("patch does not begin with a list of changed fields")
+	}
+	data, err := ReadValue(buf)
+	if err != nil {
+		return err
+	}
+	var cleared []int
+	if err := Unmarshal(data, &cleared); err != nil {
+		return fmt.Errorf("invalid list of changed fields: %w", err)
+	}
+	fields, err := fieldsByTag(val.Elem())
+	if err != nil {
+		return err
+	}
+	for _, tag := range cleared {
+		f, ok := fields[tag]
+		if !ok {
+			return fmt.Errorf("patch changes %s: %w", describeTag(val.Type(), tag), ErrUnknownField)
+		}
+		f.Set(reflect.Zero(f.Type()))
+	}
+
+	// The list of changed fields will be skipped by Unmarshal, since no field
+	// has its tag.
+	return Unmarshal(patch, base)
+}
+
+// fieldsByTag returns a map from tags to the tagged fields of val.
+// Precondition: val is a reflect.Struct.
+func fieldsByTag(val reflect.Value) (map[int]reflect.Value, error) {
+	fields := make(map[int]reflect.Value)
+	for i := 0; i < val.NumField(); i++ {
+		ftype := val.Type().Field(i)
+		tag, ok := ftype.Tag.Lookup("binpack")
+		if !ok {
+			continue
+		}
+		fi, ok := parseTag(tag)
+		if !ok {
+			return nil, fmt.Errorf("invalid field %q tag %q", ftype.Name, tag)
+		} else if _, ok := fields[fi.tag]; ok {
+			return nil, fmt.Errorf("duplicate field tag %d", fi.tag)
+		}
+		fields[fi.tag] = val.Field(i)
+	}
+	return fields, nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type diffPoint struct {
+	X int `binpack:"tag=1"`
+	Y int `binpack:"tag=2"`
+}
+
+type diffState struct {
+	Name   string            `binpack:"tag=1"`
+	Count  int               `binpack:"tag=2"`
+	Tags   []string          `binpack:"tag=3"`
+	Attrs  map[string]string `binpack:"tag=4"`
+	Where  diffPoint         `binpack:"tag=5"`
+	Target *diffPoint        `binpack:"tag=6"`
+	Stable string            `binpack:"tag=7"`
+}
+
+func TestDiff(t *testing.T) {
+	old := &diffState{
+		Name:   "before",
+		Count:  10,
+		Tags:   []string{"a", "b", "c"},
+		Attrs:  map[string]string{"k": "v", "x": "y"},
+		Where:  diffPoint{X: 1, Y: 2},
+		Target: &diffPoint{X: 5},
+		Stable: "this does not change",
+	}
+	new := &diffState{
+		Name:   "after",
+		Tags:   []string{"a", "c"},
+		Attrs:  map[string]string{"k": "v", "x": "y"},
+		Where:  diffPoint{X: 1},
+		Target: &diffPoint{Y: 6},
+		Stable: "this does not change",
+	}
+
+	patch, err := binpack.MarshalDiff(old, new)
+	if err != nil {
+		t.Fatalf("MarshalDiff: unexpected error: %v", err)
+	}
+	full, err := binpack.Marshal(new)
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	if len(patch) >= len(full) {
+		t.Errorf("Patch is %d bytes, full encoding is %d bytes", len(patch), len(full))
+	}
+
+	// Apply the patch to a copy of the old state.
+	var base diffState
+	if err := binpack.Unmarshal(mustMarshal(t, old), &base); err != nil {
+		t.Fatalf("Unmarshal: unexpected error: %v", err)
+	}
+	if err := binpack.ApplyDiff(&base, patch); err != nil {
+		t.Fatalf("ApplyDiff: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(new, &base); diff != "" {
+		t.Errorf("ApplyDiff (-want, +got):\n%s", diff)
+	}
+
+	// Equal values produce an empty patch, which changes nothing.
+	if patch, err := binpack.MarshalDiff(new, new); err != nil || len(patch) != 0 {
+		t.Errorf("MarshalDiff of equal values: got %q, %v; want empty, nil", patch, err)
+	}
+	if err := binpack.ApplyDiff(&base, nil); err != nil {
+		t.Errorf("ApplyDiff of empty patch: unexpected error: %v", err)
+	}
+
+	// Mismatched types are an error.
+	if _, err := binpack.MarshalDiff(old, &diffPoint{}); err == nil {
+		t.Error("MarshalDiff of mismatched types: got nil, want error")
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := binpack.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	return data
+}
+
+func TestJournal(t *testing.T) {
+	var buf bytes.Buffer
+	j := binpack.NewJournalWriter(&buf, 3)
+
+	state := &diffState{Name: "start", Attrs: make(map[string]string)}
+	for i := 0; i < 10; i++ {
+		state.Count = i
+		state.Tags = append(state.Tags, fmt.Sprint(i))
+		state.Attrs[fmt.Sprint(i)] = fmt.Sprint(i * i)
+		if i%4 == 0 {
+			state.Target = &diffPoint{X: i}
+		}
+		if err := j.Append(state); err != nil {
+			t.Fatalf("Append %d: unexpected error: %v", i, err)
+		}
+
+		var got diffState
+		if err := binpack.ReplayJournal(bytes.NewReader(buf.Bytes()), &got); err != nil {
+			t.Fatalf("ReplayJournal %d: unexpected error: %v", i, err)
+		}
+		if diff := cmp.Diff(state, &got); diff != "" {
+			t.Errorf("ReplayJournal %d (-want, +got):\n%s", i, diff)
+		}
+	}
+
+	// Count the snapshots and patches.
+	recs, err := binpack.Split(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Split: unexpected error: %v", err)
+	}
+	var tags []byte
+	for _, rec := range recs {
+		tags = append(tags, '0'+rec[0])
+	}
+	if got, want := string(tags), "1222122212"; got != want {
+		t.Errorf("Journal entries: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// DumpOptions control the format of a HexDump listing.
+// A nil *DumpOptions provides default values.
+type DumpOptions struct {
+	// If set, tags are annotated with the names registered for this scope.
+	// See RegisterTagNames for the meaning of a scope.
+	Scope interface{}
+
+	// If true, each tag is also shown split into its ID and kind, as by
+	// SplitTag.
+	SplitTags bool
+}
+
+// HexDump writes an annotated hexadecimal listing of the encoded records in
+// data to w. Each line shows the offset and raw bytes of one component of a
+// record (the tag, the length prefix, or a segment of the value), together
+// with a description of that component. This is meant for diagnosing
+// malformed messages; use a Decoder to process well-formed ones.
+//
+// If data ends with an incomplete record, the remaining bytes are listed and
+// HexDump reports ErrTruncated after writing them.
+func HexDump(w io.Writer, data []byte) error { return (*DumpOptions)(nil).HexDump(w, data) }
+
+// HexDump writes an annotated listing of data to w using the settings from o.
+// See the HexDump function for a description of the listing.
+func (o *DumpOptions) HexDump(w io.Writer, data []byte) error {
+	var scope interface{}
+	var split bool
+	if o != nil {
+		scope, split = o.Scope, o.SplitTags
+	}
+	var buf bytes.Buffer
+	var pos int
+	for i := 0; pos < len(data); i++ {
+		buf.Reset()
+		r, ok := wire.ParseLayout(data[pos:])
+		if !ok {
+			dumpLines(&buf, pos, data[pos:], "truncated record")
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			return ErrTruncated
+		}
+		rec := data[pos : pos+r.Size()]
+		tag, _ := ReadTag(bytes.NewReader(rec))
+		label := fmt.Sprintf("record %d: %s", i, describeTag(scope, tag))
+		if split {
+			id, kind := SplitTag(tag)
+			label += fmt.Sprintf(" [id %d kind %d]", id, kind)
+		}
+		dumpLines(&buf, pos, rec[:r.TagLen], label)
+		if r.PrefixLen != 0 {
+			dumpLines(&buf, pos+r.TagLen, rec[r.TagLen:r.TagLen+r.PrefixLen], fmt.Sprintf("length %d", r.DataLen))
+		}
+		vpos := r.TagLen + r.PrefixLen
+		dumpLines(&buf, pos+vpos, rec[vpos:], "")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		pos += len(rec)
+	}
+	return nil
+}
+
+// dumpBytesPerLine is the maximum number of bytes listed on one line.
+const dumpBytesPerLine = 16
+
+// dumpLines writes lines listing the contents of data, starting at the given
+// offset, to buf. The first line is labelled with note; if note == "" each
+// line is labelled with a printable rendering of its bytes.
+func dumpLines(buf *bytes.Buffer, offset int, data []byte, note string) {
+	if len(data) == 0 {
+		fmt.Fprintf(buf, "%08x  %-*s  (empty value)\n", offset, 3*dumpBytesPerLine-1, "")
+		return
+	}
+	for i := 0; i < len(data); i += dumpBytesPerLine {
+		end := i + dumpBytesPerLine
+		if end > len(data) {
+			end = len(data)
+		}
+		line := data[i:end]
+		label := note
+		if note == "" {
+			label = "|" + printable(line) + "|"
+		} else if i > 0 {
+			label = ""
+		}
+		fmt.Fprintf(buf, "%08x  %-*s  %s\n", offset+i, 3*dumpBytesPerLine-1, hexBytes(line), label)
+	}
+}
+
+// hexBytes renders data as space-separated hexadecimal byte values.
+func hexBytes(data []byte) string {
+	const digits = "0123456789abcdef"
+	out := make([]byte, 0, 3*len(data))
+	for i, b := range data {
+		if i > 0 {
+			out = append(out, ' ')
+		}
+		out = append(out, digits[b>>4], digits[b&0xf])
+	}
+	return string(out)
+}
+
+// printable renders data with non-printing bytes replaced by periods.
+func printable(data []byte) string {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		if b < ' ' || b > '~' {
+			b = '.'
+		}
+		out[i] = b
+	}
+	return string(out)
+}
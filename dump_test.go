@@ -0,0 +1,129 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHexDump(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte{7})
+	e.Encode(300, []byte("a string of twenty bytes"))
+	e.Encode(2, nil)
+
+	var buf strings.Builder
+	if err := binpack.HexDump(&buf, e.Data.Bytes()); err != nil {
+		t.Fatalf("HexDump: unexpected error: %v", err)
+	}
+	const want = `
+00000000  01                                               record 0: tag 1
+00000001  07                                               |.|
+00000002  81 2c                                            record 1: tag 300
+00000004  98                                               length 24
+00000005  61 20 73 74 72 69 6e 67 20 6f 66 20 74 77 65 6e  |a string of twen|
+00000015  74 79 20 62 79 74 65 73                          |ty bytes|
+0000001d  02                                               record 2: tag 2
+0000001e  80                                               length 0
+0000001f                                                   (empty value)
+`
+	if diff := cmp.Diff(strings.TrimPrefix(want, "\n"), buf.String()); diff != "" {
+		t.Errorf("HexDump (-want, +got):\n%s", diff)
+	}
+
+	// Trailing garbage is listed, and reported as an error.
+	buf.Reset()
+	if err := binpack.HexDump(&buf, []byte("\x01\x85abc")); !errors.Is(err, binpack.ErrTruncated) {
+		t.Errorf("HexDump: got error %v, want %v", err, binpack.ErrTruncated)
+	}
+	if got, want := buf.String(), "truncated record\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("HexDump: got %q, want suffix %q", got, want)
+	}
+}
+
+func TestTagNames(t *testing.T) {
+	type rec struct {
+		Name  string `binpack:"tag=1"`
+		Count int    `binpack:"tag=2"`
+	}
+	binpack.RegisterTagNames("test-scope", map[int]string{1: "alpha", 3: "gamma"})
+
+	tests := []struct {
+		scope interface{}
+		tag   int
+		want  string
+		ok    bool
+	}{
+		{"test-scope", 1, "alpha", true},
+		{"test-scope", 2, "", false},
+		{"test-scope", 3, "gamma", true},
+		{"other-scope", 1, "", false},
+		{rec{}, 2, "Count", true},      // derived from field names
+		{(*rec)(nil), 1, "Name", true}, // pointers are dereferenced
+		{rec{}, 5, "", false},
+	}
+	for _, test := range tests {
+		got, ok := binpack.LookupTagName(test.scope, test.tag)
+		if got != test.want || ok != test.ok {
+			t.Errorf("LookupTagName(%v, %d): got %q, %v; want %q, %v",
+				test.scope, test.tag, got, ok, test.want, test.ok)
+		}
+	}
+
+	var buf strings.Builder
+	opts := &binpack.DumpOptions{Scope: rec{}}
+	if err := opts.HexDump(&buf, []byte("\x02\x05")); err != nil {
+		t.Fatalf("HexDump: unexpected error: %v", err)
+	}
+	if got, want := buf.String(), "record 0: Count (tag 2)\n"; !strings.Contains(got, want) {
+		t.Errorf("HexDump: got %q, want %q", got, want)
+	}
+
+	// Errors from struct fields mention the field name.
+	var out rec
+	err := binpack.Unmarshal([]byte("\x02\x89123456789"), &out)
+	if err == nil || !strings.Contains(err.Error(), "Count (tag 2)") {
+		t.Errorf("Unmarshal: got error %v, want mention of field name", err)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	tests := []struct {
+		id, kind uint
+		tag      int
+	}{
+		{0, 0, 0},
+		{1, 2, 10},
+		{37, binpack.MaxTagKind, 37<<binpack.TagKindBits | binpack.MaxTagKind},
+		{binpack.MaxTagID, binpack.MaxTagKind, 1<<30 - 1},
+	}
+	for _, test := range tests {
+		tag := binpack.MakeTag(test.id, test.kind)
+		if tag != test.tag {
+			t.Errorf("MakeTag(%d, %d): got %d, want %d", test.id, test.kind, tag, test.tag)
+		}
+		if id, kind := binpack.SplitTag(tag); id != test.id || kind != test.kind {
+			t.Errorf("SplitTag(%d): got (%d, %d), want (%d, %d)", tag, id, kind, test.id, test.kind)
+		}
+	}
+	if tag := binpack.MakeTag(binpack.MaxTagID+1, 0); tag != -1 {
+		t.Errorf("MakeTag(MaxTagID+1, 0): got %d, want -1", tag)
+	}
+	if tag := binpack.MakeTag(1, binpack.MaxTagKind+1); tag != -1 {
+		t.Errorf("MakeTag(1, MaxTagKind+1): got %d, want -1", tag)
+	}
+
+	var buf strings.Builder
+	opts := &binpack.DumpOptions{SplitTags: true}
+	if err := opts.HexDump(&buf, []byte("\x0b\x05")); err != nil {
+		t.Fatalf("HexDump: unexpected error: %v", err)
+	}
+	if got, want := buf.String(), "record 0: tag 11 [id 1 kind 3]\n"; !strings.Contains(got, want) {
+		t.Errorf("HexDump: got %q, want %q", got, want)
+	}
+}
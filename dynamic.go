@@ -0,0 +1,128 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A DynamicMessage is a message whose fields are described by a Schema at
+// runtime rather than by a Go struct type. A DynamicMessage encodes to the
+// same bytes as Marshal produces for a struct with the same field values.
+type DynamicMessage struct {
+	schema *Schema
+	values map[string]interface{}
+}
+
+// NewDynamicMessage constructs an empty DynamicMessage with the given schema.
+func NewDynamicMessage(s *Schema) *DynamicMessage {
+	return &DynamicMessage{schema: s, values: make(map[string]interface{})}
+}
+
+// Schema returns the schema of m.
+func (m *DynamicMessage) Schema() *Schema { return m.schema }
+
+// GetByName returns the value of the named field, or nil if the field is not
+// set. Values have the types described by Schema.UnmarshalMap, except that
+// nested messages are represented as *DynamicMessage. It reports an error if
+// the schema has no field with that name.
+func (m *DynamicMessage) GetByName(name string) (interface{}, error) {
+	if m.schema.FieldByName(name) == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownField, name)
+	}
+	return m.values[name], nil
+}
+
+// SetByName sets the value of the named field to v. It accepts the values
+// described by Schema.MarshalMap, and for nested messages also accepts a
+// *DynamicMessage with the same schema as the field. Setting a field to nil
+// clears it. It reports an error if the schema has no field with that name,
+// or if v is not a valid value for the field.
+func (m *DynamicMessage) SetByName(name string, v interface{}) error {
+	f := m.schema.FieldByName(name)
+	if f == nil {
+		return fmt.Errorf("%w %q", ErrUnknownField, name)
+	} else if v == nil {
+		delete(m.values, name)
+		return nil
+	} else if !f.Repeated {
+		cv, err := f.canonical(v)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+		m.values[name] = cv
+		return nil
+	}
+
+	elts := reflect.ValueOf(v)
+	if elts.Kind() != reflect.Slice && elts.Kind() != reflect.Array {
+		return fmt.Errorf("field %q: repeated value has type %T", name, v)
+	}
+	cvs := make([]interface{}, elts.Len())
+	for i := range cvs {
+		cv, err := f.canonical(elts.Index(i).Interface())
+		if err != nil {
+			return fmt.Errorf("field %q index %d: %w", name, i, err)
+		}
+		cvs[i] = cv
+	}
+	m.values[name] = cvs
+	return nil
+}
+
+// canonical converts v to the representation of a value of f stored in a
+// DynamicMessage, or reports an error if v is not valid for f.
+func (f *SchemaField) canonical(v interface{}) (interface{}, error) {
+	if v == nil && f.Nilable {
+		return nil, nil
+	} else if f.Kind != KindMessage {
+		data, _, err := f.encodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return f.decode(data)
+	}
+	switch t := v.(type) {
+	case *DynamicMessage:
+		if t.schema != f.Message {
+			return nil, fmt.Errorf("message has the wrong schema")
+		}
+		return t, nil
+	case map[string]interface{}:
+		sub := NewDynamicMessage(f.Message)
+		for name, val := range t {
+			if err := sub.SetByName(name, val); err != nil {
+				return nil, err
+			}
+		}
+		return sub, nil
+	}
+	return nil, fmt.Errorf("invalid value of type %T for %v", v, f.Kind)
+}
+
+// MarshalBinary encodes m according to its schema. It implements the
+// encoding.BinaryMarshaler interface.
+func (m *DynamicMessage) MarshalBinary() ([]byte, error) { return m.schema.MarshalMap(m.values) }
+
+// UnmarshalBinary replaces the contents of m with the decoding of data
+// according to its schema. It implements the encoding.BinaryUnmarshaler
+// interface. Records whose tags are not described by the schema are ignored.
+func (m *DynamicMessage) UnmarshalBinary(data []byte) error {
+	return (*UnmarshalOptions)(nil).UnmarshalDynamic(m, data)
+}
+
+// UnmarshalDynamic replaces the contents of m with the decoding of data as by
+// DynamicMessage.UnmarshalBinary, using the settings from o. Only the
+// Deprecated hook and LegacyBytes apply to this decoding.
+func (o *UnmarshalOptions) UnmarshalDynamic(m *DynamicMessage, data []byte) error {
+	values, err := o.unmarshalFields(m.schema, data, func(s *Schema, data []byte) (interface{}, error) {
+		sub := NewDynamicMessage(s)
+		return sub, o.UnmarshalDynamic(sub, data)
+	})
+	if err != nil {
+		return err
+	}
+	m.values = values
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Tags of the records in a signed envelope.
+const (
+	envelopePayload   = 1 // the canonical encoding of the message
+	envelopeAlgorithm = 2 // the name of the signature algorithm
+	envelopeSignature = 3 // the signature
+)
+
+// Names of the supported signature algorithms.
+const (
+	algEd25519     = "ed25519"
+	algECDSASHA256 = "ecdsa-sha256"
+	algRSASHA256   = "rsa-pkcs1v15-sha256"
+)
+
+// Sign marshals msg in canonical form (see Hash) and wraps it in a signed
+// envelope. The envelope is a binpack message whose records contain the
+// payload, the name of the signature algorithm, and a signature over the
+// payload and algorithm records.
+//
+// The signature algorithm is chosen by the type of the key: Ed25519 keys sign
+// the message directly, while ECDSA and RSA (PKCS #1 v1.5) keys sign a SHA-256
+// digest of it.
+func Sign(priv crypto.Signer, msg interface{}) ([]byte, error) {
+	payload, err := (&MarshalOptions{Deterministic: true}).Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var alg string
+	switch priv.Public().(type) {
+	case ed25519.PublicKey:
+		alg = algEd25519
+	case *ecdsa.PublicKey:
+		alg = algECDSASHA256
+	case *rsa.PublicKey:
+		alg = algRSASHA256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", priv.Public())
+	}
+
+	e := NewEncoder(nil)
+	e.Encode(envelopePayload, payload)
+	e.Encode(envelopeAlgorithm, []byte(alg))
+	signed, opts := envelopeDigest(alg, e.Data.Bytes())
+	sig, err := priv.Sign(rand.Reader, signed, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Encode(envelopeSignature, sig); err != nil {
+		return nil, err
+	}
+	return e.Data.Bytes(), nil
+}
+
+// Verify checks the signature on an envelope produced by Sign, using the
+// public key pub. If the signature is valid and msg != nil, the payload is
+// then unmarshaled into msg.
+func Verify(pub crypto.PublicKey, envelope []byte, msg interface{}) error {
+	recs, err := Split(envelope)
+	if err != nil {
+		return fmt.Errorf("invalid envelope: %w", err)
+	} else if len(recs) != 3 {
+		return fmt.Errorf("invalid envelope: got %d records, want 3", len(recs))
+	}
+	var fields [3][]byte
+	for i, want := range []int{envelopePayload, envelopeAlgorithm, envelopeSignature} {
+		rec, err := DecodeRecord(recs[i])
+		if err != nil {
+			return fmt.Errorf("invalid envelope: %w", err)
+		} else if rec.Tag != want {
+			return fmt.Errorf("invalid envelope: got tag %d, want %d", rec.Tag, want)
+		}
+		fields[i] = rec.Value
+	}
+	end := len(recs[0]) + len(recs[1])
+
+	alg, sig := string(fields[1]), fields[2]
+	signed, opts := envelopeDigest(alg, envelope[:end])
+	var ok bool
+	switch alg {
+	case algEd25519:
+		key, isKey := pub.(ed25519.PublicKey)
+		ok = isKey && ed25519.Verify(key, signed, sig)
+	case algECDSASHA256:
+		key, isKey := pub.(*ecdsa.PublicKey)
+		ok = isKey && ecdsa.VerifyASN1(key, signed, sig)
+	case algRSASHA256:
+		key, isKey := pub.(*rsa.PublicKey)
+		ok = isKey && rsa.VerifyPKCS1v15(key, opts.HashFunc(), signed, sig) == nil
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	if msg != nil {
+		return Unmarshal(fields[0], msg)
+	}
+	return nil
+}
+
+// envelopeDigest returns the data to be signed for the given algorithm, and
+// the signer options to use.
+func envelopeDigest(alg string, data []byte) ([]byte, crypto.SignerOpts) {
+	if alg == algEd25519 {
+		return data, crypto.Hash(0)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], crypto.SHA256
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSignVerify(t *testing.T) {
+	type note struct {
+		From string `binpack:"tag=1"`
+		Text string `binpack:"tag=2"`
+	}
+	in := &note{From: "alice", Text: "meet at noon"}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := []crypto.Signer{edKey, ecKey, rsaKey}
+
+	for i, key := range keys {
+		env, err := binpack.Sign(key, in)
+		if err != nil {
+			t.Fatalf("Sign (%T): unexpected error: %v", key, err)
+		}
+		var out note
+		if err := binpack.Verify(key.Public(), env, &out); err != nil {
+			t.Errorf("Verify (%T): unexpected error: %v", key, err)
+		} else if diff := cmp.Diff(in, &out); diff != "" {
+			t.Errorf("Verify (%T) payload (-want, +got):\n%s", key, diff)
+		}
+
+		// Tampering with the envelope invalidates the signature.
+		bad := append([]byte(nil), env...)
+		bad[5] ^= 1
+		if err := binpack.Verify(key.Public(), bad, nil); err == nil {
+			t.Errorf("Verify (%T) of tampered envelope: got nil, want error", key)
+		}
+
+		// A different key does not verify.
+		for j, other := range keys {
+			if i == j {
+				continue
+			}
+			if err := binpack.Verify(other.Public(), env, nil); err == nil {
+				t.Errorf("Verify (%T) with %T key: got nil, want error", key, other)
+			}
+		}
+	}
+}
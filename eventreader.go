@@ -0,0 +1,108 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var streamTypes = struct {
+	sync.Mutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterType registers the struct type of v, which must be a struct or a
+// pointer to a struct, under the given name, so that an EventReader decodes
+// values of that type from a type stream into values of the type. If name is
+// empty, the type is registered under its default name, the package path and
+// name of the type, e.g., "example.com/pkg.Event". A TypeStreamEncoder
+// describes a registered type by its registered name.
+//
+// RegisterType reports an error if the name or the type is already
+// registered, or if the type is not supported by SchemaOf.
+func RegisterType(name string, v interface{}) error {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("type %T is not a struct or pointer to struct", v)
+	} else if _, err := schemaOfType(typ, make(map[reflect.Type]*Schema)); err != nil {
+		return fmt.Errorf("type %v: %w", typ, err)
+	}
+	if name == "" {
+		name = defaultTypeName(typ)
+	}
+	streamTypes.Lock()
+	defer streamTypes.Unlock()
+	if old, ok := streamTypes.byName[name]; ok {
+		return fmt.Errorf("name %q is already registered for type %v", name, old)
+	} else if old, ok := streamTypes.byType[typ]; ok {
+		return fmt.Errorf("type %v is already registered as %q", typ, old)
+	}
+	streamTypes.byName[name] = typ
+	streamTypes.byType[typ] = name
+	return nil
+}
+
+// registeredType returns the type registered under name, if any.
+func registeredType(name string) (reflect.Type, bool) {
+	streamTypes.Lock()
+	defer streamTypes.Unlock()
+	typ, ok := streamTypes.byName[name]
+	return typ, ok
+}
+
+// An EventReader reads the values of a type stream written by a
+// TypeStreamEncoder, such as an event log, as values of their registered Go
+// types, so that a consumer can handle each value with a type switch:
+//
+//	r := binpack.NewEventReader(d)
+//	for {
+//		v, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		} else if err != nil {
+//			return err
+//		}
+//		switch e := v.(type) {
+//		case *LoginEvent:
+//			// ...
+//		case *binpack.DynamicMessage:
+//			// a type that is not registered
+//		}
+//	}
+type EventReader struct {
+	dec *TypeStreamDecoder
+}
+
+// NewEventReader constructs an EventReader that reads records from d.
+func NewEventReader(d *Decoder) *EventReader {
+	return &EventReader{dec: NewTypeStreamDecoder(d)}
+}
+
+// Next returns the next value from the stream. If the type of the value is
+// registered (see RegisterType), Next returns a pointer to a new value of
+// that type. Otherwise, it returns a *DynamicMessage with the schema
+// described by the stream. At the end of the input, it returns io.EOF.
+func (r *EventReader) Next() (interface{}, error) {
+	tv, err := r.dec.Next()
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := registeredType(tv.TypeName)
+	if !ok {
+		return tv.Dynamic()
+	}
+	v := reflect.New(typ).Interface()
+	if err := tv.Unmarshal(v); err != nil {
+		return nil, fmt.Errorf("decoding %q: %w", tv.TypeName, err)
+	}
+	return v, nil
+}
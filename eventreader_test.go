@@ -0,0 +1,85 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type clickEvent struct {
+	Button int          `binpack:"tag=1"`
+	At     *schemaPoint `binpack:"tag=2"`
+}
+
+type keyEvent struct {
+	Key  string `binpack:"tag=1"`
+	Down bool   `binpack:"tag=2"`
+}
+
+func init() {
+	for name, v := range map[string]interface{}{"click": clickEvent{}, "": (*keyEvent)(nil)} {
+		if err := binpack.RegisterType(name, v); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestEventReader(t *testing.T) {
+	var buf bytes.Buffer
+	e := binpack.NewTypeStreamEncoder(binpack.NewEncoder(&buf))
+	for _, v := range []interface{}{
+		&clickEvent{Button: 1, At: &schemaPoint{X: 5, Y: 6}},
+		keyEvent{Key: "q", Down: true},
+		schemaPoint{X: 7, Y: 8},
+		&keyEvent{Key: "q"},
+	} {
+		if err := e.Encode(v); err != nil {
+			t.Fatalf("Encode(%+v): unexpected error: %v", v, err)
+		}
+	}
+
+	var got []interface{}
+	r := binpack.NewEventReader(binpack.NewBytesDecoder(buf.Bytes()))
+	for {
+		v, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: unexpected error: %v", err)
+		}
+		switch e := v.(type) {
+		case *clickEvent, *keyEvent:
+			got = append(got, e)
+		case *binpack.DynamicMessage:
+			x, _ := e.GetByName("X")
+			y, _ := e.GetByName("Y")
+			got = append(got, []interface{}{x, y})
+		default:
+			t.Errorf("Next: unexpected value of type %T", v)
+		}
+	}
+	if diff := cmp.Diff([]interface{}{
+		&clickEvent{Button: 1, At: &schemaPoint{X: 5, Y: 6}},
+		&keyEvent{Key: "q", Down: true},
+		[]interface{}{int64(7), int64(8)},
+		&keyEvent{Key: "q"},
+	}, got); diff != "" {
+		t.Errorf("Decoded events (-want, +got):\n%s", diff)
+	}
+
+	// Registered names and types must be unique.
+	if err := binpack.RegisterType("click", keyEvent{}); err == nil {
+		t.Error("RegisterType(click, keyEvent): got nil, want error")
+	}
+	if err := binpack.RegisterType("other", clickEvent{}); err == nil {
+		t.Error("RegisterType(other, clickEvent): got nil, want error")
+	}
+	if err := binpack.RegisterType("", 25); err == nil {
+		t.Error("RegisterType(25): got nil, want error")
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A flatPlan describes how to encode a struct type whose tagged fields all
+// have scalar types, so that it can be marshaled without intermediate
+// allocations: the size of the output is computed first, and the records are
+// then appended to a single buffer of that size.
+type flatPlan struct {
+	fields []flatField // in order of increasing tag
+}
+
+type flatField struct {
+	index int          // field index in the struct
+	tag   int          // field tag
+	kind  reflect.Kind // kind of the field value
+}
+
+// flatPlans caches the plans for struct types. A type that cannot be
+// encoded by a plan maps to a nil *flatPlan.
+var flatPlans sync.Map // map[reflect.Type]*flatPlan
+
+// flatKinds are the kinds of field types supported by a flatPlan. A field
+// type must be the predeclared type of its kind, since Marshal does not
+// accept named scalar types.
+var flatKinds = map[reflect.Type]bool{
+	reflect.TypeOf(false):      true,
+	reflect.TypeOf(uint8(0)):   true,
+	reflect.TypeOf(uint16(0)):  true,
+	reflect.TypeOf(uint32(0)):  true,
+	reflect.TypeOf(uint64(0)):  true,
+	reflect.TypeOf(int(0)):     true,
+	reflect.TypeOf(int8(0)):    true,
+	reflect.TypeOf(int16(0)):   true,
+	reflect.TypeOf(int32(0)):   true,
+	reflect.TypeOf(int64(0)):   true,
+	reflect.TypeOf(float32(0)): true,
+	reflect.TypeOf(float64(0)): true,
+	reflect.TypeOf(""):         true,
+	bytesType:                  true,
+}
+
+// flatPlanFor returns the plan for the struct type typ, or nil if the type
+// cannot be encoded by a plan.
+func flatPlanFor(typ reflect.Type) *flatPlan {
+	if p, ok := flatPlans.Load(typ); ok {
+		return p.(*flatPlan)
+	}
+	p := makeFlatPlan(typ)
+	flatPlans.Store(typ, p)
+	return p
+}
+
+func makeFlatPlan(typ reflect.Type) *flatPlan {
+	if typ.Implements(binaryMarshalerType) || reflect.PtrTo(typ).Implements(binaryMarshalerType) {
+		return nil
+	}
+	p := new(flatPlan)
+	for i := 0; i < typ.NumField(); i++ {
+		ft := typ.Field(i)
+		tag, ok := ft.Tag.Lookup("binpack")
+		if !ok {
+			continue
+		}
+		fi, ok := parseTag(tag)
+		if !ok || fi.tag < 0 || fi.tag > wire.MaxTag || fi.nilable || fi.width != 0 || !flatKinds[ft.Type] {
+			return nil // let the general path handle it
+		}
+		p.fields = append(p.fields, flatField{index: i, tag: fi.tag, kind: ft.Type.Kind()})
+	}
+	sort.Slice(p.fields, func(i, j int) bool {
+		return p.fields[i].tag < p.fields[j].tag
+	})
+	for i := 0; i < len(p.fields)-1; i++ {
+		if p.fields[i].tag == p.fields[i+1].tag {
+			return nil // duplicate tags are reported by the general path
+		}
+	}
+	return p
+}
+
+// marshal encodes val, which must be a struct of the type of p. It reports
+// false if val cannot be encoded by p, in which case the caller should use
+// the general path.
+func (p *flatPlan) marshal(val reflect.Value) ([]byte, bool) {
+	var scratch [8]byte
+	size := 0
+	for _, f := range p.fields {
+		value, ok := f.value(val.Field(f.index), &scratch)
+		if !ok {
+			continue // zero values are not encoded
+		}
+		n := wire.EncodedSize(f.tag, value)
+		if n < 0 {
+			return nil, false
+		}
+		size += n
+	}
+	out := make([]byte, 0, size)
+	for _, f := range p.fields {
+		value, ok := f.value(val.Field(f.index), &scratch)
+		if ok {
+			out, _ = wire.AppendRecord(out, f.tag, value) // checked above
+		}
+	}
+	return out, true
+}
+
+// value returns the encoding of the field value v, using scratch for numeric
+// values. It reports false if v is a zero value, which is not encoded.
+func (f flatField) value(v reflect.Value, scratch *[8]byte) ([]byte, bool) {
+	switch f.kind {
+	case reflect.Bool:
+		if v.Bool() {
+			scratch[0] = 1
+			return scratch[:1], true
+		}
+	case reflect.Uint8:
+		if z := v.Uint(); z != 0 {
+			scratch[0] = byte(z)
+			return scratch[:1], true
+		}
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if z := v.Uint(); z != 0 {
+			return packUint(z, scratch), true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if z := v.Int(); z != 0 {
+			return packUint(uint64(z<<1)^uint64(z>>63), scratch), true
+		}
+	case reflect.Float32:
+		if z := v.Float(); z != 0 {
+			return packUint(uint64(math.Float32bits(float32(z))), scratch), true
+		}
+	case reflect.Float64:
+		if z := v.Float(); z != 0 {
+			return packUint(math.Float64bits(z), scratch), true
+		}
+	case reflect.String:
+		if s := v.String(); s != "" {
+			return stringBytes(s), true
+		}
+	case reflect.Slice:
+		if !v.IsNil() {
+			return v.Bytes(), true
+		}
+	}
+	return nil, false
+}
+
+// packUint encodes z as PackUint64, using buf for storage.
+func packUint(z uint64, buf *[8]byte) []byte {
+	for i := range buf {
+		buf[i] = byte(z >> (56 - 8*i))
+	}
+	for i, b := range buf {
+		if b != 0 {
+			return buf[i:]
+		}
+	}
+	return buf[:1]
+}
+
+// stringBytes returns the contents of s as a slice without copying. The
+// caller must not modify the slice.
+func stringBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	hdr := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	return unsafe.Slice((*byte)(unsafe.Pointer(hdr.Data)), len(s))
+}
+
+// marshalFlat encodes v, a struct or pointer to struct, if its type can be
+// encoded by a flatPlan. It reports false if v must be encoded by the general
+// path instead.
+func marshalFlat(v interface{}) ([]byte, bool) {
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil, false
+		}
+		val = val.Elem()
+	}
+	p := flatPlanFor(val.Type())
+	if p == nil {
+		return nil, false
+	}
+	return p.marshal(val)
+}
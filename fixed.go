@@ -0,0 +1,115 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A FixedRegion is a sequence of records that all have the same encoded
+// size, which permits random access to the records by index and binary
+// search over them without a separate index.
+//
+// The encoding does not guarantee a uniform size, so a producer must ensure
+// it: all records must have tags of the same encoded width (for example, all
+// tags below 64, or all in the range 2^6..2^14-1), and values of the same
+// length, padding as needed. A value of one byte less than 0x80 is encoded
+// without a length prefix, so single-byte values should be avoided. Numeric
+// fields used as keys should have a fixed width, e.g., 8 bytes in big-endian
+// order, so that their byte order matches their numeric order.
+type FixedRegion struct {
+	data []byte
+	size int
+}
+
+// NewFixedRegion constructs a FixedRegion from data, using the encoded size
+// of the first record as the size of all records. It reports an error if the
+// length of data is not a multiple of this size. The sizes of the other
+// records are checked as they are accessed.
+func NewFixedRegion(data []byte) (*FixedRegion, error) {
+	if len(data) == 0 {
+		return &FixedRegion{}, nil
+	}
+	size, ok := wire.RecordSize(data)
+	if !ok {
+		return nil, ErrTruncated
+	} else if len(data)%size != 0 {
+		return nil, fmt.Errorf("region of %d bytes is not a multiple of record size %d", len(data), size)
+	}
+	return &FixedRegion{data: data, size: size}, nil
+}
+
+// Len reports the number of records in r.
+func (r *FixedRegion) Len() int {
+	if r.size == 0 {
+		return 0
+	}
+	return len(r.data) / r.size
+}
+
+// RecordSize reports the encoded size of each record in r.
+func (r *FixedRegion) RecordSize() int { return r.size }
+
+// Record returns the tag and value of the record at index i of r, for
+// 0 ≤ i < Len. It reports an error if the record does not have the size of
+// the region.
+func (r *FixedRegion) Record(i int) (int, []byte, error) {
+	if i < 0 || i >= r.Len() {
+		return 0, nil, fmt.Errorf("record %d out of range [0, %d)", i, r.Len())
+	}
+	rec := r.data[i*r.size : (i+1)*r.size]
+	tag, value, rest, err := nextRecord(rec)
+	if err != nil {
+		return 0, nil, fmt.Errorf("record %d: %w", i, err)
+	} else if len(rest) != 0 {
+		return 0, nil, fmt.Errorf("record %d is not %d bytes", i, r.size)
+	}
+	return tag, value, nil
+}
+
+// Search reports the index of the first record of r whose key is not less
+// than key, and whether its key is equal to key. The value of each record
+// must be a message, and its key is the value of the first record in that
+// message with tag keyTag, or empty if there is none. The records of r must
+// be sorted by key, compared lexicographically as bytes.
+//
+// Search inspects only O(log n) records, and reports an error if any of them
+// is malformed.
+func (r *FixedRegion) Search(keyTag int, key []byte) (int, bool, error) {
+	var err error
+	i := sort.Search(r.Len(), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var k []byte
+		k, err = r.key(i, keyTag)
+		return bytes.Compare(k, key) >= 0
+	})
+	if err != nil {
+		return 0, false, err
+	} else if i == r.Len() {
+		return i, false, nil
+	}
+	k, err := r.key(i, keyTag)
+	return i, err == nil && bytes.Equal(k, key), err
+}
+
+// key returns the key of record i.
+func (r *FixedRegion) key(i, keyTag int) ([]byte, error) {
+	_, value, err := r.Record(i)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := findTag(value, keyTag)
+	if !ok {
+		// Distinguish a missing key from a malformed message.
+		if _, err := Split(value); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	return k, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+type fixedEntry struct {
+	Key  []byte `binpack:"tag=1"`
+	Name string `binpack:"tag=2"`
+}
+
+func TestFixedRegion(t *testing.T) {
+	// Build a region of records with 8-byte keys and 8-byte names.
+	e := binpack.NewEncoder(nil)
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(3*i))
+		data, err := binpack.Marshal(&fixedEntry{Key: key, Name: fmt.Sprintf("name%04d", i)})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		e.Encode(1, data)
+	}
+	r, err := binpack.NewFixedRegion(e.Data.Bytes())
+	if err != nil {
+		t.Fatalf("NewFixedRegion failed: %v", err)
+	}
+	if r.Len() != 100 {
+		t.Fatalf("Len: got %d, want 100", r.Len())
+	}
+
+	key := func(v uint64) []byte {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], v)
+		return buf[:]
+	}
+	tests := []struct {
+		key   uint64
+		index int
+		found bool
+	}{
+		{0, 0, true}, {3, 1, true}, {4, 2, false}, {297, 99, true}, {298, 100, false},
+	}
+	for _, test := range tests {
+		i, ok, err := r.Search(1, key(test.key))
+		if err != nil {
+			t.Errorf("Search(%d) failed: %v", test.key, err)
+		} else if i != test.index || ok != test.found {
+			t.Errorf("Search(%d): got (%d, %v), want (%d, %v)", test.key, i, ok, test.index, test.found)
+		}
+	}
+
+	_, value, err := r.Record(33)
+	if err != nil {
+		t.Fatalf("Record(33) failed: %v", err)
+	}
+	var got fixedEntry
+	if err := binpack.Unmarshal(value, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if got.Name != "name0033" {
+		t.Errorf("Record(33): got name %q, want %q", got.Name, "name0033")
+	}
+
+	// A region whose length is not a multiple of the record size is rejected.
+	e.Encode(1, []byte("short"))
+	if _, err := binpack.NewFixedRegion(e.Data.Bytes()); err == nil {
+		t.Error("NewFixedRegion with a short record: got nil, want error")
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"encoding"
+	"hash"
+)
+
+// Hash writes the canonical encoding of v to h, where v must be a struct or
+// a pointer to a struct. The canonical encoding is the output of Marshal with
+// the Deterministic option set, so equal values produce equal hashes.
+//
+// The records of v are written to h as they are encoded, so the complete
+// encoding of v is never held in memory at once; only the encoding of one
+// field value at a time.
+func Hash(v interface{}, h hash.Hash) error {
+	if err := checkMarshalType(v); err != nil {
+		return err
+	}
+	if bm, ok := v.(encoding.BinaryMarshaler); ok {
+		data, err := bm.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		h.Write(data)
+		return nil
+	}
+	isNilPtr, val := deref(v)
+	if isNilPtr {
+		h.Write([]byte{0}) // placeholder for nil, as in Marshal
+		return nil
+	}
+	info, err := checkStructType(val, false /* no pointers */)
+	if err != nil {
+		return err
+	}
+	m := &marshaler{deterministic: true}
+	return m.marshalFields(NewStreamEncoder(h), val, info)
+}
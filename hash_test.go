@@ -0,0 +1,55 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestHash(t *testing.T) {
+	type entry struct {
+		Name  string         `binpack:"tag=1"`
+		Attrs map[string]int `binpack:"tag=2"`
+		Sub   *entry         `binpack:"tag=3"`
+	}
+	newEntry := func() *entry {
+		e := &entry{Name: "root", Attrs: make(map[string]int), Sub: &entry{Name: "sub"}}
+		for i := 0; i < 50; i++ {
+			e.Attrs[fmt.Sprint("key", i)] = i
+		}
+		e.Sub.Attrs = e.Attrs
+		return e
+	}
+
+	// The hash should match the deterministic encoding.
+	opts := &binpack.MarshalOptions{Deterministic: true}
+	want, err := opts.Marshal(newEntry())
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		data, err := opts.Marshal(newEntry())
+		if err != nil {
+			t.Fatalf("Marshal: unexpected error: %v", err)
+		} else if !bytes.Equal(data, want) {
+			t.Fatalf("Marshal is not deterministic: got %q, want %q", data, want)
+		}
+
+		h := sha256.New()
+		if err := binpack.Hash(newEntry(), h); err != nil {
+			t.Fatalf("Hash: unexpected error: %v", err)
+		}
+		if got, want := h.Sum(nil), sha256.Sum256(want); !bytes.Equal(got, want[:]) {
+			t.Errorf("Hash: got %x, want %x", got, want)
+		}
+	}
+
+	if err := binpack.Hash("not a struct", sha256.New()); err == nil {
+		t.Error("Hash of non-struct: got nil, want error")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package httputil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/creachadair/binpack"
+)
+
+// A FeedWriter writes a continuous stream of records to an HTTP response,
+// flushing the response after each record so that the client receives
+// records as they are written. Records are never split across flushes.
+type FeedWriter struct {
+	w     http.ResponseWriter
+	f     http.Flusher
+	enc   *binpack.Encoder
+	count int
+}
+
+// NewFeedWriter constructs a FeedWriter that writes to w, and sends the
+// response headers. It reports an error if w does not support flushing.
+func NewFeedWriter(w http.ResponseWriter) (*FeedWriter, error) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer does not support flushing")
+	}
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &FeedWriter{w: w, f: f, enc: binpack.NewStreamEncoder(w)}, nil
+}
+
+// Encode writes a record to the feed and flushes it to the client. An error
+// from writing, typically because the client has gone away, is sticky.
+func (f *FeedWriter) Encode(tag int, value []byte) error {
+	if err := f.enc.Encode(tag, value); err != nil {
+		return err
+	}
+	f.f.Flush()
+	f.count++
+	return nil
+}
+
+// EncodeAll writes a group of records to the feed and flushes them to the
+// client together. See binpack.Encoder.EncodeAll.
+func (f *FeedWriter) EncodeAll(recs []binpack.Record) error {
+	if err := f.enc.EncodeAll(recs); err != nil {
+		return err
+	}
+	f.f.Flush()
+	f.count += len(recs)
+	return nil
+}
+
+// Count returns the number of records written to the feed.
+func (f *FeedWriter) Count() int { return f.count }
+
+// A FeedReader reads records from a feed written by a FeedWriter.
+type FeedReader struct {
+	*binpack.Decoder
+	body io.Closer
+}
+
+// OpenFeed returns a FeedReader for the records in the body of rsp. It
+// reports an *Error, and closes the body, if the status of rsp is not 2xx or
+// its Content-Type is not ContentType. The caller must close the reader when
+// it is done with the feed.
+func OpenFeed(rsp *http.Response) (*FeedReader, error) {
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		defer rsp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 1<<10))
+		return nil, &Error{Code: rsp.StatusCode, Err: errors.New(strings.TrimSpace(string(msg)))}
+	}
+	if mt, _, err := mime.ParseMediaType(rsp.Header.Get("Content-Type")); err != nil || mt != ContentType {
+		rsp.Body.Close()
+		return nil, fmt.Errorf("feed has content type %q", rsp.Header.Get("Content-Type"))
+	}
+	return &FeedReader{Decoder: binpack.NewDecoder(rsp.Body), body: rsp.Body}, nil
+}
+
+// Close closes the body of the response, ending the feed.
+func (f *FeedReader) Close() error { return f.body.Close() }
@@ -0,0 +1,96 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package httputil_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/creachadair/binpack/httputil"
+)
+
+func TestFeed(t *testing.T) {
+	next := make(chan string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fw, err := httputil.NewFeedWriter(w)
+		if err != nil {
+			t.Errorf("NewFeedWriter failed: %v", err)
+			return
+		}
+		tag := 1
+		for v := range next {
+			if err := fw.Encode(tag, []byte(v)); err != nil {
+				t.Errorf("Encode failed: %v", err)
+				return
+			}
+			tag++
+		}
+		fw.EncodeAll([]binpack.Record{{Tag: 100}, {Tag: 101}})
+	}))
+	defer srv.Close()
+
+	rsp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	feed, err := httputil.OpenFeed(rsp)
+	if err != nil {
+		t.Fatalf("OpenFeed failed: %v", err)
+	}
+	defer feed.Close()
+
+	// Each record is delivered as soon as it is written, before the server
+	// finishes the response.
+	for i, want := range []string{"alpha", "bravo", "charlie"} {
+		next <- want
+		tag, value, err := feed.Decode()
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if tag != i+1 || string(value) != want {
+			t.Errorf("Decode: got (%d, %q), want (%d, %q)", tag, value, i+1, want)
+		}
+	}
+	close(next)
+	for _, want := range []int{100, 101} {
+		if tag, _, err := feed.Decode(); err != nil || tag != want {
+			t.Errorf("Decode: got (%d, %v), want tag %d", tag, err, want)
+		}
+	}
+	if _, _, err := feed.Decode(); err != io.EOF {
+		t.Errorf("Decode at end: got %v, want EOF", err)
+	}
+}
+
+func TestOpenFeedErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "no such feed", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	rsp, err := srv.Client().Get(srv.URL + "/missing")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	var herr *httputil.Error
+	if _, err := httputil.OpenFeed(rsp); !errors.As(err, &herr) || herr.Code != http.StatusNotFound {
+		t.Errorf("OpenFeed: got %v, want status %d", err, http.StatusNotFound)
+	}
+
+	rsp, err = srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := httputil.OpenFeed(rsp); err == nil {
+		t.Error("OpenFeed of a text response: got nil error")
+	}
+}
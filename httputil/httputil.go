@@ -0,0 +1,221 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+// Package httputil provides helpers for serving and consuming binpack data
+// over HTTP, with content negotiation between binpack and JSON.
+//
+// Values exchanged by these helpers are structs with binpack field tags (see
+// binpack.Marshal); the same values are encoded as JSON by encoding/json when
+// the peer does not accept binpack.
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/binpack"
+)
+
+// Media types for the encodings supported by this package.
+const (
+	ContentType     = "application/x-binpack"
+	JSONContentType = "application/json"
+)
+
+// An Error is an error with an HTTP status code. Errors reported by
+// ReadRequest have this type, and a Handler uses the status code of an Error
+// returned by its function.
+type Error struct {
+	Code int   // an HTTP status code
+	Err  error // the underlying error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s: %v", http.StatusText(e.Code), e.Err) }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Negotiate returns the media type for a response to a request with the
+// given Accept header: ContentType if binpack is acceptable and preferred at
+// least as much as JSON, otherwise JSONContentType.
+func Negotiate(accept string) string {
+	var bq, jq float64 = -1, -1 // -1 means not mentioned
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				q = v
+			}
+		}
+		switch mt {
+		case ContentType:
+			bq = q
+		case JSONContentType:
+			jq = q
+		case "application/*", "*/*":
+			if jq < 0 {
+				jq = q
+			}
+		}
+	}
+	if bq > 0 && bq >= jq {
+		return ContentType
+	}
+	return JSONContentType
+}
+
+// WriteResponse encodes v and writes it to w with a status of 200, in the
+// encoding selected by the Accept header of r (see Negotiate).
+func WriteResponse(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	ct := Negotiate(r.Header.Get("Accept"))
+	data, err := marshal(ct, v)
+	if err != nil {
+		return err
+	}
+	return writeData(w, ct, data)
+}
+
+func writeData(w http.ResponseWriter, mediaType string, data []byte) error {
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Add("Vary", "Accept")
+	_, err := w.Write(data)
+	return err
+}
+
+// DefaultMaxBodySize is the largest body read by ReadRequest and
+// ReadResponse, in bytes.
+const DefaultMaxBodySize = 32 << 20
+
+// ReadRequest decodes the body of r into v, as ReadRequestLimit with a limit
+// of DefaultMaxBodySize.
+func ReadRequest(r *http.Request, v interface{}) error {
+	return ReadRequestLimit(r, v, DefaultMaxBodySize)
+}
+
+// ReadRequestLimit decodes the body of r into v, according to its
+// Content-Type. A request with no Content-Type is decoded as JSON. It reports
+// an *Error with status 415 for an unsupported type, with status 413 if the
+// body is longer than limit bytes, and with status 400 if the body cannot be
+// decoded.
+func ReadRequestLimit(r *http.Request, v interface{}, limit int64) error {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		ct = JSONContentType
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || (mt != ContentType && mt != JSONContentType) {
+		return &Error{Code: http.StatusUnsupportedMediaType, Err: fmt.Errorf("content type %q", ct)}
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, limit)
+	data, err := io.ReadAll(r.Body)
+	if err != nil && int64(len(data)) == limit {
+		// The reader reports an error after returning exactly limit bytes.
+		return &Error{Code: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("body exceeds %d bytes", limit)}
+	} else if err == nil {
+		err = unmarshal(mt, data, v)
+	}
+	if err != nil {
+		return &Error{Code: http.StatusBadRequest, Err: err}
+	}
+	return nil
+}
+
+// NewRequest constructs an HTTP request whose body is the binpack encoding of
+// v, and which asks for a binpack response. If v == nil, the request has no
+// body.
+func NewRequest(method, url string, v interface{}) (*http.Request, error) {
+	var body io.Reader
+	if v != nil {
+		data, err := binpack.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		req.Header.Set("Content-Type", ContentType)
+	}
+	req.Header.Set("Accept", ContentType+", "+JSONContentType+";q=0.5")
+	return req, nil
+}
+
+// ReadResponse decodes the body of rsp into v, as ReadResponseLimit with a
+// limit of DefaultMaxBodySize.
+func ReadResponse(rsp *http.Response, v interface{}) error {
+	return ReadResponseLimit(rsp, v, DefaultMaxBodySize)
+}
+
+// ReadResponseLimit decodes the body of rsp into v, according to its
+// Content-Type, and closes the body. It reports an *Error if the status of
+// rsp is not 2xx, and an error if the body is longer than limit bytes.
+func ReadResponseLimit(rsp *http.Response, v interface{}, limit int64) error {
+	defer rsp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, limit+1))
+	if err != nil {
+		return err
+	} else if int64(len(data)) > limit {
+		return fmt.Errorf("response body exceeds %d bytes", limit)
+	} else if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return &Error{Code: rsp.StatusCode, Err: errors.New(strings.TrimSpace(string(data)))}
+	}
+	mt, _, err := mime.ParseMediaType(rsp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("invalid content type: %w", err)
+	}
+	return unmarshal(mt, data, v)
+}
+
+// A Handler is an http.Handler that serves the value returned by its
+// function, encoded as binpack or JSON according to the Accept header of the
+// request. If the function reports an error, the handler replies with the
+// status code of an *Error, or otherwise with status 500.
+type Handler func(*http.Request) (interface{}, error)
+
+// ServeHTTP implements the http.Handler interface.
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v, err := h(r)
+	if err == nil {
+		var data []byte
+		ct := Negotiate(r.Header.Get("Accept"))
+		data, err = marshal(ct, v)
+		if err == nil {
+			writeData(w, ct, data)
+			return
+		}
+	}
+	code := http.StatusInternalServerError
+	var herr *Error
+	if errors.As(err, &herr) {
+		code = herr.Code
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func marshal(mediaType string, v interface{}) ([]byte, error) {
+	if mediaType == ContentType {
+		return binpack.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
+func unmarshal(mediaType string, data []byte, v interface{}) error {
+	switch mediaType {
+	case ContentType:
+		return binpack.Unmarshal(data, v)
+	case JSONContentType:
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("unsupported content type %q", mediaType)
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package httputil_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/creachadair/binpack/httputil"
+	"github.com/google/go-cmp/cmp"
+)
+
+type greeting struct {
+	Name  string `binpack:"tag=1" json:"name"`
+	Count int    `binpack:"tag=2" json:"count"`
+}
+
+func TestNegotiate(t *testing.T) {
+	const bp, js = httputil.ContentType, httputil.JSONContentType
+	tests := []struct {
+		accept, want string
+	}{
+		{"", js},
+		{"*/*", js},
+		{bp, bp},
+		{"application/json, " + bp, bp},
+		{bp + ";q=0.5, application/json", js},
+		{bp + ";q=0.5, */*;q=0.1", bp},
+		{bp + ";q=0", js},
+		{"text/html, " + bp + ";q=0.9", bp},
+		{"garbage;;, " + bp, bp},
+	}
+	for _, test := range tests {
+		if got := httputil.Negotiate(test.accept); got != test.want {
+			t.Errorf("Negotiate(%q): got %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(httputil.Handler(func(r *http.Request) (interface{}, error) {
+		var g greeting
+		if err := httputil.ReadRequest(r, &g); err != nil {
+			return nil, err
+		} else if g.Name == "" {
+			return nil, &httputil.Error{Code: http.StatusNotFound, Err: errors.New("no name")}
+		}
+		g.Count++
+		return &g, nil
+	}))
+	defer srv.Close()
+
+	// A binpack client gets a binpack response.
+	req, err := httputil.NewRequest("POST", srv.URL, &greeting{Name: "alice", Count: 2})
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	rsp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if ct := rsp.Header.Get("Content-Type"); ct != httputil.ContentType {
+		t.Errorf("Content-Type: got %q, want %q", ct, httputil.ContentType)
+	}
+	var got greeting
+	if err := httputil.ReadResponse(rsp, &got); err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if diff := cmp.Diff(greeting{Name: "alice", Count: 3}, got); diff != "" {
+		t.Errorf("Response (-want, +got):\n%s", diff)
+	}
+
+	// A JSON client gets a JSON response.
+	rsp, err = srv.Client().Post(srv.URL, "application/json", strings.NewReader(`{"name":"bob"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	got = greeting{}
+	if err := httputil.ReadResponse(rsp, &got); err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if diff := cmp.Diff(greeting{Name: "bob", Count: 1}, got); diff != "" {
+		t.Errorf("Response (-want, +got):\n%s", diff)
+	}
+
+	// Errors carry their status codes.
+	for _, test := range []struct {
+		ctype, body string
+		code        int
+	}{
+		{httputil.ContentType, "", http.StatusNotFound},
+		{"text/plain", "hello", http.StatusUnsupportedMediaType},
+		{httputil.ContentType, "\x01\x85ab", http.StatusBadRequest},
+	} {
+		rsp, err := srv.Client().Post(srv.URL, test.ctype, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		err = httputil.ReadResponse(rsp, new(greeting))
+		var herr *httputil.Error
+		if !errors.As(err, &herr) || herr.Code != test.code {
+			t.Errorf("Post %q: got error %v, want status %d", test.body, err, test.code)
+		}
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", httputil.ContentType)
+	w := httptest.NewRecorder()
+	if err := httputil.WriteResponse(w, req, greeting{Name: "carol"}); err != nil {
+		t.Fatalf("WriteResponse failed: %v", err)
+	}
+	want, _ := binpack.Marshal(greeting{Name: "carol"})
+	if got := w.Body.String(); got != string(want) {
+		t.Errorf("Body: got %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary: got %q, want %q", got, "Accept")
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	body := `{"name":"0123456789"}`
+	for _, test := range []struct {
+		limit int64
+		code  int
+	}{
+		{int64(len(body)), 0},
+		{int64(len(body)) - 1, http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		err := httputil.ReadRequestLimit(req, new(greeting), test.limit)
+		var herr *httputil.Error
+		if test.code == 0 && err != nil {
+			t.Errorf("ReadRequestLimit(%d): unexpected error: %v", test.limit, err)
+		} else if test.code != 0 && (!errors.As(err, &herr) || herr.Code != test.code) {
+			t.Errorf("ReadRequestLimit(%d): got %v, want status %d", test.limit, err, test.code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", httputil.JSONContentType)
+	rec.WriteString(body)
+	if err := httputil.ReadResponseLimit(rec.Result(), new(greeting), int64(len(body))); err != nil {
+		t.Errorf("ReadResponseLimit: unexpected error: %v", err)
+	}
+	if err := httputil.ReadResponseLimit(rec.Result(), new(greeting), int64(len(body))-1); err == nil {
+		t.Error("ReadResponseLimit over limit: got nil, want error")
+	}
+}
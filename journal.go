@@ -0,0 +1,108 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Tags of the records in a journal.
+const (
+	journalSnapshot = 1 // value is the full encoding of a state
+	journalDiff     = 2 // value is a patch from the previous state
+)
+
+// A JournalWriter records a sequence of states of a struct value as a
+// journal of records. Periodically the journal contains a full snapshot of
+// the state, and between snapshots it contains only patches as produced by
+// MarshalDiff. Use ReplayJournal to reconstruct the latest state.
+type JournalWriter struct {
+	enc   *Encoder
+	every int           // write a snapshot after this many patches
+	n     int           // patches written since the last snapshot
+	prev  reflect.Value // the previous state, as a reader will see it
+}
+
+// NewJournalWriter constructs a JournalWriter that writes to w. After every
+// snapshotEvery patches, a full snapshot is written instead of a patch. If
+// snapshotEvery <= 0, only the first state is written as a snapshot.
+func NewJournalWriter(w io.Writer, snapshotEvery int) *JournalWriter {
+	return &JournalWriter{enc: NewStreamEncoder(w), every: snapshotEvery}
+}
+
+// Append adds the state v, which must be a struct or pointer to struct, to
+// the journal. Each call to Append must use the same type. If v is equal to
+// the previous state, nothing is written.
+func (j *JournalWriter) Append(v interface{}) error {
+	_, val := deref(v)
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("type %T is not a struct or pointer to struct", v)
+	} else if j.prev.IsValid() && j.prev.Elem().Type() != val.Type() {
+		return fmt.Errorf("type %v does not match journal type %v", val.Type(), j.prev.Elem().Type())
+	}
+
+	if !j.prev.IsValid() || (j.every > 0 && j.n >= j.every) {
+		data, err := Marshal(v)
+		if err != nil {
+			return err
+		}
+		prev := reflect.New(val.Type())
+		if err := Unmarshal(data, prev.Interface()); err != nil {
+			return err
+		}
+		if err := j.enc.Encode(journalSnapshot, data); err != nil {
+			return err
+		}
+		j.prev, j.n = prev, 0
+		return nil
+	}
+
+	patch, err := MarshalDiff(j.prev.Interface(), v)
+	if err != nil {
+		return err
+	} else if len(patch) == 0 {
+		return nil // no change
+	}
+	if err := j.enc.Encode(journalDiff, patch); err != nil {
+		return err
+	}
+	j.n++
+	return ApplyDiff(j.prev.Interface(), patch)
+}
+
+// ReplayJournal reads a journal written by a JournalWriter from r, and
+// populates v, which must be a non-nil pointer to a struct of the type the
+// journal records, with the latest state.
+func ReplayJournal(r io.Reader, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%T is not a non-nil pointer to struct", v)
+	}
+	d := NewDecoder(r)
+	for i := 0; ; i++ {
+		tag, data, err := d.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch tag {
+		case journalSnapshot:
+			val.Elem().Set(reflect.Zero(val.Elem().Type()))
+			err = Unmarshal(data, v)
+		case journalDiff:
+			if i == 0 {
+				return errors.New("journal does not begin with a snapshot")
+			}
+			err = ApplyDiff(v, data)
+		default:
+			return fmt.Errorf("invalid journal record tag %d", tag)
+		}
+		if err != nil {
+			return fmt.Errorf("journal entry %d: %w", i, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A JSONLinesWriter writes records as newline-delimited JSON objects, one per
+// line, so that tools that consume JSON lines can process a record stream.
+// Each object has the form
+//
+//	{"tag": 3, "value": "aGVsbG8="}
+//
+// where the value is encoded in base64. If the writer has a schema, records
+// whose tags are described by the schema also have a "field" giving the field
+// name, and their values are rendered as in Schema.UnmarshalMap:
+//
+//	{"tag": 3, "field": "Greeting", "value": "hello"}
+//
+// The records of a repeated field are written separately, one per element.
+type JSONLinesWriter struct {
+	enc    *json.Encoder
+	schema *Schema
+}
+
+// NewJSONLinesWriter constructs a JSONLinesWriter that writes to w. If s !=
+// nil, it is used to render the values of records whose tags it describes.
+func NewJSONLinesWriter(w io.Writer, s *Schema) *JSONLinesWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONLinesWriter{enc: enc, schema: s}
+}
+
+// jsonLine is the JSON representation of a single record.
+type jsonLine struct {
+	Tag   int         `json:"tag"`
+	Field string      `json:"field,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// Encode writes a single record as a line of JSON. It reports an error if the
+// value does not decode according to the schema.
+func (j *JSONLinesWriter) Encode(tag int, value []byte) error {
+	line := jsonLine{Tag: tag, Value: value}
+	if f := j.fieldByTag(tag); f != nil {
+		v, err := f.decode(value)
+		if err != nil {
+			return fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		line.Field, line.Value = f.Name, v
+	}
+	return j.enc.Encode(line)
+}
+
+// CopyFrom writes each record from d as a line of JSON until d is exhausted.
+func (j *JSONLinesWriter) CopyFrom(d *Decoder) error {
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := j.Encode(tag, value); err != nil {
+			return err
+		}
+	}
+}
+
+func (j *JSONLinesWriter) fieldByTag(tag int) *SchemaField {
+	if j.schema == nil {
+		return nil
+	}
+	return j.schema.FieldByTag(tag)
+}
+
+// A JSONLinesReader reads records from newline-delimited JSON objects in the
+// format written by a JSONLinesWriter. If the reader has a schema, an object
+// may give the field name instead of the tag, and the values of fields it
+// describes are encoded as by Schema.MarshalMap. Otherwise, the value must
+// be a base64 string. A missing or null value is an empty value.
+type JSONLinesReader struct {
+	dec    *json.Decoder
+	schema *Schema
+	count  int
+}
+
+// NewJSONLinesReader constructs a JSONLinesReader that reads from r. If
+// s != nil, it is used to encode the values of the fields it describes.
+func NewJSONLinesReader(r io.Reader, s *Schema) *JSONLinesReader {
+	return &JSONLinesReader{dec: json.NewDecoder(r), schema: s}
+}
+
+// Decode reads the next object and returns its tag and encoded value. At the
+// end of the input, it returns io.EOF.
+func (j *JSONLinesReader) Decode() (int, []byte, error) {
+	var line struct {
+		Tag   *int            `json:"tag"`
+		Field string          `json:"field"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := j.dec.Decode(&line); err == io.EOF {
+		return 0, nil, err
+	} else if err != nil {
+		return 0, nil, fmt.Errorf("record %d: %w", j.count+1, err)
+	}
+	j.count++
+	tag, value, err := j.record(line.Tag, line.Field, line.Value)
+	if err != nil {
+		return 0, nil, fmt.Errorf("record %d: %w", j.count, err)
+	}
+	return tag, value, nil
+}
+
+// CopyTo encodes each record read from j to e until the input is exhausted.
+func (j *JSONLinesReader) CopyTo(e *Encoder) error {
+	for {
+		tag, value, err := j.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := e.Encode(tag, value); err != nil {
+			return err
+		}
+	}
+}
+
+func (j *JSONLinesReader) record(tag *int, name string, raw json.RawMessage) (int, []byte, error) {
+	var f *SchemaField
+	if j.schema != nil {
+		if name != "" {
+			f = j.schema.FieldByName(name)
+			if f == nil {
+				return 0, nil, fmt.Errorf("%w %q", ErrUnknownField, name)
+			} else if tag != nil && *tag != f.Tag {
+				return 0, nil, fmt.Errorf("field %q has tag %d, not %d", name, f.Tag, *tag)
+			}
+		} else if tag != nil {
+			f = j.schema.FieldByTag(*tag)
+		}
+	}
+	if f == nil && tag == nil {
+		return 0, nil, errors.New("missing tag")
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		if f != nil {
+			return f.Tag, nil, nil
+		}
+		return *tag, nil, nil
+	}
+	if f == nil {
+		var value []byte
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return 0, nil, fmt.Errorf("tag %d: %w", *tag, err)
+		}
+		return *tag, value, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return 0, nil, fmt.Errorf("field %q: %w", f.Name, err)
+	}
+	value, _, err := f.encode(v)
+	if err != nil {
+		return 0, nil, fmt.Errorf("field %q: %w", f.Name, err)
+	}
+	return f.Tag, value, nil
+}
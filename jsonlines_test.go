@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONLines(t *testing.T) {
+	type inner struct {
+		Label string `binpack:"tag=1"`
+		Score int    `binpack:"tag=2"`
+	}
+	type thing struct {
+		Name  string   `binpack:"tag=1"`
+		Count uint64   `binpack:"tag=2"`
+		Ratio float64  `binpack:"tag=3"`
+		Sub   *inner   `binpack:"tag=4"`
+		Tags  []string `binpack:"tag=5"`
+		Blob  []byte   `binpack:"tag=6"`
+	}
+	in := &thing{
+		Name:  "apple",
+		Count: 1<<63 + 1,
+		Ratio: 0.25,
+		Sub:   &inner{Label: "<pie>", Score: -3},
+		Tags:  []string{"red", "green"},
+		Blob:  []byte{0, 1, 2},
+	}
+	data, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	data = append(data, "\x63\x82??"...) // a record not in the schema
+	s, err := binpack.SchemaOf(in)
+	if err != nil {
+		t.Fatalf("SchemaOf failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		schema *binpack.Schema
+		want   string
+	}{
+		{"Untyped", nil, `{"tag":1,"value":"YXBwbGU="}
+{"tag":2,"value":"gAAAAAAAAAE="}
+{"tag":3,"value":"P9AAAAAAAAA="}
+{"tag":4,"value":"AYU8cGllPgIF"}
+{"tag":5,"value":"cmVk"}
+{"tag":5,"value":"Z3JlZW4="}
+{"tag":6,"value":"AAEC"}
+{"tag":99,"value":"Pz8="}
+`},
+		{"Typed", s, `{"tag":1,"field":"Name","value":"apple"}
+{"tag":2,"field":"Count","value":9223372036854775809}
+{"tag":3,"field":"Ratio","value":0.25}
+{"tag":4,"field":"Sub","value":{"Label":"<pie>","Score":-3}}
+{"tag":5,"field":"Tags","value":"red"}
+{"tag":5,"field":"Tags","value":"green"}
+{"tag":6,"field":"Blob","value":"AAEC"}
+{"tag":99,"value":"Pz8="}
+`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := binpack.NewJSONLinesWriter(&buf, test.schema).CopyFrom(binpack.NewBytesDecoder(data)); err != nil {
+			t.Fatalf("%s: CopyFrom failed: %v", test.name, err)
+		}
+		if diff := cmp.Diff(test.want, buf.String()); diff != "" {
+			t.Errorf("%s: JSON output (-want, +got):\n%s", test.name, diff)
+		}
+
+		// Reading the output back reproduces the original records.
+		e := binpack.NewEncoder(nil)
+		if err := binpack.NewJSONLinesReader(&buf, test.schema).CopyTo(e); err != nil {
+			t.Fatalf("%s: CopyTo failed: %v", test.name, err)
+		}
+		if diff := cmp.Diff(data, e.Data.Bytes()); diff != "" {
+			t.Errorf("%s: records (-want, +got):\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestJSONLinesReader(t *testing.T) {
+	s := &binpack.Schema{Fields: []*binpack.SchemaField{
+		{Name: "Name", Tag: 1, Kind: binpack.KindString},
+		{Name: "OK", Tag: 2, Kind: binpack.KindBool},
+	}}
+	r := binpack.NewJSONLinesReader(strings.NewReader(`{"field":"Name","value":"pear"}
+{"tag":2,"value":true}
+{"tag":7}
+`), s)
+	var got []binpack.Record
+	for {
+		tag, value, err := r.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		got = append(got, binpack.Record{Tag: tag, Value: value})
+	}
+	want := []binpack.Record{
+		{Tag: 1, Value: []byte("pear")},
+		{Tag: 2, Value: []byte{1}},
+		{Tag: 7},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Records (-want, +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{
+		`{"value":"eA=="}`,                    // no tag or field
+		`{"field":"Nonesuch","value":"x"}`,    // unknown field
+		`{"tag":2,"field":"Name","value":""}`, // mismatched tag
+		`{"tag":1,"value":17}`,                // wrong type for field
+		`{"tag":9,"value":"not base64!"}`,     // bad untyped value
+		`{"tag":1,`,                           // malformed JSON
+	} {
+		_, _, err := binpack.NewJSONLinesReader(strings.NewReader(bad), s).Decode()
+		if err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("Decode(%s): got %v, want error", bad, err)
+		}
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/creachadair/binpack/wire"
 )
 
 // Marshal encodes a value v of struct type as a buffer of binpack tag-value
@@ -25,25 +27,183 @@ import (
 // where n is an unsigned integer value. Fields without tags are skipped, and
 // zero-valued fields are not encoded.
 //
+// A field may be limited to a range of message versions by adding since=v,
+// until=v, or both, to its tag:
+//
+//	binpack:"tag=8,since=3"
+//	binpack:"tag=5,until=2"
+//
+// Both bounds are inclusive. Such a field is encoded only if the Version of
+// the MarshalOptions is in its range, and decoded only if the Version of the
+// UnmarshalOptions is in its range; records for fields outside the range are
+// ignored when decoding. A Version of 0 includes all fields.
+//
+// A field may be marked deprecated by adding "deprecated" to its tag, so
+// that decoding it calls the Deprecated hook of the UnmarshalOptions, if any.
+// Deprecation does not otherwise affect encoding or decoding.
+//
 // Slices are marshaled as the concatenation of their contents. A struct field
 // of slice type other than []byte is encoded inline, meaning each slice
-// element is written as a separate tag-value pair within the struct.
+// element is written as a separate tag-value pair within the struct. A map
+// field is likewise encoded inline, with one record for each entry, whose
+// value is the encoding of the key followed by the encoding of the value,
+// each as a length-prefixed value.
+//
+// A struct field of type []byte is encoded as a single record whose value is
+// the contents of the slice. Earlier versions encoded such a field inline,
+// with one record per byte. To read data written in that encoding, decode it
+// with UnmarshalOptions.LegacyBytes set; re-encoding the result with Marshal
+// converts it to the current encoding.
+//
+// Containers nested within a slice element or map value are packed into the
+// value of a single record: a slice as the concatenation of its elements, and
+// a map as the concatenation of its entries, each as a length-prefixed value.
+// For example, given
+//
+//	A map[string][]int   `binpack:"tag=1"`
+//	B []map[int]string   `binpack:"tag=2"`
+//
+// each key k of A has a record with tag 1 whose value is k followed by the
+// packed elements of A[k], and each element of B has a record with tag 2
+// whose value is the packed entries of that map. An empty nested container
+// is encoded as an empty packed value, and is decoded as an empty, non-nil
+// container, so a nested nil slice or map does not round-trip as nil.
+//
+// A struct field whose type is a pointer to a slice or map, such as *[]T or
+// *map[K]V, models an optional container whose presence is distinct from
+// being empty. It is not encoded inline: if the pointer is non-nil, the field
+// is encoded as a single record whose value is the packed container, even if
+// the container is empty. Decoding such a record sets the pointer, so a nil
+// pointer, a pointer to an empty container, and a pointer to a non-empty
+// container all round-trip.
+//
+// A nil pointer that is an element of a slice or a value of a map is encoded
+// as a single zero byte, which is also the encoding of the zero value of many
+// pointee types. To keep nil distinct from a pointer to a zero value, a field
+// whose type is a pointer, or a slice or map of pointers, may add "nilable"
+// to its tag:
+//
+//	binpack:"tag=3,nilable"
+//
+// The pointers of such a field use an optional encoding, in which nil is an
+// empty value, and a non-nil pointer is the byte 0x01 followed by the
+// encoding of its pointee, which may be of a scalar type such as int. A nil
+// pointer field is then encoded rather than omitted, and decoding it sets the
+// field to nil. The encoder and decoder of a field must agree on whether it
+// is nilable.
+//
+// A field of type float32 or float64, or a slice of these, may be encoded
+// with reduced precision by adding "width=n" to its tag, where n is the size
+// in bytes of the IEEE 754 format to use: 2 for half precision, 4 for single
+// precision, or 8 for double precision. The option "f16" is a synonym for
+// "width=2". Values are converted to the narrower format with rounding, and
+// values too large for it become infinities. The encoder and decoder of a
+// field must agree on its width.
 //
 // Note that map values are encoded in iteration order, which means that
 // marshaling a value that is or contains a map may not be deterministic.
-// Other than maps, however, the output is deterministic.
-func Marshal(v interface{}) ([]byte, error) {
+// Other than maps, however, the output is deterministic. Use a MarshalOptions
+// with Deterministic set to encode maps in a consistent order.
+func Marshal(v interface{}) ([]byte, error) { return (*MarshalOptions)(nil).Marshal(v) }
+
+// MarshalOptions control the encoding of values by Marshal.
+// A nil *MarshalOptions provides default values.
+type MarshalOptions struct {
+	// If true, map entries are encoded in order of their encodings, so that
+	// equal values always produce the same output.
+	Deterministic bool
+
+	// If non-nil, Marshal adds to Sizes the number of encoded bytes
+	// contributed by each top-level field, keyed by tag. The size of a record
+	// includes its tag, length prefix, and any nested content, so the sizes
+	// sum to the length of the output. Entries are added to, not replaced, so
+	// a single map can accumulate sizes over several calls.
+	Sizes map[int]int
+
+	// If true, each record tag also records the WireKind of its value, as
+	// MakeTag(tag, kind), so that the output can be interpreted without
+	// knowing the type of v. This requires field tags to be at most
+	// MaxTagID, and must be matched by UnmarshalOptions.SelfDescribing when
+	// decoding. Values of type float32 are encoded as float64 in this mode.
+	//
+	// In this mode, each value of a map with interface{} values, such as
+	// map[string]interface{}, is encoded as a single record whose tag is the
+	// WireKind of the value, so that loosely-typed maps can be decoded. Such
+	// values are decoded as described for DecodeGeneric.
+	SelfDescribing bool
+
+	// If true, a map whose values are slices (other than []byte) is encoded
+	// as a multi-map, with one entry for each element of each value, paired
+	// with its key. Otherwise, each value is encoded as a packed slice in a
+	// single entry. This must be matched by UnmarshalOptions.MultiMap when
+	// decoding.
+	MultiMap bool
+
+	// If true, a value that implements error or fmt.Stringer, and that has
+	// no other encoding, is encoded as the string returned by its Error
+	// method, or else its String method. A value has no other encoding
+	// if it does not implement encoding.BinaryMarshaler, is not of a
+	// predeclared scalar type, slice, or map, and is not a struct with
+	// tagged fields. This allows diagnostic values such as errors to be
+	// encoded for logging. The strings cannot be decoded into the original
+	// types, but can be decoded into string fields.
+	Stringers bool
+
+	// If positive, only fields whose since and until bounds include Version
+	// are encoded. If zero, all fields are encoded.
+	Version int
+}
+
+// Marshal encodes v as by the Marshal function, using the settings from o.
+func (o *MarshalOptions) Marshal(v interface{}) ([]byte, error) {
+	if err := checkMarshalType(v); err != nil {
+		return nil, err
+	}
+	if o == nil || (o.Sizes == nil && !o.SelfDescribing && !o.MultiMap && !o.Stringers && o.Version == 0) {
+		if out, ok := marshalFlat(v); ok {
+			return out, nil
+		}
+	}
+	return o.marshaler().marshalAny(v)
+}
+
+// checkMarshalType reports an error if v is not a struct or pointer to struct.
+func checkMarshalType(v interface{}) error {
 	typ := reflect.TypeOf(v)
-	if typ.Kind() == reflect.Ptr {
+	if typ != nil && typ.Kind() == reflect.Ptr {
 		typ = typ.Elem()
 	}
-	if typ.Kind() != reflect.Struct {
-		return nil, errors.New("v is not a struct or pointer to struct")
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return errors.New("v is not a struct or pointer to struct")
+	}
+	return nil
+}
+
+func (o *MarshalOptions) marshaler() *marshaler {
+	if o == nil {
+		return new(marshaler)
+	}
+	return &marshaler{
+		deterministic:  o.Deterministic,
+		sizes:          o.Sizes,
+		selfDescribing: o.SelfDescribing,
+		version:        o.Version,
+		multiMap:       o.MultiMap,
+		stringers:      o.Stringers,
 	}
-	return marshalAny(v)
 }
 
-func marshalAny(v interface{}) ([]byte, error) {
+// A marshaler carries the settings for an encoding operation.
+type marshaler struct {
+	deterministic  bool        // encode map entries in sorted order
+	sizes          map[int]int // if non-nil, accumulates top-level record sizes
+	selfDescribing bool        // record wire kinds in tags
+	version        int         // if nonzero, the message version to encode
+	multiMap       bool        // encode slice-valued maps as multi-maps
+	stringers      bool        // encode errors and fmt.Stringers as strings
+}
+
+func (m *marshaler) marshalAny(v interface{}) ([]byte, error) {
 	switch t := v.(type) {
 	case encoding.BinaryMarshaler:
 		return t.MarshalBinary()
@@ -61,23 +221,72 @@ func marshalAny(v interface{}) ([]byte, error) {
 	case nil:
 		return []byte{0}, nil
 	}
-	if ok, buf := marshalNumber(v); ok {
+	if f, ok := v.(float32); ok && m.selfDescribing {
+		return PackFloat64(float64(f)), nil
+	} else if ok, buf := marshalNumber(v); ok {
 		return buf, nil
 	}
+	if m.stringers {
+		if s, ok := adaptString(v); ok {
+			return []byte(s), nil
+		}
+	}
 	isNilPtr, val := deref(v)
 	if isNilPtr {
 		return []byte{0}, nil // placeholder for nil
 	}
 	if typ := val.Type(); typ.Kind() == reflect.Slice {
-		return marshalSlice(val)
+		return m.marshalSlice(val)
 	} else if typ.Kind() == reflect.Struct {
-		return marshalStruct(val)
+		return m.marshalStruct(val)
 	} else if typ.Kind() == reflect.Map {
-		return marshalMap(val)
+		return m.marshalMap(val)
 	}
 	return nil, fmt.Errorf("type %T cannot be marshaled", v)
 }
 
+// adaptString reports whether v implements error or fmt.Stringer and has no
+// other encoding, and if so returns its string.
+// Precondition: v is not of a predeclared scalar type.
+func adaptString(v interface{}) (string, bool) {
+	if v == nil || isNilPointer(v) {
+		return "", false
+	}
+	typ := derefType(reflect.TypeOf(v))
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Map:
+		return "", false
+	case reflect.Struct:
+		if hasTaggedFields(typ) {
+			return "", false
+		}
+	}
+	switch t := v.(type) {
+	case error:
+		return t.Error(), true
+	case fmt.Stringer:
+		return t.String(), true
+	}
+	return "", false
+}
+
+// isNilPointer reports whether v is a nil pointer.
+func isNilPointer(v interface{}) bool {
+	val := reflect.ValueOf(v)
+	return val.Kind() == reflect.Ptr && val.IsNil()
+}
+
+// hasTaggedFields reports whether the struct type typ has any fields with
+// binpack tags.
+func hasTaggedFields(typ reflect.Type) bool {
+	for i := 0; i < typ.NumField(); i++ {
+		if _, ok := typ.Field(i).Tag.Lookup("binpack"); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // marshalNumber reports whether v is one of the built-in numeric types, apart
 // from byte and uint8; if so it also returns the encoding of v.
 func marshalNumber(v interface{}) (bool, []byte) {
@@ -123,25 +332,26 @@ func deref(v interface{}) (bool, reflect.Value) {
 
 // marshalSlice encodes a slice as a concatenated sequence of values.
 // Precondition: val is a reflect.Slice.
-func marshalSlice(val reflect.Value) ([]byte, error) {
-	vals, err := packSlice(val)
+func (m *marshaler) marshalSlice(val reflect.Value) ([]byte, error) {
+	vals, err := m.packSlice(val, m.marshalAny)
 	if err != nil {
 		return nil, err
 	}
 	buf := newBufSize(encodedSize(vals))
 	for _, elt := range vals {
-		writeValue(buf, elt)
+		WriteValue(buf, elt)
 	}
 	return buf.Bytes(), nil
 }
 
-// packSlice encodes a slice into a slice of byte records.
+// packSlice encodes a slice into a slice of byte records, encoding each
+// element with marshalElem.
 // Precondition: val is a reflect.Slice.
-func packSlice(val reflect.Value) ([][]byte, error) {
+func (m *marshaler) packSlice(val reflect.Value, marshalElem func(interface{}) ([]byte, error)) ([][]byte, error) {
 	var vals [][]byte
 	for i := 0; i < val.Len(); i++ {
 		cur := val.Index(i).Interface()
-		data, err := marshalAny(cur)
+		data, err := marshalElem(cur)
 		if err != nil {
 			return nil, fmt.Errorf("marshaling index %d: %w", i, err)
 		}
@@ -151,74 +361,272 @@ func packSlice(val reflect.Value) ([][]byte, error) {
 }
 
 // marshalMap encodes a map as a concatenated sequence of key-value pairs.
-// Note that iteration order affects the output, and may vary.
+// Note that unless m is deterministic, iteration order affects the output.
 // Precondition: val is a reflect.Map.
-func marshalMap(val reflect.Value) ([]byte, error) {
-	vals, err := packMap(val)
+func (m *marshaler) marshalMap(val reflect.Value) ([]byte, error) {
+	vals, err := m.packMap(val, false)
 	if err != nil {
 		return nil, err
 	}
-	return marshalSlice(reflect.ValueOf(vals))
+	return m.marshalSlice(reflect.ValueOf(vals))
 }
 
-// packMap encodes a map as a slice of byte records.
+// packMap encodes a map as a slice of byte records. If nilable is true, the
+// values are pointers with the optional encoding.
 // Precondition: val is a reflect.Map.
-func packMap(val reflect.Value) ([][]byte, error) {
+func (m *marshaler) packMap(val reflect.Value, nilable bool) ([][]byte, error) {
+	if m.multiMap && isMultiMap(val.Type()) {
+		return m.packMultiMap(val)
+	}
+	marshalValue := m.marshalAny
+	if nilable {
+		marshalValue = m.marshalOptional
+	} else if m.selfDescribing && val.Type().Elem() == anyType {
+		marshalValue = m.marshalKinded
+	}
 	var vals [][]byte
 	for _, key := range val.MapKeys() {
-		kbits, err := marshalAny(key.Interface())
+		kbits, err := m.marshalAny(key.Interface())
 		if err != nil {
 			return nil, err
 		}
-		vbits, err := marshalAny(val.MapIndex(key).Interface())
+		vbits, err := marshalValue(val.MapIndex(key).Interface())
 		if err != nil {
 			return nil, err
 		}
-		buf := newBufSize(lengthSize(kbits) + len(kbits) + lengthSize(vbits) + len(vbits))
-		writeValue(buf, kbits)
-		writeValue(buf, vbits)
-		vals = append(vals, buf.Bytes())
+		vals = append(vals, packEntry(kbits, vbits))
+	}
+	if m.deterministic {
+		sort.Slice(vals, func(i, j int) bool {
+			return bytes.Compare(vals[i], vals[j]) < 0
+		})
 	}
 	return vals, nil
 }
 
+// packMultiMap encodes a map whose values are slices as a slice of byte
+// records, one for each element of each value, paired with its key. The
+// elements for each key are kept in order.
+// Precondition: val is a reflect.Map, and isMultiMap(val.Type()).
+func (m *marshaler) packMultiMap(val reflect.Value) ([][]byte, error) {
+	type group struct {
+		kbits []byte
+		elts  reflect.Value
+	}
+	var groups []group
+	for _, key := range val.MapKeys() {
+		kbits, err := m.marshalAny(key.Interface())
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group{kbits: kbits, elts: val.MapIndex(key)})
+	}
+	if m.deterministic {
+		sort.Slice(groups, func(i, j int) bool {
+			return bytes.Compare(groups[i].kbits, groups[j].kbits) < 0
+		})
+	}
+	var vals [][]byte
+	for _, g := range groups {
+		for i := 0; i < g.elts.Len(); i++ {
+			vbits, err := m.marshalAny(g.elts.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("marshaling index %d: %w", i, err)
+			}
+			vals = append(vals, packEntry(g.kbits, vbits))
+		}
+	}
+	return vals, nil
+}
+
+// optionalPresent is the first byte of the optional encoding of a non-nil
+// pointer, for a nilable field. The optional encoding of nil is empty.
+const optionalPresent = 1
+
+// marshalOptional encodes v, which must be a pointer, with the optional
+// encoding used for nilable fields.
+func (m *marshaler) marshalOptional(v interface{}) ([]byte, error) {
+	if isNilPointer(v) {
+		return []byte{}, nil
+	}
+	// Encode the pointee, so that pointers to scalars are supported, unless
+	// the pointer provides its own encoding.
+	if _, ok := v.(encoding.BinaryMarshaler); !ok {
+		v = reflect.ValueOf(v).Elem().Interface()
+	}
+	data, err := m.marshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{optionalPresent}, data...), nil
+}
+
+// marshalFloat encodes v, which must be a float32 or float64, in the IEEE 754
+// format of the given width in bytes, for a field with a width option.
+func marshalFloat(v interface{}, width int) ([]byte, error) {
+	var f float64
+	switch t := v.(type) {
+	case float32:
+		f = float64(t)
+	case float64:
+		f = t
+	default:
+		return nil, fmt.Errorf("type %T does not support a width", v)
+	}
+	switch width {
+	case 2:
+		return PackFloat16(float32(f)), nil
+	case 4:
+		return PackFloat32(float32(f)), nil
+	default:
+		return PackFloat64(f), nil
+	}
+}
+
+// packEntry encodes a map entry with the given encoded key and value.
+func packEntry(kbits, vbits []byte) []byte {
+	buf := newBufSize(wire.ValueSize(kbits) + wire.ValueSize(vbits))
+	WriteValue(buf, kbits)
+	WriteValue(buf, vbits)
+	return buf.Bytes()
+}
+
+// isMultiMap reports whether the map type mtype has slice values, other than
+// []byte, and so can be encoded as a multi-map.
+func isMultiMap(mtype reflect.Type) bool {
+	vtype := mtype.Elem()
+	return vtype.Kind() == reflect.Slice && vtype != bytesType
+}
+
 // marshalStruct encodes a struct as a sequence of tag-value pairs.
+// The records for all the fields are encoded before any are written, so that
+// the output can be allocated once at its final size.
 // Precondition: val is a reflect.Struct.
-func marshalStruct(val reflect.Value) ([]byte, error) {
+func (m *marshaler) marshalStruct(val reflect.Value) ([]byte, error) {
 	info, err := checkStructType(val, false /* no pointers */)
 	if err != nil {
 		return nil, err
 	}
-	buf := NewEncoder(nil)
+	// Only the outermost struct contributes sizes.
+	sizes := m.sizes
+	m.sizes = nil
+	defer func() { m.sizes = sizes }()
 
+	var recs []Record
 	for _, fi := range info {
-		// Slice fields are flattened into the stream.
-		if fi.seq {
-			var vals [][]byte
-			switch fi.target.Kind() {
-			case reflect.Slice:
-				vals, err = packSlice(fi.target)
-			case reflect.Map:
-				vals, err = packMap(fi.target)
-			default:
-				panic("invalid sequence type")
-			}
-			if err != nil {
-				return nil, err
-			}
-			for _, elt := range vals {
-				buf.Encode(fi.tag, elt)
-			}
+		if !fi.inVersion(m.version) {
 			continue
-		} else if data, err := marshalAny(fi.target.Interface()); err != nil {
-			return nil, err
-		} else {
-			buf.Encode(fi.tag, data)
+		}
+		frecs, err := m.fieldRecords(fi)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", describeTag(val.Type(), fi.tag), err)
+		}
+		recs = append(recs, frecs...)
+	}
+	var size int
+	for _, rec := range recs {
+		if n := wire.EncodedSize(rec.Tag, rec.Value); n > 0 {
+			size += n
+			if sizes != nil {
+				sizes[m.fieldTag(rec.Tag)] += n
+			}
+		}
+	}
+	buf := NewEncoder(newBufSize(size))
+	for _, rec := range recs {
+		if err := buf.Encode(rec.Tag, rec.Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", describeTag(val.Type(), m.fieldTag(rec.Tag)), err)
 		}
 	}
 	return buf.Data.Bytes(), nil
 }
 
+// marshalFields encodes the selected fields of a struct as a sequence of
+// tag-value pairs written to buf.
+// Precondition: val is a reflect.Struct, info was obtained from val.
+func (m *marshaler) marshalFields(buf *Encoder, val reflect.Value, info []*fieldInfo) error {
+	for _, fi := range info {
+		if err := m.marshalField(buf, fi); err != nil {
+			return fmt.Errorf("%s: %w", describeTag(val.Type(), fi.tag), err)
+		}
+	}
+	return nil
+}
+
+// marshalField encodes the records for a single struct field to buf.
+func (m *marshaler) marshalField(buf *Encoder, fi *fieldInfo) error {
+	recs, err := m.fieldRecords(fi)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := buf.Encode(rec.Tag, rec.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordTag returns the record tag for a struct field.
+func (m *marshaler) recordTag(fi *fieldInfo) (int, error) {
+	if !m.selfDescribing {
+		return fi.tag, nil
+	} else if fi.tag < 0 || fi.tag > MaxTagID {
+		return 0, fmt.Errorf("%w (%d not in 0..%d in self-describing mode)", ErrTagTooLarge, fi.tag, MaxTagID)
+	}
+	return MakeTag(uint(fi.tag), uint(fieldWireKind(fi))), nil
+}
+
+// fieldTag returns the field tag for a record tag.
+func (m *marshaler) fieldTag(tag int) int {
+	if m.selfDescribing && tag >= 0 {
+		id, _ := SplitTag(tag)
+		return int(id)
+	}
+	return tag
+}
+
+// fieldRecords encodes the records for a single struct field.
+func (m *marshaler) fieldRecords(fi *fieldInfo) ([]Record, error) {
+	tag, err := m.recordTag(fi)
+	if err != nil {
+		return nil, err
+	}
+	// The encoding of the field value, or of the elements of a slice.
+	marshal := m.marshalAny
+	if fi.nilable {
+		marshal = m.marshalOptional
+	} else if fi.width != 0 {
+		marshal = func(v interface{}) ([]byte, error) { return marshalFloat(v, fi.width) }
+	}
+	if !fi.seq {
+		data, err := marshal(fi.target.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return []Record{{Tag: tag, Value: data}}, nil
+	}
+
+	// Slice fields are flattened into the stream.
+	var vals [][]byte
+	switch fi.target.Kind() {
+	case reflect.Slice:
+		vals, err = m.packSlice(fi.target, marshal)
+	case reflect.Map:
+		vals, err = m.packMap(fi.target, fi.nilable)
+	default:
+		panic("invalid sequence type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]Record, len(vals))
+	for i, elt := range vals {
+		recs[i] = Record{Tag: tag, Value: elt}
+	}
+	return recs, nil
+}
+
 // checkStructType extracts a field map from a struct type.
 // Precondition: val is a reflect.Struct.
 func checkStructType(val reflect.Value, withPointer bool) ([]*fieldInfo, error) {
@@ -234,9 +642,33 @@ func checkStructType(val reflect.Value, withPointer bool) ([]*fieldInfo, error)
 			return nil, fmt.Errorf("invalid field %q tag %q", ftype.Name, tag)
 		}
 
+		fi.name = ftype.Name
 		field := val.Field(i)
 		kind := field.Kind()
-		fi.seq = kind == reflect.Slice || kind == reflect.Map
+		// Pointers to slices and maps are not sequences: They are packed
+		// into a single record, so that their presence is preserved.
+		fi.seq = (kind == reflect.Slice && field.Type() != bytesType) || kind == reflect.Map
+		if field.Type().Implements(binaryMarshalerType) {
+			fi.seq = false // the type provides its own encoding
+		}
+		if fi.nilable {
+			ptype := field.Type()
+			if fi.seq {
+				ptype = ptype.Elem()
+			}
+			if ptype.Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("field %q is nilable but does not hold pointers", ftype.Name)
+			}
+		}
+		if fi.width != 0 {
+			etype := field.Type()
+			if fi.seq && etype.Kind() == reflect.Slice {
+				etype = etype.Elem()
+			}
+			if k := etype.Kind(); fi.nilable || (k != reflect.Float32 && k != reflect.Float64) {
+				return nil, fmt.Errorf("field %q has a width but is not a float or slice of floats", ftype.Name)
+			}
+		}
 		if withPointer {
 			if !field.CanAddr() {
 				return nil, fmt.Errorf("field %q cannot be addressed", ftype.Name)
@@ -244,8 +676,9 @@ func checkStructType(val reflect.Value, withPointer bool) ([]*fieldInfo, error)
 				fi.target = field.Addr()
 			}
 
-		} else if field.IsZero() {
-			// The caller is encoding; skip zero values.
+		} else if field.IsZero() && !(fi.nilable && kind == reflect.Ptr) {
+			// The caller is encoding; skip zero values, other than nil
+			// pointers whose encoding is explicit.
 			continue
 
 		} else {
@@ -267,9 +700,22 @@ func checkStructType(val reflect.Value, withPointer bool) ([]*fieldInfo, error)
 	return info, nil
 }
 
+// bytesType is the type of a []byte, which is encoded as a single value
+// rather than as a sequence.
+var bytesType = reflect.TypeOf([]byte(nil))
+
 type fieldInfo struct {
 	tag int  // field tag
-	seq bool // value is a sequence (slice or map)
+	seq bool // value is a sequence (slice other than []byte, or map)
+
+	since, until int    // version range of the field; 0 means unbounded
+	deprecated   bool   // the field is marked deprecated
+	nilable      bool   // pointers use the optional encoding
+	width        int    // if nonzero, the encoded size of a float in bytes
+	name         string // the Go name of the field
+	seen         bool   // a record for the field was decoded (unmarshal)
+	bytewise     bool   // the field may have legacy per-byte records (unmarshal)
+	appending    bool   // legacy per-byte records are being appended (unmarshal)
 
 	// The field value, if withPointer=false (marshal).
 	// A pointer to the field value, if withPointer=true (unmarshal).
@@ -285,15 +731,51 @@ func parseTag(s string) (fieldInfo, bool) {
 				return fi, false
 			}
 			fi.tag = v
+		} else if strings.HasPrefix(arg, "since=") {
+			v, err := strconv.Atoi(arg[6:])
+			if err != nil || v < 0 {
+				return fi, false
+			}
+			fi.since = v
+		} else if strings.HasPrefix(arg, "until=") {
+			v, err := strconv.Atoi(arg[6:])
+			if err != nil || v < 0 {
+				return fi, false
+			}
+			fi.until = v
+		} else if arg == "deprecated" {
+			fi.deprecated = true
+		} else if arg == "nilable" {
+			fi.nilable = true
+		} else if strings.HasPrefix(arg, "width=") {
+			v, err := strconv.Atoi(arg[6:])
+			if err != nil || (v != 2 && v != 4 && v != 8) {
+				return fi, false
+			}
+			fi.width = v
+		} else if arg == "f16" {
+			fi.width = 2
 		}
 	}
+	if fi.until != 0 && fi.since > fi.until {
+		return fi, false
+	}
 	return fi, true
 }
 
+// inVersion reports whether the field applies in the given message version.
+// Version 0 denotes an unversioned message, to which all fields apply.
+func (fi *fieldInfo) inVersion(version int) bool {
+	if version == 0 {
+		return true
+	}
+	return fi.since <= version && (fi.until == 0 || version <= fi.until)
+}
+
 func encodedSize(data [][]byte) int {
 	var size int
 	for _, buf := range data {
-		size += lengthSize(buf) + len(buf)
+		size += wire.ValueSize(buf)
 	}
 	return size
 }
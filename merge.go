@@ -0,0 +1,130 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+)
+
+// MergeOptions control the behaviour of a MergeDecoder. A nil *MergeOptions
+// provides default values.
+type MergeOptions struct {
+	// If non-nil, records are ordered by the keys returned by this function,
+	// compared lexicographically as bytes. Otherwise, records are ordered by
+	// tag.
+	Key func(tag int, value []byte) []byte
+}
+
+// A MergeDecoder combines several record streams, each sorted in the same
+// order, into a single sorted stream. Records that compare equal are
+// returned in the order of their inputs.
+type MergeDecoder struct {
+	opts *MergeOptions
+	ins  []*Decoder
+	h    mergeHeap
+	err  error
+}
+
+// mergeItem is the next record from one input.
+type mergeItem struct {
+	in    int // index of the input
+	tag   int
+	value []byte
+	key   []byte
+}
+
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if c := compareItems(h[i], h[j]); c != 0 {
+		return c < 0
+	}
+	return h[i].in < h[j].in
+}
+
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeItem)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// compareItems compares the keys of a and b, or their tags if they have no
+// keys.
+func compareItems(a, b *mergeItem) int {
+	if a.key != nil || b.key != nil {
+		return bytes.Compare(a.key, b.key)
+	}
+	switch {
+	case a.tag < b.tag:
+		return -1
+	case a.tag > b.tag:
+		return 1
+	}
+	return 0
+}
+
+// NewMergeDecoder constructs a MergeDecoder that merges the records of ins.
+func NewMergeDecoder(opts *MergeOptions, ins ...*Decoder) *MergeDecoder {
+	m := &MergeDecoder{opts: opts, ins: ins}
+	for i := range ins {
+		if err := m.fill(i); err != nil {
+			m.err = err
+			break
+		}
+	}
+	heap.Init(&m.h)
+	return m
+}
+
+// fill reads the next record from input i, if any, and adds it to the heap.
+func (m *MergeDecoder) fill(i int) error {
+	tag, value, err := m.ins[i].Decode()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("input %d: %w", i, err)
+	}
+	item := &mergeItem{in: i, tag: tag, value: value}
+	if m.opts != nil && m.opts.Key != nil {
+		item.key = m.opts.Key(tag, value)
+		if item.key == nil {
+			item.key = []byte{}
+		}
+	}
+	m.h = append(m.h, item)
+	return nil
+}
+
+// Decode returns the least remaining record among all the inputs. At the end
+// of all the inputs it returns io.EOF. It reports an error if an input is
+// found not to be sorted.
+func (m *MergeDecoder) Decode() (int, []byte, error) {
+	if m.err != nil {
+		return 0, nil, m.err
+	} else if len(m.h) == 0 {
+		return 0, nil, io.EOF
+	}
+	top := heap.Pop(&m.h).(*mergeItem)
+	n := len(m.h)
+	if err := m.fill(top.in); err != nil {
+		m.err = err
+		return 0, nil, err
+	}
+	if len(m.h) > n {
+		if next := m.h[n]; compareItems(next, top) < 0 {
+			m.err = fmt.Errorf("input %d is not sorted at record %d", top.in, m.ins[top.in].Count())
+			return 0, nil, m.err
+		}
+		heap.Fix(&m.h, n)
+	}
+	return top.tag, top.value, nil
+}
@@ -0,0 +1,83 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeDecoder(t *testing.T) {
+	stream := func(recs ...binpack.Record) *binpack.Decoder {
+		e := binpack.NewEncoder(nil)
+		for _, rec := range recs {
+			e.Encode(rec.Tag, rec.Value)
+		}
+		return binpack.NewDecoder(bytes.NewReader(e.Data.Bytes()))
+	}
+	readAll := func(m *binpack.MergeDecoder) ([]binpack.Record, error) {
+		var out []binpack.Record
+		for {
+			tag, value, err := m.Decode()
+			if err == io.EOF {
+				return out, nil
+			} else if err != nil {
+				return out, err
+			}
+			out = append(out, binpack.Record{Tag: tag, Value: value})
+		}
+	}
+	rec := func(tag int, value string) binpack.Record {
+		return binpack.Record{Tag: tag, Value: []byte(value)}
+	}
+
+	t.Run("ByTag", func(t *testing.T) {
+		got, err := readAll(binpack.NewMergeDecoder(nil,
+			stream(rec(1, "a"), rec(4, "a"), rec(7, "a")),
+			stream(),
+			stream(rec(2, "c"), rec(4, "c"), rec(9, "c")),
+		))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		want := []binpack.Record{
+			rec(1, "a"), rec(2, "c"), rec(4, "a"), rec(4, "c"), rec(7, "a"), rec(9, "c"),
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Merged records (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ByKey", func(t *testing.T) {
+		opts := &binpack.MergeOptions{
+			Key: func(_ int, value []byte) []byte { return value },
+		}
+		got, err := readAll(binpack.NewMergeDecoder(opts,
+			stream(rec(5, "apple"), rec(1, "cherry")),
+			stream(rec(9, "banana"), rec(2, "date")),
+		))
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		want := []binpack.Record{
+			rec(5, "apple"), rec(9, "banana"), rec(1, "cherry"), rec(2, "date"),
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Merged records (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Unsorted", func(t *testing.T) {
+		_, err := readAll(binpack.NewMergeDecoder(nil,
+			stream(rec(1, "a"), rec(5, "a"), rec(3, "a")),
+			stream(rec(2, "b")),
+		))
+		if err == nil {
+			t.Error("Decode of unsorted input: got nil, want error")
+		}
+	})
+}
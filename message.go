@@ -0,0 +1,108 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A Message is a sequence of tag-value records, in encoding order.
+type Message []Record
+
+// Encode writes the records of m in order to e.
+func (m Message) Encode(e *Encoder) error {
+	for _, r := range m {
+		if err := e.Encode(r.Tag, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the records of m in order to w, and implements the
+// io.WriterTo interface.
+func (m Message) WriteTo(w io.Writer) (int64, error) {
+	e := NewStreamEncoder(w)
+	var n int64
+	for _, r := range m {
+		if err := e.Encode(r.Tag, r.Value); err != nil {
+			return n, err
+		}
+		n += int64(wire.EncodedSize(r.Tag, r.Value))
+	}
+	return n, nil
+}
+
+// ReadFrom reads records from r until EOF and appends them to m, and
+// implements the io.ReaderFrom interface. If an error occurs, records read
+// before the error are retained.
+func (m *Message) ReadFrom(r io.Reader) (int64, error) {
+	cr := wire.NewCountingReader(r)
+	d := NewDecoder(cr)
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			return cr.BytesRead(), nil
+		} else if err != nil {
+			return cr.BytesRead(), err
+		}
+		*m = append(*m, Record{Tag: tag, Value: value})
+	}
+}
+
+// MarshalBinary encodes the records of m in order, and implements the
+// encoding.BinaryMarshaler interface. This allows a Message to be used as a
+// field of a struct encoded by Marshal.
+func (m Message) MarshalBinary() ([]byte, error) {
+	e := NewEncoder(nil)
+	if err := m.Encode(e); err != nil {
+		return nil, err
+	}
+	return e.Data.Bytes(), nil
+}
+
+// UnmarshalBinary decodes data into a sequence of records, replacing the
+// contents of m, and implements the encoding.BinaryUnmarshaler interface.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	msg, err := ReadMessage(NewBytesDecoder(data))
+	if err != nil {
+		return err
+	}
+	*m = msg
+	return nil
+}
+
+// String renders m on a single line in the text format, for debugging.
+// See MarshalText for a description of the format.
+func (m Message) String() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, r := range m {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		sb.WriteString(strconv.Itoa(r.Tag))
+		sb.WriteString(": ")
+		sb.WriteString(textValue(r.Value))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// ReadMessage reads records from d until EOF and returns them as a Message.
+func ReadMessage(d *Decoder) (Message, error) {
+	var m Message
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+		m = append(m, Record{Tag: tag, Value: value})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+// A RecordEncoder writes tag-value records. An *Encoder is a RecordEncoder.
+type RecordEncoder interface {
+	Encode(tag int, value []byte) error
+}
+
+// A RecordDecoder reads tag-value records, reporting io.EOF at the end of
+// its input. A *Decoder is a RecordDecoder, as is a *ProgressReader.
+type RecordDecoder interface {
+	Decode() (int, []byte, error)
+}
+
+// EncodeFunc implements RecordEncoder by calling a function.
+type EncodeFunc func(tag int, value []byte) error
+
+// Encode implements the RecordEncoder interface.
+func (f EncodeFunc) Encode(tag int, value []byte) error { return f(tag, value) }
+
+// DecodeFunc implements RecordDecoder by calling a function.
+type DecodeFunc func() (int, []byte, error)
+
+// Decode implements the RecordDecoder interface.
+func (f DecodeFunc) Decode() (int, []byte, error) { return f() }
+
+// An EncoderMiddleware wraps the writing of records by a RecordEncoder, to
+// implement a concern such as metrics, encryption, or tag remapping
+// separately from the encoder itself.
+type EncoderMiddleware interface {
+	// WrapEncoder returns a RecordEncoder that writes records to next,
+	// possibly after observing or modifying them.
+	WrapEncoder(next RecordEncoder) RecordEncoder
+}
+
+// A DecoderMiddleware wraps the reading of records by a RecordDecoder, as an
+// EncoderMiddleware does for writing.
+type DecoderMiddleware interface {
+	// WrapDecoder returns a RecordDecoder that reads records from next,
+	// possibly after observing or modifying them.
+	WrapDecoder(next RecordDecoder) RecordDecoder
+}
+
+// EncoderMiddlewareFunc implements EncoderMiddleware by calling a function.
+type EncoderMiddlewareFunc func(next RecordEncoder) RecordEncoder
+
+// WrapEncoder implements the EncoderMiddleware interface.
+func (f EncoderMiddlewareFunc) WrapEncoder(next RecordEncoder) RecordEncoder { return f(next) }
+
+// DecoderMiddlewareFunc implements DecoderMiddleware by calling a function.
+type DecoderMiddlewareFunc func(next RecordDecoder) RecordDecoder
+
+// WrapDecoder implements the DecoderMiddleware interface.
+func (f DecoderMiddlewareFunc) WrapDecoder(next RecordDecoder) RecordDecoder { return f(next) }
+
+// ChainEncoder returns a RecordEncoder that passes each record through the
+// given middleware in order, and then writes it to e. The first middleware
+// sees each record first, as the caller wrote it.
+func ChainEncoder(e RecordEncoder, mw ...EncoderMiddleware) RecordEncoder {
+	for i := len(mw) - 1; i >= 0; i-- {
+		e = mw[i].WrapEncoder(e)
+	}
+	return e
+}
+
+// ChainDecoder returns a RecordDecoder that reads each record from d, and
+// passes it through the given middleware in reverse order. The first
+// middleware sees each record last, as the caller will receive it, so that a
+// decoder chain can mirror the encoder chain that produced its input.
+func ChainDecoder(d RecordDecoder, mw ...DecoderMiddleware) RecordDecoder {
+	for i := len(mw) - 1; i >= 0; i-- {
+		d = mw[i].WrapDecoder(d)
+	}
+	return d
+}
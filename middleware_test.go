@@ -0,0 +1,115 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+// remapper is a middleware that renumbers tags when encoding, and reverses
+// the mapping when decoding.
+type remapper map[int]int
+
+func (r remapper) WrapEncoder(next binpack.RecordEncoder) binpack.RecordEncoder {
+	return binpack.EncodeFunc(func(tag int, value []byte) error {
+		if t, ok := r[tag]; ok {
+			tag = t
+		}
+		return next.Encode(tag, value)
+	})
+}
+
+func (r remapper) WrapDecoder(next binpack.RecordDecoder) binpack.RecordDecoder {
+	return binpack.DecodeFunc(func() (int, []byte, error) {
+		tag, value, err := next.Decode()
+		for from, to := range r {
+			if to == tag {
+				return from, value, err
+			}
+		}
+		return tag, value, err
+	})
+}
+
+// xorer is a toy "encryption" middleware that complements each value byte.
+var xorer = struct {
+	binpack.EncoderMiddlewareFunc
+	binpack.DecoderMiddlewareFunc
+}{
+	func(next binpack.RecordEncoder) binpack.RecordEncoder {
+		return binpack.EncodeFunc(func(tag int, value []byte) error {
+			return next.Encode(tag, flip(value))
+		})
+	},
+	func(next binpack.RecordDecoder) binpack.RecordDecoder {
+		return binpack.DecodeFunc(func() (int, []byte, error) {
+			tag, value, err := next.Decode()
+			return tag, flip(value), err
+		})
+	},
+}
+
+func flip(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = ^b
+	}
+	return out
+}
+
+func TestMiddleware(t *testing.T) {
+	var log []int // tags seen by the logging middleware, as written
+	logger := binpack.EncoderMiddlewareFunc(func(next binpack.RecordEncoder) binpack.RecordEncoder {
+		return binpack.EncodeFunc(func(tag int, value []byte) error {
+			log = append(log, tag)
+			return next.Encode(tag, value)
+		})
+	})
+	remap := remapper{1: 10, 2: 20}
+
+	enc := binpack.NewEncoder(nil)
+	w := binpack.ChainEncoder(enc, logger, remap, xorer)
+	w.Encode(1, []byte("apple"))
+	w.Encode(2, []byte("pear"))
+	w.Encode(3, []byte("plum"))
+
+	// The records in the output are remapped and complemented.
+	raw := binpack.NewBytesDecoder(enc.Data.Bytes())
+	if tag, value, err := raw.Decode(); err != nil || tag != 10 || !bytes.Equal(value, flip([]byte("apple"))) {
+		t.Errorf("Raw record: got %d, %q, %v; want 10, complemented apple", tag, value, err)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3}, log); diff != "" {
+		t.Errorf("Logged tags (-want, +got):\n%s", diff)
+	}
+
+	// A mirrored decoder chain recovers the original records.
+	r := binpack.ChainDecoder(binpack.NewBytesDecoder(enc.Data.Bytes()), remap, xorer)
+	var got binpack.Message
+	for {
+		tag, value, err := r.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		got = append(got, binpack.Record{Tag: tag, Value: value})
+	}
+	want := binpack.Message{
+		{Tag: 1, Value: []byte("apple")},
+		{Tag: 2, Value: []byte("pear")},
+		{Tag: 3, Value: []byte("plum")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Decoded records (-want, +got):\n%s", diff)
+	}
+
+	// With no middleware, the chain is the underlying encoder.
+	if got := binpack.ChainEncoder(enc); got != binpack.RecordEncoder(enc) {
+		t.Errorf("ChainEncoder(enc): got %v, want enc", got)
+	}
+}
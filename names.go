@@ -0,0 +1,93 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var tagNames = struct {
+	sync.RWMutex
+	scopes map[interface{}]map[int]string
+}{scopes: make(map[interface{}]map[int]string)}
+
+// RegisterTagNames associates the given names with tags in a scope, for use
+// in diagnostic output such as HexDump listings and error messages. The scope
+// may be a string, or a value whose type identifies the scope (for example, a
+// pointer to a struct type). Registering names for a scope replaces any names
+// previously registered for it.
+//
+// Struct types do not need to be registered: If no names are registered for
+// a struct type, the names of its tagged fields are used.
+func RegisterTagNames(scope interface{}, names map[int]string) {
+	cp := make(map[int]string, len(names))
+	for tag, name := range names {
+		cp[tag] = name
+	}
+	tagNames.Lock()
+	defer tagNames.Unlock()
+	tagNames.scopes[scopeKey(scope)] = cp
+}
+
+// LookupTagName reports the name associated with tag in the given scope, and
+// whether a name was found. See RegisterTagNames for the meaning of scope.
+func LookupTagName(scope interface{}, tag int) (string, bool) {
+	key := scopeKey(scope)
+	tagNames.RLock()
+	names, ok := tagNames.scopes[key]
+	tagNames.RUnlock()
+	if ok {
+		name, ok := names[tag]
+		return name, ok
+	}
+	if typ, ok := key.(reflect.Type); ok && typ.Kind() == reflect.Struct {
+		return structFieldName(typ, tag)
+	}
+	return "", false
+}
+
+// scopeKey returns the registry key for the given scope.
+func scopeKey(scope interface{}) interface{} {
+	switch t := scope.(type) {
+	case string:
+		return t
+	case reflect.Type:
+		return derefType(t)
+	case nil:
+		return nil
+	}
+	return derefType(reflect.TypeOf(scope))
+}
+
+func derefType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// structFieldName reports the name of the field of the struct type typ with
+// the given tag, if there is one.
+func structFieldName(typ reflect.Type, tag int) (string, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		ft := typ.Field(i)
+		s, ok := ft.Tag.Lookup("binpack")
+		if !ok {
+			continue
+		}
+		if fi, ok := parseTag(s); ok && fi.tag == tag {
+			return ft.Name, true
+		}
+	}
+	return "", false
+}
+
+// describeTag returns a human-readable description of tag in scope.
+func describeTag(scope interface{}, tag int) string {
+	if name, ok := LookupTagName(scope, tag); ok {
+		return fmt.Sprintf("%s (tag %d)", name, tag)
+	}
+	return fmt.Sprintf("tag %d", tag)
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// PageTag is the tag of the record that carries continuation metadata in
+// each message produced by MarshalPages. It is the largest valid tag, and
+// should not be used by application messages that are to be paginated.
+const PageTag = wire.PageTag
+
+// MarshalPages marshals v as by Marshal, and splits the encoding into one or
+// more messages none of which exceeds limit bytes. Each message begins with a
+// record having tag PageTag that records its position in the sequence, which
+// allows the pages to be reassembled by UnmarshalPages in any order.
+//
+// Records are not split across pages, so repeated (slice and map) fields of
+// v are spread across pages by element. MarshalPages reports an error if any
+// single record, together with the page metadata, does not fit in limit.
+func MarshalPages(v interface{}, limit int) ([][]byte, error) {
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := Split(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group records into pages, reserving room for the metadata.
+	const metaSize = 4 + 1 + 2*(1+8) // tag + length + two packed integers
+	if limit <= metaSize {
+		return nil, fmt.Errorf("page limit %d is too small", limit)
+	}
+	var bodies [][]byte
+	e := NewEncoder(nil)
+	e.SetBudget(limit-metaSize, func(msg []byte) error {
+		bodies = append(bodies, copyOf(msg))
+		return nil
+	})
+	for _, rec := range recs {
+		if err := e.WriteRecords(rec); err != nil {
+			return nil, fmt.Errorf("record of %d bytes does not fit in page limit %d", len(rec), limit)
+		}
+	}
+	bodies = append(bodies, e.Data.Bytes())
+
+	pages := make([][]byte, len(bodies))
+	for i, body := range bodies {
+		e := NewEncoder(nil)
+		e.Encode(PageTag, encodePageInfo(i, len(bodies)))
+		e.Data.Write(body)
+		pages[i] = e.Data.Bytes()
+	}
+	return pages, nil
+}
+
+// UnmarshalPages reassembles a sequence of pages produced by MarshalPages and
+// unmarshals the result into v, as by Unmarshal. The pages may be given in
+// any order, but all of them must be present exactly once.
+func UnmarshalPages(pages [][]byte, v interface{}) error {
+	type page struct {
+		index int
+		body  []byte
+	}
+	var ps []page
+	total := -1
+	for _, p := range pages {
+		index, count, body, err := parsePage(p)
+		if err != nil {
+			return err
+		} else if total >= 0 && count != total {
+			return fmt.Errorf("inconsistent page count (%d != %d)", count, total)
+		}
+		total = count
+		ps = append(ps, page{index: index, body: body})
+	}
+	if len(ps) != total {
+		return fmt.Errorf("have %d pages, want %d", len(ps), total)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].index < ps[j].index })
+	var buf bytes.Buffer
+	for i, p := range ps {
+		if p.index != i {
+			return fmt.Errorf("missing page %d", i)
+		}
+		buf.Write(p.body)
+	}
+	return Unmarshal(buf.Bytes(), v)
+}
+
+// encodePageInfo encodes the metadata for page index of count.
+func encodePageInfo(index, count int) []byte {
+	var buf bytes.Buffer
+	WriteValue(&buf, PackUint64(uint64(index)))
+	WriteValue(&buf, PackUint64(uint64(count)))
+	return buf.Bytes()
+}
+
+// parsePage parses the metadata of a page and returns its index, the total
+// number of pages, and the records following the metadata.
+func parsePage(data []byte) (index, count int, body []byte, _ error) {
+	buf := bytes.NewReader(data)
+	tag, err := ReadTag(buf)
+	if err != nil || tag != PageTag {
+		return 0, 0, nil, errors.New("page does not begin with page metadata")
+	}
+	meta, err := ReadValue(buf)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	mbuf := bytes.NewReader(meta)
+	ibits, err := ReadValue(mbuf)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid page index: %w", err)
+	}
+	cbits, err := ReadValue(mbuf)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid page count: %w", err)
+	} else if _, err := ReadValue(mbuf); err != io.EOF {
+		return 0, 0, nil, errors.New("extra data in page metadata")
+	}
+	index, count = int(UnpackUint64(ibits)), int(UnpackUint64(cbits))
+	if index >= count {
+		return 0, 0, nil, fmt.Errorf("page index %d out of range for %d pages", index, count)
+	}
+	return index, count, data[len(data)-buf.Len():], nil
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPages(t *testing.T) {
+	type item struct {
+		Key   string `binpack:"tag=1"`
+		Value int    `binpack:"tag=2"`
+	}
+	type batch struct {
+		Name  string   `binpack:"tag=1"`
+		Items []*item  `binpack:"tag=2"`
+		Notes []string `binpack:"tag=3"`
+	}
+	in := &batch{Name: "big batch"}
+	for i := 0; i < 100; i++ {
+		in.Items = append(in.Items, &item{Key: fmt.Sprintf("item-%d", i), Value: i * i})
+		in.Notes = append(in.Notes, fmt.Sprintf("note %d", i))
+	}
+
+	const limit = 128
+	pages, err := binpack.MarshalPages(in, limit)
+	if err != nil {
+		t.Fatalf("MarshalPages: unexpected error: %v", err)
+	}
+	if len(pages) < 2 {
+		t.Errorf("MarshalPages: got %d pages, want several", len(pages))
+	}
+	for i, p := range pages {
+		if len(p) > limit {
+			t.Errorf("Page %d: got %d bytes, want at most %d", i, len(p), limit)
+		}
+	}
+
+	// Reassemble the pages out of order.
+	for i, j := 0, len(pages)-1; i < j; i, j = i+1, j-1 {
+		pages[i], pages[j] = pages[j], pages[i]
+	}
+	out := new(batch)
+	if err := binpack.UnmarshalPages(pages, out); err != nil {
+		t.Fatalf("UnmarshalPages: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("UnmarshalPages (-want, +got):\n%s", diff)
+	}
+
+	// A missing page is reported.
+	if err := binpack.UnmarshalPages(pages[1:], new(batch)); err == nil {
+		t.Error("UnmarshalPages with a missing page: got nil, want error")
+	}
+
+	// A record that cannot fit on a page is reported.
+	if _, err := binpack.MarshalPages(in, 16); err == nil {
+		t.Error("MarshalPages with a tiny limit: got nil, want error")
+	}
+}
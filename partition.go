@@ -0,0 +1,105 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"io"
+)
+
+// A PartitionWriter routes records to one of several outputs, chosen by a
+// function of the record tag. Pre-encoded records are routed by their tags
+// alone, and are copied without decoding or re-encoding their values.
+type PartitionWriter struct {
+	outs      []*Encoder
+	partition func(tag int) int
+}
+
+// NewPartitionWriter constructs a PartitionWriter that writes each record to
+// outs[partition(tag)]. The partition function must return a value in the
+// range 0 ≤ p < len(outs).
+func NewPartitionWriter(outs []io.Writer, partition func(tag int) int) *PartitionWriter {
+	encs := make([]*Encoder, len(outs))
+	for i, w := range outs {
+		encs[i] = NewStreamEncoder(w)
+	}
+	return &PartitionWriter{outs: encs, partition: partition}
+}
+
+// output returns the encoder for the partition of tag.
+func (p *PartitionWriter) output(tag int) (*Encoder, error) {
+	i := p.partition(tag)
+	if i < 0 || i >= len(p.outs) {
+		return nil, fmt.Errorf("tag %d: partition %d out of range [0, %d)", tag, i, len(p.outs))
+	}
+	return p.outs[i], nil
+}
+
+// Encode writes a record with the given tag and value to its partition.
+func (p *PartitionWriter) Encode(tag int, value []byte) error {
+	e, err := p.output(tag)
+	if err != nil {
+		return err
+	}
+	return e.Encode(tag, value)
+}
+
+// WriteRecords writes each of the encoded records in data to its partition.
+// It reports an error without writing anything if data is not a well-formed
+// sequence of records, or if any record has no valid partition.
+func (p *PartitionWriter) WriteRecords(data []byte) error {
+	type route struct {
+		e   *Encoder
+		rec []byte
+	}
+	var routes []route
+	for len(data) != 0 {
+		tag, _, rest, err := nextRecord(data)
+		if err != nil {
+			return err
+		}
+		e, err := p.output(tag)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, route{e: e, rec: data[:len(data)-len(rest)]})
+		data = rest
+	}
+	for _, r := range routes {
+		if err := r.e.WriteRecords(r.rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A PartitionReader reads the records of several partitions, as written by
+// a PartitionWriter, as a single stream. The partitions are read in order,
+// so the records of each partition are in their original order, but records
+// from different partitions are not interleaved as they were written.
+type PartitionReader struct {
+	ins []*Decoder
+}
+
+// NewPartitionReader constructs a PartitionReader that reads the records of
+// each of ins in turn.
+func NewPartitionReader(ins ...io.Reader) *PartitionReader {
+	decs := make([]*Decoder, len(ins))
+	for i, r := range ins {
+		decs[i] = NewDecoder(r)
+	}
+	return &PartitionReader{ins: decs}
+}
+
+// Decode returns the next tag-value record. At the end of the last
+// partition, it returns io.EOF.
+func (p *PartitionReader) Decode() (int, []byte, error) {
+	for len(p.ins) != 0 {
+		tag, value, err := p.ins[0].Decode()
+		if err != io.EOF {
+			return tag, value, err
+		}
+		p.ins = p.ins[1:]
+	}
+	return 0, nil, io.EOF
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPartition(t *testing.T) {
+	var parts [3]bytes.Buffer
+	w := binpack.NewPartitionWriter(
+		[]io.Writer{&parts[0], &parts[1], &parts[2]},
+		func(tag int) int { return tag % 3 },
+	)
+
+	e := binpack.NewEncoder(nil)
+	for tag := 1; tag <= 9; tag++ {
+		e.Encode(tag, []byte{byte('a' + tag)})
+	}
+	if err := w.WriteRecords(e.Data.Bytes()); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+	if err := w.Encode(10, []byte("ten")); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// Each partition has the records for its tags.
+	for i := range parts {
+		recs, err := binpack.Split(parts[i].Bytes())
+		if err != nil {
+			t.Fatalf("Split partition %d: %v", i, err)
+		}
+		for _, rec := range recs {
+			tag, _, _ := binpack.NewDecoder(bytes.NewReader(rec)).Decode()
+			if tag%3 != i {
+				t.Errorf("Partition %d has record with tag %d", i, tag)
+			}
+		}
+	}
+
+	// The merged reader sees all the records, partition by partition.
+	r := binpack.NewPartitionReader(&parts[0], &parts[1], &parts[2])
+	var got []int
+	for {
+		tag, _, err := r.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		got = append(got, tag)
+	}
+	want := []int{3, 6, 9, 1, 4, 7, 10, 2, 5, 8}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Merged tags (-want, +got):\n%s", diff)
+	}
+
+	// A record with no valid partition is rejected, and nothing is written.
+	bad := binpack.NewPartitionWriter([]io.Writer{&parts[0]}, func(tag int) int { return tag - 1 })
+	before := parts[0].Len()
+	e = binpack.NewEncoder(nil)
+	e.Encode(1, []byte("ok"))
+	e.Encode(2, []byte("no partition"))
+	if err := bad.WriteRecords(e.Data.Bytes()); err == nil {
+		t.Error("WriteRecords: got nil, want error")
+	}
+	if parts[0].Len() != before {
+		t.Error("WriteRecords wrote records despite an error")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// GetPath returns the value of the record at the given path of tags in data.
+// The first tag selects a record of data, and each later tag selects a
+// record of the nested message in the value selected by the tag before it.
+// If several records at one level have the same tag, the first is used. The
+// result aliases data.
+//
+// GetPath reports ErrNotFound if no record matches the path.
+func GetPath(data []byte, path ...int) ([]byte, error) {
+	for i, tag := range path {
+		_, _, value, ok, err := findRecord(data, tag, 0)
+		if err != nil {
+			return nil, fmt.Errorf("path %v: %w", path[:i], err)
+		} else if !ok {
+			return nil, fmt.Errorf("path %v: %w", path[:i+1], ErrNotFound)
+		}
+		data = value
+	}
+	return data, nil
+}
+
+// SetPath returns a copy of data in which the value of the record at the
+// given path of tags is replaced by value. Records are selected as by
+// GetPath. If no record matches some tag of the path, a new record is added
+// at the end of the enclosing message, so SetPath creates nested messages
+// as needed. The path must not be empty.
+func SetPath(data, value []byte, path ...int) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return setPath(data, value, path, 0)
+}
+
+// setPath implements SetPath for path[i:], within data.
+func setPath(data, value []byte, path []int, i int) ([]byte, error) {
+	start, end, old, ok, err := findRecord(data, path[i], 0)
+	if err != nil {
+		return nil, fmt.Errorf("path %v: %w", path[:i], err)
+	} else if !ok {
+		start, end, old = len(data), len(data), nil
+	}
+	if i+1 < len(path) {
+		value, err = setPath(old, value, path, i+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) - (end - start) + len(value) + 8)
+	buf.Write(data[:start])
+	e := NewEncoder(&buf)
+	if err := e.Encode(path[i], value); err != nil {
+		return nil, fmt.Errorf("path %v: %w", path[:i+1], err)
+	}
+	buf.Write(data[end:])
+	return buf.Bytes(), nil
+}
+
+// FindRecord locates the record of data selected by tag and index, and
+// returns its start and end offsets in data and its value, which aliases
+// data. The index selects among the records with the given tag in order: 0 is
+// the first, and a negative index counts from the end, so -1 is the last.
+//
+// FindRecord reports ErrNotFound if there is no such record, or another error
+// if data is not a well-formed sequence of records.
+func FindRecord(data []byte, tag, index int) (start, end int, value []byte, err error) {
+	start, end, value, ok, err := findRecord(data, tag, index)
+	if err != nil {
+		return 0, 0, nil, err
+	} else if !ok {
+		return 0, 0, nil, ErrNotFound
+	}
+	return start, end, value, nil
+}
+
+// findRecord finds the record in data with the given tag and index, as
+// FindRecord, and reports whether it was found. It reports an error if data
+// is not a well-formed sequence of records.
+func findRecord(data []byte, tag, index int) (start, end int, value []byte, ok bool, err error) {
+	type match struct {
+		start, end int
+		value      []byte
+	}
+	var last []match // for a negative index, the most recent matches
+	pos, n := 0, 0
+	for {
+		t, v, rest, err := nextRecord(data[pos:])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, nil, false, err
+		}
+		next := len(data) - len(rest)
+		if t == tag {
+			if index < 0 {
+				last = append(last, match{pos, next, v})
+				if len(last) > -index {
+					last = last[1:]
+				}
+			} else if n == index {
+				start, end, value, ok = pos, next, v, true
+			}
+			n++
+		}
+		pos = next
+	}
+	if index < 0 && len(last) == -index {
+		return last[0].start, last[0].end, last[0].value, true, nil
+	}
+	return start, end, value, ok, nil
+}
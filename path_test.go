@@ -0,0 +1,98 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPath(t *testing.T) {
+	type leaf struct {
+		Name string `binpack:"tag=7"`
+	}
+	type mid struct {
+		Count int   `binpack:"tag=1"`
+		Leaf  *leaf `binpack:"tag=2"`
+	}
+	type root struct {
+		Title string   `binpack:"tag=1"`
+		Mids  []*mid   `binpack:"tag=5"`
+		Tail  []string `binpack:"tag=9"`
+	}
+	in := &root{
+		Title: "top",
+		Mids: []*mid{
+			{Count: 1, Leaf: &leaf{Name: "first"}},
+			{Count: 2, Leaf: &leaf{Name: "second"}},
+		},
+		Tail: []string{"end"},
+	}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Repeated tags select the first record.
+	if got, err := binpack.GetPath(bits, 5, 2, 7); err != nil {
+		t.Errorf("GetPath(5, 2, 7) failed: %v", err)
+	} else if string(got) != "first" {
+		t.Errorf("GetPath(5, 2, 7): got %q, want %q", got, "first")
+	}
+	if got, err := binpack.GetPath(bits, 5, 3); !errors.Is(err, binpack.ErrNotFound) {
+		t.Errorf("GetPath(5, 3): got (%q, %v), want %v", got, err, binpack.ErrNotFound)
+	}
+
+	// Replace an existing nested value, and create a new one.
+	out, err := binpack.SetPath(bits, []byte("FIRST"), 5, 2, 7)
+	if err != nil {
+		t.Fatalf("SetPath(5, 2, 7) failed: %v", err)
+	}
+	out, err = binpack.SetPath(out, []byte("new"), 4, 7)
+	if err != nil {
+		t.Fatalf("SetPath(4, 7) failed: %v", err)
+	}
+	got := new(root)
+	if err := binpack.Unmarshal(out, got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	in.Mids[0].Leaf.Name = "FIRST"
+	if diff := cmp.Diff(in, got); diff != "" {
+		t.Errorf("SetPath result (-want, +got):\n%s", diff)
+	}
+	if v, err := binpack.GetPath(out, 4, 7); err != nil || string(v) != "new" {
+		t.Errorf("GetPath(4, 7): got (%q, %v), want %q", v, err, "new")
+	}
+}
+
+func TestFindRecord(t *testing.T) {
+	data := []byte("\x01\x81a\x02\x81b\x01\x81c\x01\x81d")
+	tests := []struct {
+		tag, index int
+		start, end int
+		value      string
+	}{
+		{1, 0, 0, 3, "a"},
+		{1, 1, 6, 9, "c"},
+		{1, -1, 9, 12, "d"},
+		{1, -3, 0, 3, "a"},
+		{2, -1, 3, 6, "b"},
+	}
+	for _, test := range tests {
+		start, end, value, err := binpack.FindRecord(data, test.tag, test.index)
+		if err != nil {
+			t.Errorf("FindRecord(%d, %d) failed: %v", test.tag, test.index, err)
+		} else if start != test.start || end != test.end || string(value) != test.value {
+			t.Errorf("FindRecord(%d, %d): got (%d, %d, %q), want (%d, %d, %q)",
+				test.tag, test.index, start, end, value, test.start, test.end, test.value)
+		}
+	}
+	for _, index := range []int{3, -4} {
+		if _, _, _, err := binpack.FindRecord(data, 1, index); !errors.Is(err, binpack.ErrNotFound) {
+			t.Errorf("FindRecord(1, %d): got %v, want %v", index, err, binpack.ErrNotFound)
+		}
+	}
+}
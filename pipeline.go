@@ -0,0 +1,240 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"io"
+	"sync"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A Filter is an io.Writer that accepts a stream of encoded tag-value records
+// and copies those records whose tags are selected by its keep function to an
+// Encoder. Records may be split across calls to Write; a partial record is
+// buffered until the rest of it arrives.
+type Filter struct {
+	dst  *Encoder
+	keep func(tag int) bool
+	buf  []byte // a partial record awaiting more input
+}
+
+// NewFilter constructs a Filter that writes to dst each record for which keep
+// reports true.
+func NewFilter(dst *Encoder, keep func(tag int) bool) *Filter {
+	return &Filter{dst: dst, keep: keep}
+}
+
+// Write implements the io.Writer interface. It reports an error if the dst
+// encoder fails.
+func (f *Filter) Write(data []byte) (int, error) {
+	f.buf = append(f.buf, data...)
+	for {
+		n, ok := wire.RecordSize(f.buf)
+		if !ok {
+			break
+		}
+		if err := f.filter(f.buf[:n]); err != nil {
+			return 0, err
+		}
+		f.buf = f.buf[n:]
+	}
+	if len(f.buf) == 0 {
+		f.buf = nil
+	}
+	return len(data), nil
+}
+
+// Close reports an error if the filter holds an incomplete record, and
+// otherwise returns nil. It does not close the dst encoder.
+func (f *Filter) Close() error {
+	if len(f.buf) != 0 {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// filter decodes the complete record in rec and passes it to the output if
+// it is selected.
+func (f *Filter) filter(rec []byte) error {
+	r, err := DecodeRecord(rec)
+	if err != nil {
+		return err
+	} else if f.keep(r.Tag) {
+		return f.dst.Encode(r.Tag, r.Value)
+	}
+	return nil
+}
+
+// Transform reads records from src until EOF, applies fn to each tag and
+// value, and writes the resulting record to dst if fn reports true.  A record
+// for which fn reports false is dropped.
+func Transform(dst *Encoder, src *Decoder, fn func(tag int, value []byte) (int, []byte, bool)) error {
+	for {
+		tag, value, err := src.Decode()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if tag, value, ok := fn(tag, value); ok {
+			if err := dst.Encode(tag, value); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ConsumeOptions control the behaviour of the Consume function.
+// A nil *ConsumeOptions provides default values.
+type ConsumeOptions struct {
+	// The number of worker goroutines to run concurrently.
+	// If Workers ≤ 0, one worker is used.
+	Workers int
+
+	// If true, results are delivered in the order the corresponding records
+	// were read from the input. Otherwise, results are delivered in the order
+	// the workers complete them.
+	Ordered bool
+}
+
+func (o *ConsumeOptions) workers() int {
+	if o == nil || o.Workers <= 0 {
+		return 1
+	}
+	return o.Workers
+}
+
+func (o *ConsumeOptions) ordered() bool { return o != nil && o.Ordered }
+
+// Consume reads records from d until EOF and fans them out to concurrent
+// worker goroutines, each of which calls work with the tag and value of a
+// record. The results of work are passed to deliver, which is called from a
+// single goroutine at a time. If deliver == nil, results are discarded.
+//
+// At most Workers records are in flight at once, counting records whose
+// results are waiting to be delivered in order, so a slow record stalls the
+// reader rather than letting buffered results grow without bound.
+//
+// If d, work, or deliver reports an error, Consume stops reading, waits for
+// the workers to exit, and returns the first such error. No further records
+// are read and no further calls to work begin after a work or deliver error.
+// Otherwise Consume returns nil after all records have been delivered.
+func Consume(d *Decoder, opts *ConsumeOptions, work func(tag int, value []byte) (interface{}, error), deliver func(interface{}) error) error {
+	type job struct {
+		seq   int
+		tag   int
+		value []byte
+	}
+	type result struct {
+		seq   int
+		value interface{}
+	}
+	nw := opts.workers()
+	done := make(chan struct{})
+	jobs := make(chan job, nw)
+	results := make(chan result, nw)
+	slots := make(chan struct{}, nw) // one per record in flight
+
+	// The first error from work or deliver is recorded in err, and closes
+	// done to stop the other goroutines.
+	var err error
+	var once sync.Once
+	fail := func(e error) { once.Do(func() { err = e; close(done) }) }
+	stopped := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Reader: Decode records from the input and dispatch them to workers.
+	var readErr error
+	go func() {
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			if stopped() {
+				return // the slot may have been freed by a failure
+			}
+			tag, value, err := d.Decode()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				readErr = err
+				return
+			}
+			// This does not block, since at most nw records are in flight.
+			jobs <- job{seq: seq, tag: tag, value: value}
+		}
+	}()
+
+	// Workers: Process records and send back the results.
+	var wg sync.WaitGroup
+	for i := 0; i < nw; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if stopped() {
+					return
+				}
+				v, e := work(j.tag, j.value)
+				if e != nil {
+					fail(e)
+					return
+				}
+				select {
+				case results <- result{seq: j.seq, value: v}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() { wg.Wait(); close(results) }()
+
+	// Collect results and deliver them, releasing the slot of each record as
+	// it is delivered. After an error, keep draining until all the goroutines
+	// have exited.
+	send := func(v interface{}) {
+		if deliver != nil {
+			if e := deliver(v); e != nil {
+				fail(e)
+			}
+		}
+		<-slots
+	}
+	next := 0
+	pending := make(map[int]interface{})
+	for r := range results {
+		if stopped() {
+			continue
+		} else if !opts.ordered() {
+			send(r.value)
+			continue
+		}
+		pending[r.seq] = r.value
+		for !stopped() {
+			v, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			send(v)
+		}
+	}
+
+	// All the goroutines have exited, so err and readErr are safe to read.
+	if err == nil {
+		err = readErr
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestFilter(t *testing.T) {
+	src := binpack.NewEncoder(nil)
+	for i, s := range []string{"a", "bb", "ccc", "dddd", "eeeee"} {
+		src.Encode(i+1, []byte(s))
+	}
+	input := src.Data.Bytes()
+
+	dst := binpack.NewEncoder(nil)
+	f := binpack.NewFilter(dst, func(tag int) bool { return tag%2 == 1 })
+
+	// Write the input a byte at a time to exercise buffering.
+	for i := range input {
+		if _, err := f.Write(input[i : i+1]); err != nil {
+			t.Fatalf("Write: unexpected error: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if got, want := dst.Data.String(), "\x01a\x03\x83ccc\x05\x85eeeee"; got != want {
+		t.Errorf("Filter output: got %q, want %q", got, want)
+	}
+
+	// Closing with a partial record reports an error.
+	f = binpack.NewFilter(dst, func(int) bool { return true })
+	f.Write([]byte("\x01\x85ab"))
+	if err := f.Close(); err == nil {
+		t.Error("Close with partial record: got nil error, want error")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := binpack.NewEncoder(nil)
+	src.Encode(1, []byte("keep"))
+	src.Encode(2, []byte("drop"))
+	src.Encode(3, []byte("shout"))
+
+	dst := binpack.NewEncoder(nil)
+	if err := binpack.Transform(dst, binpack.NewDecoder(bytes.NewReader(src.Data.Bytes())),
+		func(tag int, value []byte) (int, []byte, bool) {
+			switch tag {
+			case 2:
+				return 0, nil, false
+			case 3:
+				return 10, []byte(strings.ToUpper(string(value))), true
+			}
+			return tag, value, true
+		}); err != nil {
+		t.Fatalf("Transform: unexpected error: %v", err)
+	}
+	if got, want := dst.Data.String(), "\x01\x84keep\x0a\x85SHOUT"; got != want {
+		t.Errorf("Transform output: got %q, want %q", got, want)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	const numRecords = 200
+
+	enc := binpack.NewEncoder(nil)
+	for i := 0; i < numRecords; i++ {
+		enc.Encode(i, binpack.PackUint64(uint64(i)))
+	}
+	input := enc.Data.Bytes()
+
+	square := func(tag int, value []byte) (interface{}, error) {
+		z := binpack.UnpackUint64(value)
+		return z * z, nil
+	}
+	for _, ordered := range []bool{false, true} {
+		var got []uint64
+		err := binpack.Consume(binpack.NewDecoder(bytes.NewReader(input)),
+			&binpack.ConsumeOptions{Workers: 8, Ordered: ordered},
+			square, func(v interface{}) error {
+				got = append(got, v.(uint64))
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Consume (ordered=%v): unexpected error: %v", ordered, err)
+		}
+		if len(got) != numRecords {
+			t.Fatalf("Consume (ordered=%v): got %d results, want %d", ordered, len(got), numRecords)
+		}
+		if ordered {
+			for i, v := range got {
+				if want := uint64(i * i); v != want {
+					t.Errorf("Result %d: got %d, want %d", i, v, want)
+				}
+			}
+		}
+	}
+
+	// An error from a worker stops processing and is reported.
+	errBad := errors.New("bad record")
+	err := binpack.Consume(binpack.NewDecoder(bytes.NewReader(input)),
+		&binpack.ConsumeOptions{Workers: 4},
+		func(tag int, value []byte) (interface{}, error) {
+			if tag == 17 {
+				return nil, errBad
+			}
+			return nil, nil
+		}, nil)
+	if err != errBad {
+		t.Errorf("Consume: got error %v, want %v", err, errBad)
+	}
+}
+
+func TestConsumeBounded(t *testing.T) {
+	const numRecords = 100
+	const numWorkers = 4
+
+	enc := binpack.NewEncoder(nil)
+	for i := 0; i < numRecords; i++ {
+		enc.Encode(i, nil)
+	}
+	input := enc.Data.Bytes()
+
+	// Record 0 is slow, so later results must wait for it to be delivered.
+	// Record i cannot be read until at most numWorkers records are in flight.
+	var delivered int64
+	err := binpack.Consume(binpack.NewDecoder(bytes.NewReader(input)),
+		&binpack.ConsumeOptions{Workers: numWorkers, Ordered: true},
+		func(tag int, _ []byte) (interface{}, error) {
+			if tag == 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if n := atomic.LoadInt64(&delivered); int64(tag) >= n+numWorkers {
+				return nil, fmt.Errorf("record %d read with %d delivered", tag, n)
+			}
+			return tag, nil
+		}, func(interface{}) error {
+			atomic.AddInt64(&delivered, 1)
+			return nil
+		})
+	if err != nil {
+		t.Errorf("Consume: unexpected error: %v", err)
+	}
+	if delivered != numRecords {
+		t.Errorf("Consume: delivered %d results, want %d", delivered, numRecords)
+	}
+}
+
+func TestConsumeStop(t *testing.T) {
+	enc := binpack.NewEncoder(nil)
+	for i := 0; i < 10; i++ {
+		enc.Encode(i, nil)
+	}
+	input := enc.Data.Bytes()
+	errBad := errors.New("bad")
+
+	// After an error from work or deliver, no further calls to work occur.
+	for _, failWork := range []bool{true, false} {
+		for _, ordered := range []bool{false, true} {
+			calls := 0
+			err := binpack.Consume(binpack.NewDecoder(bytes.NewReader(input)),
+				&binpack.ConsumeOptions{Workers: 1, Ordered: ordered},
+				func(int, []byte) (interface{}, error) {
+					calls++
+					if failWork {
+						return nil, errBad
+					}
+					return nil, nil
+				}, func(interface{}) error {
+					return errBad
+				})
+			if err != errBad {
+				t.Errorf("Consume (work=%v, ordered=%v): got error %v, want %v", failWork, ordered, err, errBad)
+			}
+			if calls != 1 {
+				t.Errorf("Consume (work=%v, ordered=%v): got %d calls to work, want 1", failWork, ordered, calls)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A Profile bundles the settings for encoding and decoding a stream of
+// messages, so that the producers and consumers of a stream can share a
+// single named configuration rather than copying option values. The
+// constructors and methods of a Profile apply its settings to the encoders,
+// decoders, and values they handle.
+//
+// The predefined profiles, such as ProfileStorageV1, should not be modified.
+// To customize one, copy it and modify the copy. The registry used by
+// ReadHeader holds its own copies of the predefined profiles, so changes to
+// these variables do not affect the decoding of stream headers.
+type Profile struct {
+	// Name identifies the profile, for example in diagnostics and stream
+	// headers (see WriteHeader).
+	Name string
+
+	// Version distinguishes revisions of the profile with the same name. A
+	// stream header records the version, and ReadHeader reports an error if
+	// it does not match the registered profile.
+	Version int
+
+	// The options used by the Marshal and Unmarshal methods.
+	MarshalOptions   MarshalOptions
+	UnmarshalOptions UnmarshalOptions
+
+	// If true, encoders track records for message trailers, which the
+	// producer writes with FinishWithTrailer at the end of each message, and
+	// decoders verify the trailers (see TrailerTag).
+	Trailers bool
+
+	// If true, decoders require the records of the stream to be in
+	// non-decreasing order by tag (see Decoder.RequireTagOrder).
+	TagOrder bool
+
+	// If positive, decoders limit the time allowed to read each record, if
+	// the reader supports it (see Decoder.SetRecordTimeout).
+	RecordTimeout time.Duration
+}
+
+// Predefined profiles.
+var (
+	// ProfileCompact uses the default encoding with no framing, checksums,
+	// or self-description, and encodes maps deterministically.
+	ProfileCompact = Profile{
+		Name:           "compact",
+		Version:        1,
+		MarshalOptions: MarshalOptions{Deterministic: true},
+	}
+
+	// ProfileSelfDescribing records the wire kind of each value in its tag,
+	// so that messages can be decoded without their types (see
+	// DecodeGeneric).
+	ProfileSelfDescribing = Profile{
+		Name:             "self-describing",
+		Version:          1,
+		MarshalOptions:   MarshalOptions{Deterministic: true, SelfDescribing: true},
+		UnmarshalOptions: UnmarshalOptions{SelfDescribing: true},
+	}
+
+	// ProfileChecksummed ends each message with a trailer carrying its record
+	// count and checksum, which decoders verify.
+	ProfileChecksummed = Profile{
+		Name:           "checksummed",
+		Version:        1,
+		MarshalOptions: MarshalOptions{Deterministic: true},
+		Trailers:       true,
+	}
+
+	// ProfileStorageV1 is intended for data at rest. Messages have trailers,
+	// records must be in tag order, and decoding checks strings, integer
+	// ranges, and the order of fields, within an allocation limit of 64MiB
+	// per message.
+	ProfileStorageV1 = Profile{
+		Name:           "storage-v1",
+		Version:        1,
+		MarshalOptions: MarshalOptions{Deterministic: true},
+		UnmarshalOptions: UnmarshalOptions{
+			StrictOrder:  true,
+			ValidateUTF8: true,
+			CheckRange:   true,
+			MaxAlloc:     64 << 20,
+		},
+		Trailers: true,
+		TagOrder: true,
+	}
+)
+
+// Marshal encodes v as by the Marshal function, using the options of p.
+func (p *Profile) Marshal(v interface{}) ([]byte, error) { return p.MarshalOptions.Marshal(v) }
+
+// Unmarshal decodes data into v as by the Unmarshal function, using the
+// options of p.
+func (p *Profile) Unmarshal(data []byte, v interface{}) error {
+	return p.UnmarshalOptions.Unmarshal(data, v)
+}
+
+// NewEncoder constructs an Encoder that writes data to buf, as the NewEncoder
+// function, configured for p.
+func (p *Profile) NewEncoder(buf *bytes.Buffer) *Encoder { return p.setupEncoder(NewEncoder(buf)) }
+
+// NewStreamEncoder constructs an Encoder that writes data to w, as the
+// NewStreamEncoder function, configured for p.
+func (p *Profile) NewStreamEncoder(w io.Writer) *Encoder {
+	return p.setupEncoder(NewStreamEncoder(w))
+}
+
+// NewDecoder constructs a Decoder that reads records from r, as the
+// NewDecoder function, configured for p.
+func (p *Profile) NewDecoder(r io.Reader) *Decoder { return p.setupDecoder(NewDecoder(r)) }
+
+// NewBytesDecoder constructs a Decoder that reads records from data, as the
+// NewBytesDecoder function, configured for p.
+func (p *Profile) NewBytesDecoder(data []byte) *Decoder {
+	return p.setupDecoder(NewBytesDecoder(data))
+}
+
+func (p *Profile) setupEncoder(e *Encoder) *Encoder {
+	if p.Trailers {
+		e.EnableTrailer()
+	}
+	return e
+}
+
+func (p *Profile) setupDecoder(d *Decoder) *Decoder {
+	if p.Trailers {
+		d.VerifyTrailers()
+	}
+	if p.TagOrder {
+		d.RequireTagOrder()
+	}
+	if p.RecordTimeout > 0 {
+		d.SetRecordTimeout(p.RecordTimeout)
+	}
+	return d
+}
+
+// HeaderTag is the tag of a stream header record, which identifies the
+// profile of the stream (see WriteHeader).
+const HeaderTag = wire.HeaderTag
+
+// FormatVersion is the version of the stream header format written by
+// WriteHeader. ReadHeader rejects headers with a later format version.
+const FormatVersion = 1
+
+// Tags of the records in the value of a stream header.
+const (
+	headerFormat  = 1
+	headerName    = 2
+	headerVersion = 3
+)
+
+// ErrHeader is reported by ReadHeader when the stream header is missing or
+// invalid, or names a profile that is not registered or has a different
+// version.
+var ErrHeader = errors.New("invalid stream header")
+
+// profiles holds copies of the registered profiles, so that callers cannot
+// change a registered profile by modifying the value they registered.
+var profiles = struct {
+	sync.Mutex
+	m map[string]Profile
+}{m: map[string]Profile{
+	ProfileCompact.Name:        ProfileCompact,
+	ProfileSelfDescribing.Name: ProfileSelfDescribing,
+	ProfileChecksummed.Name:    ProfileChecksummed,
+	ProfileStorageV1.Name:      ProfileStorageV1,
+}}
+
+// RegisterProfile registers a copy of p by name, so that ReadHeader can
+// configure a decoder for streams that use it. It reports an error if the
+// name of p is empty or a profile with that name is already registered. The
+// predefined profiles are registered automatically.
+func RegisterProfile(p *Profile) error {
+	if p.Name == "" {
+		return errors.New("profile has no name")
+	}
+	profiles.Lock()
+	defer profiles.Unlock()
+	if _, ok := profiles.m[p.Name]; ok {
+		return fmt.Errorf("profile %q is already registered", p.Name)
+	}
+	profiles.m[p.Name] = *p
+	return nil
+}
+
+// LookupProfile returns a copy of the registered profile with the given name,
+// and reports whether it was found.
+func LookupProfile(name string) (*Profile, bool) {
+	profiles.Lock()
+	defer profiles.Unlock()
+	p, ok := profiles.m[name]
+	if !ok {
+		return nil, false
+	}
+	return &p, true
+}
+
+// WriteHeader writes a stream header record identifying p to w. The header
+// should be the first record of a stream, and written before any encoder
+// configured for p is constructed on w, so that the header is not covered by
+// a trailer. For example:
+//
+//	if err := p.WriteHeader(w); err != nil {
+//		return err
+//	}
+//	e := p.NewStreamEncoder(w)
+//
+// A consumer of the stream calls ReadHeader to configure its decoder.
+func (p *Profile) WriteHeader(w io.Writer) error {
+	buf, _ := wire.AppendRecord(nil, headerFormat, PackUint64(FormatVersion))
+	buf, _ = wire.AppendRecord(buf, headerName, []byte(p.Name))
+	buf, err := wire.AppendRecord(buf, headerVersion, PackUint64(uint64(p.Version)))
+	if err != nil {
+		return err
+	}
+	return NewStreamEncoder(w).Encode(HeaderTag, buf)
+}
+
+// ReadHeader reads a stream header record written by WriteHeader from d, and
+// configures d for the registered profile it names, a copy of which it
+// returns. The header must be the first record read from d, and d must not
+// already be configured for a profile. ReadHeader reports an error wrapping
+// ErrHeader if the header is missing or invalid, if it names an unregistered
+// profile, or if its version does not match the registered profile.
+func ReadHeader(d *Decoder) (*Profile, error) {
+	rec, err := d.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty stream", ErrHeader)
+	} else if err != nil {
+		return nil, err
+	} else if rec.Tag != HeaderTag {
+		return nil, fmt.Errorf("%w: first record has tag %d", ErrHeader, rec.Tag)
+	}
+
+	var format, version uint64
+	var name string
+	hd := NewBytesDecoder(rec.Value)
+	for {
+		rec, err := hd.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHeader, err)
+		}
+		switch rec.Tag {
+		case headerFormat:
+			format, err = rec.Uint64()
+		case headerName:
+			name = rec.Text()
+		case headerVersion:
+			version, err = rec.Uint64()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHeader, err)
+		}
+	}
+
+	if format == 0 || format > FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrHeader, format)
+	}
+	p, ok := LookupProfile(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown profile %q", ErrHeader, name)
+	} else if version != uint64(p.Version) {
+		return nil, fmt.Errorf("%w: profile %q has version %d, want %d", ErrHeader, name, version, p.Version)
+	}
+	p.setupDecoder(d)
+	return p, nil
+}
@@ -0,0 +1,184 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProfile(t *testing.T) {
+	type event struct {
+		ID   int    `binpack:"tag=1"`
+		Name string `binpack:"tag=2"`
+	}
+	p := &binpack.ProfileStorageV1
+	e := p.NewEncoder(nil)
+	for _, ev := range []event{{1, "start"}, {2, "stop"}} {
+		data, err := p.Marshal(ev)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if err := e.WriteRecords(data); err != nil {
+			t.Fatalf("WriteRecords failed: %v", err)
+		} else if err := e.FinishWithTrailer(); err != nil {
+			t.Fatalf("FinishWithTrailer failed: %v", err)
+		}
+	}
+	stream := e.Data.Bytes()
+
+	// The decoder verifies and consumes the trailers.
+	d := p.NewBytesDecoder(stream)
+	var got binpack.Message
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		got = append(got, binpack.Record{Tag: tag, Value: value})
+	}
+	want := binpack.Message{
+		{Tag: 1, Value: binpack.PackInt64(1)},
+		{Tag: 2, Value: []byte("start")},
+		{Tag: 1, Value: binpack.PackInt64(2)},
+		{Tag: 2, Value: []byte("stop")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Decoded records (-want, +got):\n%s", diff)
+	}
+
+	// A corrupted stream is detected.
+	bad := append([]byte(nil), stream...)
+	bad[bytes.Index(bad, []byte("start"))] ^= 0x20
+	d = p.NewBytesDecoder(bad)
+	var err error
+	for err == nil {
+		_, _, err = d.Decode()
+	}
+	if !errors.Is(err, binpack.ErrTrailer) {
+		t.Errorf("Decode of corrupted stream: got %v, want %v", err, binpack.ErrTrailer)
+	}
+
+	// Unmarshal applies the options of the profile.
+	reordered := new(binpack.Builder).Str(2, "x").Int(1, 3).Bytes()
+	if err := p.Unmarshal(reordered, new(event)); !errors.Is(err, binpack.ErrTagOrder) {
+		t.Errorf("Unmarshal reordered: got %v, want %v", err, binpack.ErrTagOrder)
+	}
+	if err := binpack.ProfileCompact.Unmarshal(reordered, new(event)); err != nil {
+		t.Errorf("Unmarshal with ProfileCompact: unexpected error: %v", err)
+	}
+
+	// A self-describing profile can be decoded generically.
+	sd := &binpack.ProfileSelfDescribing
+	data, err := sd.Marshal(event{ID: 5, Name: "x"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var generic interface{}
+	if err := sd.Unmarshal(data, &generic); err != nil {
+		t.Fatalf("Unmarshal generic failed: %v", err)
+	}
+	if m, ok := generic.(map[int]interface{}); !ok || m[2] != "x" {
+		t.Errorf("Unmarshal generic: got %#v", generic)
+	}
+}
+
+// testProfile is registered by TestStreamHeader.
+var testProfile = binpack.Profile{
+	Name:     "test-stream",
+	Version:  3,
+	Trailers: true,
+	TagOrder: true,
+}
+
+func TestStreamHeader(t *testing.T) {
+	custom := &testProfile
+	if _, ok := binpack.LookupProfile(custom.Name); !ok {
+		if err := binpack.RegisterProfile(custom); err != nil {
+			t.Fatalf("RegisterProfile failed: %v", err)
+		}
+	}
+	if err := binpack.RegisterProfile(custom); err == nil {
+		t.Error("RegisterProfile again: got nil error")
+	}
+
+	// The registry is not affected by changes to the profiles it returns or
+	// to the predefined profile variables.
+	if p, ok := binpack.LookupProfile(custom.Name); ok {
+		p.Version++
+	}
+	saved := binpack.ProfileStorageV1
+	binpack.ProfileStorageV1.Trailers = false
+	if p, ok := binpack.LookupProfile(saved.Name); !ok || !p.Trailers {
+		t.Errorf("LookupProfile(%q): got %+v, want trailers", saved.Name, p)
+	}
+	binpack.ProfileStorageV1 = saved
+	if p, ok := binpack.LookupProfile(custom.Name); !ok || p.Version != custom.Version {
+		t.Errorf("LookupProfile(%q): got %+v, want version %d", custom.Name, p, custom.Version)
+	}
+
+	var buf bytes.Buffer
+	if err := custom.WriteHeader(&buf); err != nil {
+		t.Fatalf("WriteHeader failed: %v", err)
+	}
+	e := custom.NewStreamEncoder(&buf)
+	e.EncodeString(1, "a")
+	e.EncodeString(2, "b")
+	if err := e.FinishWithTrailer(); err != nil {
+		t.Fatalf("FinishWithTrailer failed: %v", err)
+	}
+	stream := buf.Bytes()
+
+	d := binpack.NewDecoder(bytes.NewReader(stream))
+	p, err := binpack.ReadHeader(d)
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %v", err)
+	}
+	if diff := cmp.Diff(custom, p); diff != "" {
+		t.Errorf("ReadHeader profile (-want, +got):\n%s", diff)
+	}
+	var tags []int
+	for d.More() {
+		tag, _, err := d.Decode()
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	if _, _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode at end: got %v, want %v", err, io.EOF)
+	}
+	if diff := cmp.Diff([]int{1, 2}, tags); diff != "" {
+		t.Errorf("Tags (-want, +got):\n%s", diff)
+	}
+
+	// Mismatched and missing headers are reported.
+	older := *custom
+	older.Version = 2
+	unknown := *custom
+	unknown.Name = "no-such-profile"
+	for _, test := range []struct {
+		name  string
+		input func(*bytes.Buffer)
+	}{
+		{"Version", func(b *bytes.Buffer) { older.WriteHeader(b) }},
+		{"Unknown", func(b *bytes.Buffer) { unknown.WriteHeader(b) }},
+		{"Missing", func(b *bytes.Buffer) { binpack.NewEncoder(b).EncodeString(1, "a") }},
+		{"Empty", func(*bytes.Buffer) {}},
+	} {
+		var b bytes.Buffer
+		test.input(&b)
+		if _, err := binpack.ReadHeader(binpack.NewBytesDecoder(b.Bytes())); !errors.Is(err, binpack.ErrHeader) {
+			t.Errorf("ReadHeader %s: got %v, want %v", test.name, err, binpack.ErrHeader)
+		} else {
+			t.Logf("ReadHeader %s: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// Progress records the position of a ProgressReader in its input.
+type Progress struct {
+	Offset  int64 // bytes consumed from the start of the input
+	Total   int64 // the total size of the input, or 0 if unknown
+	Records int   // records read from the start of the input
+}
+
+// Fraction returns the fraction of the input consumed, between 0 and 1. It
+// returns 0 if the total size of the input is unknown.
+func (p Progress) Fraction() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Offset) / float64(p.Total)
+}
+
+func (p Progress) String() string {
+	if p.Total <= 0 {
+		return fmt.Sprintf("%d bytes, %d records", p.Offset, p.Records)
+	}
+	return fmt.Sprintf("%d/%d bytes (%.1f%%), %d records",
+		p.Offset, p.Total, 100*p.Fraction(), p.Records)
+}
+
+// A ProgressReader reads records from a stream, and keeps track of its
+// progress through the input. A job that reads a large input can save the
+// progress reported after a record, and later use ResumeProgressReader to
+// continue reading from the following record.
+type ProgressReader struct {
+	dec  *Decoder
+	cr   *wire.CountingReader
+	base int64 // the offset at which cr began reading
+	pos  Progress
+}
+
+// NewProgressReader constructs a ProgressReader that reads records from r.
+// If total > 0, it is reported as the total size of the input.
+func NewProgressReader(r io.Reader, total int64) *ProgressReader {
+	return newProgressReader(r, Progress{Total: total})
+}
+
+// ResumeProgressReader constructs a ProgressReader that reads records from r
+// starting after the position saved, which must have been reported by the
+// Progress method of a ProgressReader on the same input. The position of r
+// is set to saved.Offset.
+func ResumeProgressReader(r io.ReadSeeker, saved Progress) (*ProgressReader, error) {
+	if _, err := r.Seek(saved.Offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("resuming at offset %d: %w", saved.Offset, err)
+	}
+	return newProgressReader(r, saved), nil
+}
+
+func newProgressReader(r io.Reader, pos Progress) *ProgressReader {
+	cr := wire.NewCountingReader(r)
+	return &ProgressReader{dec: NewDecoder(cr), cr: cr, base: pos.Offset, pos: pos}
+}
+
+// Decode returns the next tag-value record from the input, as
+// Decoder.Decode. At the end of the input, it returns io.EOF.
+func (p *ProgressReader) Decode() (int, []byte, error) {
+	tag, value, err := p.dec.Decode()
+	if err != nil {
+		return tag, value, err
+	}
+	p.pos.Offset = p.base + p.cr.BytesRead()
+	p.pos.Records++
+	return tag, value, nil
+}
+
+// Progress reports the position of p after the last record read by Decode.
+func (p *ProgressReader) Progress() Progress { return p.pos }
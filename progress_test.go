@@ -0,0 +1,59 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestProgressReader(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	for i := 0; i < 100; i++ {
+		e.Encode(i+1, []byte(fmt.Sprintf("value %d", i)))
+	}
+	e.Encode(1000, make([]byte, 500)) // a longer record
+	data := e.Data.Bytes()
+
+	r := binpack.NewProgressReader(bytes.NewReader(data), int64(len(data)))
+	var saved binpack.Progress
+	for i := 0; i < 40; i++ {
+		if _, _, err := r.Decode(); err != nil {
+			t.Fatalf("Decode %d failed: %v", i, err)
+		}
+		if i == 24 {
+			saved = r.Progress()
+		}
+	}
+	if p := r.Progress(); p.Records != 40 {
+		t.Errorf("Records: got %d, want 40", p.Records)
+	}
+	t.Logf("Saved progress: %v", saved)
+
+	// Resume from the saved position, and read to the end.
+	r, err := binpack.ResumeProgressReader(bytes.NewReader(data), saved)
+	if err != nil {
+		t.Fatalf("ResumeProgressReader failed: %v", err)
+	}
+	tag, value, err := r.Decode()
+	if err != nil {
+		t.Fatalf("Decode after resume failed: %v", err)
+	} else if tag != 26 || string(value) != "value 25" {
+		t.Errorf("Decode after resume: got (%d, %q), want (26, %q)", tag, value, "value 25")
+	}
+	for {
+		if _, _, err := r.Decode(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+	}
+	p := r.Progress()
+	if p.Offset != int64(len(data)) || p.Records != 101 || p.Fraction() != 1 {
+		t.Errorf("Final progress: got %+v, want offset %d and 101 records", p, len(data))
+	}
+}
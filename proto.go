@@ -0,0 +1,347 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field numbers and enumerators from google/protobuf/descriptor.proto.
+const (
+	fileName        = 1  // FileDescriptorProto.name
+	filePackage     = 2  // FileDescriptorProto.package
+	fileMessageType = 4  // FileDescriptorProto.message_type
+	fileSyntax      = 12 // FileDescriptorProto.syntax
+
+	msgName  = 1 // DescriptorProto.name
+	msgField = 2 // DescriptorProto.field
+
+	fieldName     = 1 // FieldDescriptorProto.name
+	fieldNumber   = 3 // FieldDescriptorProto.number
+	fieldLabel    = 4 // FieldDescriptorProto.label
+	fieldType     = 5 // FieldDescriptorProto.type
+	fieldTypeName = 6 // FieldDescriptorProto.type_name
+
+	labelOptional = 1
+	labelRepeated = 3
+
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+
+	maxProtoField = 1<<29 - 1
+)
+
+// SchemaToDescriptor returns the wire encoding of a protobuf
+// FileDescriptorProto describing s as a message with the given name, in the
+// given protobuf package, so that code generators for other languages can
+// produce types with the same shape as the binpack message. Only the shapes
+// of the types are shared: the binpack wire format differs from the protobuf
+// wire format, so parsers generated by protobuf cannot decode binpack data.
+//
+// Since every binpack value is length-prefixed, each field is described as a
+// length-delimited protobuf field: string fields as type string, nested
+// messages as type message, and all other kinds as type bytes. The values of
+// numeric fields must be unpacked as described by PackUint64, PackInt64,
+// PackFloat32, and PackFloat64. Each nested schema is described by a
+// separate message, named by joining the name of the enclosing message and
+// the field name with "_"; it is an error if two nested schemas would get
+// the same name.
+//
+// Field tags must be valid protobuf field numbers: 1 to 2^29-1, excluding the
+// range 19000 to 19999 reserved by protobuf.
+func SchemaToDescriptor(s *Schema, pkg, name string) ([]byte, error) {
+	var file protoBuffer
+	fname, prefix := name+".proto", "."
+	if pkg != "" {
+		fname = strings.ReplaceAll(pkg, ".", "/") + "/" + fname
+		prefix = "." + pkg + "."
+	}
+	file.appendString(fileName, fname)
+	if pkg != "" {
+		file.appendString(filePackage, pkg)
+	}
+
+	names := map[*Schema]string{s: name}
+	used := map[string]bool{name: true}
+	queue := []*Schema{s}
+	for len(queue) != 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		var msg protoBuffer
+		msg.appendString(msgName, names[cur])
+		for _, f := range cur.Fields {
+			if f.Tag < 1 || f.Tag > maxProtoField || (f.Tag >= 19000 && f.Tag <= 19999) {
+				return nil, fmt.Errorf("field %q: tag %d is not a valid protobuf field number", f.Name, f.Tag)
+			}
+			var fd protoBuffer
+			fd.appendString(fieldName, f.Name)
+			fd.appendVarint(fieldNumber, uint64(f.Tag))
+			if f.Repeated {
+				fd.appendVarint(fieldLabel, labelRepeated)
+			} else {
+				fd.appendVarint(fieldLabel, labelOptional)
+			}
+			switch f.Kind {
+			case KindString:
+				fd.appendVarint(fieldType, typeString)
+			case KindMessage:
+				sub, ok := names[f.Message]
+				if !ok {
+					sub = names[cur] + "_" + f.Name
+					if used[sub] {
+						return nil, fmt.Errorf("field %q: message name %q is already in use", f.Name, sub)
+					}
+					names[f.Message] = sub
+					used[sub] = true
+					queue = append(queue, f.Message)
+				}
+				fd.appendVarint(fieldType, typeMessage)
+				fd.appendString(fieldTypeName, prefix+sub)
+			default:
+				fd.appendVarint(fieldType, typeBytes)
+			}
+			msg.appendBytes(msgField, fd)
+		}
+		file.appendBytes(fileMessageType, msg)
+	}
+	file.appendString(fileSyntax, "proto3")
+	return file, nil
+}
+
+// SchemaFromDescriptor constructs a Schema from the message with the given
+// name in the wire encoding of a protobuf FileDescriptorProto. The name may
+// be qualified by the package name. Nested message types must be defined in
+// the same file.
+//
+// This is the inverse of SchemaToDescriptor, except that fields of numeric
+// kinds are described there as bytes, and so are recovered as KindBytes.
+// Scalar protobuf types are mapped to the binpack kinds of the same meaning,
+// but note that their protobuf and binpack encodings differ. Enumerations
+// and groups are not supported.
+func SchemaFromDescriptor(data []byte, name string) (*Schema, error) {
+	var pkg string
+	msgs := make(map[string][]byte) // fully-qualified name → DescriptorProto
+	var order []string
+	err := parseProto(data, func(num int, v uint64, b []byte) error {
+		switch num {
+		case filePackage:
+			pkg = string(b)
+		case fileMessageType:
+			var mname string
+			if err := parseProto(b, func(num int, _ uint64, b []byte) error {
+				if num == msgName {
+					mname = string(b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			msgs[mname] = b
+			order = append(order, mname)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor: %w", err)
+	}
+	qualify := func(n string) string {
+		if pkg != "" && !strings.HasPrefix(n, pkg+".") {
+			return pkg + "." + n
+		}
+		return n
+	}
+	full := make(map[string][]byte)
+	for _, n := range order {
+		full[qualify(n)] = msgs[n]
+	}
+
+	schemas := make(map[string]*Schema)
+	var build func(string) (*Schema, error)
+	build = func(n string) (*Schema, error) {
+		if s, ok := schemas[n]; ok {
+			return s, nil
+		}
+		desc, ok := full[n]
+		if !ok {
+			return nil, fmt.Errorf("message %q: %w", n, ErrNotFound)
+		}
+		s := new(Schema)
+		schemas[n] = s
+		err := parseProto(desc, func(num int, _ uint64, b []byte) error {
+			if num != msgField {
+				return nil
+			}
+			f, typeName, err := parseFieldDescriptor(b)
+			if err != nil {
+				return err
+			}
+			if f.Kind == KindMessage {
+				sub, err := build(qualify(strings.TrimPrefix(typeName, ".")))
+				if err != nil {
+					return fmt.Errorf("field %q: %w", f.Name, err)
+				}
+				f.Message = sub
+			}
+			s.Fields = append(s.Fields, f)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("message %q: %w", n, err)
+		}
+		if err := s.check(); err != nil {
+			return nil, fmt.Errorf("message %q: %w", n, err)
+		}
+		return s, nil
+	}
+	return build(qualify(name))
+}
+
+// parseFieldDescriptor decodes a FieldDescriptorProto.
+func parseFieldDescriptor(data []byte) (*SchemaField, string, error) {
+	f := new(SchemaField)
+	var typ uint64
+	var typeName string
+	err := parseProto(data, func(num int, v uint64, b []byte) error {
+		switch num {
+		case fieldName:
+			f.Name = string(b)
+		case fieldNumber:
+			f.Tag = int(v)
+		case fieldLabel:
+			f.Repeated = v == labelRepeated
+		case fieldType:
+			typ = v
+		case fieldTypeName:
+			typeName = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	switch typ {
+	case typeString:
+		f.Kind = KindString
+	case typeBytes:
+		f.Kind = KindBytes
+	case typeMessage:
+		f.Kind = KindMessage
+	case typeBool:
+		f.Kind = KindBool
+	case typeUint32, typeUint64, typeFixed32, typeFixed64:
+		f.Kind = KindUint
+	case typeInt32, typeInt64, typeSint32, typeSint64, typeSfixed32, typeSfixed64:
+		f.Kind = KindInt
+	case typeFloat:
+		f.Kind = KindFloat32
+	case typeDouble:
+		f.Kind = KindFloat64
+	default:
+		return nil, "", fmt.Errorf("field %q: unsupported protobuf type %d", f.Name, typ)
+	}
+	return f, typeName, nil
+}
+
+// A protoBuffer accumulates fields in the protobuf wire encoding.
+type protoBuffer []byte
+
+func (p *protoBuffer) appendRawVarint(v uint64) {
+	for v >= 0x80 {
+		*p = append(*p, byte(v)|0x80)
+		v >>= 7
+	}
+	*p = append(*p, byte(v))
+}
+
+func (p *protoBuffer) appendVarint(num int, v uint64) {
+	p.appendRawVarint(uint64(num) << 3)
+	p.appendRawVarint(v)
+}
+
+func (p *protoBuffer) appendBytes(num int, b []byte) {
+	p.appendRawVarint(uint64(num)<<3 | 2)
+	p.appendRawVarint(uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *protoBuffer) appendString(num int, s string) { p.appendBytes(num, []byte(s)) }
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// parseProto calls f for each field of the protobuf message in data, with
+// the field number and either the value (for varint fields) or the contents
+// (for length-delimited fields). Fixed-width fields are skipped.
+func parseProto(data []byte, f func(num int, v uint64, b []byte) error) error {
+	for len(data) != 0 {
+		key, n := protoVarint(data)
+		if n == 0 {
+			return errBadProto
+		}
+		data = data[n:]
+		num := int(key >> 3)
+		switch key & 7 {
+		case 0: // varint
+			v, n := protoVarint(data)
+			if n == 0 {
+				return errBadProto
+			}
+			data = data[n:]
+			if err := f(num, v, nil); err != nil {
+				return err
+			}
+		case 1: // fixed64
+			if len(data) < 8 {
+				return errBadProto
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			size, n := protoVarint(data)
+			if n == 0 || uint64(len(data)-n) < size {
+				return errBadProto
+			}
+			b := data[n : n+int(size)]
+			data = data[n+int(size):]
+			if err := f(num, 0, b); err != nil {
+				return err
+			}
+		case 5: // fixed32
+			if len(data) < 4 {
+				return errBadProto
+			}
+			data = data[4:]
+		default:
+			return errBadProto
+		}
+	}
+	return nil
+}
+
+// protoVarint decodes a varint from the front of data, and returns its value
+// and length. It returns length 0 if data does not begin with a valid varint.
+func protoVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestSchemaDescriptor(t *testing.T) {
+	s, err := binpack.SchemaOf((*schemaThing)(nil))
+	if err != nil {
+		t.Fatalf("SchemaOf: unexpected error: %v", err)
+	}
+	desc, err := binpack.SchemaToDescriptor(s, "test.things", "Thing")
+	if err != nil {
+		t.Fatalf("SchemaToDescriptor: unexpected error: %v", err)
+	}
+
+	got, err := binpack.SchemaFromDescriptor(desc, "Thing")
+	if err != nil {
+		t.Fatalf("SchemaFromDescriptor: unexpected error: %v", err)
+	}
+	if len(got.Fields) != len(s.Fields) {
+		t.Fatalf("Got %d fields, want %d", len(got.Fields), len(s.Fields))
+	}
+	for i, f := range s.Fields {
+		g := got.Fields[i]
+
+		// Numeric kinds are described as bytes, so that is what comes back.
+		want := f.Kind
+		if want != binpack.KindString && want != binpack.KindMessage {
+			want = binpack.KindBytes
+		}
+		if g.Name != f.Name || g.Tag != f.Tag || g.Kind != want || g.Repeated != f.Repeated {
+			t.Errorf("Field %d: got %+v, want %+v with kind %v", i, g, f, want)
+		}
+	}
+
+	// The recursive field refers back to the top-level message.
+	if kids := got.FieldByName("Kids"); kids == nil || kids.Message != got {
+		t.Errorf("Kids field does not refer to the enclosing schema: %+v", kids)
+	}
+	if origin := got.FieldByName("Origin"); origin == nil || origin.Message == nil {
+		t.Errorf("Origin field has no message schema: %+v", origin)
+	} else if y := origin.Message.FieldByTag(2); y == nil || y.Name != "Y" {
+		t.Errorf("Origin schema is missing field Y: %+v", origin.Message)
+	}
+
+	// The package-qualified name works too, and unknown names are reported.
+	if _, err := binpack.SchemaFromDescriptor(desc, "test.things.Thing_Origin"); err != nil {
+		t.Errorf("SchemaFromDescriptor(qualified): unexpected error: %v", err)
+	}
+	if _, err := binpack.SchemaFromDescriptor(desc, "Nonesuch"); !errors.Is(err, binpack.ErrNotFound) {
+		t.Errorf("SchemaFromDescriptor(Nonesuch): got %v, want %v", err, binpack.ErrNotFound)
+	}
+	if _, err := binpack.SchemaFromDescriptor(desc[:len(desc)-3], "Thing"); err == nil {
+		t.Error("SchemaFromDescriptor(truncated): got nil, want error")
+	}
+
+	// Tags outside the protobuf field number range are rejected.
+	bad := &binpack.Schema{Fields: []*binpack.SchemaField{
+		{Name: "Big", Tag: 1 << 29, Kind: binpack.KindUint},
+	}}
+	if _, err := binpack.SchemaToDescriptor(bad, "", "Bad"); err == nil {
+		t.Error("SchemaToDescriptor(Bad): got nil, want error")
+	}
+
+	// Without a package, the file name has no directory.
+	empty := new(binpack.Schema)
+	if desc, err := binpack.SchemaToDescriptor(empty, "", "Only"); err != nil {
+		t.Errorf("SchemaToDescriptor(Only): unexpected error: %v", err)
+	} else if want := "\x0a\x0aOnly.proto"; !strings.HasPrefix(string(desc), want) {
+		t.Errorf("SchemaToDescriptor(Only): got %q, want prefix %q", desc, want)
+	}
+	if desc, err := binpack.SchemaToDescriptor(empty, "a.b", "Only"); err != nil {
+		t.Errorf("SchemaToDescriptor(a.b.Only): unexpected error: %v", err)
+	} else if want := "\x0a\x0ea/b/Only.proto"; !strings.HasPrefix(string(desc), want) {
+		t.Errorf("SchemaToDescriptor(a.b.Only): got %q, want prefix %q", desc, want)
+	}
+
+	// Distinct nested schemas whose joined names collide are rejected.
+	leaf := func() *binpack.Schema {
+		return &binpack.Schema{Fields: []*binpack.SchemaField{
+			{Name: "v", Tag: 1, Kind: binpack.KindString},
+		}}
+	}
+	clash := &binpack.Schema{Fields: []*binpack.SchemaField{
+		{Name: "b_c", Tag: 1, Kind: binpack.KindMessage, Message: leaf()},
+		{Name: "b", Tag: 2, Kind: binpack.KindMessage, Message: &binpack.Schema{
+			Fields: []*binpack.SchemaField{
+				{Name: "c", Tag: 1, Kind: binpack.KindMessage, Message: leaf()},
+			},
+		}},
+	}}
+	if _, err := binpack.SchemaToDescriptor(clash, "", "A"); err == nil {
+		t.Error("SchemaToDescriptor(clash): got nil, want error")
+	}
+}
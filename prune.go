@@ -0,0 +1,75 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"io"
+	"time"
+)
+
+// PruneOptions control the behaviour of Prune.
+type PruneOptions struct {
+	// The tag of the timestamp field within the value of each record.
+	TimeTag int
+
+	// Records whose timestamps are before this time are dropped.
+	Cutoff time.Time
+
+	// If non-nil, only records with tags for which Select reports true are
+	// subject to pruning; all others are kept.
+	Select func(tag int) bool
+
+	// If non-nil, this function is used to decode timestamps. By default,
+	// timestamps are decoded by time.Time.UnmarshalBinary, which matches the
+	// encoding of time.Time fields by Marshal.
+	ParseTime func(data []byte) (time.Time, error)
+}
+
+// Prune copies records from src to dst until src is exhausted, dropping each
+// record whose value is a nested message containing a timestamp field (with
+// tag o.TimeTag) that is before o.Cutoff. Only the records of each value are
+// scanned; the values are not otherwise decoded, and the records that are
+// kept are copied unmodified.
+//
+// A record whose value does not parse as a message, or that has no valid
+// timestamp field, is kept.
+func (o PruneOptions) Prune(dst *Encoder, src *Decoder) error {
+	return Transform(dst, src, func(tag int, value []byte) (int, []byte, bool) {
+		if o.Select != nil && !o.Select(tag) {
+			return tag, value, true
+		}
+		ts, ok := findTag(value, o.TimeTag)
+		if !ok {
+			return tag, value, true
+		}
+		when, err := o.parseTime(ts)
+		return tag, value, err != nil || !when.Before(o.Cutoff)
+	})
+}
+
+func (o PruneOptions) parseTime(data []byte) (time.Time, error) {
+	if o.ParseTime != nil {
+		return o.ParseTime(data)
+	}
+	var t time.Time
+	err := t.UnmarshalBinary(data)
+	return t, err
+}
+
+// findTag returns the value of the first record in data with the given tag,
+// and reports whether one was found. It reports false if data is not a
+// well-formed sequence of records.
+func findTag(data []byte, tag int) ([]byte, bool) {
+	var found []byte
+	ok := false
+	d := NewBytesDecoder(data)
+	for {
+		t, v, err := d.Decode()
+		if err != nil {
+			// Only report success if the whole value was well-formed.
+			return found, ok && err == io.EOF
+		} else if t == tag && !ok {
+			found, ok = v, true
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrune(t *testing.T) {
+	type entry struct {
+		Key  string    `binpack:"tag=1"`
+		When time.Time `binpack:"tag=2"`
+	}
+	base := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	src := binpack.NewEncoder(nil)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		data, err := binpack.Marshal(&entry{Key: key, When: base.Add(time.Duration(i) * time.Hour)})
+		if err != nil {
+			t.Fatalf("Marshal: unexpected error: %v", err)
+		}
+		src.Encode(10, data)
+	}
+	src.Encode(10, []byte("not a message"))
+	src.Encode(20, mustMarshal(t, &entry{Key: "old", When: base.Add(-time.Hour)}))
+
+	dst := binpack.NewEncoder(nil)
+	opts := binpack.PruneOptions{
+		TimeTag: 2,
+		Cutoff:  base.Add(90 * time.Minute),
+		Select:  func(tag int) bool { return tag == 10 },
+	}
+	if err := opts.Prune(dst, binpack.NewDecoder(bytes.NewReader(src.Data.Bytes()))); err != nil {
+		t.Fatalf("Prune: unexpected error: %v", err)
+	}
+
+	var got []string
+	d := binpack.NewDecoder(dst.Data)
+	for {
+		_, value, err := d.Decode()
+		if err != nil {
+			break
+		}
+		var e entry
+		if string(value) == "not a message" {
+			got = append(got, string(value))
+		} else if err := binpack.Unmarshal(value, &e); err != nil {
+			t.Fatalf("Unmarshal: unexpected error: %v", err)
+		} else {
+			got = append(got, e.Key)
+		}
+	}
+	if diff := cmp.Diff([]string{"c", "d", "not a message", "old"}, got); diff != "" {
+		t.Errorf("Prune (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+// Package reclog implements an append-only log of binpack records stored in
+// a file, suitable for use as a write-ahead log.
+package reclog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// A SyncPolicy determines when a Log flushes its file to stable storage.
+type SyncPolicy int
+
+// Constants defining the sync policies.
+const (
+	// SyncOnClose syncs the file only when the log is closed, or when Sync
+	// is called explicitly. This is the default.
+	SyncOnClose SyncPolicy = iota
+
+	// SyncEveryRecord syncs the file after each record is appended.
+	SyncEveryRecord
+
+	// SyncEveryBytes syncs the file after at least Options.SyncBytes bytes
+	// have been appended since the last sync.
+	SyncEveryBytes
+)
+
+var policyNames = [...]string{"SyncOnClose", "SyncEveryRecord", "SyncEveryBytes"}
+
+func (p SyncPolicy) String() string {
+	if p >= 0 && int(p) < len(policyNames) {
+		return policyNames[p]
+	}
+	return fmt.Sprintf("SyncPolicy(%d)", int(p))
+}
+
+// Options control the behaviour of a Log. A nil *Options provides default
+// values.
+type Options struct {
+	// When to sync the file to stable storage.
+	Sync SyncPolicy
+
+	// The number of bytes between syncs, for SyncEveryBytes.
+	SyncBytes int64
+}
+
+func (o *Options) policy() SyncPolicy {
+	if o == nil {
+		return SyncOnClose
+	}
+	return o.Sync
+}
+
+// A Log is an append-only sequence of records in a file. Each record is
+// written with a single write, but is only durable once the file has been
+// synced, as determined by the sync policy of the log.
+type Log struct {
+	f        *os.File
+	opts     *Options
+	buf      bytes.Buffer
+	unsynced int64 // bytes appended since the last sync
+}
+
+// Open opens or creates the log file at path. New records are appended to
+// the end of the existing contents.
+func Open(path string, opts *Options) (*Log, error) {
+	if p := opts.policy(); p < SyncOnClose || p > SyncEveryBytes {
+		return nil, fmt.Errorf("invalid sync policy %v", p)
+	} else if p == SyncEveryBytes && opts.SyncBytes <= 0 {
+		return nil, errors.New("SyncEveryBytes requires positive SyncBytes")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f, opts: opts}, nil
+}
+
+// Append adds a record with the given tag and value to the end of the log,
+// and syncs the file if required by the sync policy.
+func (l *Log) Append(tag int, value []byte) error {
+	l.buf.Reset()
+	if err := wire.NewEncoder(&l.buf).Encode(tag, value); err != nil {
+		return err
+	}
+	n, err := l.f.Write(l.buf.Bytes())
+	l.unsynced += int64(n)
+	if err != nil {
+		return err
+	}
+	switch l.opts.policy() {
+	case SyncEveryRecord:
+		return l.Sync()
+	case SyncEveryBytes:
+		if l.unsynced >= l.opts.SyncBytes {
+			return l.Sync()
+		}
+	}
+	return nil
+}
+
+// Sync flushes the contents of the log to stable storage.
+func (l *Log) Sync() error {
+	if l.unsynced == 0 {
+		return nil
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.unsynced = 0
+	return nil
+}
+
+// Close syncs and closes the log file.
+func (l *Log) Close() error {
+	serr := l.Sync()
+	cerr := l.f.Close()
+	if serr != nil {
+		return serr
+	}
+	return cerr
+}
+
+// Recovery reports the result of recovering a log.
+type Recovery struct {
+	Offset    int64 // the length of the log after recovery
+	Records   int   // the number of complete records in the log
+	Truncated int64 // the number of bytes discarded from a torn final record
+}
+
+// Recover scans the log from the beginning to find the end of the last
+// complete record. If the log ends with a torn record, for example because
+// the process crashed while it was being written, Recover truncates the file
+// to remove it and syncs the file. Call Recover after Open and before
+// appending new records.
+//
+// Recover reports an error without modifying the file if the log contains a
+// malformed record before its end.
+func (l *Log) Recover() (Recovery, error) {
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return Recovery{}, err
+	}
+	cr := wire.NewCountingReader(l.f)
+	dec := wire.NewDecoder(cr)
+	var rec Recovery
+	for {
+		_, _, err := dec.Decode()
+		if err == io.EOF {
+			return rec, nil
+		} else if errors.Is(err, wire.ErrTruncated) {
+			break
+		} else if err != nil {
+			return rec, fmt.Errorf("record %d at offset %d: %w", rec.Records, rec.Offset, err)
+		}
+		rec.Offset = cr.BytesRead()
+		rec.Records++
+	}
+
+	// The log ends with a torn record; remove it.
+	fi, err := l.f.Stat()
+	if err != nil {
+		return rec, err
+	}
+	rec.Truncated = fi.Size() - rec.Offset
+	if err := l.f.Truncate(rec.Offset); err != nil {
+		return rec, err
+	}
+	l.unsynced += rec.Truncated
+	return rec, l.Sync()
+}
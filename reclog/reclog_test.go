@@ -0,0 +1,111 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package reclog_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/creachadair/binpack/reclog"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	want := binpack.NewEncoder(nil)
+	for _, opts := range []*reclog.Options{
+		nil,
+		{Sync: reclog.SyncEveryRecord},
+		{Sync: reclog.SyncEveryBytes, SyncBytes: 16},
+	} {
+		lg, err := reclog.Open(path, opts)
+		if err != nil {
+			t.Fatalf("Open %+v: %v", opts, err)
+		}
+		for i, s := range []string{"alpha", "bravo", "charlie"} {
+			if err := lg.Append(i+1, []byte(s)); err != nil {
+				t.Fatalf("Append %q: %v", s, err)
+			}
+			want.Encode(i+1, []byte(s))
+		}
+		if err := lg.Sync(); err != nil {
+			t.Errorf("Sync: %v", err)
+		}
+		if err := lg.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if diff := cmp.Diff(want.Data.Bytes(), got); diff != "" {
+		t.Errorf("Log contents (-want, +got):\n%s", diff)
+	}
+
+	if _, err := reclog.Open(path, &reclog.Options{Sync: reclog.SyncEveryBytes}); err == nil {
+		t.Error("Open with SyncEveryBytes and no SyncBytes: got nil, want error")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	lg, err := reclog.Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i, s := range []string{"alpha", "bravo", "charlie", "delta"} {
+		if err := lg.Append(i+1, []byte(s)); err != nil {
+			t.Fatalf("Append %q: %v", s, err)
+		}
+	}
+	lg.Close()
+
+	// Simulate a crash partway through writing the last record.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	good := len(data) - 7 // tag, length, "delta"
+	if err := os.WriteFile(path, data[:len(data)-3], 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	lg, err = reclog.Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	rec, err := lg.Recover()
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	want := reclog.Recovery{Offset: int64(good), Records: 3, Truncated: 4}
+	if rec != want {
+		t.Errorf("Recover: got %+v, want %+v", rec, want)
+	}
+
+	// Recovering an intact log changes nothing, and new records are added
+	// after the recovered ones.
+	if rec, err := lg.Recover(); err != nil || rec.Truncated != 0 || rec.Records != 3 {
+		t.Errorf("Recover again: got %+v, %v; want 3 records and no truncation", rec, err)
+	}
+	if err := lg.Append(5, []byte("echo")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	lg.Close()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	recs, err := binpack.Split(got)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if len(recs) != 4 {
+		t.Errorf("Got %d records after recovery, want 4", len(recs))
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import "fmt"
+
+// Remap renumbers the tags of the records in data according to mapping, and
+// returns the resulting message. A record whose tag is a key of mapping gets
+// the corresponding value as its new tag. A record whose tag is not in
+// mapping is dropped if dropUnmapped is true, and otherwise kept with its
+// original tag. The values and the order of the records are preserved.
+//
+// Remap does not look inside values; use RemapOptions to renumber the tags
+// of nested messages as well.
+func Remap(data []byte, mapping map[int]int, dropUnmapped bool) ([]byte, error) {
+	return (&RemapOptions{Mapping: mapping, DropUnmapped: dropUnmapped}).Remap(data)
+}
+
+// RemapOptions describe how to renumber the tags of a message.
+// See the Remap function.
+type RemapOptions struct {
+	// Mapping maps original tags to new tags.
+	Mapping map[int]int
+
+	// If true, records whose tags are not in Mapping are dropped.
+	DropUnmapped bool
+
+	// Nested maps the original tags of records whose values are nested
+	// messages to the options for renumbering those messages. The values of
+	// other records are copied unmodified.
+	Nested map[int]*RemapOptions
+}
+
+// Remap renumbers the tags of the records in data according to o, and
+// returns the resulting message. It reports an error if data or a nested
+// message is not a well-formed sequence of records, or if a new tag is out
+// of range.
+func (o *RemapOptions) Remap(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	for pos := 0; len(data) != 0; {
+		tag, value, rest, err := nextRecord(data)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", pos, err)
+		}
+		pos += len(data) - len(rest)
+		data = rest
+
+		newTag, ok := o.Mapping[tag]
+		if !ok {
+			if o.DropUnmapped {
+				continue
+			}
+			newTag = tag
+		}
+		if sub, ok := o.Nested[tag]; ok && sub != nil {
+			value, err = sub.Remap(value)
+			if err != nil {
+				return nil, fmt.Errorf("tag %d: %w", tag, err)
+			}
+		}
+		out, err = AppendRecord(out, newTag, value)
+		if err != nil {
+			return nil, fmt.Errorf("tag %d: %w", tag, err)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemap(t *testing.T) {
+	encode := func(recs ...binpack.Record) []byte {
+		e := binpack.NewEncoder(nil)
+		if err := e.EncodeAll(recs); err != nil {
+			t.Fatalf("EncodeAll failed: %v", err)
+		}
+		return e.Data.Bytes()
+	}
+	inner := encode(
+		binpack.Record{Tag: 1, Value: []byte("x")},
+		binpack.Record{Tag: 2, Value: []byte("y")},
+	)
+	in := encode(
+		binpack.Record{Tag: 1, Value: []byte("one")},
+		binpack.Record{Tag: 2, Value: []byte("two")},
+		binpack.Record{Tag: 3, Value: inner},
+		binpack.Record{Tag: 4, Value: []byte("four")},
+		binpack.Record{Tag: 1, Value: []byte("uno")},
+	)
+	mapping := map[int]int{1: 100, 2: 1, 3: 20000}
+
+	tests := []struct {
+		name string
+		opts *binpack.RemapOptions
+		want []byte
+	}{
+		{"Keep", &binpack.RemapOptions{Mapping: mapping}, encode(
+			binpack.Record{Tag: 100, Value: []byte("one")},
+			binpack.Record{Tag: 1, Value: []byte("two")},
+			binpack.Record{Tag: 20000, Value: inner},
+			binpack.Record{Tag: 4, Value: []byte("four")},
+			binpack.Record{Tag: 100, Value: []byte("uno")},
+		)},
+		{"Drop", &binpack.RemapOptions{Mapping: mapping, DropUnmapped: true}, encode(
+			binpack.Record{Tag: 100, Value: []byte("one")},
+			binpack.Record{Tag: 1, Value: []byte("two")},
+			binpack.Record{Tag: 20000, Value: inner},
+			binpack.Record{Tag: 100, Value: []byte("uno")},
+		)},
+		{"Nested", &binpack.RemapOptions{
+			Mapping: map[int]int{4: 1},
+			Nested: map[int]*binpack.RemapOptions{
+				3: {Mapping: map[int]int{1: 2}, DropUnmapped: true},
+			},
+		}, encode(
+			binpack.Record{Tag: 1, Value: []byte("one")},
+			binpack.Record{Tag: 2, Value: []byte("two")},
+			binpack.Record{Tag: 3, Value: encode(binpack.Record{Tag: 2, Value: []byte("x")})},
+			binpack.Record{Tag: 1, Value: []byte("four")},
+			binpack.Record{Tag: 1, Value: []byte("uno")},
+		)},
+	}
+	for _, test := range tests {
+		got, err := test.opts.Remap(in)
+		if err != nil {
+			t.Errorf("%s: Remap failed: %v", test.name, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s: Remap (-want, +got):\n%s", test.name, diff)
+		}
+	}
+
+	// The function form matches the options without nesting.
+	got, err := binpack.Remap(in, mapping, true)
+	if err != nil {
+		t.Fatalf("Remap failed: %v", err)
+	}
+	if diff := cmp.Diff(tests[1].want, got); diff != "" {
+		t.Errorf("Remap (-want, +got):\n%s", diff)
+	}
+
+	if _, err := binpack.Remap(in[:len(in)-1], mapping, false); !errors.Is(err, binpack.ErrTruncated) {
+		t.Errorf("Remap truncated: got %v, want %v", err, binpack.ErrTruncated)
+	}
+	if _, err := binpack.Remap(in, map[int]int{1: -1}, false); !errors.Is(err, binpack.ErrTagTooLarge) {
+		t.Errorf("Remap to invalid tag: got %v, want %v", err, binpack.ErrTagTooLarge)
+	}
+}
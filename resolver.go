@@ -0,0 +1,103 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A SchemaResolver maps the schema identifiers of bus envelopes to schemas,
+// so that a consumer can decode payloads whose types it was not built with,
+// including those from newer producers. Implementations may consult a local
+// table or a remote registry, and must be safe for concurrent use.
+//
+// If there is no schema for id, ResolveSchema reports an error wrapping
+// ErrNotFound.
+type SchemaResolver interface {
+	ResolveSchema(ctx context.Context, id string) (*Schema, error)
+}
+
+// A SchemaMap is a SchemaResolver that maps identifiers to schemas from a
+// fixed table.
+type SchemaMap map[string]*Schema
+
+// ResolveSchema implements the SchemaResolver interface.
+func (m SchemaMap) ResolveSchema(_ context.Context, id string) (*Schema, error) {
+	if s, ok := m[id]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("schema %q: %w", id, ErrNotFound)
+}
+
+// A ResolverFunc is a SchemaResolver implemented by a function.
+type ResolverFunc func(ctx context.Context, id string) (*Schema, error)
+
+// ResolveSchema implements the SchemaResolver interface.
+func (f ResolverFunc) ResolveSchema(ctx context.Context, id string) (*Schema, error) {
+	return f(ctx, id)
+}
+
+// MultiResolver returns a SchemaResolver that consults each of rs in order,
+// and returns the first schema found. An error other than ErrNotFound stops
+// the search.
+func MultiResolver(rs ...SchemaResolver) SchemaResolver {
+	return ResolverFunc(func(ctx context.Context, id string) (*Schema, error) {
+		for _, r := range rs {
+			s, err := r.ResolveSchema(ctx, id)
+			if err == nil || !errors.Is(err, ErrNotFound) {
+				return s, err
+			}
+		}
+		return nil, fmt.Errorf("schema %q: %w", id, ErrNotFound)
+	})
+}
+
+// CachingResolver returns a SchemaResolver that remembers the schemas found
+// by r, so that each identifier is resolved by r at most once after it is
+// found. Since a schema identifier denotes a fixed schema, entries are never
+// evicted. Errors are not cached.
+func CachingResolver(r SchemaResolver) SchemaResolver {
+	return &schemaCache{r: r, m: make(map[string]*Schema)}
+}
+
+type schemaCache struct {
+	r SchemaResolver
+
+	mu sync.Mutex
+	m  map[string]*Schema
+}
+
+func (c *schemaCache) ResolveSchema(ctx context.Context, id string) (*Schema, error) {
+	c.mu.Lock()
+	s, ok := c.m[id]
+	c.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	s, err := c.r.ResolveSchema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[id] = s
+	return s, nil
+}
+
+// Decode resolves the schema of e by its SchemaID using r, and decodes the
+// payload according to that schema. Records of the payload whose tags are not
+// described by the schema are ignored.
+func (e *BusEnvelope) Decode(ctx context.Context, r SchemaResolver) (*DynamicMessage, error) {
+	s, err := r.ResolveSchema(ctx, e.SchemaID)
+	if err != nil {
+		return nil, err
+	}
+	m := NewDynamicMessage(s)
+	if err := m.UnmarshalBinary(e.Payload); err != nil {
+		return nil, fmt.Errorf("schema %q: %w", e.SchemaID, err)
+	}
+	return m, nil
+}
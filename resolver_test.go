@@ -0,0 +1,85 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/creachadair/binpack"
+)
+
+func TestSchemaResolver(t *testing.T) {
+	ctx := context.Background()
+
+	// A newer producer has added a field that the consumer's schema lacks.
+	type eventV2 struct {
+		Kind  string `binpack:"tag=1"`
+		ID    int    `binpack:"tag=2"`
+		Extra string `binpack:"tag=3"`
+	}
+	payload, err := binpack.Marshal(eventV2{Kind: "click", ID: 25, Extra: "new"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	data, err := binpack.PackEnvelope("events.v2", payload, nil)
+	if err != nil {
+		t.Fatalf("PackEnvelope failed: %v", err)
+	}
+	env, err := binpack.UnpackEnvelope(data)
+	if err != nil {
+		t.Fatalf("UnpackEnvelope failed: %v", err)
+	}
+
+	v2 := &binpack.Schema{Fields: []*binpack.SchemaField{
+		{Name: "Kind", Tag: 1, Kind: binpack.KindString},
+		{Name: "ID", Tag: 2, Kind: binpack.KindInt},
+	}}
+	var calls int
+	remote := binpack.ResolverFunc(func(_ context.Context, id string) (*binpack.Schema, error) {
+		calls++
+		if id == "events.v2" {
+			return v2, nil
+		}
+		return nil, binpack.ErrNotFound
+	})
+	r := binpack.MultiResolver(
+		binpack.SchemaMap{"events.v1": new(binpack.Schema)},
+		binpack.CachingResolver(remote),
+	)
+
+	for i := 0; i < 3; i++ {
+		m, err := env.Decode(ctx, r)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if v, err := m.GetByName("Kind"); err != nil || v != "click" {
+			t.Errorf("Kind: got (%v, %v), want click", v, err)
+		}
+		if v, err := m.GetByName("ID"); err != nil || v != int64(25) {
+			t.Errorf("ID: got (%v, %v), want 25", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Remote resolver called %d times, want 1", calls)
+	}
+
+	env.SchemaID = "events.v3"
+	if _, err := env.Decode(ctx, r); !errors.Is(err, binpack.ErrNotFound) {
+		t.Errorf("Decode with unknown schema: got %v, want %v", err, binpack.ErrNotFound)
+	}
+	if calls != 2 {
+		t.Errorf("Remote resolver called %d times, want 2", calls)
+	}
+
+	// An error other than ErrNotFound stops the search.
+	errDown := errors.New("registry unavailable")
+	r = binpack.MultiResolver(
+		binpack.ResolverFunc(func(context.Context, string) (*binpack.Schema, error) { return nil, errDown }),
+		binpack.SchemaMap{"events.v3": v2},
+	)
+	if _, err := env.Decode(ctx, r); err != errDown {
+		t.Errorf("Decode with failed resolver: got %v, want %v", err, errDown)
+	}
+}
@@ -0,0 +1,560 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// A Kind identifies the encoding of a field value described by a Schema.
+type Kind int
+
+// Constants defining the kinds of field values.
+const (
+	KindBytes   Kind = iota // raw bytes
+	KindString              // a string, as raw bytes
+	KindBool                // a boolean, as a single byte
+	KindUint                // an unsigned integer, as PackUint64
+	KindInt                 // a signed integer, as PackInt64
+	KindFloat32             // a floating-point value, as PackFloat32
+	KindFloat64             // a floating-point value, as PackFloat64
+	KindMessage             // a nested message described by a Schema
+)
+
+var kindNames = [...]string{
+	"bytes", "string", "bool", "uint", "int", "float32", "float64", "message",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// A Schema describes the fields of a message, so that it can be encoded and
+// decoded without a Go struct type. Fields are identified by name in the
+// dynamic representation and by tag in the encoding.
+type Schema struct {
+	Fields []*SchemaField
+}
+
+// A SchemaField describes a single field of a Schema.
+type SchemaField struct {
+	Name     string  // the field name
+	Tag      int     // the field tag
+	Kind     Kind    // the kind of the value, or of each element if Repeated
+	Repeated bool    // the field holds a sequence of values, encoded inline
+	Message  *Schema // the schema of a nested message (for KindMessage)
+
+	// For KindFloat32 and KindFloat64, if Width is nonzero, values are
+	// encoded in the IEEE 754 format of that size in bytes (2, 4, or 8), as
+	// for a struct field with a width option. See Marshal.
+	Width int
+
+	// If true, values use the optional encoding of a nilable struct field, in
+	// which nil is an empty value and any other value is the byte 0x01
+	// followed by its encoding. See Marshal.
+	Nilable bool
+
+	// If true, the field is deprecated, and decoding it calls the
+	// Deprecated hook of the UnmarshalOptions.
+	Deprecated bool
+}
+
+// SchemaOf constructs a Schema describing the struct type of v, which must be
+// a struct or a pointer to a struct. Fields are named by their Go field names.
+// Map-valued fields and fields of types that implement
+// encoding.BinaryMarshaler are not supported.
+func SchemaOf(v interface{}) (*Schema, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type %T is not a struct or pointer to struct", v)
+	}
+	return schemaOfType(typ, make(map[reflect.Type]*Schema))
+}
+
+var binaryMarshalerType = reflect.TypeOf((*interface{ MarshalBinary() ([]byte, error) })(nil)).Elem()
+
+// schemaOfType constructs a schema for the struct type typ. The seen map
+// records schemas already constructed, to allow recursive types.
+func schemaOfType(typ reflect.Type, seen map[reflect.Type]*Schema) (*Schema, error) {
+	if s, ok := seen[typ]; ok {
+		return s, nil
+	}
+	s := new(Schema)
+	seen[typ] = s
+	for i := 0; i < typ.NumField(); i++ {
+		ft := typ.Field(i)
+		tag, ok := ft.Tag.Lookup("binpack")
+		if !ok {
+			continue
+		}
+		fi, ok := parseTag(tag)
+		if !ok {
+			return nil, fmt.Errorf("invalid field %q tag %q", ft.Name, tag)
+		}
+		sf := &SchemaField{
+			Name:       ft.Name,
+			Tag:        fi.tag,
+			Width:      fi.width,
+			Nilable:    fi.nilable,
+			Deprecated: fi.deprecated,
+		}
+		etype := ft.Type
+		if etype.Kind() == reflect.Slice && etype != bytesType {
+			sf.Repeated = true
+			etype = etype.Elem()
+		}
+		if err := sf.setKind(etype, seen); err != nil {
+			return nil, fmt.Errorf("field %q: %w", ft.Name, err)
+		}
+		s.Fields = append(s.Fields, sf)
+	}
+	sort.Slice(s.Fields, func(i, j int) bool {
+		return s.Fields[i].Tag < s.Fields[j].Tag
+	})
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setKind sets the kind of f based on the element type typ.
+func (f *SchemaField) setKind(typ reflect.Type, seen map[reflect.Type]*Schema) error {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Implements(binaryMarshalerType) || reflect.PtrTo(typ).Implements(binaryMarshalerType) {
+		return fmt.Errorf("type %v implements encoding.BinaryMarshaler", typ)
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("nested sequence type %v is not supported", typ)
+		}
+		f.Kind = KindBytes
+	case reflect.String:
+		f.Kind = KindString
+	case reflect.Bool:
+		f.Kind = KindBool
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f.Kind = KindUint
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.Kind = KindInt
+	case reflect.Float32:
+		f.Kind = KindFloat32
+	case reflect.Float64:
+		f.Kind = KindFloat64
+	case reflect.Struct:
+		f.Kind = KindMessage
+		sub, err := schemaOfType(typ, seen)
+		if err != nil {
+			return err
+		}
+		f.Message = sub
+	default:
+		return fmt.Errorf("type %v is not supported", typ)
+	}
+	return nil
+}
+
+// check reports an error if s has duplicate field names or tags, or a field
+// whose settings are invalid.
+func (s *Schema) check() error {
+	names := make(map[string]bool)
+	tags := make(map[int]bool)
+	for _, f := range s.Fields {
+		if names[f.Name] {
+			return fmt.Errorf("duplicate field name %q", f.Name)
+		} else if tags[f.Tag] {
+			return fmt.Errorf("duplicate field tag %d", f.Tag)
+		} else if f.Kind == KindMessage && f.Message == nil {
+			return fmt.Errorf("field %q has no message schema", f.Name)
+		} else if f.Width != 0 && (f.Kind != KindFloat32 && f.Kind != KindFloat64 ||
+			f.Width != 2 && f.Width != 4 && f.Width != 8) {
+			return fmt.Errorf("field %q of %v has invalid width %d", f.Name, f.Kind, f.Width)
+		} else if f.Width != 0 && f.Nilable {
+			return fmt.Errorf("field %q is nilable but has a width", f.Name)
+		}
+		names[f.Name] = true
+		tags[f.Tag] = true
+	}
+	return nil
+}
+
+// sortedFields returns the fields of s in order of increasing tag.
+func (s *Schema) sortedFields() []*SchemaField {
+	fields := append([]*SchemaField(nil), s.Fields...)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Tag < fields[j].Tag
+	})
+	return fields
+}
+
+// FieldByName returns the field of s with the given name, or nil.
+func (s *Schema) FieldByName(name string) *SchemaField {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// FieldByTag returns the field of s with the given tag, or nil.
+func (s *Schema) FieldByTag(tag int) *SchemaField {
+	for _, f := range s.Fields {
+		if f.Tag == tag {
+			return f
+		}
+	}
+	return nil
+}
+
+// MarshalMap encodes m, a map from field names to values, according to s.
+// It produces the same encoding as Marshal does for a struct with the same
+// field values. Absent, nil, and zero-valued fields are not encoded, except
+// that an absent or nil value of a Nilable field is encoded as nil, as for a
+// nil pointer in a nilable struct field.
+//
+// Values are accepted in the forms produced by encoding/json, as well as
+// their natural Go types: A string or []byte for KindBytes (where a string is
+// decoded as base64); a string for KindString; a bool for KindBool; any
+// integer or integral floating-point value, or json.Number, for KindUint and
+// KindInt; any number for KindFloat32 and KindFloat64; and a
+// map[string]interface{} or *DynamicMessage for KindMessage. A repeated
+// field accepts a slice of any of these, and of nil if the field is Nilable.
+func (s *Schema) MarshalMap(m map[string]interface{}) ([]byte, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	for name := range m {
+		if s.FieldByName(name) == nil {
+			return nil, fmt.Errorf("%w %q", ErrUnknownField, name)
+		}
+	}
+	buf := NewEncoder(nil)
+	for _, f := range s.sortedFields() {
+		v, ok := m[f.Name]
+		if !ok || v == nil {
+			if f.Nilable && !f.Repeated {
+				if err := buf.Encode(f.Tag, nil); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !f.Repeated {
+			data, zero, err := f.encode(v)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", f.Name, err)
+			} else if !zero {
+				if err := buf.Encode(f.Tag, data); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		elts := reflect.ValueOf(v)
+		if elts.Kind() != reflect.Slice && elts.Kind() != reflect.Array {
+			return nil, fmt.Errorf("field %q: repeated value has type %T", f.Name, v)
+		}
+		for i := 0; i < elts.Len(); i++ {
+			data, _, err := f.encode(elts.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("field %q index %d: %w", f.Name, i, err)
+			} else if err := buf.Encode(f.Tag, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Data.Bytes(), nil
+}
+
+// encode encodes a single value v for f, and reports whether it is zero.
+// A value of a Nilable field is never zero.
+func (f *SchemaField) encode(v interface{}) ([]byte, bool, error) {
+	if !f.Nilable {
+		return f.encodeValue(v)
+	} else if v == nil {
+		return []byte{}, false, nil
+	}
+	data, _, err := f.encodeValue(v)
+	if err != nil {
+		return nil, false, err
+	}
+	return append([]byte{optionalPresent}, data...), false, nil
+}
+
+// encodeValue encodes a single non-nil value v for f, without the optional
+// encoding of a Nilable field, and reports whether it is zero.
+func (f *SchemaField) encodeValue(v interface{}) ([]byte, bool, error) {
+	switch f.Kind {
+	case KindBytes:
+		switch t := v.(type) {
+		case []byte:
+			return t, len(t) == 0, nil
+		case string:
+			data, err := base64.StdEncoding.DecodeString(t)
+			return data, len(data) == 0, err
+		}
+	case KindString:
+		if t, ok := v.(string); ok {
+			return []byte(t), t == "", nil
+		}
+	case KindBool:
+		if t, ok := v.(bool); ok {
+			if t {
+				return []byte{1}, false, nil
+			}
+			return []byte{0}, true, nil
+		}
+	case KindUint:
+		z, ok := toInt(v)
+		if ok && z.neg {
+			return nil, false, fmt.Errorf("negative value %v for %v", v, f.Kind)
+		} else if ok {
+			return PackUint64(z.mag), z.mag == 0, nil
+		}
+	case KindInt:
+		if z, ok := toInt(v); ok {
+			if z.neg && z.mag > 1<<63 || !z.neg && z.mag >= 1<<63 {
+				return nil, false, fmt.Errorf("value %v out of range for %v", v, f.Kind)
+			}
+			n := int64(z.mag)
+			if z.neg {
+				n = -n
+			}
+			return PackInt64(n), n == 0, nil
+		}
+	case KindFloat32, KindFloat64:
+		if x, ok := toFloat(v); ok {
+			if f.Width != 0 {
+				data, err := marshalFloat(x, f.Width)
+				return data, math.Float64bits(x) == 0, err
+			}
+			if f.Kind == KindFloat32 {
+				return PackFloat32(float32(x)), math.Float32bits(float32(x)) == 0, nil
+			}
+			return PackFloat64(x), math.Float64bits(x) == 0, nil
+		}
+	case KindMessage:
+		switch t := v.(type) {
+		case map[string]interface{}:
+			data, err := f.Message.MarshalMap(t)
+			return data, false, err
+		case *DynamicMessage:
+			data, err := t.MarshalBinary()
+			return data, false, err
+		}
+	default:
+		return nil, false, fmt.Errorf("invalid kind %v", f.Kind)
+	}
+	return nil, false, fmt.Errorf("invalid value of type %T for %v", v, f.Kind)
+}
+
+// An intValue is the sign and magnitude of an integer.
+type intValue struct {
+	neg bool
+	mag uint64
+}
+
+// toInt converts v to an integer, and reports whether this is possible.
+func toInt(v interface{}) (intValue, bool) {
+	if n, ok := v.(json.Number); ok {
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return intValue{mag: u}, true
+		} else if s, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return intValue{neg: true, mag: uint64(-s)}, true
+		} else if x, err := n.Float64(); err == nil {
+			return toInt(x)
+		}
+		return intValue{}, false
+	}
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := val.Int(); n < 0 {
+			return intValue{neg: true, mag: uint64(-n)}, true
+		}
+		return intValue{mag: uint64(val.Int())}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return intValue{mag: val.Uint()}, true
+	case reflect.Float32, reflect.Float64:
+		x := val.Float()
+		if x != math.Trunc(x) || math.Abs(x) >= 1<<64 {
+			return intValue{}, false
+		} else if x < 0 {
+			return intValue{neg: true, mag: uint64(-x)}, true
+		}
+		return intValue{mag: uint64(x)}, true
+	}
+	return intValue{}, false
+}
+
+// toFloat converts v to a float64, and reports whether this is possible.
+func toFloat(v interface{}) (float64, bool) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), true
+	}
+	if n, ok := v.(json.Number); ok {
+		x, err := n.Float64()
+		return x, err == nil
+	}
+	return 0, false
+}
+
+// UnmarshalMap decodes data according to s into a map from field names to
+// values. Values are represented as []byte for KindBytes, string for
+// KindString, bool for KindBool, uint64 for KindUint, int64 for KindInt,
+// float64 for KindFloat32 and KindFloat64, and map[string]interface{} for
+// KindMessage. Repeated fields are represented as []interface{}. A nil value
+// of a Nilable field is represented as nil in a repeated field, and is
+// otherwise absent. Records whose tags are not described by s are ignored.
+func (s *Schema) UnmarshalMap(data []byte) (map[string]interface{}, error) {
+	return (*UnmarshalOptions)(nil).UnmarshalMap(s, data)
+}
+
+// UnmarshalMap decodes data according to s as by Schema.UnmarshalMap, using
+// the settings from o. Only the Deprecated hook and LegacyBytes apply to this
+// decoding.
+func (o *UnmarshalOptions) UnmarshalMap(s *Schema, data []byte) (map[string]interface{}, error) {
+	return o.unmarshalFields(s, data, func(s *Schema, data []byte) (interface{}, error) {
+		return o.UnmarshalMap(s, data)
+	})
+}
+
+// unmarshalFields decodes data according to s into a map from field names to
+// values, as described by Schema.UnmarshalMap, except that the value of each
+// nested message is the result of calling message with its schema and
+// encoding. It implements both UnmarshalMap and DynamicMessage.UnmarshalBinary.
+func (o *UnmarshalOptions) unmarshalFields(s *Schema, data []byte, message func(*Schema, []byte) (interface{}, error)) (map[string]interface{}, error) {
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{})
+	legacy := o != nil && o.LegacyBytes
+	bytewise := make(map[*SchemaField]bool) // fields that may have per-byte records
+	d := NewBytesDecoder(data)
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		f := s.FieldByTag(tag)
+		if f == nil {
+			continue // skip unknown fields
+		}
+		if f.Deprecated && o != nil && o.Deprecated != nil {
+			o.Deprecated(f.Name, f.Tag)
+		}
+		if bytewise[f] && len(value) == 1 {
+			// A legacy per-byte record (see UnmarshalOptions.LegacyBytes).
+			b, _ := out[f.Name].([]byte)
+			out[f.Name] = append(b, value[0])
+			continue
+		}
+		value, present, err := f.optionalValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		var v interface{}
+		if !present {
+			if !f.Repeated {
+				delete(out, f.Name)
+				continue
+			}
+		} else if f.Kind == KindMessage {
+			v, err = message(f.Message, value)
+		} else {
+			v, err = f.decode(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		if f.Repeated {
+			elts, _ := out[f.Name].([]interface{})
+			out[f.Name] = append(elts, v)
+		} else {
+			out[f.Name] = v
+		}
+		if legacy && f.Kind == KindBytes && !f.Repeated && !f.Nilable {
+			bytewise[f] = len(value) == 1
+		}
+	}
+}
+
+// optionalValue returns the encoding of the value in data, removing the
+// optional encoding of a Nilable field, and reports whether the value is
+// present (that is, not nil).
+func (f *SchemaField) optionalValue(data []byte) ([]byte, bool, error) {
+	if !f.Nilable {
+		return data, true, nil
+	} else if len(data) == 0 {
+		return nil, false, nil
+	} else if data[0] != optionalPresent {
+		return nil, false, errInvalidOptional
+	}
+	return data[1:], true, nil
+}
+
+// decode decodes a single value for f, without the optional encoding of a
+// Nilable field.
+func (f *SchemaField) decode(data []byte) (interface{}, error) {
+	switch f.Kind {
+	case KindBytes:
+		return copyOf(data), nil
+	case KindString:
+		return string(data), nil
+	case KindBool:
+		b, ok := oneByte(data)
+		if !ok {
+			return nil, fmt.Errorf("invalid encoding of %v", f.Kind)
+		}
+		return b != 0, nil
+	case KindUint, KindInt, KindFloat32, KindFloat64:
+		if f.Width != 0 {
+			x, err := unpackFloatWidth(data, f.Width)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", f.Kind, err)
+			}
+			return x, nil
+		} else if len(data) == 0 || len(data) > 8 {
+			return nil, fmt.Errorf("%v: %w", f.Kind, ErrInvalidNumber)
+		}
+		switch f.Kind {
+		case KindUint:
+			return UnpackUint64(data), nil
+		case KindInt:
+			return UnpackInt64(data), nil
+		case KindFloat32:
+			return float64(UnpackFloat32(data)), nil
+		default:
+			return UnpackFloat64(data), nil
+		}
+	case KindMessage:
+		return f.Message.UnmarshalMap(data)
+	default:
+		return nil, fmt.Errorf("invalid kind %v", f.Kind)
+	}
+}
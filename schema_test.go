@@ -0,0 +1,323 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type schemaPoint struct {
+	X int `binpack:"tag=1"`
+	Y int `binpack:"tag=2"`
+}
+
+type schemaThing struct {
+	Name   string         `binpack:"tag=10"`
+	ID     uint64         `binpack:"tag=3"`
+	Ratio  float64        `binpack:"tag=4"`
+	OK     bool           `binpack:"tag=5"`
+	Blob   []byte         `binpack:"tag=6"`
+	Labels []string       `binpack:"tag=7"`
+	Origin *schemaPoint   `binpack:"tag=8"`
+	Path   []schemaPoint  `binpack:"tag=9"`
+	Kids   []*schemaThing `binpack:"tag=11"`
+	Skip   int
+}
+
+func TestSchemaMap(t *testing.T) {
+	s, err := binpack.SchemaOf((*schemaThing)(nil))
+	if err != nil {
+		t.Fatalf("SchemaOf: unexpected error: %v", err)
+	}
+
+	in := &schemaThing{
+		Name:   "thing",
+		ID:     12345,
+		Ratio:  0.25,
+		OK:     true,
+		Blob:   []byte{1, 2, 3},
+		Labels: []string{"a", "", "c"},
+		Origin: &schemaPoint{X: -5},
+		Path:   []schemaPoint{{1, 2}, {3, 4}},
+		Kids:   []*schemaThing{{Name: "kid"}},
+	}
+	want, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+
+	// Decode a JSON representation, and check that the schema produces the
+	// same encoding as the struct.
+	const input = `{
+  "Name": "thing", "ID": 12345, "Ratio": 0.25, "OK": true, "Blob": "AQID",
+  "Labels": ["a", "", "c"], "Origin": {"X": -5},
+  "Path": [{"X": 1, "Y": 2}, {"X": 3, "Y": 4}],
+  "Kids": [{"Name": "kid"}]
+}`
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(input), &m); err != nil {
+		t.Fatalf("Unmarshal JSON: %v", err)
+	}
+	got, err := s.MarshalMap(m)
+	if err != nil {
+		t.Fatalf("MarshalMap: unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalMap: got %q, want %q", got, want)
+	}
+
+	// Decode the encoding and check the generic values.
+	dec, err := s.UnmarshalMap(want)
+	if err != nil {
+		t.Fatalf("UnmarshalMap: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{
+		"Name":   "thing",
+		"ID":     uint64(12345),
+		"Ratio":  0.25,
+		"OK":     true,
+		"Blob":   []byte{1, 2, 3},
+		"Labels": []interface{}{"a", "", "c"},
+		"Origin": map[string]interface{}{"X": int64(-5)},
+		"Path": []interface{}{
+			map[string]interface{}{"X": int64(1), "Y": int64(2)},
+			map[string]interface{}{"X": int64(3), "Y": int64(4)},
+		},
+		"Kids": []interface{}{map[string]interface{}{"Name": "kid"}},
+	}, dec); diff != "" {
+		t.Errorf("UnmarshalMap (-want, +got):\n%s", diff)
+	}
+
+	// Invalid inputs are reported.
+	for _, bad := range []map[string]interface{}{
+		{"Nonesuch": 1},
+		{"ID": -1},
+		{"ID": 1.5},
+		{"Name": 25},
+		{"Labels": "not a slice"},
+		{"Origin": map[string]interface{}{"Z": 0}},
+	} {
+		if got, err := s.MarshalMap(bad); err == nil {
+			t.Errorf("MarshalMap(%v): got %q, want error", bad, got)
+		}
+	}
+}
+
+func TestDynamicMessage(t *testing.T) {
+	s, err := binpack.SchemaOf(schemaThing{})
+	if err != nil {
+		t.Fatalf("SchemaOf: unexpected error: %v", err)
+	}
+	want, err := binpack.Marshal(schemaThing{
+		Name:   "dyn",
+		ID:     7,
+		Labels: []string{"x", "y"},
+		Origin: &schemaPoint{X: 3, Y: 4},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+
+	m := binpack.NewDynamicMessage(s)
+	mustSet := func(m *binpack.DynamicMessage, name string, v interface{}) {
+		t.Helper()
+		if err := m.SetByName(name, v); err != nil {
+			t.Fatalf("SetByName(%q, %v): unexpected error: %v", name, v, err)
+		}
+	}
+	mustSet(m, "Name", "dyn")
+	mustSet(m, "ID", 7)
+	mustSet(m, "Labels", []string{"x", "y"})
+	origin := binpack.NewDynamicMessage(s.FieldByName("Origin").Message)
+	mustSet(origin, "X", 3)
+	mustSet(origin, "Y", int8(4))
+	mustSet(m, "Origin", origin)
+
+	got, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary: got %q, want %q", got, want)
+	}
+
+	// Values are stored in canonical form.
+	if v, err := m.GetByName("ID"); err != nil || v != uint64(7) {
+		t.Errorf("GetByName(ID): got %v, %v; want 7, nil", v, err)
+	}
+	if v, err := m.GetByName("Ratio"); err != nil || v != nil {
+		t.Errorf("GetByName(Ratio): got %v, %v; want nil, nil", v, err)
+	}
+
+	// Decoding recovers the same contents.
+	cp := binpack.NewDynamicMessage(s)
+	if err := cp.UnmarshalBinary(want); err != nil {
+		t.Fatalf("UnmarshalBinary: unexpected error: %v", err)
+	}
+	if v, _ := cp.GetByName("Origin"); v == nil {
+		t.Error("GetByName(Origin): got nil, want message")
+	} else if y, _ := v.(*binpack.DynamicMessage).GetByName("Y"); y != int64(4) {
+		t.Errorf("GetByName(Origin.Y): got %v, want 4", y)
+	}
+	if rt, err := cp.MarshalBinary(); err != nil || !bytes.Equal(rt, want) {
+		t.Errorf("MarshalBinary after decode: got %q, %v; want %q, nil", rt, err, want)
+	}
+
+	// Type and name errors are reported.
+	for _, bad := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"Nonesuch", 1}, {"Name", 1}, {"OK", "true"}, {"Labels", "x"}, {"Origin", origin.Schema()},
+	} {
+		if err := m.SetByName(bad.name, bad.value); err == nil {
+			t.Errorf("SetByName(%q, %v): got nil, want error", bad.name, bad.value)
+		}
+	}
+	if _, err := m.GetByName("Nonesuch"); err == nil {
+		t.Error("GetByName(Nonesuch): got nil, want error")
+	}
+}
+
+type schemaReading struct {
+	Temp    float32   `binpack:"tag=1,f16"`
+	Samples []float64 `binpack:"tag=2,width=4"`
+}
+
+func TestSchemaWidth(t *testing.T) {
+	s, err := binpack.SchemaOf(schemaReading{})
+	if err != nil {
+		t.Fatalf("SchemaOf: unexpected error: %v", err)
+	}
+	if w := s.FieldByName("Temp").Width; w != 2 {
+		t.Errorf("Temp width: got %d, want 2", w)
+	}
+	if w := s.FieldByName("Samples").Width; w != 4 {
+		t.Errorf("Samples width: got %d, want 4", w)
+	}
+
+	want, err := binpack.Marshal(schemaReading{Temp: 1.5, Samples: []float64{0.25, 3}})
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	got, err := s.MarshalMap(map[string]interface{}{"Temp": 1.5, "Samples": []float64{0.25, 3}})
+	if err != nil {
+		t.Fatalf("MarshalMap: unexpected error: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("MarshalMap: got %q, want %q", got, want)
+	}
+
+	// Values decoded through a type stream respect the widths.
+	var buf bytes.Buffer
+	if err := binpack.NewTypeStreamEncoder(binpack.NewEncoder(&buf)).Encode(schemaReading{
+		Temp: 1.5, Samples: []float64{0.25, 3},
+	}); err != nil {
+		t.Fatalf("Encode: unexpected error: %v", err)
+	}
+	tv, err := binpack.NewTypeStreamDecoder(binpack.NewBytesDecoder(buf.Bytes())).Next()
+	if err != nil {
+		t.Fatalf("Next: unexpected error: %v", err)
+	}
+	m, err := tv.Dynamic()
+	if err != nil {
+		t.Fatalf("Dynamic: unexpected error: %v", err)
+	}
+	temp, _ := m.GetByName("Temp")
+	samples, _ := m.GetByName("Samples")
+	if diff := cmp.Diff([]interface{}{1.5, []interface{}{0.25, 3.0}}, []interface{}{temp, samples}); diff != "" {
+		t.Errorf("Decoded values (-want, +got):\n%s", diff)
+	}
+
+	// Widths are only valid for floats.
+	bad := &binpack.Schema{Fields: []*binpack.SchemaField{{Name: "N", Tag: 1, Kind: binpack.KindInt, Width: 2}}}
+	if got, err := bad.MarshalMap(map[string]interface{}{"N": 1}); err == nil {
+		t.Errorf("MarshalMap with invalid width: got %q, want error", got)
+	}
+}
+
+type schemaOptional struct {
+	Count  *int         `binpack:"tag=1,nilable"`
+	Origin *schemaPoint `binpack:"tag=2,nilable"`
+	Marks  []*int       `binpack:"tag=3,nilable"`
+}
+
+func TestSchemaNilable(t *testing.T) {
+	s, err := binpack.SchemaOf(schemaOptional{})
+	if err != nil {
+		t.Fatalf("SchemaOf: unexpected error: %v", err)
+	}
+	for _, f := range s.Fields {
+		if !f.Nilable {
+			t.Errorf("Field %q is not nilable", f.Name)
+		}
+	}
+
+	zero, one, two := 0, 1, 2
+	in := schemaOptional{Count: &zero, Marks: []*int{&one, nil, &two}}
+	want, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: unexpected error: %v", err)
+	}
+	got, err := s.MarshalMap(map[string]interface{}{"Count": 0, "Marks": []interface{}{1, nil, 2}})
+	if err != nil {
+		t.Fatalf("MarshalMap: unexpected error: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("MarshalMap: got %q, want %q", got, want)
+	}
+
+	// Values decoded through a type stream distinguish nil from zero.
+	var buf bytes.Buffer
+	if err := binpack.NewTypeStreamEncoder(binpack.NewEncoder(&buf)).Encode(in); err != nil {
+		t.Fatalf("Encode: unexpected error: %v", err)
+	}
+	tv, err := binpack.NewTypeStreamDecoder(binpack.NewBytesDecoder(buf.Bytes())).Next()
+	if err != nil {
+		t.Fatalf("Next: unexpected error: %v", err)
+	}
+	m, err := tv.Dynamic()
+	if err != nil {
+		t.Fatalf("Dynamic: unexpected error: %v", err)
+	}
+	var vals []interface{}
+	for _, name := range []string{"Count", "Origin", "Marks"} {
+		v, _ := m.GetByName(name)
+		vals = append(vals, v)
+	}
+	if diff := cmp.Diff([]interface{}{
+		int64(0), nil, []interface{}{int64(1), nil, int64(2)},
+	}, vals); diff != "" {
+		t.Errorf("Decoded values (-want, +got):\n%s", diff)
+	}
+	if rt, err := m.MarshalBinary(); err != nil || !bytes.Equal(rt, want) {
+		t.Errorf("MarshalBinary: got %q, %v; want %q, nil", rt, err, want)
+	}
+
+	// A present message is decoded.
+	m = binpack.NewDynamicMessage(s)
+	if err := m.SetByName("Origin", map[string]interface{}{"X": 3}); err != nil {
+		t.Fatalf("SetByName(Origin): unexpected error: %v", err)
+	}
+	bits, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: unexpected error: %v", err)
+	}
+	var out schemaOptional
+	if err := binpack.Unmarshal(bits, &out); err != nil {
+		t.Fatalf("Unmarshal: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(schemaOptional{Origin: &schemaPoint{X: 3}}, out); diff != "" {
+		t.Errorf("Unmarshal (-want, +got):\n%s", diff)
+	}
+
+	// Values without the optional encoding are rejected.
+	e := binpack.NewEncoder(nil)
+	e.Encode(1, []byte{2})
+	if err := m.UnmarshalBinary(e.Data.Bytes()); err == nil {
+		t.Error("UnmarshalBinary with invalid optional value: got nil, want error")
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// A WireKind identifies how a value is encoded, in the self-describing mode
+// of Marshal (see MarshalOptions.SelfDescribing). In this mode each record
+// tag is constructed by MakeTag from the field tag and the WireKind of the
+// value, so that tools can render messages without knowing their types.
+type WireKind uint
+
+// Constants defining the wire kinds of values.
+const (
+	WireBytes  WireKind = iota // raw bytes
+	WireString                 // a string, as raw bytes
+	WireUint                   // an unsigned integer or bool, as PackUint64
+	WireInt                    // a signed integer, as PackInt64
+	WireFloat                  // a floating-point value, as PackFloat64
+	WireNested                 // a nested sequence of records
+	WireList                   // a packed sequence of values
+	WireMap                    // a map entry, or a packed sequence of entries
+)
+
+var wireKindNames = [...]string{
+	"bytes", "string", "uint", "int", "float", "nested", "list", "map",
+}
+
+func (k WireKind) String() string {
+	if int(k) < len(wireKindNames) {
+		return wireKindNames[k]
+	}
+	return fmt.Sprintf("WireKind(%d)", uint(k))
+}
+
+// wireKindOf returns the wire kind of a value of type typ.
+func wireKindOf(typ reflect.Type) WireKind {
+	if typ.Implements(binaryMarshalerType) {
+		return WireBytes
+	}
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return wireKindOf(typ.Elem())
+	case reflect.Bool, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return WireUint
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return WireInt
+	case reflect.Float32, reflect.Float64:
+		return WireFloat
+	case reflect.String:
+		return WireString
+	case reflect.Struct:
+		return WireNested
+	case reflect.Map:
+		return WireMap
+	case reflect.Slice:
+		if typ == bytesType {
+			return WireBytes
+		}
+		return WireList
+	}
+	return WireBytes
+}
+
+// fieldWireKind returns the wire kind of the records for a struct field. For
+// a sequence field, this is the kind of each element. A pointer to a map is
+// packed into one record, which is described as a list of entries.
+func fieldWireKind(fi *fieldInfo) WireKind {
+	typ := fi.target.Type()
+	if typ.Kind() == reflect.Interface && !fi.target.IsNil() {
+		typ = fi.target.Elem().Type()
+	}
+	if fi.seq && typ.Kind() == reflect.Slice {
+		return wireKindOf(typ.Elem())
+	}
+	if !fi.seq && typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Map {
+		return WireList
+	}
+	return wireKindOf(typ)
+}
+
+// DecodeGeneric decodes data, which must be encoded in self-describing mode
+// (see MarshalOptions.SelfDescribing), into a map from field tag to value,
+// without a Go type. It is shorthand for calling Unmarshal with a
+// *map[int]interface{} and SelfDescribing set.
+//
+// Each value is decoded according to its wire kind:
+//
+//	WireBytes    []byte
+//	WireString   string
+//	WireUint     uint64
+//	WireInt      int64
+//	WireFloat    float64
+//	WireNested   map[int]interface{}, decoded recursively
+//	WireList     []interface{}, whose elements are []byte
+//	WireMap      map[string]interface{}, whose values are []byte
+//
+// The elements of lists and maps do not record their kinds, so they are left
+// as raw bytes. A field with several records (other than map entries) is
+// decoded as a []interface{} of the values of those records, in order. The
+// entries of a map field are merged into a single map.
+func DecodeGeneric(data []byte) (map[int]interface{}, error) {
+	u := &unmarshaler{selfDescribing: true}
+	return u.decodeMessage(data)
+}
+
+// decodeMessage decodes a self-describing message into a map from field tag
+// to value.
+func (u *unmarshaler) decodeMessage(data []byte) (map[int]interface{}, error) {
+	out := make(map[int]interface{})
+	count := make(map[int]int)
+	for {
+		tag, value, rest, err := nextRecord(data)
+		if err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		data = rest
+
+		id, k := SplitTag(tag)
+		kind, key := WireKind(k), int(id)
+		if kind == WireMap {
+			if err := u.decodeEntry(out, key, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v, err := u.decodeKind(kind, value)
+		if err != nil {
+			return nil, fmt.Errorf("tag %d: %w", key, err)
+		}
+		count[key]++
+		switch count[key] {
+		case 1:
+			out[key] = v
+		case 2:
+			out[key] = []interface{}{out[key], v}
+		default:
+			out[key] = append(out[key].([]interface{}), v)
+		}
+	}
+}
+
+// decodeEntry decodes a map entry and adds it to the map for key in out.
+func (u *unmarshaler) decodeEntry(out map[int]interface{}, key int, entry []byte) error {
+	kdata, rest, err := nextValue(entry)
+	if err != nil {
+		return fmt.Errorf("tag %d: map key: %w", key, err)
+	}
+	vdata, rest, err := nextValue(rest)
+	if err != nil {
+		return fmt.Errorf("tag %d: map value: %w", key, err)
+	} else if len(rest) != 0 {
+		return fmt.Errorf("tag %d: extra data in map entry", key)
+	}
+	m, ok := out[key].(map[string]interface{})
+	if !ok {
+		if out[key] != nil {
+			return fmt.Errorf("tag %d: map entry mixed with other records", key)
+		}
+		m = make(map[string]interface{})
+		out[key] = m
+	}
+	v, err := u.bytesOf(vdata)
+	if err != nil {
+		return err
+	}
+	m[string(kdata)] = v
+	return nil
+}
+
+// decodeKind decodes a single value of the given wire kind.
+func (u *unmarshaler) decodeKind(kind WireKind, data []byte) (interface{}, error) {
+	switch kind {
+	case WireString:
+		return u.stringOf(data)
+	case WireUint, WireInt, WireFloat:
+		if len(data) == 0 || len(data) > 8 {
+			return nil, fmt.Errorf("%v: %w", kind, ErrInvalidNumber)
+		}
+		switch kind {
+		case WireUint:
+			return UnpackUint64(data), nil
+		case WireInt:
+			return UnpackInt64(data), nil
+		}
+		return UnpackFloat64(data), nil
+	case WireNested:
+		return u.decodeMessage(data)
+	case WireList:
+		var list []interface{}
+		for len(data) != 0 {
+			elt, rest, err := nextValue(data)
+			if err != nil {
+				return nil, err
+			}
+			v, err := u.bytesOf(elt)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			data = rest
+		}
+		return list, nil
+	case WireMap:
+		m := make(map[int]interface{})
+		if err := u.decodeEntry(m, 0, data); err != nil {
+			return nil, err
+		}
+		return m[0], nil
+	}
+	return u.bytesOf(data)
+}
+
+var anyType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// marshalKinded encodes v as a single record whose tag is the WireKind of its
+// dynamic type, so that it can be decoded without knowing that type. A map is
+// described as a list of entries, and a nil value as empty bytes. This is used
+// for the interface{} values of maps in self-describing mode.
+func (m *marshaler) marshalKinded(v interface{}) ([]byte, error) {
+	if v == nil {
+		return AppendRecord(nil, MakeTag(0, uint(WireBytes)), nil)
+	}
+	kind := wireKindOf(reflect.TypeOf(v))
+	if kind == WireMap {
+		kind = WireList
+	}
+	data, err := m.marshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	return AppendRecord(nil, MakeTag(0, uint(kind)), data)
+}
+
+// decodeKinded decodes a value encoded by marshalKinded, as by decodeKind.
+func (u *unmarshaler) decodeKinded(data []byte) (interface{}, error) {
+	tag, value, rest, err := nextRecord(data)
+	if err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("extra data after value")
+	}
+	_, kind := SplitTag(tag)
+	return u.decodeKind(WireKind(kind), value)
+}
@@ -0,0 +1,200 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelfDescribing(t *testing.T) {
+	type inner struct {
+		Label string `binpack:"tag=1"`
+	}
+	type thing struct {
+		Name   string         `binpack:"tag=1"`
+		Count  uint16         `binpack:"tag=2"`
+		Delta  int            `binpack:"tag=3"`
+		Ratio  float32        `binpack:"tag=4"`
+		Blob   []byte         `binpack:"tag=5"`
+		Sub    *inner         `binpack:"tag=6"`
+		Tags   []string       `binpack:"tag=7"`
+		Attrs  map[string]int `binpack:"tag=8"`
+		Nested [][]int        `binpack:"tag=9"`
+		OK     bool           `binpack:"tag=10"`
+	}
+	in := &thing{
+		Name:   "sprocket",
+		Count:  300,
+		Delta:  -5,
+		Ratio:  0.25,
+		Blob:   []byte{1, 2, 3},
+		Sub:    &inner{Label: "cog"},
+		Tags:   []string{"a", "b"},
+		Attrs:  map[string]int{"x": 1},
+		Nested: [][]int{{1, 2}},
+		OK:     true,
+	}
+	opts := &binpack.MarshalOptions{SelfDescribing: true}
+	bits, err := opts.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var msg binpack.Message
+	if err := msg.UnmarshalBinary(bits); err != nil {
+		t.Fatalf("Decoding records failed: %v", err)
+	}
+	type idKind struct {
+		ID   uint
+		Kind binpack.WireKind
+	}
+	var got []idKind
+	for _, r := range msg {
+		id, kind := binpack.SplitTag(r.Tag)
+		got = append(got, idKind{id, binpack.WireKind(kind)})
+	}
+	want := []idKind{
+		{1, binpack.WireString},
+		{2, binpack.WireUint},
+		{3, binpack.WireInt},
+		{4, binpack.WireFloat},
+		{5, binpack.WireBytes},
+		{6, binpack.WireNested},
+		{7, binpack.WireString},
+		{7, binpack.WireString},
+		{8, binpack.WireMap},
+		{9, binpack.WireList},
+		{10, binpack.WireUint},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Record kinds (-want, +got):\n%s", diff)
+	}
+
+	out := new(thing)
+	uopts := &binpack.UnmarshalOptions{SelfDescribing: true}
+	if err := uopts.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// Field tags too large to hold a kind are rejected.
+	type big struct {
+		X int `binpack:"tag=1000000000"`
+	}
+	if _, err := opts.Marshal(big{X: 1}); err == nil {
+		t.Error("Marshal of large tag: got nil error, want error")
+	}
+}
+
+func TestDecodeGeneric(t *testing.T) {
+	type inner struct {
+		Label string `binpack:"tag=1"`
+		Score int    `binpack:"tag=2"`
+	}
+	type thing struct {
+		Name  string            `binpack:"tag=1"`
+		Count uint16            `binpack:"tag=2"`
+		Delta int               `binpack:"tag=3"`
+		Ratio float64           `binpack:"tag=4"`
+		Blob  []byte            `binpack:"tag=5"`
+		Sub   *inner            `binpack:"tag=6"`
+		Tags  []string          `binpack:"tag=7"`
+		Attrs map[string][]byte `binpack:"tag=8"`
+		Grid  [][]int           `binpack:"tag=9"`
+		Any   interface{}       `binpack:"tag=10"`
+	}
+	opts := &binpack.MarshalOptions{SelfDescribing: true}
+	bits, err := opts.Marshal(&thing{
+		Name:  "sprocket",
+		Count: 300,
+		Delta: -5,
+		Ratio: 0.5,
+		Blob:  []byte{1, 2, 3},
+		Sub:   &inner{Label: "cog", Score: 7},
+		Tags:  []string{"a", "b", "c"},
+		Attrs: map[string][]byte{"x": []byte("1"), "y": []byte("2")},
+		Grid:  [][]int{{1, 2}},
+		Any:   "whatever",
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := map[int]interface{}{
+		1:  "sprocket",
+		2:  uint64(300),
+		3:  int64(-5),
+		4:  0.5,
+		5:  []byte{1, 2, 3},
+		6:  map[int]interface{}{1: "cog", 2: int64(7)},
+		7:  []interface{}{"a", "b", "c"},
+		8:  map[string]interface{}{"x": []byte("1"), "y": []byte("2")},
+		9:  []interface{}{[]byte{2}, []byte{4}}, // zigzag encoding
+		10: "whatever",
+	}
+	got, err := binpack.DecodeGeneric(bits)
+	if err != nil {
+		t.Fatalf("DecodeGeneric failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DecodeGeneric (-want, +got):\n%s", diff)
+	}
+
+	// Unmarshal into an empty interface gives the same result.
+	var v interface{}
+	uopts := &binpack.UnmarshalOptions{SelfDescribing: true}
+	if err := uopts.Unmarshal(bits, &v); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(want, v); diff != "" {
+		t.Errorf("Unmarshal (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSelfDescribingInterfaceMap(t *testing.T) {
+	type inner struct {
+		Label string `binpack:"tag=1"`
+	}
+	type thing struct {
+		Attrs map[string]interface{} `binpack:"tag=1"`
+	}
+	in := &thing{Attrs: map[string]interface{}{
+		"name":  "plugin",
+		"count": uint32(3),
+		"delta": -2,
+		"ratio": float32(0.25),
+		"hot":   true,
+		"raw":   []byte("\x01\x02"),
+		"none":  nil,
+		"inner": inner{Label: "x"},
+		"list":  []string{"a", "b"},
+	}}
+	want := &thing{Attrs: map[string]interface{}{
+		"name":  "plugin",
+		"count": uint64(3),
+		"delta": int64(-2),
+		"ratio": float64(0.25),
+		"hot":   uint64(1),
+		"raw":   []byte("\x01\x02"),
+		"none":  []byte{},
+		"inner": map[int]interface{}{1: "x"},
+		"list":  []interface{}{[]byte("a"), []byte("b")},
+	}}
+
+	bits, err := (&binpack.MarshalOptions{SelfDescribing: true}).Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got := new(thing)
+	if err := (&binpack.UnmarshalOptions{SelfDescribing: true}).Unmarshal(bits, got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/creachadair/binpack/wire"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplit(t *testing.T) {
+	want := []string{
+		"\x01\x00",
+		"\x02\x83abc",
+		"\x80\x81\xE0\x00\x23\x28" + strings.Repeat("z", 9000),
+		"\xC0\x00\x46\x50\x7f",
+		"\x05\x80",
+	}
+	input := strings.Join(want, "")
+
+	recs, err := binpack.Split([]byte(input))
+	if err != nil {
+		t.Fatalf("Split: unexpected error: %v", err)
+	}
+	var got []string
+	for _, rec := range recs {
+		got = append(got, string(rec))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Split (-want, +got):\n%s", diff)
+	}
+
+	// The same records should be found by a scanner, even if the input is
+	// delivered in small pieces.
+	s := bufio.NewScanner(bufio.NewReaderSize(strings.NewReader(input), 16))
+	s.Buffer(nil, 16384)
+	s.Split(binpack.ScanRecords)
+	got = nil
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Scan (-want, +got):\n%s", diff)
+	}
+
+	// A truncated record is reported as an error.
+	if _, err := binpack.Split([]byte(input[:len(input)-1])); err == nil {
+		t.Error("Split of truncated input: got nil error, want error")
+	}
+	s = bufio.NewScanner(bytes.NewReader([]byte("\x01\x83ab")))
+	s.Split(binpack.ScanRecords)
+	for s.Scan() {
+		t.Errorf("Scan of truncated input: unexpected token %q", s.Text())
+	}
+	if s.Err() == nil {
+		t.Error("Scan of truncated input: got nil error, want error")
+	}
+}
+
+func TestRecordScanner(t *testing.T) {
+	want := []binpack.Record{
+		{Tag: 1, Value: []byte("\x00")},
+		{Tag: 2, Value: []byte("abc")},
+		{Tag: 200, Value: bytes.Repeat([]byte("z"), 9000)},
+		{Tag: 70000, Value: []byte{}},
+	}
+	e := binpack.NewEncoder(nil)
+	for _, r := range want {
+		e.Encode(r.Tag, r.Value)
+	}
+
+	s := binpack.NewRecordScanner(bufio.NewReaderSize(bytes.NewReader(e.Data.Bytes()), 16))
+	s.Buffer(nil, 16384)
+	var got []binpack.Record
+	for s.Scan() {
+		r := s.Record()
+		got = append(got, binpack.Record{Tag: r.Tag, Value: append([]byte{}, r.Value...)})
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Scan (-want, +got):\n%s", diff)
+	}
+
+	// A record larger than the buffer is reported as an error.
+	s = binpack.NewRecordScanner(bytes.NewReader(e.Data.Bytes()))
+	s.Buffer(nil, 1024)
+	for s.Scan() {
+	}
+	if err := s.Err(); err != bufio.ErrTooLong {
+		t.Errorf("Scan with small buffer: got error %v, want %v", err, bufio.ErrTooLong)
+	}
+}
+
+// appendOffsets is a simple reference for AppendOffsets.
+func appendOffsets(data []byte) ([]int, bool) {
+	var offs []int
+	for pos := 0; pos < len(data); {
+		n, ok := wire.RecordSize(data[pos:])
+		if !ok {
+			return offs, false
+		}
+		offs = append(offs, pos)
+		pos += n
+	}
+	return offs, true
+}
+
+func TestAppendOffsets(t *testing.T) {
+	e := binpack.NewEncoder(nil)
+	for i, tag := range []int{0, 5, 127, 128, 300, 16383, 16384, 1 << 29} {
+		for _, n := range []int{0, 1, 2, 63, 64, 200} {
+			value := bytes.Repeat([]byte{byte(i * n)}, n)
+			e.Encode(tag, value)
+		}
+	}
+	big := e.Data.Len()
+	e.Encode(1, make([]byte, 9000)) // 4-byte length prefix
+	e.Encode(2, []byte("end"))
+	input := e.Data.Bytes()
+
+	for n := 0; n <= len(input); n++ {
+		if n > big+16 && n < len(input)-16 {
+			n = len(input) - 16 // skip the middle of the large value
+		}
+		want, ok := appendOffsets(input[:n])
+		got, err := binpack.AppendOffsets(nil, input[:n])
+		if ok != (err == nil) {
+			t.Errorf("Prefix %d: got error %v, want complete=%v", n, err, ok)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("Prefix %d: offsets differ (-want, +got):\n%s", n, diff)
+		}
+	}
+
+	// Offsets are appended to the existing contents of dst.
+	got, err := binpack.AppendOffsets([]int{-1}, input[:2])
+	if err != nil {
+		t.Fatalf("AppendOffsets failed: %v", err)
+	}
+	if diff := cmp.Diff([]int{-1, 0}, got); diff != "" {
+		t.Errorf("AppendOffsets (-want, +got):\n%s", diff)
+	}
+}
+
+func BenchmarkSplit(b *testing.B) {
+	e := binpack.NewEncoder(nil)
+	for i := 0; i < 10000; i++ {
+		e.Encode(i, bytes.Repeat([]byte("v"), i%100))
+	}
+	input := e.Data.Bytes()
+	b.Run("Split", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		for i := 0; i < b.N; i++ {
+			if _, err := binpack.Split(input); err != nil {
+				b.Fatalf("Split failed: %v", err)
+			}
+		}
+	})
+	b.Run("AppendOffsets", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		var offs []int
+		for i := 0; i < b.N; i++ {
+			var err error
+			offs, err = binpack.AppendOffsets(offs[:0], input)
+			if err != nil {
+				b.Fatalf("AppendOffsets failed: %v", err)
+			}
+		}
+	})
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		rec  binpack.Record
+		want string
+	}{
+		{binpack.Record{Tag: 1}, "\x01\x80"},
+		{binpack.Record{Tag: 2, Value: []byte("x")}, "\x02x"},
+		{binpack.Record{Tag: 300, Value: []byte("apple")}, "\x81\x2c\x85apple"},
+		{binpack.Record{Tag: 1 << 20, Value: []byte{200}}, "\xC0\x10\x00\x00\x81\xc8"},
+	}
+	for _, test := range tests {
+		if got := test.rec.Size(); got != len(test.want) {
+			t.Errorf("Size(%v): got %d, want %d", test.rec, got, len(test.want))
+		}
+		enc, err := binpack.EncodeRecord(test.rec)
+		if err != nil {
+			t.Fatalf("EncodeRecord(%v) failed: %v", test.rec, err)
+		} else if string(enc) != test.want {
+			t.Errorf("EncodeRecord(%v): got %q, want %q", test.rec, enc, test.want)
+		}
+		buf, err := test.rec.AppendTo([]byte("prefix"))
+		if err != nil {
+			t.Fatalf("AppendTo(%v) failed: %v", test.rec, err)
+		} else if got := string(buf); got != "prefix"+test.want {
+			t.Errorf("AppendTo(%v): got %q, want %q", test.rec, got, "prefix"+test.want)
+		}
+
+		dec, err := binpack.DecodeRecord(enc)
+		if err != nil {
+			t.Fatalf("DecodeRecord(%q) failed: %v", enc, err)
+		}
+		if dec.Tag != test.rec.Tag || !bytes.Equal(dec.Value, test.rec.Value) {
+			t.Errorf("DecodeRecord(%q): got %v, want %v", enc, dec, test.rec)
+		}
+	}
+
+	bad := binpack.Record{Tag: -1}
+	if n := bad.Size(); n != -1 {
+		t.Errorf("Size(%v): got %d, want -1", bad, n)
+	}
+	if _, err := binpack.EncodeRecord(bad); !errors.Is(err, binpack.ErrTagTooLarge) {
+		t.Errorf("EncodeRecord(%v): got %v, want %v", bad, err, binpack.ErrTagTooLarge)
+	}
+	if _, err := binpack.DecodeRecord([]byte("\x01\x83ab")); !errors.Is(err, binpack.ErrTruncated) {
+		t.Errorf("DecodeRecord truncated: got %v, want %v", err, binpack.ErrTruncated)
+	}
+	if _, err := binpack.DecodeRecord([]byte("\x01\x00\x02\x00")); !errors.Is(err, binpack.ErrExtraData) {
+		t.Errorf("DecodeRecord extra: got %v, want %v", err, binpack.ErrExtraData)
+	}
+}
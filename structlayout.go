@@ -0,0 +1,154 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// A StructLayout describes how the values of a tagged Go struct type are
+// encoded, in a form that can be exported (for example, as JSON) to generate
+// readers in other languages without parsing Go source.
+type StructLayout struct {
+	Root  string                 `json:"root"`  // the name of the top-level type
+	Types map[string]*LayoutType `json:"types"` // all struct types, by name
+}
+
+// A LayoutType describes the encoded fields of a struct type.
+type LayoutType struct {
+	Fields []*LayoutField `json:"fields"` // in order of increasing tag
+}
+
+// A LayoutField describes the encoding of a single struct field.
+//
+// Kind is the wire kind of each value of the field, as named by
+// WireKind.String. Type is the Go type of the value: For a field of kind
+// "nested", it names an entry in the Types map of the StructLayout; otherwise
+// it gives the width and signedness of numeric values, e.g., "float32".
+// Width is the size in bytes of the IEEE 754 format of a float field with a
+// width option, which may differ from the size of its Type. Nilable reports
+// whether the values of the field, or of its map entries, use the optional
+// encoding of a nilable field (see Marshal).
+type LayoutField struct {
+	Name     string     `json:"name"`
+	Tag      int        `json:"tag"`
+	Kind     string     `json:"kind"`
+	Type     string     `json:"type"`
+	Width    int        `json:"width,omitempty"`
+	Nilable  bool       `json:"nilable,omitempty"`
+	Repeated bool       `json:"repeated,omitempty"` // one record per element
+	Map      *LayoutMap `json:"map,omitempty"`      // for kind "map"
+}
+
+// A LayoutMap describes the keys and values of a map field. Each entry of the
+// map is encoded as a separate record holding the key and value.
+type LayoutMap struct {
+	KeyKind   string `json:"key_kind"`
+	KeyType   string `json:"key_type"`
+	ValueKind string `json:"value_kind"`
+	ValueType string `json:"value_type"`
+}
+
+// StructLayoutOf constructs a StructLayout describing the struct type of v,
+// which must be a struct or a pointer to a struct. Types are named by their Go
+// type names, including the package name. The result can be encoded as JSON.
+//
+// Fields of interface type are described by their static type, and have kind
+// "bytes" since their encoding depends on the dynamic type.
+func StructLayoutOf(v interface{}) (*StructLayout, error) {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type %T is not a struct or pointer to struct", v)
+	}
+	lay := &StructLayout{Root: typ.String(), Types: make(map[string]*LayoutType)}
+	if err := lay.addType(typ); err != nil {
+		return nil, err
+	}
+	return lay, nil
+}
+
+// addType adds a description of the struct type typ and the struct types it
+// refers to, if typ has not already been added.
+func (lay *StructLayout) addType(typ reflect.Type) error {
+	name := typ.String()
+	if _, ok := lay.Types[name]; ok {
+		return nil
+	}
+	lt := new(LayoutType)
+	lay.Types[name] = lt
+	for i := 0; i < typ.NumField(); i++ {
+		ft := typ.Field(i)
+		tag, ok := ft.Tag.Lookup("binpack")
+		if !ok {
+			continue
+		}
+		fi, ok := parseTag(tag)
+		if !ok {
+			return fmt.Errorf("invalid field %q tag %q", ft.Name, tag)
+		}
+		lf := &LayoutField{Name: ft.Name, Tag: fi.tag, Width: fi.width, Nilable: fi.nilable}
+		etype := ft.Type
+		switch {
+		case etype.Implements(binaryMarshalerType):
+			// The type provides its own encoding.
+		case etype.Kind() == reflect.Slice && etype != bytesType:
+			lf.Repeated = true
+			etype = etype.Elem()
+		case etype.Kind() == reflect.Map:
+			kt, vt := etype.Key(), etype.Elem()
+			lf.Map = &LayoutMap{
+				KeyKind:   wireKindOf(kt).String(),
+				KeyType:   lay.typeName(kt),
+				ValueKind: wireKindOf(vt).String(),
+				ValueType: lay.typeName(vt),
+			}
+			if err := lay.addNested(kt); err != nil {
+				return fmt.Errorf("field %q: %w", ft.Name, err)
+			}
+			if err := lay.addNested(vt); err != nil {
+				return fmt.Errorf("field %q: %w", ft.Name, err)
+			}
+		}
+		lf.Kind = wireKindOf(etype).String()
+		lf.Type = lay.typeName(etype)
+		if err := lay.addNested(etype); err != nil {
+			return fmt.Errorf("field %q: %w", ft.Name, err)
+		}
+		lt.Fields = append(lt.Fields, lf)
+	}
+	sort.Slice(lt.Fields, func(i, j int) bool {
+		return lt.Fields[i].Tag < lt.Fields[j].Tag
+	})
+	for i := 0; i < len(lt.Fields)-1; i++ {
+		if lt.Fields[i].Tag == lt.Fields[i+1].Tag {
+			return fmt.Errorf("type %v: duplicate field tag %d", typ, lt.Fields[i].Tag)
+		}
+	}
+	return nil
+}
+
+// addNested adds a description of typ if it is encoded as a nested message.
+func (lay *StructLayout) addNested(typ reflect.Type) error {
+	if wireKindOf(typ) != WireNested {
+		return nil
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return lay.addType(typ)
+}
+
+// typeName returns the name of typ, without pointer indirections.
+func (lay *StructLayout) typeName(typ reflect.Type) string {
+	if !typ.Implements(binaryMarshalerType) {
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	return typ.String()
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type layoutThing struct {
+	Name  string                  `binpack:"tag=1"`
+	Ratio float32                 `binpack:"tag=2"`
+	Data  []byte                  `binpack:"tag=3"`
+	Path  []*schemaPoint          `binpack:"tag=4"`
+	Attrs map[string]*schemaPoint `binpack:"tag=5"`
+	Next  *layoutThing            `binpack:"tag=6"`
+	Temps []float64               `binpack:"tag=7,f16"`
+	Prev  *layoutThing            `binpack:"tag=8,nilable"`
+	Skip  int
+}
+
+func TestLayoutOf(t *testing.T) {
+	lay, err := binpack.StructLayoutOf((*layoutThing)(nil))
+	if err != nil {
+		t.Fatalf("StructLayoutOf: unexpected error: %v", err)
+	}
+	want := &binpack.StructLayout{
+		Root: "binpack_test.layoutThing",
+		Types: map[string]*binpack.LayoutType{
+			"binpack_test.layoutThing": {Fields: []*binpack.LayoutField{
+				{Name: "Name", Tag: 1, Kind: "string", Type: "string"},
+				{Name: "Ratio", Tag: 2, Kind: "float", Type: "float32"},
+				{Name: "Data", Tag: 3, Kind: "bytes", Type: "[]uint8"},
+				{Name: "Path", Tag: 4, Kind: "nested", Type: "binpack_test.schemaPoint", Repeated: true},
+				{Name: "Attrs", Tag: 5, Kind: "map", Type: "map[string]*binpack_test.schemaPoint",
+					Map: &binpack.LayoutMap{
+						KeyKind: "string", KeyType: "string",
+						ValueKind: "nested", ValueType: "binpack_test.schemaPoint",
+					}},
+				{Name: "Next", Tag: 6, Kind: "nested", Type: "binpack_test.layoutThing"},
+				{Name: "Temps", Tag: 7, Kind: "float", Type: "float64", Width: 2, Repeated: true},
+				{Name: "Prev", Tag: 8, Kind: "nested", Type: "binpack_test.layoutThing", Nilable: true},
+			}},
+			"binpack_test.schemaPoint": {Fields: []*binpack.LayoutField{
+				{Name: "X", Tag: 1, Kind: "int", Type: "int"},
+				{Name: "Y", Tag: 2, Kind: "int", Type: "int"},
+			}},
+		},
+	}
+	if diff := cmp.Diff(want, lay); diff != "" {
+		t.Errorf("Wrong layout (-want, +got):\n%s", diff)
+	}
+
+	// The layout round-trips through JSON.
+	bits, err := json.Marshal(lay)
+	if err != nil {
+		t.Fatalf("Marshal JSON: unexpected error: %v", err)
+	}
+	var got binpack.StructLayout
+	if err := json.Unmarshal(bits, &got); err != nil {
+		t.Fatalf("Unmarshal JSON: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, &got); diff != "" {
+		t.Errorf("Wrong layout from JSON (-want, +got):\n%s", diff)
+	}
+
+	if _, err := binpack.StructLayoutOf(25); err == nil {
+		t.Error("StructLayoutOf(int): got nil, want error")
+	}
+}
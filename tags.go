@@ -0,0 +1,30 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import "github.com/creachadair/binpack/wire"
+
+// Applications may pack a small "kind" code into the low-order bits of each
+// tag, for example to record the type of the value. MakeTag and SplitTag
+// implement this convention with TagKindBits bits of kind.
+const (
+	TagKindBits = 3                          // number of low-order bits holding the kind
+	MaxTagKind  = 1<<TagKindBits - 1         // largest kind that can be packed
+	MaxTagID    = wire.MaxTag >> TagKindBits // largest ID that can be packed
+)
+
+// MakeTag packs id and kind into a tag, with kind in the low-order
+// TagKindBits bits. It returns -1 if id > MaxTagID or kind > MaxTagKind; the
+// encoder reports ErrTagTooLarge for such a tag.
+func MakeTag(id, kind uint) int {
+	if id > MaxTagID || kind > MaxTagKind {
+		return -1
+	}
+	return int(id<<TagKindBits | kind)
+}
+
+// SplitTag unpacks a tag constructed by MakeTag into its id and kind.
+// It is the inverse of MakeTag for valid tags.
+func SplitTag(tag int) (id, kind uint) {
+	return uint(tag) >> TagKindBits, uint(tag) & MaxTagKind
+}
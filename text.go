@@ -0,0 +1,157 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// MarshalText encodes m in the binpack text format, and implements the
+// encoding.TextMarshaler interface.
+//
+// The text format is a sequence of records, each of which has one of the
+// forms
+//
+//	tag: "string"
+//	tag: 0xhex
+//	tag { records... }
+//
+// where tag is a decimal integer, "string" is a Go string literal (either
+// double-quoted or backquoted), and 0xhex is a hexadecimal byte string. The
+// third form denotes a record whose value is the encoding of the nested
+// records between the braces. Comments in Go syntax are ignored.
+//
+// MarshalText writes one record per line, and renders each value as a string
+// literal if it is valid printable UTF-8, otherwise in hexadecimal. Nested
+// messages are not detected, since the format does not record types.
+func (m Message) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, r := range m {
+		if r.Tag < 0 || r.Tag > wire.MaxTag {
+			return nil, fmt.Errorf("tag out of range (%d not in 0..%d)", r.Tag, wire.MaxTag)
+		}
+		fmt.Fprintf(&buf, "%d: %s\n", r.Tag, textValue(r.Value))
+	}
+	return buf.Bytes(), nil
+}
+
+// textValue renders value in the text format.
+func textValue(value []byte) string {
+	if len(value) == 0 || IsText(value) {
+		return strconv.Quote(string(value))
+	}
+	return "0x" + hex.EncodeToString(value)
+}
+
+// IsText reports whether value is valid UTF-8 consisting of printable
+// characters and spaces. The text format, and tools that display values,
+// use this to decide whether to render a value as a string.
+func IsText(value []byte) bool {
+	return utf8.Valid(value) && strings.IndexFunc(string(value), func(r rune) bool {
+		return !unicode.IsPrint(r) && !unicode.IsSpace(r)
+	}) < 0
+}
+
+// UnmarshalText decodes data in the binpack text format into m, and
+// implements the encoding.TextUnmarshaler interface. See MarshalText for a
+// description of the format.
+func (m *Message) UnmarshalText(data []byte) error {
+	var s scanner.Scanner
+	s.Init(bytes.NewReader(data))
+	s.Mode = scanner.GoTokens
+	var perr error
+	s.Error = func(s *scanner.Scanner, msg string) {
+		if perr == nil {
+			perr = fmt.Errorf("%s: %s", s.Position, msg)
+		}
+	}
+	msg, err := parseText(&s, false)
+	if perr != nil {
+		return perr
+	} else if err != nil {
+		return err
+	}
+	*m = msg
+	return nil
+}
+
+// parseText parses a sequence of text records from s. If nested is true, the
+// sequence must be terminated by a close brace; otherwise by end of input.
+func parseText(s *scanner.Scanner, nested bool) (Message, error) {
+	var m Message
+	for {
+		switch tok := s.Scan(); tok {
+		case scanner.EOF:
+			if nested {
+				return nil, fmt.Errorf("%s: unexpected end of input in nested message", s.Position)
+			}
+			return m, nil
+		case '}':
+			if !nested {
+				return nil, fmt.Errorf("%s: unexpected %q", s.Position, "}")
+			}
+			return m, nil
+		case scanner.Int:
+			tag, err := strconv.Atoi(s.TokenText())
+			if err != nil || tag > wire.MaxTag {
+				return nil, fmt.Errorf("%s: invalid tag %q", s.Position, s.TokenText())
+			}
+			value, err := parseTextValue(s)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, Record{Tag: tag, Value: value})
+		default:
+			return nil, fmt.Errorf("%s: expected tag, got %q", s.Position, s.TokenText())
+		}
+	}
+}
+
+// parseTextValue parses the value of a record following its tag.
+func parseTextValue(s *scanner.Scanner) ([]byte, error) {
+	switch tok := s.Scan(); tok {
+	case '{':
+		sub, err := parseText(s, true)
+		if err != nil {
+			return nil, err
+		}
+		e := NewEncoder(nil)
+		if err := sub.Encode(e); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Position, err)
+		}
+		return e.Data.Bytes(), nil
+	case ':':
+	default:
+		return nil, fmt.Errorf("%s: expected %q or %q, got %q", s.Position, ":", "{", s.TokenText())
+	}
+
+	switch tok := s.Scan(); tok {
+	case scanner.String, scanner.RawString:
+		v, err := strconv.Unquote(s.TokenText())
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid string: %v", s.Position, err)
+		}
+		return []byte(v), nil
+	case scanner.Int:
+		text := s.TokenText()
+		if !strings.HasPrefix(text, "0x") && !strings.HasPrefix(text, "0X") {
+			return nil, fmt.Errorf("%s: invalid value %q", s.Position, text)
+		}
+		v, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid hex value: %v", s.Position, err)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("%s: expected value, got %q", s.Position, s.TokenText())
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMessageText(t *testing.T) {
+	const input = `
+// A comment is ignored.
+1: "hello, world"
+2: 0x00ff
+3 {
+  1: "nested"
+  2 { 5: "" }
+}
+4: ` + "`raw`\n"
+
+	var m binpack.Message
+	if err := m.UnmarshalText([]byte(input)); err != nil {
+		t.Fatalf("UnmarshalText: unexpected error: %v", err)
+	}
+	want := binpack.Message{
+		{Tag: 1, Value: []byte("hello, world")},
+		{Tag: 2, Value: []byte{0, 255}},
+		{Tag: 3, Value: []byte("\x01\x86nested\x02\x82\x05\x80")},
+		{Tag: 4, Value: []byte("raw")},
+	}
+	if diff := cmp.Diff(want, m); diff != "" {
+		t.Errorf("UnmarshalText (-want, +got):\n%s", diff)
+	}
+
+	text, err := m.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText: unexpected error: %v", err)
+	}
+	const wantText = `1: "hello, world"
+2: 0x00ff
+3: 0x01866e657374656402820580
+4: "raw"
+`
+	if got := string(text); got != wantText {
+		t.Errorf("MarshalText: got %q, want %q", got, wantText)
+	}
+
+	var rt binpack.Message
+	if err := rt.UnmarshalText(text); err != nil {
+		t.Fatalf("UnmarshalText(%q): unexpected error: %v", text, err)
+	}
+	if diff := cmp.Diff(m, rt); diff != "" {
+		t.Errorf("Round trip (-want, +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{
+		`1`, `1:`, `1: 25`, `x: "y"`, `1: 0xabc`, `1 { 2: "x"`, `}`, `-1: "x"`,
+	} {
+		var m binpack.Message
+		if err := m.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q): got %+v, want error", bad, m)
+		}
+	}
+}
+
+func TestMessageField(t *testing.T) {
+	type envelope struct {
+		Kind string          `binpack:"tag=1"`
+		Body binpack.Message `binpack:"tag=2"`
+	}
+	in := &envelope{
+		Kind: "note",
+		Body: binpack.Message{
+			{Tag: 1, Value: []byte("alpha")},
+			{Tag: 5, Value: []byte{0, 1, 2}},
+		},
+	}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	out := new(envelope)
+	if err := binpack.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	const want = `{1: "alpha" 5: 0x000102}`
+	if got := out.Body.String(); got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
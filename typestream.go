@@ -0,0 +1,299 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// TypeDescriptorTag is the tag of a type descriptor record in a type stream
+// (see TypeStreamEncoder).
+const TypeDescriptorTag = wire.TypeDescriptorTag
+
+// ErrUnknownType is reported by a TypeStreamDecoder for a value whose type
+// has not been described by the stream.
+var ErrUnknownType = errors.New("unknown type")
+
+// A typeDescriptor is the value of a type descriptor record.
+type typeDescriptor struct {
+	ID     int               `binpack:"tag=1"`
+	Name   string            `binpack:"tag=2"`
+	Fields []fieldDescriptor `binpack:"tag=3"`
+}
+
+// A fieldDescriptor describes one field of a typeDescriptor.
+type fieldDescriptor struct {
+	Name       string `binpack:"tag=1"`
+	Tag        int    `binpack:"tag=2"`
+	Kind       int    `binpack:"tag=3"`
+	Repeated   bool   `binpack:"tag=4"`
+	Message    int    `binpack:"tag=5"` // type ID, for KindMessage
+	Deprecated bool   `binpack:"tag=6"`
+	Width      int    `binpack:"tag=7"`
+	Nilable    bool   `binpack:"tag=8"`
+}
+
+// A TypeStreamEncoder writes values of many struct types to a single stream,
+// in the manner of encoding/gob. The first time a value of a given type is
+// written, the encoder writes a type descriptor record giving the type a
+// small integer ID, its name (see RegisterType), and its Schema. Each value
+// is then written as a record whose tag is the ID of its type, and whose
+// value is the encoding of the value as by Marshal.
+//
+// A TypeStreamDecoder can read the resulting stream without knowing the
+// types in advance. The types of values must be supported by SchemaOf.
+type TypeStreamEncoder struct {
+	enc    *Encoder
+	seen   map[reflect.Type]*Schema
+	ids    map[*Schema]int
+	nextID int
+}
+
+// NewTypeStreamEncoder constructs a TypeStreamEncoder that writes records to
+// e. The stream must not have been written by another TypeStreamEncoder.
+func NewTypeStreamEncoder(e *Encoder) *TypeStreamEncoder {
+	return &TypeStreamEncoder{
+		enc:    e,
+		seen:   make(map[reflect.Type]*Schema),
+		ids:    make(map[*Schema]int),
+		nextID: 1,
+	}
+}
+
+// Encode writes v, which must be a struct or a pointer to a struct, to the
+// stream. If this is the first value of its type, Encode first writes type
+// descriptors for the type and any message types it contains.
+func (e *TypeStreamEncoder) Encode(v interface{}) error {
+	typ := reflect.TypeOf(v)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("type %T is not a struct or pointer to struct", v)
+	}
+	id, err := e.typeID(typ)
+	if err != nil {
+		return err
+	}
+	bits, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(id, bits)
+}
+
+// typeID returns the ID of the struct type typ, writing descriptors for it
+// and its message types if they have not already been written.
+func (e *TypeStreamEncoder) typeID(typ reflect.Type) (int, error) {
+	if s, ok := e.seen[typ]; ok {
+		return e.ids[s], nil
+	}
+
+	// Construct the schema in a copy of the seen map, so that a type whose
+	// schema is invalid does not leave a partial schema behind.
+	seen := make(map[reflect.Type]*Schema, len(e.seen)+1)
+	for t, s := range e.seen {
+		seen[t] = s
+	}
+	s, err := schemaOfType(typ, seen)
+	if err != nil {
+		return 0, fmt.Errorf("type %v: %w", typ, err)
+	}
+	names := make(map[*Schema]string)
+	for t, s := range seen {
+		names[s] = typeName(t)
+	}
+	id, err := e.define(s, names)
+	if err != nil {
+		return 0, err
+	}
+	e.seen = seen
+	return id, nil
+}
+
+// define writes descriptors for s and the message types it contains that do
+// not already have IDs, and returns the ID of s. Message types are described
+// before the types that contain them, except in recursive types.
+func (e *TypeStreamEncoder) define(s *Schema, names map[*Schema]string) (int, error) {
+	if id, ok := e.ids[s]; ok {
+		return id, nil
+	} else if e.nextID >= TypeDescriptorTag {
+		return 0, errors.New("too many types in stream")
+	}
+	id := e.nextID
+	e.nextID++
+	e.ids[s] = id
+
+	td := typeDescriptor{ID: id, Name: names[s]}
+	for _, f := range s.Fields {
+		fd := fieldDescriptor{
+			Name:       f.Name,
+			Tag:        f.Tag,
+			Kind:       int(f.Kind),
+			Repeated:   f.Repeated,
+			Deprecated: f.Deprecated,
+			Width:      f.Width,
+			Nilable:    f.Nilable,
+		}
+		if f.Message != nil {
+			mid, err := e.define(f.Message, names)
+			if err != nil {
+				return 0, err
+			}
+			fd.Message = mid
+		}
+		td.Fields = append(td.Fields, fd)
+	}
+	bits, err := Marshal(&td)
+	if err != nil {
+		return 0, err
+	}
+	return id, e.enc.Encode(TypeDescriptorTag, bits)
+}
+
+// typeName returns the name recorded in a type descriptor for typ, which is
+// its registered name if it has one (see RegisterType).
+func typeName(typ reflect.Type) string {
+	streamTypes.Lock()
+	name, ok := streamTypes.byType[typ]
+	streamTypes.Unlock()
+	if ok {
+		return name
+	}
+	return defaultTypeName(typ)
+}
+
+// defaultTypeName returns the default name of typ.
+func defaultTypeName(typ reflect.Type) string {
+	if typ.Name() != "" && typ.PkgPath() != "" {
+		return typ.PkgPath() + "." + typ.Name()
+	}
+	return typ.String()
+}
+
+// A TypedValue is a value read from a type stream by a TypeStreamDecoder.
+type TypedValue struct {
+	TypeID   int     // the ID of the type in the stream
+	TypeName string  // the name of the type, e.g., "example.com/pkg.Event"
+	Schema   *Schema // the schema of the type
+	Data     []byte  // the encoded value
+}
+
+// Dynamic decodes the value of v into a new DynamicMessage with its schema.
+func (v *TypedValue) Dynamic() (*DynamicMessage, error) {
+	m := NewDynamicMessage(v.Schema)
+	if err := m.UnmarshalBinary(v.Data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Unmarshal decodes the value of v into out, as by the Unmarshal function.
+func (v *TypedValue) Unmarshal(out interface{}) error { return Unmarshal(v.Data, out) }
+
+// A TypeStreamDecoder reads the values of a stream written by a
+// TypeStreamEncoder, using the type descriptors in the stream.
+type TypeStreamDecoder struct {
+	dec       *Decoder
+	types     map[int]*streamType
+	undefined int // the number of types referenced but not yet described
+}
+
+// A streamType is a type described by a type stream.
+type streamType struct {
+	name    string
+	schema  *Schema
+	defined bool
+}
+
+// NewTypeStreamDecoder constructs a TypeStreamDecoder that reads records from d.
+func NewTypeStreamDecoder(d *Decoder) *TypeStreamDecoder {
+	return &TypeStreamDecoder{dec: d, types: make(map[int]*streamType)}
+}
+
+// Next returns the next value from the stream, reading any type descriptors
+// that precede it. At the end of the input, it returns io.EOF. It reports an
+// error wrapping ErrUnknownType if the type of a value, or of a message it
+// contains, has not been described.
+func (d *TypeStreamDecoder) Next() (*TypedValue, error) {
+	for {
+		tag, value, err := d.dec.Decode()
+		if err != nil {
+			return nil, err
+		} else if tag == TypeDescriptorTag {
+			if err := d.define(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		st, ok := d.types[tag]
+		if !ok || !st.defined {
+			return nil, fmt.Errorf("%w: type ID %d", ErrUnknownType, tag)
+		} else if d.undefined != 0 {
+			return nil, fmt.Errorf("%w: value of %q refers to %d undescribed types",
+				ErrUnknownType, st.name, d.undefined)
+		}
+		return &TypedValue{TypeID: tag, TypeName: st.name, Schema: st.schema, Data: value}, nil
+	}
+}
+
+// define records the type described by the descriptor encoded in data.
+func (d *TypeStreamDecoder) define(data []byte) error {
+	var td typeDescriptor
+	if err := Unmarshal(data, &td); err != nil {
+		return fmt.Errorf("invalid type descriptor: %w", err)
+	} else if td.ID <= 0 || td.ID >= TypeDescriptorTag {
+		return fmt.Errorf("invalid type descriptor: type ID %d out of range", td.ID)
+	}
+	st := d.lookup(td.ID)
+	if st.defined {
+		return fmt.Errorf("invalid type descriptor: duplicate type ID %d", td.ID)
+	}
+
+	var fields []*SchemaField
+	for _, fd := range td.Fields {
+		f := &SchemaField{
+			Name:       fd.Name,
+			Tag:        fd.Tag,
+			Kind:       Kind(fd.Kind),
+			Repeated:   fd.Repeated,
+			Deprecated: fd.Deprecated,
+			Width:      fd.Width,
+			Nilable:    fd.Nilable,
+		}
+		if f.Kind < KindBytes || f.Kind > KindMessage {
+			return fmt.Errorf("invalid type descriptor: field %q has %v", f.Name, f.Kind)
+		} else if f.Kind == KindMessage {
+			if fd.Message <= 0 || fd.Message >= TypeDescriptorTag {
+				return fmt.Errorf("invalid type descriptor: field %q has message type ID %d", f.Name, fd.Message)
+			}
+			f.Message = d.lookup(fd.Message).schema
+		}
+		fields = append(fields, f)
+	}
+	s := &Schema{Fields: fields}
+	if err := s.check(); err != nil {
+		return fmt.Errorf("invalid type descriptor: %w", err)
+	}
+	st.schema.Fields = fields
+	st.name = td.Name
+	st.defined = true
+	d.undefined--
+	return nil
+}
+
+// lookup returns the type with the given ID, adding an undefined type if it
+// has not been seen, so that descriptors can refer to types described later.
+func (d *TypeStreamDecoder) lookup(id int) *streamType {
+	st, ok := d.types[id]
+	if !ok {
+		st = &streamType{schema: new(Schema)}
+		d.types[id] = st
+		d.undefined++
+	}
+	return st
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type loginEvent struct {
+	User  string       `binpack:"tag=1"`
+	Where *schemaPoint `binpack:"tag=2"`
+}
+
+type logoutEvent struct {
+	User    string `binpack:"tag=1"`
+	Seconds uint64 `binpack:"tag=2"`
+}
+
+func TestTypeStream(t *testing.T) {
+	var buf bytes.Buffer
+	e := binpack.NewTypeStreamEncoder(binpack.NewEncoder(&buf))
+	values := []interface{}{
+		&loginEvent{User: "alice", Where: &schemaPoint{X: 1, Y: 2}},
+		logoutEvent{User: "alice", Seconds: 30},
+		&schemaThing{Name: "root", Kids: []*schemaThing{{Name: "kid"}}},
+		&loginEvent{User: "bob"},
+	}
+	for _, v := range values {
+		if err := e.Encode(v); err != nil {
+			t.Fatalf("Encode(%+v): unexpected error: %v", v, err)
+		}
+	}
+	if err := e.Encode(map[string]int{}); err == nil {
+		t.Error("Encode(map): got nil, want error")
+	}
+
+	// Each type is described once, before its first value, and message types
+	// are described before the types that refer to them.
+	var tags []int
+	d := binpack.NewBytesDecoder(buf.Bytes())
+	for {
+		tag, _, err := d.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode: unexpected error: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	const desc = binpack.TypeDescriptorTag
+	if diff := cmp.Diff([]int{desc, desc, 1, desc, 3, desc, 4, 1}, tags); diff != "" {
+		t.Errorf("Stream tags (-want, +got):\n%s", diff)
+	}
+
+	type result struct {
+		ID   int
+		Name string
+		User interface{}
+	}
+	var got []result
+	td := binpack.NewTypeStreamDecoder(binpack.NewBytesDecoder(buf.Bytes()))
+	for {
+		v, err := td.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: unexpected error: %v", err)
+		}
+		m, err := v.Dynamic()
+		if err != nil {
+			t.Fatalf("Dynamic: unexpected error: %v", err)
+		}
+		field := "User"
+		if m.Schema().FieldByName(field) == nil {
+			field = "Name"
+		}
+		user, err := m.GetByName(field)
+		if err != nil {
+			t.Fatalf("GetByName(%q): unexpected error: %v", field, err)
+		}
+		got = append(got, result{ID: v.TypeID, Name: v.TypeName, User: user})
+
+		if v.TypeID == 4 {
+			var thing schemaThing
+			if err := v.Unmarshal(&thing); err != nil {
+				t.Fatalf("Unmarshal: unexpected error: %v", err)
+			} else if len(thing.Kids) != 1 || thing.Kids[0].Name != "kid" {
+				t.Errorf("Unmarshal: got %+v, want one kid", thing)
+			}
+		}
+	}
+	const pkg = "github.com/creachadair/binpack_test."
+	if diff := cmp.Diff([]result{
+		{1, pkg + "loginEvent", "alice"},
+		{3, pkg + "logoutEvent", "alice"},
+		{4, pkg + "schemaThing", "root"},
+		{1, pkg + "loginEvent", "bob"},
+	}, got); diff != "" {
+		t.Errorf("Decoded values (-want, +got):\n%s", diff)
+	}
+
+	// A value whose type has not been described is an error.
+	var bad bytes.Buffer
+	if err := binpack.NewEncoder(&bad).Encode(1, []byte("x")); err != nil {
+		t.Fatalf("Encode: unexpected error: %v", err)
+	}
+	td = binpack.NewTypeStreamDecoder(binpack.NewBytesDecoder(bad.Bytes()))
+	if _, err := td.Next(); !errors.Is(err, binpack.ErrUnknownType) {
+		t.Errorf("Next: got %v, want %v", err, binpack.ErrUnknownType)
+	}
+}
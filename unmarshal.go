@@ -3,12 +3,14 @@
 package binpack
 
 import (
-	"bytes"
 	"encoding"
 	"errors"
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
 )
 
 // Unmarshal decodes data from binpack format into v.
@@ -16,28 +18,287 @@ import (
 //
 // Because the binpack format does not record type information, unmarshaling
 // into an untyped interface will produce the input data unmodified.
-func Unmarshal(data []byte, v interface{}) error {
+//
+// By default, Unmarshal merges data into the existing contents of v: Fields
+// with no records in data are not modified, and records for slice and map
+// fields are added to the existing elements. Use an UnmarshalOptions with
+// ResetTargets set to clear the fields of v before decoding.
+func Unmarshal(data []byte, v interface{}) error { return (*UnmarshalOptions)(nil).Unmarshal(data, v) }
+
+// UnmarshalOptions control the decoding of values by Unmarshal.
+// A nil *UnmarshalOptions provides default values.
+type UnmarshalOptions struct {
+	// If non-nil, Alloc is called to obtain storage for the contents of each
+	// []byte and string value produced by decoding, including map keys and
+	// values. It must return a slice of length n. This allows the caller to
+	// allocate decoded data from an arena that is released all at once.
+	//
+	// Strings decoded using Alloc share storage with the slices it returns,
+	// so the caller must not modify or reuse that storage while the decoded
+	// values are in use. Alloc is not used for the backing arrays of other
+	// slices or maps, nor for values decoded by an UnmarshalBinary method.
+	Alloc func(n int) []byte
+
+	// If true, decoded strings share storage with the input data rather than
+	// copying it, so that decoding a string does not allocate. The caller
+	// must not modify the input data while the decoded values are in use.
+	// This takes precedence over Alloc for strings.
+	UnsafeStrings bool
+
+	// If true, decoded []byte values alias the input data rather than
+	// copying it. The caller must not modify or reuse the input data while
+	// the decoded values are in use. This takes precedence over Alloc for
+	// []byte values.
+	AliasBytes bool
+
+	// If true, record tags are decoded as by SplitTag, and the wire kind is
+	// ignored. This must be set to decode data encoded with
+	// MarshalOptions.SelfDescribing.
+	SelfDescribing bool
+
+	// If positive, records for fields whose since and until bounds do not
+	// include Version are ignored. If zero, all fields are decoded.
+	Version int
+
+	// If non-nil, Deprecated is called with the name and tag of a field
+	// marked deprecated, each time a record for that field is decoded. This
+	// allows a service to observe uses of old fields before removing them.
+	// Fields are marked deprecated by a struct tag option (see Marshal) or
+	// by the Deprecated flag of a SchemaField.
+	Deprecated func(name string, tag int)
+
+	// If true, each tagged field of a struct is set to its zero value before
+	// the struct is decoded, so that the result does not depend on the prior
+	// contents of the value. Otherwise, data is merged into the existing
+	// contents (see Unmarshal). This applies to nested structs as well, so a
+	// struct field whose tag occurs more than once holds only the last value.
+	// Fields outside the bounds of Version (if set) cannot be decoded, so
+	// they are not reset.
+	ResetTargets bool
+
+	// Duplicates selects how Unmarshal handles a struct field that is not a
+	// sequence, when its tag occurs more than once. The default,
+	// KeepLastDuplicate, decodes each record in turn so that the last one
+	// wins. A sequence field (a slice other than []byte, or a map) collects
+	// all its records regardless.
+	//
+	// Duplicates also applies to a map key that occurs in more than one
+	// entry. A key already present in the map before decoding is treated as
+	// if it occurred in an earlier entry.
+	Duplicates DuplicatePolicy
+
+	// If true, Unmarshal reports ErrInvalidUTF8 for a string value that is
+	// not valid UTF-8, including map keys and strings decoded in
+	// self-describing mode. Values of type []byte are not checked.
+	ValidateUTF8 bool
+
+	// If true, each invalid UTF-8 sequence in a decoded string value is
+	// replaced by the replacement character U+FFFD, as by
+	// strings.ToValidUTF8. Such a string is newly allocated, regardless of
+	// UnsafeStrings and Alloc. This takes precedence over ValidateUTF8.
+	ReplaceInvalidUTF8 bool
+
+	// If positive, MaxAlloc limits the total number of bytes allocated by a
+	// single call to Unmarshal, and Unmarshal reports ErrAllocLimit if it is
+	// exceeded. This protects against messages composed of many values, each
+	// of modest size. The total includes the contents of []byte and string
+	// values that are copied, and the size of each slice element and map
+	// entry; it is an estimate, as it does not include the overhead of
+	// growing slices and maps.
+	MaxAlloc int
+
+	// If true, a map whose values are slices (other than []byte) is decoded
+	// as a multi-map: The value of each entry is decoded as a single element
+	// and appended to the slice for its key, so that all the values of a
+	// repeated key are preserved. Duplicates does not apply to such maps. See MarshalOptions.MultiMap.
+	MultiMap bool
+
+	// If true, a struct field of type []byte is also decoded from the legacy
+	// encoding written by earlier versions, which had one record per byte
+	// (see Marshal): When the first record of such a field has a single byte,
+	// each later single-byte record for the field is appended to its value
+	// rather than treated as a duplicate. Longer records are decoded as
+	// usual. Since a single-byte value in the current encoding cannot be
+	// distinguished from a legacy record, this is not the default. It also
+	// applies to KindBytes fields decoded by UnmarshalMap and
+	// UnmarshalDynamic.
+	LegacyBytes bool
+
+	// If true, Unmarshal stops reading the records of a struct as soon as
+	// every field of the struct has been assigned a value, and ignores the
+	// remaining records without checking them. This applies only to structs
+	// with no sequence fields (nor []byte fields, if LegacyBytes is set), and
+	// avoids a full pass over long messages whose interesting fields are
+	// encoded first. Since the records after that point are not read, a later
+	// duplicate of a field is not applied or reported, regardless of
+	// Duplicates.
+	StopWhenFilled bool
+
+	// If true, Unmarshal reports ErrTagOrder if the records of a struct are
+	// not in non-decreasing order by tag, as Marshal encodes them. This
+	// detects corrupted or reordered messages. In self-describing mode, the
+	// order is by field ID, as reported by SplitTag.
+	StrictOrder bool
+
+	// If true, Unmarshal reports ErrOutOfRange for an integer value that
+	// does not fit in its destination type, such as 70000 decoded into an
+	// int16, and leaves the destination unmodified. Otherwise, such values
+	// are silently truncated to the width of the destination.
+	CheckRange bool
+
+	// If non-nil, FixScalar is called to decode a value for a bool or byte
+	// destination whose encoding is not valid, instead of reporting an
+	// error. A valid encoding is a single byte, and for a bool, a byte that
+	// is either 0 or 1; other single bytes are otherwise decoded as true,
+	// but are passed to FixScalar when it is set. The argument v is the
+	// destination, a *bool or *byte, and data is the encoded value.
+	//
+	// FixScalar may store a value through v and return nil, or return an
+	// error, which Unmarshal reports. This allows legacy data from a faulty
+	// producer, such as bools encoded in two bytes, to be decoded without a
+	// separate pass to repair it.
+	FixScalar func(v interface{}, data []byte) error
+}
+
+// A DuplicatePolicy selects how Unmarshal handles several records for a
+// struct field that is not a sequence, or several entries with the same map
+// key. See UnmarshalOptions.Duplicates.
+type DuplicatePolicy int
+
+// Constants defining the duplicate policies.
+const (
+	KeepLastDuplicate  DuplicatePolicy = iota // decode each record, so the last wins
+	KeepFirstDuplicate                        // decode only the first record
+	RejectDuplicate                           // report ErrDuplicateField
+)
+
+func (p DuplicatePolicy) String() string {
+	switch p {
+	case KeepLastDuplicate:
+		return "KeepLastDuplicate"
+	case KeepFirstDuplicate:
+		return "KeepFirstDuplicate"
+	case RejectDuplicate:
+		return "RejectDuplicate"
+	}
+	return fmt.Sprintf("DuplicatePolicy(%d)", int(p))
+}
+
+// Unmarshal decodes data into v as by the Unmarshal function, using the
+// settings from o.
+//
+// If o.SelfDescribing is true, v may also be a *interface{} or a
+// *map[int]interface{}, which is populated with a map from field tag to the
+// decoded value of each field. See DecodeGeneric for details.
+func (o *UnmarshalOptions) Unmarshal(data []byte, v interface{}) error {
+	u := o.unmarshaler()
+	if u.selfDescribing {
+		switch t := v.(type) {
+		case *interface{}:
+			m, err := u.decodeMessage(data)
+			if err != nil {
+				return err
+			}
+			*t = m
+			return nil
+		case *map[int]interface{}:
+			m, err := u.decodeMessage(data)
+			if err != nil {
+				return err
+			}
+			*t = m
+			return nil
+		}
+	}
+	return u.unmarshal(data, v)
+}
+
+func (o *UnmarshalOptions) unmarshaler() *unmarshaler {
+	if o == nil {
+		return new(unmarshaler)
+	}
+	return &unmarshaler{
+		alloc:          o.Alloc,
+		unsafeStrings:  o.UnsafeStrings,
+		aliasBytes:     o.AliasBytes,
+		selfDescribing: o.SelfDescribing,
+		version:        o.Version,
+		deprecated:     o.Deprecated,
+		resetTargets:   o.ResetTargets,
+		duplicates:     o.Duplicates,
+		multiMap:       o.MultiMap,
+		legacyBytes:    o.LegacyBytes,
+		maxAlloc:       o.MaxAlloc,
+		validateUTF8:   o.ValidateUTF8,
+		replaceUTF8:    o.ReplaceInvalidUTF8,
+		stopFilled:     o.StopWhenFilled,
+		strictOrder:    o.StrictOrder,
+		checkRange:     o.CheckRange,
+		fixScalar:      o.FixScalar,
+	}
+}
+
+// An unmarshaler carries the settings for a decoding operation.
+type unmarshaler struct {
+	alloc          func(n int) []byte              // if non-nil, allocates byte and string storage
+	unsafeStrings  bool                            // if true, strings alias the input
+	aliasBytes     bool                            // if true, byte slices alias the input
+	selfDescribing bool                            // if true, tags include wire kinds
+	version        int                             // if nonzero, the message version to decode
+	deprecated     func(string, int)               // if non-nil, reports deprecated fields
+	resetTargets   bool                            // if true, zero struct fields before decoding
+	duplicates     DuplicatePolicy                 // how to handle repeated non-sequence fields
+	multiMap       bool                            // decode slice-valued maps as multi-maps
+	legacyBytes    bool                            // accept per-byte records for []byte fields
+	maxAlloc       int                             // if positive, the allocation budget in bytes
+	allocated      int                             // bytes allocated so far
+	validateUTF8   bool                            // reject strings that are not valid UTF-8
+	replaceUTF8    bool                            // replace invalid UTF-8 in strings
+	stopFilled     bool                            // stop when all struct fields are set
+	strictOrder    bool                            // require struct records in tag order
+	checkRange     bool                            // reject integers that do not fit
+	fixScalar      func(interface{}, []byte) error // if non-nil, repairs bools and bytes
+}
+
+func (u *unmarshaler) unmarshal(data []byte, v interface{}) error {
 	switch t := v.(type) {
 	case encoding.BinaryUnmarshaler:
 		return t.UnmarshalBinary(data)
 	case *byte:
 		b, ok := oneByte(data)
-		if !ok {
+		if !ok && u.fixScalar != nil {
+			return u.fixScalar(t, data)
+		} else if !ok {
 			return errors.New("invalid encoding of byte")
 		}
 		*t = b
 		return nil
 	case *[]byte:
-		*t = copyOf(data)
+		b, err := u.bytesOf(data)
+		if err != nil {
+			return err
+		}
+		*t = b
 		return nil
 	case *interface{}:
-		*t = copyOf(data)
+		b, err := u.bytesOf(data)
+		if err != nil {
+			return err
+		}
+		*t = b
+		return nil
 	case *string:
-		*t = string(data)
+		str, err := u.stringOf(data)
+		if err != nil {
+			return err
+		}
+		*t = str
 		return nil
 	case *bool:
 		b, ok := oneByte(data)
-		if !ok {
+		if u.fixScalar != nil && (!ok || b > 1) {
+			return u.fixScalar(t, data)
+		} else if !ok {
 			return errors.New("invalid encoding of bool")
 		}
 		*t = b != 0
@@ -45,6 +306,17 @@ func Unmarshal(data []byte, v interface{}) error {
 	case nil:
 		return errors.New("cannot unmarshal into nil")
 	}
+	if f, ok := v.(*float32); ok && u.selfDescribing {
+		*f = float32(UnpackFloat64(data))
+		if len(data) == 0 || len(data) > 8 {
+			return ErrInvalidNumber
+		}
+		return nil
+	} else if u.checkRange {
+		if err := checkNumberRange(data, v); err != nil {
+			return err
+		}
+	}
 	if ok, err := unmarshalNumber(data, v); ok {
 		return err
 	}
@@ -56,18 +328,18 @@ func Unmarshal(data []byte, v interface{}) error {
 	} else if typ.Elem().Kind() == reflect.Ptr {
 		// Pointer-to-pointer.
 		p := reflect.New(typ.Elem().Elem())
-		if err := Unmarshal(data, p.Interface()); err != nil {
+		if err := u.unmarshal(data, p.Interface()); err != nil {
 			return err
 		}
 		val.Elem().Set(p)
 		return nil
 	}
 	if kind := val.Elem().Type().Kind(); kind == reflect.Slice {
-		return unmarshalSlice(data, val)
+		return u.unmarshalSlice(data, val)
 	} else if kind == reflect.Struct {
-		return unmarshalStruct(data, val)
+		return u.unmarshalStruct(data, val)
 	} else if kind == reflect.Map {
-		return unmarshalMap(data, val)
+		return u.unmarshalMap(data, val)
 	}
 	return fmt.Errorf("type %T cannot be unmarshaled", v)
 }
@@ -112,17 +384,164 @@ func unmarshalNumber(data []byte, v interface{}) (bool, error) {
 	// N.B. We don't do this check till we know the target was actually a
 	// numeric type, since this might be fine for some other value.
 	if len(data) == 0 || len(data) > 8 {
-		return true, errors.New("invalid number encoding")
+		return true, ErrInvalidNumber
 	}
 	return true, nil
 }
 
+// checkNumberRange reports an error if v is a pointer to an integer type
+// narrower than 64 bits, and the value encoded by data does not fit in that
+// type.
+func checkNumberRange(data []byte, v interface{}) error {
+	if len(data) > 8 {
+		return nil // reported by unmarshalNumber
+	}
+	var fits bool
+	var z interface{}
+	switch v.(type) {
+	case *uint16, *uint32:
+		u := UnpackUint64(data)
+		fits, z = !reflect.ValueOf(v).Elem().OverflowUint(u), u
+	case *int, *int8, *int16, *int32:
+		n := UnpackInt64(data)
+		fits, z = !reflect.ValueOf(v).Elem().OverflowInt(n), n
+	default:
+		return nil
+	}
+	if !fits {
+		return fmt.Errorf("value %d does not fit in %v: %w", z, reflect.TypeOf(v).Elem(), ErrOutOfRange)
+	}
+	return nil
+}
+
 func copyOf(data []byte) []byte {
 	out := make([]byte, len(data))
 	copy(out, data)
 	return out
 }
 
+// copyOf returns a copy of data, allocated by u.alloc if it is set.
+func (u *unmarshaler) copyOf(data []byte) []byte {
+	if u.alloc == nil {
+		return copyOf(data)
+	}
+	out := u.alloc(len(data))
+	copy(out, data)
+	return out
+}
+
+// bytesOf returns a slice with the contents of data. The result is data
+// itself if u.aliasBytes is set, otherwise a copy as by u.copyOf, which is
+// charged to the allocation budget before it is made.
+func (u *unmarshaler) bytesOf(data []byte) ([]byte, error) {
+	if u.aliasBytes {
+		return data, nil
+	} else if err := u.allocate(len(data)); err != nil {
+		return nil, err
+	}
+	return u.copyOf(data), nil
+}
+
+// stringOf returns a string with the contents of data. The string shares
+// storage with data if u.unsafeStrings is set, otherwise its storage is
+// allocated by u.alloc if that is set.
+func (u *unmarshaler) stringOf(data []byte) (string, error) {
+	if (u.validateUTF8 || u.replaceUTF8) && !utf8.Valid(data) {
+		if !u.replaceUTF8 {
+			return "", ErrInvalidUTF8
+		}
+		return strings.ToValidUTF8(string(data), string(utf8.RuneError)), nil
+	}
+	if u.unsafeStrings {
+		return unsafeString(data), nil
+	} else if err := u.allocate(len(data)); err != nil {
+		return "", err
+	} else if u.alloc == nil {
+		return string(data), nil
+	}
+	return unsafeString(u.copyOf(data)), nil
+}
+
+// allocate charges n bytes to the allocation budget of u, and reports an
+// error if the budget is exceeded.
+func (u *unmarshaler) allocate(n int) error {
+	u.allocated += n
+	if u.maxAlloc > 0 && u.allocated > u.maxAlloc {
+		return fmt.Errorf("%w (%d bytes)", ErrAllocLimit, u.maxAlloc)
+	}
+	return nil
+}
+
+// nextValue parses the encoded value at the front of data, and returns the
+// value and the remainder of data. The value aliases data. At the end of the
+// input, nextValue returns io.EOF; if the value is incomplete, ErrTruncated.
+func nextValue(data []byte) (value, rest []byte, err error) {
+	if len(data) == 0 {
+		return nil, nil, io.EOF
+	}
+	var plen, dlen int
+	if b := data[0]; b < 0x80 {
+		dlen = 1 // 1-byte value, no length prefix
+	} else if b < 0xc0 {
+		plen, dlen = 1, int(b&0x3f)
+	} else if b < 0xe0 {
+		if len(data) < 2 {
+			return nil, nil, ErrTruncated
+		}
+		plen, dlen = 2, int(b&0x1f)<<8|int(data[1])
+	} else {
+		if len(data) < 4 {
+			return nil, nil, ErrTruncated
+		}
+		plen = 4
+		dlen = int(b&0x1f)<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	}
+	end := plen + dlen
+	if len(data) < end {
+		return nil, nil, ErrTruncated
+	}
+	return data[plen:end:end], data[end:], nil
+}
+
+// nextRecord parses the encoded record at the front of data, and returns its
+// tag and value and the remainder of data. The value aliases data. At the
+// end of the input, nextRecord returns io.EOF; if the record is incomplete,
+// ErrTruncated.
+func nextRecord(data []byte) (tag int, value, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil, io.EOF
+	}
+	var tlen int
+	switch b := data[0]; b >> 6 {
+	case 0, 1:
+		tag, tlen = int(b), 1
+	case 2:
+		if len(data) < 2 {
+			return 0, nil, nil, ErrTruncated
+		}
+		tag, tlen = int(b&0x3f)<<8|int(data[1]), 2
+	default:
+		if len(data) < 4 {
+			return 0, nil, nil, ErrTruncated
+		}
+		tag, tlen = int(b&0x3f)<<24|int(data[1])<<16|int(data[2])<<8|int(data[3]), 4
+	}
+	value, rest, err = nextValue(data[tlen:])
+	if err == io.EOF {
+		err = ErrTruncated
+	}
+	return tag, value, rest, err
+}
+
+// unsafeString returns a string that shares storage with data.
+// The caller must ensure data is not modified while the string is in use.
+func unsafeString(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	return *(*string)(unsafe.Pointer(&data))
+}
+
 func newElement(etype reflect.Type) (reflect.Value, bool) {
 	if etype.Kind() == reflect.Ptr {
 		return reflect.New(etype.Elem()), true
@@ -132,13 +551,16 @@ func newElement(etype reflect.Type) (reflect.Value, bool) {
 
 // unpackElement decodes a single value and appends it to a slice.
 // Precondition: val is a pointer to a reflect.Slice.
-func unpackElement(element []byte, val reflect.Value) error {
+func (u *unmarshaler) unpackElement(element []byte, val reflect.Value) error {
 	if val.IsZero() {
 		val.Set(reflect.New(val.Elem().Type()))
 	}
 	etype := val.Elem().Type().Elem()
+	if err := u.allocate(int(derefType(etype).Size())); err != nil {
+		return err
+	}
 	elt, isPtr := newElement(etype)
-	if err := Unmarshal(element, elt.Interface()); err != nil {
+	if err := u.unmarshal(element, elt.Interface()); err != nil {
 		return err
 	}
 	if !isPtr {
@@ -148,53 +570,164 @@ func unpackElement(element []byte, val reflect.Value) error {
 	return nil
 }
 
+// unpackOptional decodes a single pointer with the optional encoding (see
+// marshalOptional) and appends it to a slice.
+// Precondition: val is a pointer to a reflect.Slice of pointers.
+func (u *unmarshaler) unpackOptional(element []byte, val reflect.Value) error {
+	if len(element) != 0 {
+		if element[0] != optionalPresent {
+			return errInvalidOptional
+		}
+		return u.unpackElement(element[1:], val)
+	}
+	if val.IsZero() {
+		val.Set(reflect.New(val.Elem().Type()))
+	}
+	nilElem := reflect.Zero(val.Elem().Type().Elem())
+	val.Elem().Set(reflect.Append(val.Elem(), nilElem))
+	return nil
+}
+
+// unmarshalOptional decodes a pointer with the optional encoding (see
+// marshalOptional) into *target, which is set to nil for an empty value.
+// Precondition: target is a pointer to a pointer.
+func (u *unmarshaler) unmarshalOptional(data []byte, target reflect.Value) error {
+	if len(data) == 0 {
+		target.Elem().Set(reflect.Zero(target.Elem().Type()))
+		return nil
+	} else if data[0] != optionalPresent {
+		return errInvalidOptional
+	}
+	return u.unmarshal(data[1:], target.Interface())
+}
+
+var errInvalidOptional = errors.New("invalid encoding of optional value")
+
+// unpackFloat decodes a single float encoded by marshalFloat with the given
+// width, and appends it to a slice.
+// Precondition: val is a pointer to a reflect.Slice of floats.
+func (u *unmarshaler) unpackFloat(element []byte, val reflect.Value, width int) error {
+	etype := val.Elem().Type().Elem()
+	if err := u.allocate(int(etype.Size())); err != nil {
+		return err
+	}
+	elt := reflect.New(etype)
+	if err := unmarshalFloat(element, elt, width); err != nil {
+		return err
+	}
+	val.Elem().Set(reflect.Append(val.Elem(), elt.Elem()))
+	return nil
+}
+
+// unmarshalFloat decodes a float encoded by marshalFloat with the given width
+// into *target.
+// Precondition: target is a pointer to a float32 or float64.
+func unmarshalFloat(data []byte, target reflect.Value, width int) error {
+	f, err := unpackFloatWidth(data, width)
+	if err != nil {
+		return err
+	}
+	target.Elem().SetFloat(f)
+	return nil
+}
+
+// unpackFloatWidth decodes a float encoded by marshalFloat with the given
+// width.
+func unpackFloatWidth(data []byte, width int) (float64, error) {
+	if len(data) == 0 || len(data) > width {
+		return 0, ErrInvalidNumber
+	}
+	switch width {
+	case 2:
+		return float64(UnpackFloat16(data)), nil
+	case 4:
+		return float64(UnpackFloat32(data)), nil
+	default:
+		return UnpackFloat64(data), nil
+	}
+}
+
 // unmarshalSlice decodes into a slice from a packed array. The values are
-// appended to the current contents of val.
+// appended to the current contents of val. An empty array produces an empty
+// slice, not nil, as for maps and []byte values.
 // Precondition: val is a pointer to a reflect.Slice.
-func unmarshalSlice(data []byte, val reflect.Value) error {
-	buf := bytes.NewReader(data)
+func (u *unmarshaler) unmarshalSlice(data []byte, val reflect.Value) error {
+	if val.Elem().IsNil() {
+		val.Elem().Set(reflect.MakeSlice(val.Elem().Type(), 0, 0))
+	}
 	for {
-		next, err := readValue(buf)
+		next, rest, err := nextValue(data)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
-		if err := unpackElement(next, val); err != nil {
+		if err := u.unpackElement(next, val); err != nil {
 			return err
 		}
+		data = rest
 	}
 	return nil
 }
 
 // unpackEntry decodes an entry and adds the key/value pair to val.
 // Precondition: val is a pointer to a reflect.Value.
-func unpackEntry(entry []byte, val reflect.Value) error {
+func (u *unmarshaler) unpackEntry(entry []byte, val reflect.Value, nilable bool) error {
 	out := val.Elem()
 	if out.IsNil() {
 		out.Set(reflect.MakeMap(out.Type()))
 	}
 	ktype := out.Type().Key()
 	vtype := out.Type().Elem()
+	if err := u.allocate(int(ktype.Size() + vtype.Size())); err != nil {
+		return err
+	}
 
-	ebuf := bytes.NewReader(entry)
-	kdata, err := readValue(ebuf)
+	kdata, rest, err := nextValue(entry)
 	if err != nil {
 		return fmt.Errorf("map key: %w", err)
 	}
-	vdata, err := readValue(ebuf)
+	vdata, rest, err := nextValue(rest)
 	if err != nil {
 		return fmt.Errorf("map value: %w", err)
 	}
-	if v, err := readValue(ebuf); err != io.EOF {
-		return fmt.Errorf("extra data in map entry: %q", string(v))
+	if len(rest) != 0 {
+		return fmt.Errorf("extra data in map entry: %q", string(rest))
 	}
 	mkey := reflect.New(ktype)
-	if err := Unmarshal(kdata, mkey.Interface()); err != nil {
+	if err := u.unmarshal(kdata, mkey.Interface()); err != nil {
 		return err
 	}
+	old := out.MapIndex(mkey.Elem())
+	if u.multiMap && isMultiMap(out.Type()) {
+		elts := reflect.New(vtype)
+		if old.IsValid() {
+			elts.Elem().Set(old)
+		}
+		if err := u.unpackElement(vdata, elts); err != nil {
+			return err
+		}
+		out.SetMapIndex(mkey.Elem(), elts.Elem())
+		return nil
+	} else if old.IsValid() {
+		if u.duplicates == RejectDuplicate {
+			return fmt.Errorf("map key %v: %w", mkey.Elem(), ErrDuplicateField)
+		} else if u.duplicates == KeepFirstDuplicate {
+			return nil
+		}
+	}
 	mval := reflect.New(vtype)
-	if err := Unmarshal(vdata, mval.Interface()); err != nil {
+	if u.selfDescribing && vtype == anyType {
+		v, err := u.decodeKinded(vdata)
+		if err != nil {
+			return fmt.Errorf("map value: %w", err)
+		}
+		mval.Elem().Set(reflect.ValueOf(&v).Elem())
+	} else if nilable {
+		if err := u.unmarshalOptional(vdata, mval); err != nil {
+			return fmt.Errorf("map value: %w", err)
+		}
+	} else if err := u.unmarshal(vdata, mval.Interface()); err != nil {
 		return err
 	}
 	out.SetMapIndex(mkey.Elem(), mval.Elem())
@@ -203,7 +736,7 @@ func unpackEntry(entry []byte, val reflect.Value) error {
 
 // unmarshalMap decodes a map from a sequence of values representing pairs of
 // map keys and values in sequence.
-func unmarshalMap(data []byte, val reflect.Value) error {
+func (u *unmarshaler) unmarshalMap(data []byte, val reflect.Value) error {
 	mtype := val.Elem().Type()
 	if val.IsNil() {
 		val.Set(reflect.New(mtype))
@@ -212,80 +745,172 @@ func unmarshalMap(data []byte, val reflect.Value) error {
 		val.Elem().Set(reflect.MakeMap(mtype))
 	}
 
-	buf := bytes.NewReader(data)
 	for {
-		entry, err := readValue(buf)
+		entry, rest, err := nextValue(data)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
-		if err := unpackEntry(entry, val); err != nil {
+		if err := u.unpackEntry(entry, val, false); err != nil {
 			return err
 		}
+		data = rest
 	}
 	return nil
 }
 
 // unmarshalStruct decodes a struct from a sequence of tag-value pairs.
 // Precondition: val is a non-nil pointer to a reflect.Struct.
-func unmarshalStruct(data []byte, val reflect.Value) error {
+func (u *unmarshaler) unmarshalStruct(data []byte, val reflect.Value) error {
 	info, err := checkStructType(val.Elem(), true /* pointers */)
 	if err != nil {
 		return err
 	}
+	if u.resetTargets {
+		for _, fi := range info {
+			if !fi.inVersion(u.version) {
+				continue
+			}
+			elt := fi.target.Elem()
+			elt.Set(reflect.Zero(elt.Type()))
+		}
+	}
 	find := func(tag int) *fieldInfo {
 		for _, fi := range info {
-			if fi.tag == tag {
+			if fi.tag == tag && fi.inVersion(u.version) {
 				return fi
 			}
 		}
 		return nil
 	}
 
-	d := NewDecoder(bytes.NewReader(data))
-	for {
-		tag, data, err := d.Decode()
+	// If requested, count the fields not yet assigned, so that decoding can
+	// stop when there are none left. A negative count means never stop.
+	unfilled := -1
+	if u.stopFilled {
+		unfilled = 0
+		for _, fi := range info {
+			if !fi.inVersion(u.version) {
+				continue
+			} else if fi.seq || (u.legacyBytes && isBytesField(fi)) {
+				unfilled = -1
+				break
+			}
+			unfilled++
+		}
+	}
+
+	last := 0 // the tag of the previous record, for strictOrder
+	for unfilled != 0 {
+		tag, value, rest, err := nextRecord(data)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
+		data = rest
+		var kind WireKind
+		if u.selfDescribing {
+			id, k := SplitTag(tag)
+			tag, kind = int(id), WireKind(k)
+		}
+		if u.strictOrder {
+			if tag < last {
+				return fmt.Errorf("%s after tag %d: %w", describeTag(val.Type(), tag), last, ErrTagOrder)
+			}
+			last = tag
+		}
 		fi := find(tag)
 		if fi == nil {
 			continue // skip unknown fields
 		}
-
-		// Non-sequence.
-		if !fi.seq {
-			if err := Unmarshal(data, fi.target.Interface()); err != nil {
-				return err
+		legacy := fi.bytewise && len(value) == 1 // see LegacyBytes
+		if fi.seen && !fi.seq && !legacy {
+			if u.duplicates == RejectDuplicate {
+				return fmt.Errorf("%s: %w", describeTag(val.Type(), tag), ErrDuplicateField)
+			} else if u.duplicates == KeepFirstDuplicate {
+				continue
 			}
-			continue
 		}
-		slc := fi.target
-		kind := slc.Type().Elem().Kind()
+		if !fi.seen {
+			unfilled--
+		}
+		fi.seen = true
+		if fi.deprecated && u.deprecated != nil {
+			u.deprecated(fi.name, tag)
+		}
+		if legacy {
+			err = u.appendLegacyByte(fi, value[0])
+		} else {
+			err = u.unmarshalField(fi, kind, value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", describeTag(val.Type(), tag), err)
+		}
+		if !legacy {
+			fi.bytewise = u.legacyBytes && !u.selfDescribing && len(value) == 1 && isBytesField(fi)
+			fi.appending = false
+		}
+	}
+	return nil
+}
 
-		// Inline sequence element
-		switch kind {
-		case reflect.Map:
-			if err := unpackEntry(data, slc); err != nil {
-				return err
-			}
+// isBytesField reports whether fi is a field of type []byte.
+func isBytesField(fi *fieldInfo) bool { return fi.target.Type().Elem() == bytesType }
 
-		case reflect.Slice:
-			if slc.IsNil() {
-				slc.Set(reflect.New(slc.Elem().Type()))
-			}
-			elt, isPtr := newElement(slc.Elem().Type().Elem())
-			if err := Unmarshal(data, elt.Interface()); err != nil {
-				return err
-			}
-			if !isPtr {
-				elt = elt.Elem()
-			}
-			slc.Elem().Set(reflect.Append(slc.Elem(), elt))
+// appendLegacyByte appends b to the value of the []byte field fi, for a
+// legacy per-byte record (see UnmarshalOptions.LegacyBytes).
+func (u *unmarshaler) appendLegacyByte(fi *fieldInfo, b byte) error {
+	if err := u.allocate(1); err != nil {
+		return err
+	}
+	elt := fi.target.Elem()
+	buf := elt.Bytes()
+	if !fi.appending {
+		// The first value may alias the input or storage from Alloc, so copy
+		// it before appending.
+		buf = append(make([]byte, 0, 2*len(buf)+1), buf...)
+		fi.appending = true
+	}
+	elt.SetBytes(append(buf, b))
+	return nil
+}
+
+// unmarshalField decodes the value of a single record into a struct field.
+// The kind is used only in self-describing mode.
+func (u *unmarshaler) unmarshalField(fi *fieldInfo, kind WireKind, data []byte) error {
+	if p, ok := fi.target.Interface().(*interface{}); ok && u.selfDescribing {
+		v, err := u.decodeKind(kind, data)
+		if err != nil {
+			return err
+		}
+		*p = v
+		return nil
+	}
+
+	// Non-sequence.
+	if !fi.seq {
+		if fi.nilable {
+			return u.unmarshalOptional(data, fi.target)
+		} else if fi.width != 0 {
+			return unmarshalFloat(data, fi.target, fi.width)
+		}
+		return u.unmarshal(data, fi.target.Interface())
+	}
+	slc := fi.target
+
+	// Inline sequence element
+	switch slc.Type().Elem().Kind() {
+	case reflect.Map:
+		return u.unpackEntry(data, slc, fi.nilable)
+	case reflect.Slice:
+		if fi.nilable {
+			return u.unpackOptional(data, slc)
+		} else if fi.width != 0 {
+			return u.unpackFloat(data, slc, fi.width)
 		}
+		return u.unpackElement(data, slc)
 	}
 	return nil
 }
@@ -0,0 +1,780 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+type allocThing struct {
+	Name  string            `binpack:"tag=1"`
+	Data  []byte            `binpack:"tag=2"`
+	Tags  []string          `binpack:"tag=3,pack"`
+	Attrs map[string][]byte `binpack:"tag=4"`
+	Count int               `binpack:"tag=5"`
+}
+
+func TestUnmarshalAlloc(t *testing.T) {
+	in := &allocThing{
+		Name:  "a thing of beauty",
+		Data:  []byte("is a joy forever"),
+		Tags:  []string{"its", "loveliness", "increases"},
+		Attrs: map[string][]byte{"it": []byte("will never"), "pass": []byte("into nothingness")},
+		Count: 1818,
+	}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// A simple bump allocator: each request is carved from a single buffer.
+	arena := make([]byte, 0, 1024)
+	var calls, total int
+	opts := &binpack.UnmarshalOptions{
+		Alloc: func(n int) []byte {
+			calls++
+			total += n
+			start := len(arena)
+			arena = arena[:start+n]
+			return arena[start : start+n : start+n]
+		},
+	}
+	out := new(allocThing)
+	if err := opts.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// Name, Data, 3 tags, 2 map keys, 2 map values.
+	if want := 9; calls != want {
+		t.Errorf("Alloc called %d times, want %d", calls, want)
+	}
+	if total != len(arena) {
+		t.Errorf("Alloc total is %d bytes, arena holds %d", total, len(arena))
+	}
+
+	// Clobbering the arena should clobber the decoded values, showing they
+	// were allocated from it.
+	for i := range arena {
+		arena[i] = 'x'
+	}
+	if out.Name == in.Name || string(out.Data) == string(in.Data) {
+		t.Errorf("Decoded values were not allocated from the arena: %+v", out)
+	}
+}
+
+func TestUnmarshalUnsafeStrings(t *testing.T) {
+	in := &allocThing{Name: "a thing of beauty", Tags: []string{"is", "a", "joy"}}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	opts := &binpack.UnmarshalOptions{UnsafeStrings: true}
+	out := new(allocThing)
+	if err := opts.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// Clobbering the input should clobber the decoded strings, showing they
+	// share storage with it.
+	for i := range bits {
+		bits[i] = 'x'
+	}
+	if out.Name == in.Name || out.Tags[0] == in.Tags[0] {
+		t.Errorf("Decoded strings do not share the input: %+v", out)
+	}
+}
+
+func TestUnmarshalAliasBytes(t *testing.T) {
+	in := &allocThing{Data: []byte("is a joy forever"), Name: "endymion"}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	opts := &binpack.UnmarshalOptions{AliasBytes: true}
+	out := new(allocThing)
+	if err := opts.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	// Clobbering the input should clobber the decoded bytes but not the
+	// decoded string, which is still copied.
+	for i := range bits {
+		bits[i] = 'x'
+	}
+	if string(out.Data) == string(in.Data) {
+		t.Errorf("Decoded bytes do not alias the input: %q", out.Data)
+	}
+	if out.Name != in.Name {
+		t.Errorf("Decoded string was modified: got %q, want %q", out.Name, in.Name)
+	}
+}
+
+type versionThing struct {
+	ID    int    `binpack:"tag=1"`
+	Old   string `binpack:"tag=2,until=2"`
+	New   string `binpack:"tag=3,since=3"`
+	Mid   bool   `binpack:"tag=4,since=2,until=3"`
+	Extra []byte `binpack:"tag=5"`
+}
+
+func TestVersionedFields(t *testing.T) {
+	in := &versionThing{ID: 17, Old: "old", New: "new", Mid: true, Extra: []byte("x")}
+	tests := []struct {
+		version int
+		want    *versionThing
+	}{
+		{0, in},
+		{1, &versionThing{ID: 17, Old: "old", Extra: []byte("x")}},
+		{2, &versionThing{ID: 17, Old: "old", Mid: true, Extra: []byte("x")}},
+		{3, &versionThing{ID: 17, New: "new", Mid: true, Extra: []byte("x")}},
+		{4, &versionThing{ID: 17, New: "new", Extra: []byte("x")}},
+	}
+	all, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, test := range tests {
+		// Encoding at a version omits the fields outside it.
+		bits, err := (&binpack.MarshalOptions{Version: test.version}).Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal(v%d) failed: %v", test.version, err)
+		}
+		got := new(versionThing)
+		if err := binpack.Unmarshal(bits, got); err != nil {
+			t.Fatalf("Unmarshal(v%d) failed: %v", test.version, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Marshal(v%d) output differs (-want, +got):\n%s", test.version, diff)
+		}
+
+		// Decoding at a version ignores the fields outside it.
+		got = new(versionThing)
+		if err := (&binpack.UnmarshalOptions{Version: test.version}).Unmarshal(all, got); err != nil {
+			t.Fatalf("Unmarshal(v%d) failed: %v", test.version, err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Unmarshal(v%d) output differs (-want, +got):\n%s", test.version, diff)
+		}
+	}
+
+	type badRange struct {
+		X int `binpack:"tag=1,since=4,until=2"`
+	}
+	if _, err := binpack.Marshal(badRange{X: 1}); err == nil {
+		t.Error("Marshal with since > until: got nil error")
+	}
+}
+
+func TestDeprecatedHook(t *testing.T) {
+	type inner struct {
+		Old string `binpack:"tag=1,deprecated"`
+	}
+	type thing struct {
+		Name  string   `binpack:"tag=1"`
+		Code  int      `binpack:"tag=2,deprecated"`
+		Notes []string `binpack:"tag=3,deprecated"`
+		Sub   inner    `binpack:"tag=4"`
+	}
+	in := &thing{Name: "a", Code: 5, Notes: []string{"x", "y"}, Sub: inner{Old: "z"}}
+	bits, err := binpack.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := []string{"Code/2", "Notes/3", "Notes/3", "Old/1"}
+
+	var got []string
+	opts := &binpack.UnmarshalOptions{
+		Deprecated: func(name string, tag int) {
+			got = append(got, fmt.Sprintf("%s/%d", name, tag))
+		},
+	}
+	out := new(thing)
+	if err := opts.Unmarshal(bits, out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Deprecated fields differ (-want, +got):\n%s", diff)
+	}
+
+	// The same fields are reported when decoding by schema.
+	s, err := binpack.SchemaOf(in)
+	if err != nil {
+		t.Fatalf("SchemaOf failed: %v", err)
+	}
+	got = nil
+	if _, err := opts.UnmarshalMap(s, bits); err != nil {
+		t.Fatalf("UnmarshalMap failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Deprecated schema fields differ (-want, +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalResetTargets(t *testing.T) {
+	bits, err := binpack.Marshal(&allocThing{Name: "new", Tags: []string{"c"}, Attrs: map[string][]byte{"k": []byte("v")}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	prior := func() *allocThing {
+		return &allocThing{
+			Name:  "old",
+			Data:  []byte("stale"),
+			Tags:  []string{"a", "b"},
+			Attrs: map[string][]byte{"j": []byte("w")},
+			Count: 3,
+		}
+	}
+	tests := []struct {
+		reset bool
+		want  *allocThing
+	}{
+		{false, &allocThing{
+			Name:  "new",
+			Data:  []byte("stale"),
+			Tags:  []string{"a", "b", "c"},
+			Attrs: map[string][]byte{"j": []byte("w"), "k": []byte("v")},
+			Count: 3,
+		}},
+		{true, &allocThing{
+			Name:  "new",
+			Tags:  []string{"c"},
+			Attrs: map[string][]byte{"k": []byte("v")},
+		}},
+	}
+	for _, test := range tests {
+		got := prior()
+		opts := &binpack.UnmarshalOptions{ResetTargets: test.reset}
+		if err := opts.Unmarshal(bits, got); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("Unmarshal(reset=%v) output differs (-want, +got):\n%s", test.reset, diff)
+		}
+	}
+
+	// Fields outside the decoding version are not reset.
+	vbits, err := binpack.Marshal(&versionThing{ID: 2, New: "new"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got := &versionThing{ID: 1, Old: "old", New: "stale"}
+	opts := &binpack.UnmarshalOptions{ResetTargets: true, Version: 3}
+	if err := opts.Unmarshal(vbits, got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if diff := cmp.Diff(&versionThing{ID: 2, Old: "old", New: "new"}, got); diff != "" {
+		t.Errorf("Unmarshal(version=3) output differs (-want, +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalDuplicates(t *testing.T) {
+	type thing struct {
+		Name string   `binpack:"tag=1"`
+		Tags []string `binpack:"tag=2"`
+	}
+	e := binpack.NewEncoder(nil)
+	if err := e.EncodeRecords(
+		binpack.Record{Tag: 1, Value: []byte("first")},
+		binpack.Record{Tag: 2, Value: []byte("a")},
+		binpack.Record{Tag: 1, Value: []byte("second")},
+		binpack.Record{Tag: 2, Value: []byte("b")},
+	); err != nil {
+		t.Fatalf("EncodeRecords failed: %v", err)
+	}
+	bits := e.Data.Bytes()
+
+	tests := []struct {
+		opts *binpack.UnmarshalOptions
+		want string
+	}{
+		{nil, "second"},
+		{&binpack.UnmarshalOptions{Duplicates: binpack.KeepLastDuplicate}, "second"},
+		{&binpack.UnmarshalOptions{Duplicates: binpack.KeepFirstDuplicate}, "first"},
+	}
+	for _, test := range tests {
+		var got thing
+		if err := test.opts.Unmarshal(bits, &got); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		want := thing{Name: test.want, Tags: []string{"a", "b"}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Unmarshal(%+v) output differs (-want, +got):\n%s", test.opts, diff)
+		}
+	}
+
+	opts := &binpack.UnmarshalOptions{Duplicates: binpack.RejectDuplicate}
+	if err := opts.Unmarshal(bits, new(thing)); !errors.Is(err, binpack.ErrDuplicateField) {
+		t.Errorf("Unmarshal with duplicates: got %v, want %v", err, binpack.ErrDuplicateField)
+	}
+	seq, err := binpack.Marshal(thing{Name: "x", Tags: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if err := opts.Unmarshal(seq, new(thing)); err != nil {
+		t.Errorf("Unmarshal with repeated sequence: unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalLegacyBytes(t *testing.T) {
+	type thing struct {
+		Data []byte `binpack:"tag=1,deprecated"`
+		Name string `binpack:"tag=2"`
+	}
+
+	// Earlier versions encoded a []byte field with one record per byte.
+	e := binpack.NewEncoder(nil)
+	if err := e.EncodeRecords(
+		binpack.Record{Tag: 1, Value: []byte("a")},
+		binpack.Record{Tag: 1, Value: []byte("b")},
+		binpack.Record{Tag: 2, Value: []byte("x")},
+		binpack.Record{Tag: 1, Value: []byte("c")},
+	); err != nil {
+		t.Fatalf("EncodeRecords failed: %v", err)
+	}
+	legacy := e.Data.Bytes()
+	orig := string(legacy)
+
+	// By default, the records are duplicates.
+	var got thing
+	if err := binpack.Unmarshal(legacy, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if string(got.Data) != "c" {
+		t.Errorf("Unmarshal: got %q, want %q", got.Data, "c")
+	}
+
+	var hooks int
+	for _, opts := range []*binpack.UnmarshalOptions{
+		{LegacyBytes: true},
+		{LegacyBytes: true, Duplicates: binpack.RejectDuplicate},
+		{LegacyBytes: true, StopWhenFilled: true},
+		{LegacyBytes: true, AliasBytes: true},
+		{LegacyBytes: true, Deprecated: func(string, int) { hooks++ }},
+	} {
+		var got thing
+		if err := opts.Unmarshal(legacy, &got); err != nil {
+			t.Fatalf("Unmarshal(%+v) failed: %v", opts, err)
+		}
+		if diff := cmp.Diff(thing{Data: []byte("abc"), Name: "x"}, got); diff != "" {
+			t.Errorf("Unmarshal(%+v) output differs (-want, +got):\n%s", opts, diff)
+		}
+	}
+	if hooks != 3 {
+		t.Errorf("Deprecated hook: got %d calls, want 3", hooks)
+	}
+	if string(legacy) != orig {
+		t.Errorf("Input was modified: got %q, want %q", legacy, orig)
+	}
+
+	// Appended bytes count against the allocation budget.
+	opts := &binpack.UnmarshalOptions{LegacyBytes: true, MaxAlloc: 3}
+	if err := opts.Unmarshal(legacy, new(thing)); !errors.Is(err, binpack.ErrAllocLimit) {
+		t.Errorf("Unmarshal with MaxAlloc: got %v, want %v", err, binpack.ErrAllocLimit)
+	}
+
+	// The schema decoders accept the legacy encoding too.
+	s, err := binpack.SchemaOf(thing{})
+	if err != nil {
+		t.Fatalf("SchemaOf failed: %v", err)
+	}
+	m, err := (&binpack.UnmarshalOptions{LegacyBytes: true}).UnmarshalMap(s, legacy)
+	if err != nil {
+		t.Fatalf("UnmarshalMap failed: %v", err)
+	} else if diff := cmp.Diff(map[string]interface{}{"Data": []byte("abc"), "Name": "x"}, m); diff != "" {
+		t.Errorf("UnmarshalMap (-want, +got):\n%s", diff)
+	}
+	dm := binpack.NewDynamicMessage(s)
+	if err := (&binpack.UnmarshalOptions{LegacyBytes: true}).UnmarshalDynamic(dm, legacy); err != nil {
+		t.Fatalf("UnmarshalDynamic failed: %v", err)
+	} else if v, _ := dm.GetByName("Data"); string(v.([]byte)) != "abc" {
+		t.Errorf("UnmarshalDynamic: got Data %q, want %q", v, "abc")
+	}
+
+	// Re-encoding produces a single record.
+	bits, err := binpack.Marshal(thing{Data: []byte("abc")})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if value, err := binpack.GetPath(bits, 1); err != nil || string(value) != "abc" {
+		t.Errorf("GetPath(1): got %q, %v; want %q", value, err, "abc")
+	}
+
+	// A longer record replaces the value, as for other fields.
+	e = binpack.NewEncoder(nil)
+	e.EncodeRecords(
+		binpack.Record{Tag: 1, Value: []byte("a")},
+		binpack.Record{Tag: 1, Value: []byte("hello")},
+		binpack.Record{Tag: 1, Value: []byte("!")},
+	)
+	got = thing{}
+	if err := (&binpack.UnmarshalOptions{LegacyBytes: true}).Unmarshal(e.Data.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if string(got.Data) != "!" {
+		t.Errorf("Unmarshal: got %q, want %q", got.Data, "!")
+	}
+}
+
+func TestUnmarshalMapDuplicates(t *testing.T) {
+	type single struct {
+		Attrs map[string]int `binpack:"tag=1"`
+	}
+	type multi struct {
+		Attrs map[string][]int `binpack:"tag=1"`
+	}
+	// Concatenating two messages gives a key that occurs twice.
+	m1, err := binpack.Marshal(single{Attrs: map[string]int{"a": 1, "b": 2}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	m2, err := binpack.Marshal(single{Attrs: map[string]int{"a": 3}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	bits := append(m1, m2...)
+
+	tests := []struct {
+		opts *binpack.UnmarshalOptions
+		want map[string]int
+	}{
+		{nil, map[string]int{"a": 3, "b": 2}},
+		{&binpack.UnmarshalOptions{Duplicates: binpack.KeepFirstDuplicate}, map[string]int{"a": 1, "b": 2}},
+	}
+	for _, test := range tests {
+		var got single
+		if err := test.opts.Unmarshal(bits, &got); err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if diff := cmp.Diff(test.want, got.Attrs); diff != "" {
+			t.Errorf("Unmarshal(%+v) output differs (-want, +got):\n%s", test.opts, diff)
+		}
+	}
+	opts := &binpack.UnmarshalOptions{Duplicates: binpack.RejectDuplicate}
+	if err := opts.Unmarshal(bits, new(single)); !errors.Is(err, binpack.ErrDuplicateField) {
+		t.Errorf("Unmarshal with duplicate keys: got %v, want %v", err, binpack.ErrDuplicateField)
+	}
+
+	// A multi-map collects all the values for each key.
+	var got multi
+	if err := (&binpack.UnmarshalOptions{MultiMap: true}).Unmarshal(bits, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := map[string][]int{"a": {1, 3}, "b": {2}}
+	if diff := cmp.Diff(want, got.Attrs); diff != "" {
+		t.Errorf("Unmarshal multi-map output differs (-want, +got):\n%s", diff)
+	}
+
+	// A multi-map round-trips, and its encoding is deterministic.
+	in := multi{Attrs: map[string][]int{"x": {5, 4, 3}, "y": {1}, "z": nil}}
+	mopts := &binpack.MarshalOptions{MultiMap: true, Deterministic: true}
+	enc, err := mopts.Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, err := mopts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !bytes.Equal(again, enc) {
+			t.Fatalf("Marshal is not deterministic:\n got %q\nwant %q", again, enc)
+		}
+	}
+	var out multi
+	if err := (&binpack.UnmarshalOptions{MultiMap: true}).Unmarshal(enc, &out); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	delete(in.Attrs, "z") // a key with no values has no entries
+	if diff := cmp.Diff(in, out); diff != "" {
+		t.Errorf("Multi-map round trip differs (-want, +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalUTF8(t *testing.T) {
+	type thing struct {
+		Name  string            `binpack:"tag=1"`
+		Attrs map[string]string `binpack:"tag=2"`
+		Raw   []byte            `binpack:"tag=3"`
+	}
+	good := &thing{Name: "café", Attrs: map[string]string{"ключ": "値"}, Raw: []byte("\xff")}
+	bad := []*thing{
+		{Name: "a\xffb"},
+		{Attrs: map[string]string{"k\xc3": "v"}},
+		{Attrs: map[string]string{"k": "\xed\xa0\x80"}},
+	}
+	opts := &binpack.UnmarshalOptions{ValidateUTF8: true}
+
+	bits, err := binpack.Marshal(good)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got := new(thing)
+	if err := opts.Unmarshal(bits, got); err != nil {
+		t.Fatalf("Unmarshal valid UTF-8: unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(good, got); diff != "" {
+		t.Errorf("Unmarshal output differs (-want, +got):\n%s", diff)
+	}
+
+	for _, in := range bad {
+		bits, err := binpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if err := opts.Unmarshal(bits, new(thing)); !errors.Is(err, binpack.ErrInvalidUTF8) {
+			t.Errorf("Unmarshal %+v: got %v, want %v", in, err, binpack.ErrInvalidUTF8)
+		}
+		if err := binpack.Unmarshal(bits, new(thing)); err != nil {
+			t.Errorf("Unmarshal %+v without validation: unexpected error: %v", in, err)
+		}
+	}
+
+	bits, err = binpack.Marshal(&thing{Name: "a\xffb\xc3", Attrs: map[string]string{"k\xc3": "v"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	ropts := &binpack.UnmarshalOptions{ReplaceInvalidUTF8: true, ValidateUTF8: true, UnsafeStrings: true}
+	got = new(thing)
+	if err := ropts.Unmarshal(bits, got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := &thing{Name: "a\uFFFDb\uFFFD", Attrs: map[string]string{"k\uFFFD": "v"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal replaced output differs (-want, +got):\n%s", diff)
+	}
+}
+
+func TestUnmarshalMaxAlloc(t *testing.T) {
+	type elt struct {
+		A int64 `binpack:"tag=1"`
+		B int64 `binpack:"tag=2"`
+		C int64 `binpack:"tag=3"`
+		D int64 `binpack:"tag=4"`
+	}
+	type thing struct {
+		Names []string `binpack:"tag=1"`
+		Elts  []elt    `binpack:"tag=2"`
+	}
+
+	// Many short strings.
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = "0123456789"
+	}
+	bits, err := binpack.Marshal(&thing{Names: names})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	strSize := int(reflect.TypeOf("").Size())
+	need := len(names) * (10 + strSize)
+	if err := (&binpack.UnmarshalOptions{MaxAlloc: need}).Unmarshal(bits, new(thing)); err != nil {
+		t.Errorf("Unmarshal within budget %d: unexpected error: %v", need, err)
+	}
+	if err := (&binpack.UnmarshalOptions{MaxAlloc: need - 1}).Unmarshal(bits, new(thing)); !errors.Is(err, binpack.ErrAllocLimit) {
+		t.Errorf("Unmarshal over budget: got %v, want %v", err, binpack.ErrAllocLimit)
+	}
+
+	// Many empty records, each of which allocates an element.
+	e := binpack.NewEncoder(nil)
+	for i := 0; i < 1000; i++ {
+		if err := e.Encode(2, nil); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	bits = e.Data.Bytes()
+	if err := (&binpack.UnmarshalOptions{MaxAlloc: 4 * len(bits)}).Unmarshal(bits, new(thing)); !errors.Is(err, binpack.ErrAllocLimit) {
+		t.Errorf("Unmarshal empty elements: got %v, want %v", err, binpack.ErrAllocLimit)
+	}
+	if err := binpack.Unmarshal(bits, new(thing)); err != nil {
+		t.Errorf("Unmarshal without budget: unexpected error: %v", err)
+	}
+
+	// Bytes are checked before they are copied, including at the top level.
+	var blob []byte
+	if err := (&binpack.UnmarshalOptions{MaxAlloc: 9}).Unmarshal([]byte("0123456789"), &blob); !errors.Is(err, binpack.ErrAllocLimit) {
+		t.Errorf("Unmarshal bytes over budget: got %v, want %v", err, binpack.ErrAllocLimit)
+	} else if blob != nil {
+		t.Errorf("Unmarshal bytes over budget: got %q, want nil", blob)
+	}
+}
+
+func TestUnmarshalStopWhenFilled(t *testing.T) {
+	type header struct {
+		ID   int    `binpack:"tag=1"`
+		Kind string `binpack:"tag=2"`
+	}
+	// The header fields are followed by a duplicate and a malformed record,
+	// which are not reached when decoding stops early.
+	data := append(new(binpack.Builder).Int(1, 5).Str(2, "ping").Int(1, 9).Bytes(), 0xff, 0xff)
+
+	opts := &binpack.UnmarshalOptions{StopWhenFilled: true}
+	var got header
+	if err := opts.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if want := (header{ID: 5, Kind: "ping"}); got != want {
+		t.Errorf("Unmarshal: got %+v, want %+v", got, want)
+	}
+
+	// Without the option, the whole input is read.
+	if err := binpack.Unmarshal(data, new(header)); err == nil {
+		t.Error("Unmarshal without StopWhenFilled: got nil error")
+	}
+
+	// A struct with a sequence field is read completely.
+	type withSeq struct {
+		ID   int      `binpack:"tag=1"`
+		Tags []string `binpack:"tag=2"`
+	}
+	var seq withSeq
+	if err := opts.Unmarshal(data[:len(data)-2], &seq); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if seq.ID != 9 || len(seq.Tags) != 1 {
+		t.Errorf("Unmarshal: got %+v, want ID 9 and one tag", seq)
+	}
+}
+
+func TestUnmarshalStrictOrder(t *testing.T) {
+	type msg struct {
+		A int    `binpack:"tag=1"`
+		B string `binpack:"tag=2"`
+		C []bool `binpack:"tag=3"`
+	}
+	ordered := new(binpack.Builder).Int(1, 1).Str(2, "b").Bool(3, true).Bool(3, false).Bytes()
+	reordered := new(binpack.Builder).Str(2, "b").Int(1, 1).Bytes()
+
+	opts := &binpack.UnmarshalOptions{StrictOrder: true}
+	var got msg
+	if err := opts.Unmarshal(ordered, &got); err != nil {
+		t.Errorf("Unmarshal ordered: unexpected error: %v", err)
+	}
+	if err := opts.Unmarshal(reordered, &got); !errors.Is(err, binpack.ErrTagOrder) {
+		t.Errorf("Unmarshal reordered: got %v, want %v", err, binpack.ErrTagOrder)
+	}
+	if err := binpack.Unmarshal(reordered, &got); err != nil {
+		t.Errorf("Unmarshal reordered without StrictOrder: unexpected error: %v", err)
+	}
+}
+
+func TestUnmarshalCheckRange(t *testing.T) {
+	type counters struct {
+		Small int16            `binpack:"tag=1"`
+		Count uint32           `binpack:"tag=2"`
+		List  []int8           `binpack:"tag=3"`
+		Map   map[string]int32 `binpack:"tag=4"`
+	}
+	opts := &binpack.UnmarshalOptions{CheckRange: true}
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"InRange", new(binpack.Builder).Int(1, -32768).Uint(2, 1<<32-1).Int(3, 127).Bytes(), true},
+		{"Int16", new(binpack.Builder).Int(1, 70000).Bytes(), false},
+		{"Int16Neg", new(binpack.Builder).Int(1, -32769).Bytes(), false},
+		{"Uint32", new(binpack.Builder).Uint(2, 1<<32).Bytes(), false},
+		{"Element", new(binpack.Builder).Int(3, 1).Int(3, 128).Bytes(), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := counters{Small: 5}
+			err := opts.Unmarshal(test.data, &c)
+			if test.ok && err != nil {
+				t.Errorf("Unmarshal: unexpected error: %v", err)
+			} else if !test.ok && !errors.Is(err, binpack.ErrOutOfRange) {
+				t.Errorf("Unmarshal: got %v (%+v), want %v", err, c, binpack.ErrOutOfRange)
+			}
+
+			// Without the option, values are truncated.
+			if err := binpack.Unmarshal(test.data, new(counters)); err != nil {
+				t.Errorf("Unmarshal without CheckRange: unexpected error: %v", err)
+			}
+		})
+	}
+
+	wide, err := binpack.Marshal(struct {
+		M map[string]int64 `binpack:"tag=4"`
+	}{M: map[string]int64{"x": 1 << 40}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if err := opts.Unmarshal(wide, new(counters)); !errors.Is(err, binpack.ErrOutOfRange) {
+		t.Errorf("Unmarshal map value: got %v, want %v", err, binpack.ErrOutOfRange)
+	}
+}
+
+func TestUnmarshalFixScalar(t *testing.T) {
+	type flags struct {
+		On    bool   `binpack:"tag=1"`
+		Off   bool   `binpack:"tag=2"`
+		Level byte   `binpack:"tag=3"`
+		Bits  []bool `binpack:"tag=4"`
+	}
+	// A faulty producer wrote bools as 2-byte values, and wrote 2 for true.
+	data := new(binpack.Builder).
+		Raw(1, []byte{0, 1}).
+		Raw(2, []byte{0, 0}).
+		Raw(3, []byte{0, 7}).
+		Raw(4, []byte{2}).
+		Bytes()
+
+	if err := binpack.Unmarshal(data, new(flags)); err == nil {
+		t.Error("Unmarshal without FixScalar: got nil error")
+	}
+
+	var calls int
+	opts := &binpack.UnmarshalOptions{
+		FixScalar: func(v interface{}, data []byte) error {
+			calls++
+			var z int
+			for _, b := range data {
+				z = z<<8 | int(b)
+			}
+			switch t := v.(type) {
+			case *bool:
+				*t = z != 0
+			case *byte:
+				if z > 255 {
+					return fmt.Errorf("byte value %d out of range", z)
+				}
+				*t = byte(z)
+			}
+			return nil
+		},
+	}
+	var got flags
+	if err := opts.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := flags{On: true, Off: false, Level: 7, Bits: []bool{true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal: got %+v, want %+v", got, want)
+	}
+	if calls != 4 {
+		t.Errorf("FixScalar calls: got %d, want 4", calls)
+	}
+
+	// Errors from the hook are reported.
+	bad := new(binpack.Builder).Raw(3, []byte{1, 0}).Bytes()
+	if err := opts.Unmarshal(bad, new(flags)); err == nil {
+		t.Error("Unmarshal with failing FixScalar: got nil error")
+	}
+}
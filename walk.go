@@ -0,0 +1,132 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SkipNested may be returned by the callback of Walk to prevent Walk from
+// descending into the value of the current record.
+var SkipNested = errors.New("skip nested records")
+
+// WalkOptions control the traversal of messages by Walk.
+// A nil *WalkOptions provides default values.
+type WalkOptions struct {
+	// If set, the fields of the schema whose kind is KindMessage are treated
+	// as nested messages, and all other fields are not.
+	Schema *Schema
+
+	// If true, the data are encoded in self-describing mode (see
+	// MarshalOptions.SelfDescribing), and values of kind WireNested are
+	// treated as nested messages. Tags are reported without their kinds.
+	SelfDescribing bool
+
+	// If set, and neither Schema nor SelfDescribing is set, Nested reports
+	// whether the value of the record at path should be treated as a nested
+	// message. Walk only calls Nested for values that consist entirely of
+	// complete records. If nil, Walk treats such a value as nested unless it
+	// is printable text.
+	Nested func(path []int, value []byte) bool
+}
+
+// Walk calls fn for each record of the message in data, in order, with the
+// path of tags leading to the record and the value of the record. If the
+// value appears to be a nested message, Walk then visits its records
+// recursively, with the tag of the enclosing record appended to the path.
+//
+// Since the format does not record types, Walk must guess which values are
+// nested messages. By default, a value is nested if it consists entirely of
+// complete records and is not printable text; use a WalkOptions to change
+// this. The path slice is only valid during the call to fn.
+//
+// If fn returns SkipNested, Walk does not visit the records of the value. If
+// fn returns any other error, Walk stops and returns that error.
+func Walk(data []byte, fn func(path []int, value []byte) error) error {
+	return (*WalkOptions)(nil).Walk(data, fn)
+}
+
+// Walk visits the records of data as by the Walk function, using the
+// settings from o.
+func (o *WalkOptions) Walk(data []byte, fn func(path []int, value []byte) error) error {
+	var w walker
+	if o != nil {
+		w.selfDescribing = o.SelfDescribing
+		w.nested = o.Nested
+		return w.walk(nil, o.Schema, data, fn)
+	}
+	return w.walk(nil, nil, data, fn)
+}
+
+type walker struct {
+	selfDescribing bool
+	nested         func(path []int, value []byte) bool
+}
+
+func (w *walker) walk(path []int, s *Schema, data []byte, fn func([]int, []byte) error) error {
+	for {
+		tag, value, rest, err := nextRecord(data)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("path %v: %w", path, err)
+		}
+		data = rest
+
+		var kind WireKind
+		if w.selfDescribing {
+			id, k := SplitTag(tag)
+			tag, kind = int(id), WireKind(k)
+		}
+		cur := append(path, tag)
+		if err := fn(cur[:len(cur):len(cur)], value); err == SkipNested {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		var sub *Schema
+		if s != nil {
+			f := s.FieldByTag(tag)
+			if f == nil || f.Kind != KindMessage {
+				continue
+			}
+			sub = f.Message
+		} else if w.selfDescribing {
+			if kind != WireNested {
+				continue
+			}
+		} else if !isRecords(value) || !w.isNested(cur, value) {
+			continue
+		}
+		if err := w.walk(cur, sub, value, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// isNested reports whether value, which consists of complete records, should
+// be treated as a nested message.
+func (w *walker) isNested(path []int, value []byte) bool {
+	if w.nested != nil {
+		return w.nested(path, value)
+	}
+	return !IsText(value)
+}
+
+// isRecords reports whether data is a non-empty sequence of complete records.
+func isRecords(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	for len(data) != 0 {
+		_, _, rest, err := nextRecord(data)
+		if err != nil {
+			return false
+		}
+		data = rest
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package binpack_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/binpack"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWalk(t *testing.T) {
+	type leaf struct {
+		Name string `binpack:"tag=1"`
+		Data []byte `binpack:"tag=2"`
+	}
+	type branch struct {
+		Label string `binpack:"tag=1"`
+		Leaf  *leaf  `binpack:"tag=5"`
+	}
+	type root struct {
+		ID     int     `binpack:"tag=1"`
+		Branch *branch `binpack:"tag=2"`
+		Note   string  `binpack:"tag=3"`
+	}
+	in := &root{
+		ID:     17,
+		Branch: &branch{Label: "limb", Leaf: &leaf{Name: "frond", Data: []byte{0xff}}},
+		Note:   "hi there",
+	}
+
+	// collect returns a walk callback that records the paths visited.
+	collect := func(paths *[]string) func([]int, []byte) error {
+		return func(path []int, value []byte) error {
+			*paths = append(*paths, fmt.Sprint(path))
+			return nil
+		}
+	}
+
+	t.Run("Heuristic", func(t *testing.T) {
+		bits, err := binpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var got []string
+		if err := binpack.Walk(bits, collect(&got)); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		want := []string{"[1]", "[2]", "[2 1]", "[2 5]", "[2 5 1]", "[2 5 2]", "[3]"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Walk paths (-want, +got):\n%s", diff)
+		}
+
+		// SkipNested prevents descent.
+		got = nil
+		if err := binpack.Walk(bits, func(path []int, value []byte) error {
+			got = append(got, fmt.Sprint(path))
+			if len(path) == 2 && path[1] == 5 {
+				return binpack.SkipNested
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		want = []string{"[1]", "[2]", "[2 1]", "[2 5]", "[3]"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Walk paths with skip (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		bits, err := binpack.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		s, err := binpack.SchemaOf(root{})
+		if err != nil {
+			t.Fatalf("SchemaOf failed: %v", err)
+		}
+		var got []string
+		opts := &binpack.WalkOptions{Schema: s}
+		if err := opts.Walk(bits, collect(&got)); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		want := []string{"[1]", "[2]", "[2 1]", "[2 5]", "[2 5 1]", "[2 5 2]", "[3]"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Walk paths (-want, +got):\n%s", diff)
+		}
+	})
+
+	t.Run("SelfDescribing", func(t *testing.T) {
+		mopts := &binpack.MarshalOptions{SelfDescribing: true}
+		bits, err := mopts.Marshal(in)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		var got []string
+		opts := &binpack.WalkOptions{SelfDescribing: true}
+		if err := opts.Walk(bits, collect(&got)); err != nil {
+			t.Fatalf("Walk failed: %v", err)
+		}
+		want := []string{"[1]", "[2]", "[2 1]", "[2 5]", "[2 5 1]", "[2 5 2]", "[3]"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Walk paths (-want, +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrTagOrder is reported by a Decoder that requires tag order, when a record
+// has a smaller tag than the record before it.
+var ErrTagOrder = errors.New("record tags out of order")
+
+// An orderState tracks the tags of records for RequireTagOrder.
+type orderState struct {
+	last int // the tag of the previous record
+}
+
+// check reports an error if a record with the given tag may not follow the
+// records already seen, and otherwise records its tag.
+func (o *orderState) check(tag int) error {
+	if tag < o.last {
+		return wrapError{
+			msg: "tag " + strconv.Itoa(tag) + " after tag " + strconv.Itoa(o.last) + ": " + ErrTagOrder.Error(),
+			err: ErrTagOrder,
+		}
+	}
+	o.last = tag
+	return nil
+}
+
+// RequireTagOrder causes d to check that the tags of the records in its input
+// are non-decreasing, as they are in the encoding of a struct by Marshal. A
+// record whose tag is smaller than the tag of the record before it is
+// consumed, and d reports an error wrapping ErrTagOrder in place of that
+// record. This allows a reader to detect a corrupted or reordered stream
+// early, and to rely on the order to find a field in a single pass.
+//
+// The order applies across the whole input. If d also verifies trailers (see
+// VerifyTrailers), the order starts over after each trailer, so that a
+// stream may contain a sequence of ordered messages.
+func (d *Decoder) RequireTagOrder() {
+	if d.order == nil {
+		d.order = new(orderState)
+	}
+}
+
+// checkOrder reports whether a record with the given tag satisfies the tag
+// order required by d, if any.
+func (d *Decoder) checkOrder(tag int) error {
+	if d.order == nil {
+		return nil
+	}
+	return d.order.check(tag)
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire
+
+import "math"
+
+// PackUint64 encodes z as a slice in big-endian order, omitting leading zeroes.
+// The encoding of 0 is a slice of length 1.
+func PackUint64(z uint64) []byte {
+	var buf [8]byte
+	for i := range buf {
+		buf[i] = byte(z >> (56 - 8*i))
+	}
+	for i, b := range buf {
+		if b != 0 {
+			return buf[i:]
+		}
+	}
+	return buf[:1]
+}
+
+// UnpackUint64 decodes z from a big-endian slice.
+func UnpackUint64(data []byte) uint64 {
+	var z uint64
+	for _, b := range data {
+		z = (z << 8) | uint64(b)
+	}
+	return z
+}
+
+// PackInt64 encodes z as a slice in big-endian order with zigzag encoding,
+// omitting leading zeroes. The encoding of 0 is a slice of length 1.
+//
+// Zigzag encoding represents a signed value as the bitwise complement of its
+// 2s complement value, with its sign in the least-significant bit.
+func PackInt64(z int64) []byte {
+	u := uint64(z<<1) ^ uint64(z>>63)
+	return PackUint64(u)
+}
+
+// UnpackInt64 decodes z from a big-endian slice with zigzag encoding.
+func UnpackInt64(data []byte) int64 {
+	z := UnpackUint64(data)
+	mask := math.MaxUint64 + (1 - z&1)
+	return int64(mask ^ z>>1)
+}
+
+// PackFloat64 encodes v by converting it to a uint64 in IEEE 754
+// representation and encoding that value as PackUint64.
+func PackFloat64(v float64) []byte { return PackUint64(math.Float64bits(v)) }
+
+// UnpackFloat64 decodes data as a uint64 in IEEE 754 representation, and
+// converts that representation back to a float64.
+func UnpackFloat64(data []byte) float64 { return math.Float64frombits(UnpackUint64(data)) }
+
+// PackFloat32 encodes v by converting it to a uint64 in IEEE 754
+// representation and encoding that value as PackUint64.
+func PackFloat32(v float32) []byte { return PackUint64(uint64(math.Float32bits(v))) }
+
+// UnpackFloat32 decodes data as a uint64 in IEEE 754 representation, and
+// converts that representation back to a float32.
+func UnpackFloat32(data []byte) float32 { return math.Float32frombits(uint32(UnpackUint64(data))) }
+
+// PackFloat16 encodes v by converting it to a uint16 in IEEE 754
+// half-precision representation and encoding that value as PackUint64.
+// The conversion rounds to the nearest representable value, with ties to
+// even, and values too large in magnitude become infinities.
+func PackFloat16(v float32) []byte { return PackUint64(uint64(float16Bits(v))) }
+
+// UnpackFloat16 decodes data as a uint16 in IEEE 754 half-precision
+// representation, and converts that representation to a float32.
+func UnpackFloat16(data []byte) float32 { return float16Value(uint16(UnpackUint64(data))) }
+
+// float16Bits returns the IEEE 754 half-precision representation of v.
+func float16Bits(v float32) uint16 {
+	b := math.Float32bits(v)
+	sign := uint16(b>>16) & 0x8000
+	exp := int(b>>23&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case b&0x7fffffff > 0x7f800000: // NaN
+		return sign | 0x7e00
+	case exp >= 0x1f: // infinity, or too large
+		return sign | 0x7c00
+	case exp <= 0: // subnormal, or too small
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000 // the implicit leading bit
+		return sign | uint16(roundShift(mant, uint(14-exp)))
+	}
+	// N.B. Rounding may carry into the exponent, which is correct.
+	return sign | uint16(uint32(exp)<<10+roundShift(mant, 13))
+}
+
+// roundShift returns z >> shift, rounded to the nearest value with ties to
+// even.
+func roundShift(z uint32, shift uint) uint32 {
+	r := z >> shift
+	rem, half := z&(1<<shift-1), uint32(1)<<(shift-1)
+	if rem > half || (rem == half && r&1 == 1) {
+		r++
+	}
+	return r
+}
+
+// float16Value returns the value of the IEEE 754 half-precision
+// representation h.
+func float16Value(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f: // infinity or NaN
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0 && mant == 0:
+		return math.Float32frombits(sign)
+	case exp == 0: // subnormal; normalize the mantissa
+		exp = 127 - 15 + 1
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		return math.Float32frombits(sign | exp<<23 | (mant&0x3ff)<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
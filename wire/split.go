@@ -0,0 +1,266 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// A Record is a single tag-value pair.
+type Record struct {
+	Tag   int
+	Value []byte
+}
+
+// Size returns the number of bytes needed to encode r, or -1 if r cannot be
+// encoded because its tag or value is out of range.
+func (r Record) Size() int { return EncodedSize(r.Tag, r.Value) }
+
+// AppendTo appends the encoding of r to buf, and returns the extended slice.
+// It reports an error, and returns buf unmodified, if r cannot be encoded.
+func (r Record) AppendTo(buf []byte) ([]byte, error) { return AppendRecord(buf, r.Tag, r.Value) }
+
+// EncodeRecord returns the encoding of r.
+func EncodeRecord(r Record) ([]byte, error) {
+	n := r.Size()
+	if n < 0 {
+		return r.AppendTo(nil) // report the error
+	}
+	return r.AppendTo(make([]byte, 0, n))
+}
+
+// DecodeRecord decodes data, which must contain exactly one complete record.
+// The value of the result aliases data. It reports ErrTruncated if data does
+// not contain a complete record, and ErrExtraData if data contains more than
+// one record.
+func DecodeRecord(data []byte) (Record, error) {
+	lay, ok := ParseLayout(data)
+	if !ok {
+		return Record{}, ErrTruncated
+	} else if lay.Size() != len(data) {
+		return Record{}, ErrExtraData
+	}
+	start := lay.TagLen + lay.PrefixLen
+	return Record{
+		Tag:   parseTag(data[:lay.TagLen]),
+		Value: data[start:],
+	}, nil
+}
+
+// ErrExtraData is reported by DecodeRecord when its input contains more than
+// one record.
+var ErrExtraData = errors.New("extra data after record")
+
+// Split splits data into a slice of complete tag-value records, without
+// decoding the values. Each element of the result holds the encoded tag and
+// value of one record, and aliases the corresponding bytes of data.
+// It reports ErrTruncated if data ends with an incomplete record.
+func Split(data []byte) ([][]byte, error) {
+	offs, end := scanOffsets(nil, data)
+	var err error
+	if end != len(data) {
+		err = ErrTruncated
+	}
+	if len(offs) == 0 {
+		return nil, err
+	}
+	recs := make([][]byte, len(offs))
+	for i, off := range offs {
+		next := end
+		if i+1 < len(offs) {
+			next = offs[i+1]
+		}
+		recs[i] = data[off:next:next]
+	}
+	return recs, err
+}
+
+// AppendOffsets appends to dst the offset of each complete record in data,
+// and returns the extended slice. This is the first step in building an index
+// of the records. It reports ErrTruncated, along with the offsets of the
+// complete records, if data ends with an incomplete record.
+func AppendOffsets(dst []int, data []byte) ([]int, error) {
+	offs, end := scanOffsets(dst, data)
+	if end != len(data) {
+		return offs, ErrTruncated
+	}
+	return offs, nil
+}
+
+// scanOffsets appends to dst the offset of each complete record in data, and
+// returns the extended slice along with the offset of the end of the last
+// complete record.
+//
+// The main loop handles records whose headers do not extend past the end of
+// data, which is all but the last few; it decodes each header from a fixed
+// window of 8 bytes (the longest possible header), so that it can do so
+// without further bounds checks or calls. The rest are handled by ParseLayout.
+func scanOffsets(dst []int, data []byte) ([]int, int) {
+	pos := 0
+	for pos+8 <= len(data) {
+		hdr := (*[8]byte)(data[pos : pos+8])
+		t := tagLength[hdr[0]>>6]
+		size := t + 1
+		switch b := hdr[t&7]; b >> 5 {
+		case 0, 1, 2, 3:
+			// single-byte value in the index
+		case 4, 5:
+			size += int(b & 0x3f)
+		case 6:
+			size += 1 + (int(b&0x1f)<<8 | int(hdr[(t+1)&7]))
+		default:
+			size += 3 + (int(b&0x1f)<<24 | int(hdr[(t+1)&7])<<16 | int(hdr[(t+2)&7])<<8 | int(hdr[(t+3)&7]))
+		}
+		if size > len(data)-pos {
+			return dst, pos // truncated
+		}
+		dst = append(dst, pos)
+		pos += size
+	}
+	for pos < len(data) {
+		n, ok := RecordSize(data[pos:])
+		if !ok {
+			break
+		}
+		dst = append(dst, pos)
+		pos += n
+	}
+	return dst, pos
+}
+
+// tagLength maps the high-order two bits of a tag index to the length of the
+// encoded tag.
+var tagLength = [4]int{1, 1, 2, 4}
+
+// ScanRecords is a split function for a bufio.Scanner that returns each
+// complete tag-value record as a token, including its encoded tag and value.
+func ScanRecords(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if n, ok := RecordSize(data); ok {
+		return n, data[:n], nil
+	} else if atEOF {
+		return 0, nil, ErrTruncated
+	}
+	return 0, nil, nil // request more data
+}
+
+// A RecordScanner reads tag-value records from an io.Reader using a
+// bufio.Scanner with the ScanRecords split function. As with a bufio.Scanner,
+// the size of the largest record that can be read is limited by the size of
+// the scanner's buffer; use the Buffer method to adjust it.
+type RecordScanner struct {
+	s   *bufio.Scanner
+	rec Record
+}
+
+// NewRecordScanner constructs a RecordScanner that reads records from r.
+func NewRecordScanner(r io.Reader) *RecordScanner {
+	s := bufio.NewScanner(r)
+	s.Split(ScanRecords)
+	return &RecordScanner{s: s}
+}
+
+// Buffer sets the initial buffer and maximum buffer size for the scanner, as
+// bufio.Scanner.Buffer. It must be called before the first call to Scan.
+func (s *RecordScanner) Buffer(buf []byte, max int) { s.s.Buffer(buf, max) }
+
+// Scan advances the scanner to the next record, and reports whether one was
+// found. When Scan returns false, use Err to check for an error.
+func (s *RecordScanner) Scan() bool {
+	if !s.s.Scan() {
+		s.rec = Record{}
+		return false
+	}
+	data := s.s.Bytes()
+	r, _ := ParseLayout(data) // ScanRecords guarantees a complete record
+	vpos := r.TagLen + r.PrefixLen
+	s.rec = Record{Tag: parseTag(data[:r.TagLen]), Value: data[vpos:r.Size()]}
+	return true
+}
+
+// Record returns the most recent record found by Scan. The value aliases the
+// buffer of the scanner, and is only valid until the next call to Scan.
+func (s *RecordScanner) Record() Record { return s.rec }
+
+// Bytes returns the complete encoding of the most recent record found by
+// Scan. It aliases the buffer of the scanner, and is only valid until the
+// next call to Scan.
+func (s *RecordScanner) Bytes() []byte { return s.s.Bytes() }
+
+// Err returns the first error encountered by the scanner, other than io.EOF.
+func (s *RecordScanner) Err() error { return s.s.Err() }
+
+// parseTag decodes a complete encoded tag.
+func parseTag(data []byte) int {
+	switch len(data) {
+	case 1:
+		return int(data[0])
+	case 2:
+		return int(data[0]&0x3f)<<8 | int(data[1])
+	default:
+		return int(data[0]&0x3f)<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	}
+}
+
+// RecordSize reports the total encoded size of the record at the front of
+// data, and whether data contains the complete record.
+func RecordSize(data []byte) (int, bool) {
+	r, ok := ParseLayout(data)
+	return r.Size(), ok
+}
+
+// A Layout describes the sizes of the components of an encoded record.
+type Layout struct {
+	TagLen    int // length of the encoded tag
+	PrefixLen int // length of the value length prefix (0 for 1-byte values)
+	DataLen   int // length of the value data
+}
+
+// Size returns the total encoded size of the record.
+func (r Layout) Size() int { return r.TagLen + r.PrefixLen + r.DataLen }
+
+// ParseLayout parses the layout of the record at the front of data, and
+// reports whether data contains the complete record. If data is too short to
+// contain the length prefix, the reported layout is incomplete.
+func ParseLayout(data []byte) (Layout, bool) {
+	var r Layout
+	if len(data) == 0 {
+		return r, false
+	}
+	switch b := data[0]; b >> 6 {
+	case 0, 1:
+		r.TagLen = 1
+	case 2:
+		r.TagLen = 2
+	default:
+		r.TagLen = 4
+	}
+	pos := r.TagLen
+	if len(data) <= pos {
+		return r, false
+	}
+
+	// Now pos is the offset of the value index.
+	b := data[pos]
+	if v := b >> 5; v < 4 {
+		r.DataLen = 1 // 1-byte value, no length prefix
+	} else if v < 6 {
+		r.PrefixLen, r.DataLen = 1, int(b&0x3f)
+	} else if v == 6 {
+		if len(data) < pos+2 {
+			return r, false
+		}
+		r.PrefixLen, r.DataLen = 2, int(b&0x1f)<<8|int(data[pos+1])
+	} else {
+		if len(data) < pos+4 {
+			return r, false
+		}
+		r.PrefixLen = 4
+		r.DataLen = int(b&0x1f)<<24 | int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+	}
+	return r, len(data) >= r.Size()
+}
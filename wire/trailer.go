@@ -0,0 +1,164 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// A message trailer is an optional record at the end of a message, with tag
+// TrailerTag, that carries the number of records that precede it and a
+// checksum of their encoding, so that a consumer of a stream can check the
+// integrity of each message without a container format.
+//
+// The value of a trailer is a nested message with the following records:
+//
+//	tag 1: the number of records before the trailer, as PackUint64
+//	tag 2: the CRC-32C (Castagnoli) checksum of the encoded records before
+//	       the trailer, as 4 bytes in big-endian order
+//
+// A trailer covers the records since the previous trailer, or since tracking
+// began. See Encoder.EnableTrailer and Decoder.VerifyTrailers.
+const (
+	trailerCount = 1
+	trailerSum   = 2
+)
+
+// ErrTrailer is reported by a Decoder that verifies trailers, when a trailer
+// does not match the records that precede it, or when the input ends with
+// records that are not followed by a trailer.
+var ErrTrailer = errors.New("invalid message trailer")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// A trailerState accumulates the record count and checksum for a trailer.
+type trailerState struct {
+	count int
+	sum   uint32
+}
+
+// add adds a single record to t.
+func (t *trailerState) add(tag int, value []byte) {
+	var buf [8]byte
+	t.sum = crc32.Update(t.sum, castagnoli, appendHeader(buf[:0], tag, value))
+	if lengthSize(value) != 0 {
+		t.sum = crc32.Update(t.sum, castagnoli, value)
+	}
+	t.count++
+}
+
+// addRaw adds the complete encoded records in data to t.
+func (t *trailerState) addRaw(data []byte) {
+	t.sum = crc32.Update(t.sum, castagnoli, data)
+	for len(data) != 0 {
+		n, _ := RecordSize(data) // the caller ensures data are complete
+		data = data[n:]
+		t.count++
+	}
+}
+
+// value returns the encoded value of a trailer for the records in t.
+func (t *trailerState) value() []byte {
+	sum := []byte{byte(t.sum >> 24), byte(t.sum >> 16), byte(t.sum >> 8), byte(t.sum)}
+	buf, _ := AppendRecord(nil, trailerCount, PackUint64(uint64(t.count)))
+	buf, _ = AppendRecord(buf, trailerSum, sum)
+	return buf
+}
+
+// check reports whether value is the encoded value of a trailer matching the
+// records in t.
+func (t *trailerState) check(value []byte) error {
+	d := NewBytesDecoder(value)
+	var count, sum []byte
+	for {
+		tag, v, err := d.readRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return wrapError{msg: "invalid message trailer: " + err.Error(), err: err}
+		}
+		switch tag {
+		case trailerCount:
+			count = v
+		case trailerSum:
+			sum = v
+		}
+	}
+	want := t.value()
+	if len(count) == 0 || len(count) > 8 || UnpackUint64(count) != uint64(t.count) {
+		return wrapError{msg: "invalid message trailer: record count mismatch", err: ErrTrailer}
+	} else if len(sum) != 4 || string(sum) != string(want[len(want)-4:]) {
+		return wrapError{msg: "invalid message trailer: checksum mismatch", err: ErrTrailer}
+	}
+	return nil
+}
+
+// EnableTrailer causes e to track the number of records it writes and a
+// checksum of their encoding, for a trailer written by FinishWithTrailer. It
+// should be called before any records of the message are written.
+func (e *Encoder) EnableTrailer() {
+	if e.trailer == nil {
+		e.trailer = new(trailerState)
+	}
+}
+
+// FinishWithTrailer writes a trailer record with tag TrailerTag for the
+// records written since EnableTrailer was called or since the previous
+// trailer, and resets the count and checksum for a following message. It
+// reports an error if EnableTrailer has not been called.
+func (e *Encoder) FinishWithTrailer() error {
+	if e.trailer == nil {
+		return errors.New("trailer is not enabled")
+	}
+	t := e.trailer
+	e.trailer = nil // do not count the trailer itself
+	err := e.Encode(TrailerTag, t.value())
+	e.trailer = t
+	if err == nil {
+		*t = trailerState{}
+	}
+	return err
+}
+
+// VerifyTrailers causes d to check the trailer records in its input (see
+// TrailerTag). Each trailer is consumed by d rather than returned, and if it
+// does not match the records that precede it, d reports an error wrapping
+// ErrTrailer. If the input ends with records that are not followed by a
+// trailer, d reports ErrTrailer instead of io.EOF.
+//
+// Verification requires the encoding of every record, so SkipUntil reads the
+// values of the records it skips.
+func (d *Decoder) VerifyTrailers() {
+	if d.verify == nil {
+		d.verify = new(trailerState)
+	}
+}
+
+// checkTrailer consumes and verifies any trailers at the front of the input,
+// if d verifies trailers. At the end of the input, it reports io.EOF, or
+// ErrTrailer if there are records not covered by a trailer.
+func (d *Decoder) checkTrailer() error {
+	for d.verify != nil {
+		h, err := d.header()
+		if err == io.EOF && d.verify.count != 0 {
+			return wrapError{msg: "invalid message trailer: missing trailer", err: ErrTrailer}
+		} else if err != nil {
+			return err
+		} else if h.tag != TrailerTag {
+			return nil
+		}
+		_, value, err := d.readRecord()
+		if err != nil {
+			return err
+		} else if err := d.verify.check(value); err != nil {
+			return err
+		}
+		*d.verify = trailerState{}
+		if d.order != nil {
+			*d.order = orderState{} // the next message starts over
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// The methods in this file encode and decode values of common types with the
+// standard encodings used by the binpack package, so that a record written by
+// one of them decodes as the corresponding struct field type, and vice versa.
+
+// EncodeString encodes a record with the given tag whose value is the bytes
+// of s.
+func (e *Encoder) EncodeString(tag int, s string) error { return e.Encode(tag, []byte(s)) }
+
+// EncodeUint encodes a record with the given tag whose value is z, encoded as
+// by PackUint64.
+func (e *Encoder) EncodeUint(tag int, z uint64) error { return e.Encode(tag, PackUint64(z)) }
+
+// EncodeInt encodes a record with the given tag whose value is z, encoded as
+// by PackInt64.
+func (e *Encoder) EncodeInt(tag int, z int64) error { return e.Encode(tag, PackInt64(z)) }
+
+// EncodeFloat encodes a record with the given tag whose value is v, encoded
+// as by PackFloat64.
+func (e *Encoder) EncodeFloat(tag int, v float64) error { return e.Encode(tag, PackFloat64(v)) }
+
+// EncodeBool encodes a record with the given tag whose value is a single
+// byte, 1 if b is true and 0 otherwise.
+func (e *Encoder) EncodeBool(tag int, b bool) error {
+	if b {
+		return e.Encode(tag, []byte{1})
+	}
+	return e.Encode(tag, []byte{0})
+}
+
+// EncodeTime encodes a record with the given tag whose value is the encoding
+// of t by its MarshalBinary method.
+func (e *Encoder) EncodeTime(tag int, t time.Time) error {
+	data, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return e.Encode(tag, data)
+}
+
+// ErrInvalidNumber is reported when decoding a number from a value that is
+// empty or longer than 8 bytes.
+var ErrInvalidNumber = errors.New("invalid number encoding")
+
+// The methods of Record below decode its value with the standard encodings,
+// as the counterparts of the Encode methods above. Each reports an error that
+// includes the tag of the record if the value is not a valid encoding.
+
+// Uint64 decodes the value of r as an unsigned integer, as by UnpackUint64.
+func (r Record) Uint64() (uint64, error) {
+	if err := r.checkNumber(); err != nil {
+		return 0, err
+	}
+	return UnpackUint64(r.Value), nil
+}
+
+// Int64 decodes the value of r as a signed integer, as by UnpackInt64.
+func (r Record) Int64() (int64, error) {
+	if err := r.checkNumber(); err != nil {
+		return 0, err
+	}
+	return UnpackInt64(r.Value), nil
+}
+
+// Float64 decodes the value of r as a floating-point value, as by
+// UnpackFloat64.
+func (r Record) Float64() (float64, error) {
+	if err := r.checkNumber(); err != nil {
+		return 0, err
+	}
+	return UnpackFloat64(r.Value), nil
+}
+
+// Bool decodes the value of r as a Boolean, which must be a single byte. A
+// zero byte is false, and any other is true.
+func (r Record) Bool() (bool, error) {
+	if len(r.Value) != 1 {
+		return false, r.valueError("invalid encoding of bool", nil)
+	}
+	return r.Value[0] != 0, nil
+}
+
+// Text returns the value of r as a string. Any value is a valid string.
+func (r Record) Text() string { return string(r.Value) }
+
+// Time decodes the value of r as a time.Time, as by its UnmarshalBinary
+// method.
+func (r Record) Time() (time.Time, error) {
+	var t time.Time
+	if err := t.UnmarshalBinary(r.Value); err != nil {
+		return time.Time{}, r.valueError("invalid encoding of time: "+err.Error(), err)
+	}
+	return t, nil
+}
+
+func (r Record) checkNumber() error {
+	if len(r.Value) == 0 || len(r.Value) > 8 {
+		return r.valueError(ErrInvalidNumber.Error(), ErrInvalidNumber)
+	}
+	return nil
+}
+
+// valueError returns an error with msg prefixed by the tag of r, wrapping err.
+func (r Record) valueError(msg string, err error) error {
+	return wrapError{msg: "tag " + strconv.Itoa(r.Tag) + ": " + msg, err: err}
+}
+
+// Next decodes the next record from the reader, as Decode, and returns it as a
+// Record so that its value can be decoded by the methods of Record.
+func (d *Decoder) Next() (Record, error) {
+	tag, value, err := d.Decode()
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Tag: tag, Value: value}, nil
+}
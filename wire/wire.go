@@ -0,0 +1,983 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+// Package wire implements the binpack record encoding at the level of tags,
+// values, and byte strings, without reflection. It has no dependencies on
+// the reflect or fmt packages, so it can be used in constrained environments
+// where the reflection-based encoding of the parent binpack package is not
+// available. The parent package re-exports the types and functions defined
+// here, so most programs do not need to import this package directly.
+//
+// See the documentation of package binpack for a description of the format.
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// An Encoder encodes tag-value records to a buffer.  Call the Encode method to
+// add values. The buffer can be recovered from the Data field.
+//
+// An Encoder constructed by NewStreamEncoder instead writes records directly
+// to an io.Writer, and its Data field is nil. Each record whose value is at
+// most 4096 bytes is written with a single call to Write.
+//
+// If writing a record fails, the Encoder records the error, and subsequent
+// calls to Encode do nothing and return that error. Use the Err method to
+// check whether an error has occurred.
+type Encoder struct {
+	Data *bytes.Buffer
+
+	w   io.Writer // if non-nil, the output stream
+	err error     // the first write error, if any
+	buf []byte    // scratch space for records written to w
+
+	limit int                    // if positive, the size budget in bytes
+	split func(msg []byte) error // called when the budget is exhausted
+	used  int                    // bytes written to w since the last split
+
+	trailer *trailerState // if non-nil, records covered by the next trailer
+}
+
+// NewEncoder constructs an Encoder that writes data to buf. If buf == nil, a
+// new empty buffer is allocated and can be retrieved from the Data field of
+// the Encoder.
+func NewEncoder(buf *bytes.Buffer) *Encoder {
+	if buf == nil {
+		buf = bytes.NewBuffer(nil)
+	}
+	return &Encoder{Data: buf}
+}
+
+// NewStreamEncoder constructs an Encoder that writes records directly to w
+// rather than to a buffer.
+func NewStreamEncoder(w io.Writer) *Encoder { return &Encoder{w: w} }
+
+// NewFixedEncoder constructs an Encoder that writes records to buf, starting
+// at offset 0, and never grows it beyond its capacity. If a record does not
+// fit in the remaining capacity, Encode reports ErrBufferFull and writes
+// nothing; this error is not sticky. Use the Bytes method to recover the
+// encoded records. The Data field of the Encoder is nil.
+func NewFixedEncoder(buf []byte) *Encoder {
+	return &Encoder{w: &fixedBuffer{buf: buf[:0]}}
+}
+
+// Bytes returns the records encoded so far by an Encoder constructed by
+// NewEncoder or NewFixedEncoder. For a fixed encoder, the result aliases the
+// caller's buffer. For an Encoder that writes to a stream, Bytes returns nil.
+func (e *Encoder) Bytes() []byte {
+	if e.Data != nil {
+		return e.Data.Bytes()
+	} else if f, ok := e.w.(*fixedBuffer); ok {
+		return f.buf
+	}
+	return nil
+}
+
+// ErrBufferFull is reported by an Encoder constructed by NewFixedEncoder when
+// a record does not fit in the remaining capacity of its buffer.
+var ErrBufferFull = errors.New("buffer is full")
+
+// A fixedBuffer is an io.Writer that appends to a slice without growing it.
+type fixedBuffer struct{ buf []byte }
+
+func (f *fixedBuffer) Write(data []byte) (int, error) {
+	if len(data) > f.free() {
+		return 0, ErrBufferFull
+	}
+	f.buf = append(f.buf, data...)
+	return len(data), nil
+}
+
+// free reports the number of bytes remaining in the buffer.
+func (f *fixedBuffer) free() int { return cap(f.buf) - len(f.buf) }
+
+// checkFixed reports ErrBufferFull if e writes to a fixed buffer that does
+// not have room for n more bytes.
+func (e *Encoder) checkFixed(n int) error {
+	if f, ok := e.w.(*fixedBuffer); ok && n > f.free() {
+		return ErrBufferFull
+	}
+	return nil
+}
+
+// Encode appends a single tag-value pair to the output. If tag or value is
+// out of range, Encode reports an error without writing anything.
+func (e *Encoder) Encode(tag int, value []byte) error {
+	if e.err != nil {
+		return e.err
+	} else if tagSize(tag) < 0 {
+		return tagRangeError(tag)
+	} else if lengthSize(value) < 0 {
+		return valueSizeError(value)
+	}
+	size := tagSize(tag) + lengthSize(value) + len(value)
+	if err := e.checkFixed(size); err != nil {
+		return err
+	} else if err := e.reserve(size); err != nil {
+		return err
+	}
+	var err error
+	if e.w != nil {
+		err = e.writeRecord(tag, value)
+	} else {
+		e.Data.Grow(size)
+		err = WriteTag(e.Data, tag)
+		if err == nil {
+			err = WriteValue(e.Data, value)
+		}
+	}
+	if err != nil {
+		e.err = err
+		return err
+	}
+	if e.trailer != nil {
+		e.trailer.add(tag, value)
+	}
+	e.used += size
+	return nil
+}
+
+// maxCoalesce is the size of the largest value that writeRecord copies so
+// that it can be written together with its tag and length prefix.
+const maxCoalesce = 4096
+
+// writeRecord writes a record to the output stream. A record with a small
+// value is written with a single call to Write. A larger value is written
+// separately after its header, since copying it costs more than the extra
+// call saves. The caller must check that tag and value are in range.
+func (e *Encoder) writeRecord(tag int, value []byte) error {
+	e.buf = appendHeader(e.buf[:0], tag, value)
+	if lengthSize(value) == 0 {
+		// The value is encoded in the header.
+	} else if len(value) <= maxCoalesce {
+		e.buf = append(e.buf, value...)
+	} else if _, err := e.w.Write(e.buf); err != nil {
+		return err
+	} else {
+		_, err := e.w.Write(value)
+		return err
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// ErrOverBudget is reported by an Encoder when a record does not fit within
+// its size budget. See Encoder.SetBudget.
+var ErrOverBudget = errors.New("record exceeds size budget")
+
+// SetBudget limits the size of each message written by e to at most limit
+// bytes. If limit <= 0, the size is not limited.
+//
+// When a record would cause the current message to exceed the budget, e
+// calls split to end the message and begin a new one. For an Encoder that
+// writes to a buffer, split receives the buffered message, which is only
+// valid for the duration of the call; if split succeeds the buffer is reset
+// before the record is written. For an Encoder that writes to a stream, split
+// receives nil and marks a boundary in the stream.
+//
+// If split == nil, or if a single record exceeds the budget by itself, Encode
+// reports ErrOverBudget and writes nothing. If split reports an error, Encode
+// returns that error and writes nothing.
+func (e *Encoder) SetBudget(limit int, split func(msg []byte) error) {
+	e.limit = limit
+	e.split = split
+	e.used = 0
+}
+
+// reserve ensures there is space in the budget for n more bytes, splitting
+// the message if necessary.
+func (e *Encoder) reserve(n int) error {
+	if e.limit <= 0 {
+		return nil
+	} else if n > e.limit {
+		return ErrOverBudget
+	}
+	used := e.used
+	if e.w == nil {
+		used = e.Data.Len()
+	}
+	if used+n <= e.limit {
+		return nil
+	} else if e.split == nil {
+		return ErrOverBudget
+	}
+	var msg []byte
+	if e.w == nil {
+		msg = e.Data.Bytes()
+	}
+	if err := e.split(msg); err != nil {
+		return err
+	}
+	if e.w == nil {
+		e.Data.Reset()
+	}
+	e.used = 0
+	return nil
+}
+
+// EncodeGroup calls f with a temporary Encoder, and if f succeeds appends
+// all the records f encoded to the output in a single write. If f reports an
+// error, nothing is written to e and EncodeGroup returns that error. This
+// ensures that a group of records is either written completely or not at all.
+func (e *Encoder) EncodeGroup(f func(*Encoder) error) error {
+	if e.err != nil {
+		return e.err
+	}
+	g := NewEncoder(nil)
+	if err := f(g); err != nil {
+		return err
+	}
+	return e.writeRaw(g.Data.Bytes())
+}
+
+// EncodeAll appends the records in recs to the output in a single write. It
+// checks all the tags and values before writing, and if any record cannot be
+// encoded, it reports an error and writes nothing. The records must fit
+// within the size budget as a unit (see SetBudget).
+func (e *Encoder) EncodeAll(recs []Record) error {
+	if e.err != nil {
+		return e.err
+	}
+	size := 0
+	for _, r := range recs {
+		n := r.Size()
+		if n < 0 {
+			_, err := r.AppendTo(nil) // report the error
+			return err
+		}
+		size += n
+	}
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, 0, size)
+	for _, r := range recs {
+		buf, _ = r.AppendTo(buf) // checked above
+	}
+	return e.writeRaw(buf)
+}
+
+// EncodeRecords is a variadic form of EncodeAll.
+func (e *Encoder) EncodeRecords(recs ...Record) error { return e.EncodeAll(recs) }
+
+// WriteRecords writes data, which must consist of complete encoded records,
+// to the output without modification. It reports an error without writing
+// anything if data ends with an incomplete record, or if data does not fit
+// within the size budget as a unit (see SetBudget).
+func (e *Encoder) WriteRecords(data []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	for rest := data; len(rest) != 0; {
+		n, ok := RecordSize(rest)
+		if !ok {
+			return ErrTruncated
+		}
+		rest = rest[n:]
+	}
+	return e.writeRaw(data)
+}
+
+// writeRaw writes pre-encoded records to the output.
+func (e *Encoder) writeRaw(data []byte) error {
+	if err := e.checkFixed(len(data)); err != nil {
+		return err
+	} else if err := e.reserve(len(data)); err != nil {
+		return err
+	}
+	if e.w == nil {
+		e.Data.Write(data)
+	} else if _, err := e.w.Write(data); err != nil {
+		e.err = err
+		return err
+	}
+	e.used += len(data)
+	if e.trailer != nil {
+		e.trailer.addRaw(data)
+	}
+	return nil
+}
+
+// Err returns the first error that occurred while writing to the output, or
+// nil if no such error has occurred.
+func (e *Encoder) Err() error { return e.err }
+
+// WriteTo writes the buffered contents of e to w, and implements the
+// io.WriterTo interface. The contents written are consumed from e.Data.
+// If e writes directly to a stream, WriteTo does nothing.
+func (e *Encoder) WriteTo(w io.Writer) (int64, error) {
+	if e.Data == nil {
+		return 0, nil
+	}
+	return e.Data.WriteTo(w)
+}
+
+// ReadFrom reads tag-value records from r until EOF and appends them to the
+// output, and implements the io.ReaderFrom interface. Each record is checked
+// for validity before it is added; if an error occurs, records read before
+// the error are retained.
+func (e *Encoder) ReadFrom(r io.Reader) (int64, error) {
+	cr := NewCountingReader(r)
+	d := NewDecoder(cr)
+	for {
+		tag, value, err := d.Decode()
+		if err == io.EOF {
+			return cr.BytesRead(), nil
+		} else if err != nil {
+			return cr.BytesRead(), err
+		}
+		if err := e.Encode(tag, value); err != nil {
+			return cr.BytesRead(), err
+		}
+	}
+}
+
+// A CountingReader wraps an io.Reader and counts the bytes read through it.
+// It implements ByteReader, buffering the underlying reader if necessary, so
+// that a Decoder reading from a CountingReader does not read ahead of the
+// records it returns, and BytesRead reports its exact position in the input.
+type CountingReader struct {
+	r ByteReader
+	n int64
+}
+
+// NewCountingReader constructs a CountingReader that reads from r. If r does
+// not implement ByteReader, it is wrapped in a bufio.Reader.
+func NewCountingReader(r io.Reader) *CountingReader {
+	br, ok := r.(ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &CountingReader{r: br}
+}
+
+// Read implements the io.Reader interface.
+func (c *CountingReader) Read(data []byte) (int, error) {
+	nr, err := c.r.Read(data)
+	c.n += int64(nr)
+	return nr, err
+}
+
+// ReadByte implements the io.ByteReader interface.
+func (c *CountingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// BytesRead reports the number of bytes read from c.
+func (c *CountingReader) BytesRead() int64 { return c.n }
+
+// tagSize returns the number of bytes needed to encode tag, or -1.
+func tagSize(tag int) int {
+	if tag < 0 {
+		return -1
+	} else if tag < 128 {
+		return 1
+	} else if tag < (1 << 14) {
+		return 2
+	} else if tag < (1 << 30) {
+		return 4
+	}
+	return -1
+}
+
+// WriteTag writes the encoding of tag to w. It reports an error without
+// writing anything if tag is negative or exceeds the maximum tag value.
+func WriteTag(w io.Writer, tag int) (err error) {
+	switch tagSize(tag) {
+	case 1:
+		_, err = w.Write([]byte{byte(tag)})
+	case 2:
+		_, err = w.Write([]byte{0x80 | byte(tag>>8), byte(tag & 0xff)})
+	case 4:
+		_, err = w.Write([]byte{
+			0xC0 | byte(tag>>24), byte(tag >> 16), byte(tag >> 8), byte(tag),
+		})
+	default:
+		return tagRangeError(tag)
+	}
+	return
+}
+
+// AppendRecord appends the encoding of a record with the given tag and value
+// to buf, and returns the extended slice. It reports an error, and returns
+// buf unmodified, if tag or value is out of range.
+func AppendRecord(buf []byte, tag int, value []byte) ([]byte, error) {
+	if tagSize(tag) < 0 {
+		return buf, tagRangeError(tag)
+	} else if lengthSize(value) < 0 {
+		return buf, valueSizeError(value)
+	}
+	buf = appendHeader(buf, tag, value)
+	if lengthSize(value) == 0 {
+		return buf, nil // the value is encoded in the header
+	}
+	return append(buf, value...), nil
+}
+
+// appendHeader appends the encoded tag and the length prefix of value to buf.
+// If value is a single byte encoded in the length prefix, the result is the
+// complete record. The caller must check that tag and value are in range.
+func appendHeader(buf []byte, tag int, value []byte) []byte {
+	switch tagSize(tag) {
+	case 1:
+		buf = append(buf, byte(tag))
+	case 2:
+		buf = append(buf, 0x80|byte(tag>>8), byte(tag))
+	default:
+		buf = append(buf, 0xC0|byte(tag>>24), byte(tag>>16), byte(tag>>8), byte(tag))
+	}
+	n := len(value)
+	switch lengthSize(value) {
+	case 0:
+		return append(buf, value[0])
+	case 1:
+		return append(buf, 0x80|byte(n))
+	case 2:
+		return append(buf, 0xC0|byte(n>>8), byte(n))
+	default:
+		return append(buf, 0xE0|byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+// MaxTag is the largest tag value that can be encoded.
+const MaxTag = 1<<30 - 1
+
+// Reserved tags. The largest tags are reserved for records that have a
+// special meaning to this module, and should not be used by application
+// messages. Each reserved tag must be distinct from the others, so that
+// streams combining several of these features remain unambiguous.
+const (
+	// PageTag is the tag of the pagination record of a message produced by
+	// binpack.MarshalPages.
+	PageTag = MaxTag
+
+	// DiffTag is the tag of the record that lists the fields changed by a
+	// patch produced by binpack.MarshalDiff.
+	DiffTag = MaxTag - 1
+
+	// TypeDescriptorTag is the tag of a type descriptor record in a type
+	// stream written by binpack.TypeStreamEncoder.
+	TypeDescriptorTag = MaxTag - 2
+
+	// TrailerTag is the tag of a message trailer record (see FinishWithTrailer).
+	TrailerTag = MaxTag - 3
+
+	// HeaderTag is the tag of a stream header record written by
+	// binpack.WriteHeader.
+	HeaderTag = MaxTag - 4
+)
+
+// MaxValueLen is the length in bytes of the longest value that can be encoded.
+const MaxValueLen = 1<<29 - 1
+
+// Errors reported by the encoding and decoding functions. These are wrapped
+// with additional detail, so use errors.Is to check for them.
+var (
+	// ErrTagTooLarge is reported for a tag outside the range 0..MaxTag.
+	ErrTagTooLarge = errors.New("tag out of range")
+
+	// ErrValueTooLarge is reported for a value longer than MaxValueLen.
+	ErrValueTooLarge = errors.New("value too big")
+
+	// ErrTruncated is reported when the input ends partway through a record.
+	// For compatibility, errors.Is(ErrTruncated, io.ErrUnexpectedEOF) is true.
+	ErrTruncated error = truncatedError{}
+)
+
+type truncatedError struct{}
+
+func (truncatedError) Error() string        { return "truncated record" }
+func (truncatedError) Is(target error) bool { return target == io.ErrUnexpectedEOF }
+
+// truncated converts an end-of-input error partway through a record into
+// ErrTruncated, and returns other errors unchanged.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// wrapError is an error with a message that wraps an underlying error.
+type wrapError struct {
+	msg string
+	err error
+}
+
+func (w wrapError) Error() string { return w.msg }
+func (w wrapError) Unwrap() error { return w.err }
+
+func tagRangeError(tag int) error {
+	return wrapError{
+		msg: "tag out of range (" + strconv.Itoa(tag) + " not in 0.." + strconv.Itoa(MaxTag) + ")",
+		err: ErrTagTooLarge,
+	}
+}
+
+func valueSizeError(value []byte) error {
+	return wrapError{
+		msg: "value too big (" + strconv.Itoa(len(value)) + " bytes > " + strconv.Itoa(MaxValueLen) + ")",
+		err: ErrValueTooLarge,
+	}
+}
+
+// ValueSize returns the number of bytes needed to encode value, including its
+// length prefix, or -1 if value is too long to encode.
+func ValueSize(value []byte) int {
+	if n := lengthSize(value); n >= 0 {
+		return n + len(value)
+	}
+	return -1
+}
+
+// EncodedSize returns the number of bytes needed to encode a record with the
+// given tag and value, or -1 if the record cannot be encoded.
+func EncodedSize(tag int, value []byte) int {
+	t, v := tagSize(tag), ValueSize(value)
+	if t < 0 || v < 0 {
+		return -1
+	}
+	return t + v
+}
+
+// lengthSize returns the number of bytes to encode the length of value, or -1.
+func lengthSize(value []byte) int {
+	n := len(value)
+	if n == 1 && value[0] < 128 {
+		return 0
+	} else if n < (1 << 6) {
+		return 1
+	} else if n < (1 << 13) {
+		return 2
+	} else if n < (1 << 29) {
+		return 4
+	}
+	return -1
+}
+
+// WriteValue writes the encoding of value, including its length prefix, to w.
+// It reports an error without writing anything if value is too long.
+func WriteValue(w io.Writer, value []byte) error {
+	n := len(value)
+	var err error
+	switch lengthSize(value) {
+	case 0:
+		_, err := w.Write([]byte{value[0]})
+		return err
+	case 1:
+		_, err = w.Write([]byte{0x80 | byte(n)})
+	case 2:
+		_, err = w.Write([]byte{0xC0 | byte(n>>8), byte(n)})
+	case 4:
+		_, err = w.Write([]byte{0xE0 | byte(n>>24), byte(n >> 16), byte(n >> 8), byte(n)})
+	default:
+		return valueSizeError(value)
+	}
+	if err == nil {
+		_, err = w.Write(value)
+	}
+	return err
+}
+
+// A Decoder decodes tag-value pairs from an io.Reader.
+type Decoder struct {
+	buf   ByteReader
+	next  *header // if non-nil, the header of the next record, already read
+	count int     // number of records decoded
+
+	// If inMem is true, the input is the unread portion of data, and buf is
+	// not used.
+	inMem bool
+	data  []byte
+
+	dl      readDeadliner // if non-nil, the reader supports deadlines
+	timeout time.Duration // if positive, the time allowed per record
+
+	verify *trailerState // if non-nil, records covered by the next trailer
+	order  *orderState   // if non-nil, the tag order required of records
+}
+
+// A readDeadliner is a reader that supports read deadlines, such as a
+// net.Conn or an *os.File for a pipe.
+type readDeadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// A header records the tag and value length of a record whose value has not
+// yet been read.
+type header struct {
+	tag    int
+	n      int    // length of the value
+	inline []byte // if non-nil, the value, encoded in the length prefix
+	value  []byte // for an in-memory decoder, the value (aliasing the input)
+}
+
+// NewDecoder constructs a Decoder that reads records from r. If r implements
+// ByteReader, the Decoder reads from it directly; otherwise r is wrapped in a
+// bufio.Reader.
+func NewDecoder(r io.Reader) *Decoder {
+	dl, _ := r.(readDeadliner)
+	if t, ok := r.(ByteReader); ok {
+		return &Decoder{buf: t, dl: dl}
+	}
+	return &Decoder{buf: bufio.NewReader(r), dl: dl}
+}
+
+// SetRecordTimeout limits the time allowed to read each record to timeout.
+// Before it begins to read a record, d sets the read deadline of the
+// underlying reader to timeout from the current time, so that a slow peer
+// cannot stall d indefinitely. If timeout <= 0, the deadline is cleared and
+// reads are not limited.
+//
+// This requires the reader passed to NewDecoder to have a SetReadDeadline
+// method, as net.Conn does; otherwise SetRecordTimeout has no effect and
+// returns false. When a deadline expires, the Decoder reports the error from
+// the reader, which satisfies errors.Is(err, os.ErrDeadlineExceeded) for the
+// standard library types. The input may then be positioned partway through
+// a record, so the Decoder should not be used further.
+func (d *Decoder) SetRecordTimeout(timeout time.Duration) bool {
+	if d.dl == nil {
+		return false
+	}
+	d.timeout = timeout
+	if timeout <= 0 {
+		d.dl.SetReadDeadline(time.Time{})
+	}
+	return true
+}
+
+// NewBytesDecoder constructs a Decoder that reads records from data. It is
+// equivalent to NewDecoder(bytes.NewReader(data)), but decodes directly from
+// the slice rather than a byte at a time through the ByteReader interface.
+// Values returned by Decode are copies, and do not alias data.
+func NewBytesDecoder(data []byte) *Decoder { return &Decoder{inMem: true, data: data} }
+
+// Decode returns the next tag-value record from the reader.
+// At the end of the input, it returns io.EOF. If the input ends partway
+// through a record, it returns ErrTruncated.
+func (d *Decoder) Decode() (int, []byte, error) {
+	if err := d.checkTrailer(); err != nil {
+		return 0, nil, err
+	}
+	tag, value, err := d.readRecord()
+	if err != nil {
+		return tag, nil, err
+	} else if err := d.checkOrder(tag); err != nil {
+		return tag, nil, err
+	}
+	d.count++
+	if d.verify != nil {
+		d.verify.add(tag, value)
+	}
+	return tag, value, nil
+}
+
+// readRecord reads the next record from the input.
+func (d *Decoder) readRecord() (int, []byte, error) {
+	h, err := d.header()
+	if err != nil {
+		return h.tag, nil, err
+	}
+	d.next = nil
+	if h.inline != nil {
+		return h.tag, h.inline, nil
+	}
+	value := make([]byte, h.n)
+	if d.inMem {
+		copy(value, h.value)
+		return h.tag, value, nil
+	}
+	if _, err := io.ReadFull(d.buf, value); err != nil {
+		return h.tag, nil, truncated(err)
+	}
+	return h.tag, value, nil
+}
+
+// DecodeBatch decodes up to max records from the reader into dst, and returns
+// the number of records decoded. If max <= 0 or max > len(dst), it decodes up
+// to len(dst) records. The values of the records in a batch may share a
+// single allocation.
+//
+// If the input ends after at least one record was decoded, DecodeBatch
+// returns the records with a nil error, and the next call returns 0, io.EOF.
+// If decoding fails for another reason, DecodeBatch returns the records
+// decoded before the failure along with the error.
+func (d *Decoder) DecodeBatch(dst []Record, max int) (int, error) {
+	if max <= 0 || max > len(dst) {
+		max = len(dst)
+	}
+	if d.inMem && d.verify == nil {
+		return d.memBatch(dst[:max])
+	}
+	for n := 0; n < max; n++ {
+		tag, value, err := d.Decode()
+		if err == io.EOF && n > 0 {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		dst[n] = Record{Tag: tag, Value: value}
+	}
+	return max, nil
+}
+
+// memBatch implements DecodeBatch for an in-memory decoder, copying the
+// values of the batch into a single buffer.
+func (d *Decoder) memBatch(dst []Record) (int, error) {
+	n, size := 0, 0
+	var err error
+	for n < len(dst) {
+		var h header
+		h, err = d.header()
+		if err != nil {
+			break
+		}
+		d.next = nil
+		if err = d.checkOrder(h.tag); err != nil {
+			break
+		}
+		value := h.value
+		if h.inline != nil {
+			value = h.inline
+		}
+		dst[n] = Record{Tag: h.tag, Value: value}
+		size += len(value)
+		n++
+	}
+	buf := make([]byte, size)
+	for i := 0; i < n; i++ {
+		m := copy(buf, dst[i].Value)
+		dst[i].Value, buf = buf[:m:m], buf[m:]
+	}
+	d.count += n
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// More reports whether another record is available from the reader. If More
+// returns false, the next call to Decode will report an error, either io.EOF
+// at the end of the input or the error that prevented reading the record.
+//
+// The standard decoding loop is:
+//
+//	for d.More() {
+//		tag, value, err := d.Decode()
+//		...
+//	}
+func (d *Decoder) More() bool {
+	if d.checkTrailer() != nil {
+		return false
+	}
+	_, err := d.header()
+	return err == nil
+}
+
+// SkipUntil discards records from the reader until it finds one with the
+// given tag, and returns the value of that record. The values of discarded
+// records are not allocated. If no record has the tag, SkipUntil returns
+// io.EOF at the end of the input.
+func (d *Decoder) SkipUntil(tag int) ([]byte, error) {
+	if d.verify != nil {
+		// Every record must be read to verify the trailer.
+		for {
+			t, value, err := d.Decode()
+			if err != nil || t == tag {
+				return value, err
+			}
+		}
+	}
+	for {
+		h, err := d.header()
+		if err != nil {
+			return nil, err
+		} else if h.tag == tag {
+			_, value, err := d.Decode()
+			return value, err
+		}
+		d.next = nil
+		if h.inline == nil && !d.inMem {
+			if err := discard(d.buf, h.n); err != nil {
+				return nil, err
+			}
+		}
+		if err := d.checkOrder(h.tag); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// discard reads and discards n bytes from r. If fewer than n bytes are
+// available, it reports ErrTruncated.
+func discard(r io.Reader, n int) error {
+	var err error
+	if d, ok := r.(interface{ Discard(int) (int, error) }); ok {
+		_, err = d.Discard(n)
+	} else {
+		_, err = io.CopyN(io.Discard, r, int64(n))
+	}
+	return truncated(err)
+}
+
+// Count returns the number of records successfully decoded so far.
+func (d *Decoder) Count() int { return d.count }
+
+// Peek reports the tag and value length of the next record from the reader,
+// without consuming it. The following call to Decode returns that record.
+// At the end of the input, it returns io.EOF.
+func (d *Decoder) Peek() (tag, valueLen int, err error) {
+	if err := d.checkTrailer(); err != nil {
+		return 0, 0, err
+	}
+	h, err := d.header()
+	return h.tag, h.n, err
+}
+
+// header returns the header of the next record, reading it from the input if
+// it has not already been read.
+func (d *Decoder) header() (header, error) {
+	if d.next != nil {
+		return *d.next, nil
+	} else if d.inMem {
+		return d.memHeader()
+	}
+	if d.timeout > 0 {
+		if err := d.dl.SetReadDeadline(time.Now().Add(d.timeout)); err != nil {
+			return header{}, err
+		}
+	}
+	tag, err := ReadTag(d.buf)
+	if err != nil {
+		return header{}, err
+	}
+	n, inline, err := readLength(d.buf)
+	if err != nil {
+		return header{tag: tag}, truncated(err)
+	}
+	d.next = &header{tag: tag, n: n, inline: inline}
+	return *d.next, nil
+}
+
+// memHeader parses the header of the next record from the input of an
+// in-memory decoder, and consumes the record.
+func (d *Decoder) memHeader() (header, error) {
+	if len(d.data) == 0 {
+		return header{}, io.EOF
+	}
+	r, ok := ParseLayout(d.data)
+	if len(d.data) < r.TagLen {
+		return header{}, ErrTruncated
+	}
+	h := header{tag: parseTag(d.data[:r.TagLen]), n: r.DataLen}
+	if !ok {
+		return header{tag: h.tag}, ErrTruncated
+	}
+	start := r.TagLen + r.PrefixLen
+	if r.PrefixLen == 0 {
+		h.inline = []byte{d.data[start]}
+	} else {
+		h.value = d.data[start : start+r.DataLen]
+	}
+	d.data = d.data[r.Size():]
+	d.next = &h
+	return h, nil
+}
+
+// ByteReader is the interface required by ReadTag and ReadValue. It is
+// satisfied by *bytes.Buffer, *bytes.Reader, *bufio.Reader, and others.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// ReadTag reads an encoded tag from the current position of buf.
+func ReadTag(buf ByteReader) (int, error) {
+	b, err := buf.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch v := b >> 6; v {
+	case 0, 1:
+		return int(b), nil
+	case 2:
+		c, err := buf.ReadByte()
+		if err != nil {
+			return 0, truncated(err)
+		}
+		return int(b&0x3f)<<8 | int(c), nil
+	default:
+		z, err := readInt24(buf)
+		if err != nil {
+			return 0, truncated(err)
+		}
+		return int(b&0x3f)<<24 | z, nil
+	}
+}
+
+// ReadValue reads an encoded value, including its length prefix, from the
+// current position of buf.
+func ReadValue(buf ByteReader) ([]byte, error) {
+	n, inline, err := readLength(buf)
+	if err != nil {
+		return nil, err
+	} else if inline != nil {
+		return inline, nil
+	}
+
+	// Now n is the number of data bytes we need to read.
+	data := make([]byte, n)
+	if _, err := io.ReadFull(buf, data); err != nil {
+		return nil, truncated(err)
+	}
+	return data, nil
+}
+
+// readLength reads the length prefix of a value from buf, and returns the
+// length of the value. If the value is a single byte encoded in the index,
+// readLength also returns the value as inline.
+func readLength(buf ByteReader) (n int, inline []byte, err error) {
+	b, err := buf.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if v := b >> 5; v < 4 {
+		// index with 1-byte value; no additional data bytes
+		return 1, []byte{b}, nil
+	} else if v < 6 {
+		// index + data
+		return int(b & 0x3f), nil, nil
+	} else if v == 6 {
+		// index + 2 + data
+		c, err := buf.ReadByte()
+		if err != nil {
+			return 0, nil, truncated(err)
+		}
+		return int(b&0x1f)<<8 | int(c), nil, nil
+	}
+	// index + 3 + data
+	z, err := readInt24(buf)
+	if err != nil {
+		return 0, nil, truncated(err)
+	}
+	return int(b&0x1f)<<24 | z, nil, nil
+}
+
+// readInt24 reads three bytes from the input and decodes the value as an
+// unsigned integer in big-endian order.
+func readInt24(buf ByteReader) (int, error) {
+	var data [3]byte
+	if _, err := io.ReadFull(buf, data[:]); err != nil {
+		return 0, err
+	}
+	return int(data[0])<<16 | int(data[1])<<8 | int(data[2]), nil
+}
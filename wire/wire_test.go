@@ -0,0 +1,46 @@
+// Copyright (C) 2020 Michael J. Fromberger. All Rights Reserved.
+
+package wire_test
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/binpack/wire"
+)
+
+// The wire package must not depend on reflection or formatting, so that it
+// remains usable in constrained environments.
+func TestDependencies(t *testing.T) {
+	out, err := exec.Command("go", "list", "-deps", ".").Output()
+	if err != nil {
+		t.Skipf("Unable to list dependencies: %v", err)
+	}
+	for _, pkg := range strings.Fields(string(out)) {
+		if pkg == "reflect" || pkg == "fmt" {
+			t.Errorf("Package wire depends on %q", pkg)
+		}
+	}
+}
+
+// The reserved tags must be valid and pairwise distinct.
+func TestReservedTags(t *testing.T) {
+	tags := map[string]int{
+		"PageTag":           wire.PageTag,
+		"DiffTag":           wire.DiffTag,
+		"TypeDescriptorTag": wire.TypeDescriptorTag,
+		"TrailerTag":        wire.TrailerTag,
+		"HeaderTag":         wire.HeaderTag,
+	}
+	seen := make(map[int]string)
+	for name, tag := range tags {
+		if tag < 0 || tag > wire.MaxTag {
+			t.Errorf("%s = %d is not a valid tag", name, tag)
+		}
+		if old, ok := seen[tag]; ok {
+			t.Errorf("%s and %s are both %d", name, old, tag)
+		}
+		seen[tag] = name
+	}
+}